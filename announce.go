@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A listenAnnouncement tells booth automation where the server ended up,
+// which on a random port can't be known ahead of time.
+type listenAnnouncement struct {
+	URL       string `json:"url"`
+	PublicURL string `json:"public_url"`
+	Port      int    `json:"port"`
+	PID       int    `json:"pid"`
+}
+
+// REGISTER_ATTEMPTS is how many times the announcement is POSTed to
+// -register-url before giving up, waiting REGISTER_BACKOFF longer each time.
+const (
+	REGISTER_ATTEMPTS = 5
+	REGISTER_BACKOFF  = 2 * time.Second
+)
+
+// writeURLFile writes the server's URL to path, followed by a newline. It is
+// written to a temporary file first and renamed into place, so a script
+// polling for it never reads half of it.
+func writeURLFile(path string, url string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".url-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintln(tmp, url); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// register POSTs the announcement to url as JSON, retrying with backoff while
+// the registry is unreachable or answers with an error.
+func register(url string, announcement listenAnnouncement) {
+	body, err := json.Marshal(announcement)
+	if err != nil {
+		log.Printf("Not registering with %v: %v\n", url, err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for attempt := 1; ; attempt++ {
+		err := postAnnouncement(client, url, body)
+		if err == nil {
+			log.Printf("Registered %v with %v\n", announcement.URL, url)
+			return
+		}
+		if attempt == REGISTER_ATTEMPTS {
+			log.Printf("Giving up registering with %v: %v\n", url, err)
+			return
+		}
+		log.Printf("Registering with %v failed: %v; retrying\n", url, err)
+		time.Sleep(REGISTER_BACKOFF * time.Duration(attempt))
+	}
+}
+
+func postAnnouncement(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("registry answered %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"elevate2024/internal/server"
+)
+
+// An adminCommand is a subcommand that manages a running server over its
+// admin API.
+type adminCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(flags *adminFlags, args []string) error
+}
+
+// ADMIN_COMMANDS are the subcommands besides serve, in the order usage lists
+// them.
+var ADMIN_COMMANDS = []adminCommand{
+	{"top", "top [-n count]", "print the top of the board", runTop},
+	{"reset", "reset -yes [-totp code]", "clear the board", runReset},
+	{"export", "export [-format csv|json] [-o file]", "write every score on the board to stdout or a file", runExport},
+	{"import", "import [-format csv|json] file|-", "add scores collected elsewhere, e.g. a paper backup round", runImport},
+	{"ban", "ban [-reason text] [-lift] address", "shadow-hide a client's scores from now on, or -lift the ban", runBan},
+	{"monitor", "monitor [-public url]", "follow the board, submissions and load live in the terminal", runMonitor},
+}
+
+// usage describes every subcommand.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [serve] [flags]\n       %s command [flags] [args]\n\ncommands:\n", os.Args[0], os.Args[0])
+	fmt.Fprintf(out, "  %-40s %s\n", "serve", "run the server (the default)")
+	for _, command := range ADMIN_COMMANDS {
+		fmt.Fprintf(out, "  %-40s %s\n", command.usage, command.help)
+	}
+	fmt.Fprintf(out, "\nRun %s command -h for a command's flags. The server's flags are:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// adminMain runs the subcommand name with its arguments and exits if it
+// fails.
+func adminMain(name string, args []string) {
+	i := slices.IndexFunc(ADMIN_COMMANDS, func(c adminCommand) bool { return c.name == name })
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if err := ADMIN_COMMANDS[i].run(newAdminFlags(ADMIN_COMMANDS[i]), args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// adminFlags are the flags every admin command takes to reach the server.
+// Commands add their own before calling parse.
+type adminFlags struct {
+	*flag.FlagSet
+	server string
+	pw     string
+	pwFile string
+	totp   string
+	cert   string
+	key    string
+	ca     string
+}
+
+func newAdminFlags(command adminCommand) *adminFlags {
+	f := &adminFlags{FlagSet: flag.NewFlagSet(command.name, flag.ExitOnError)}
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "usage: %s %s\n\nTo %s.\n\n", os.Args[0], command.usage, command.help)
+		f.PrintDefaults()
+	}
+	f.StringVar(&f.server, "server", os.Getenv("SERVER_URL"), "URL of the server's admin routes, e.g. http://192.168.1.10:8080 (default $SERVER_URL)")
+	f.StringVar(&f.pw, "pw", "", "admin password (default $ADMIN_PASSWORD)")
+	f.StringVar(&f.pwFile, "pw-file", "", "file containing the admin password")
+	f.StringVar(&f.totp, "totp", "", "current TOTP code, if the server requires one for destructive actions")
+	f.StringVar(&f.cert, "cert", "", "client certificate, if the admin routes require one")
+	f.StringVar(&f.key, "key", "", "key for -cert")
+	f.StringVar(&f.ca, "ca", "", "CA bundle to verify the server's certificate with (default: the system roots)")
+	return f
+}
+
+// parse parses args and connects to the server.
+func (f *adminFlags) parse(args []string) (*adminClient, error) {
+	f.Parse(args)
+	if f.server == "" {
+		return nil, errors.New("set -server or $SERVER_URL to the server's URL")
+	}
+	password, _, err := loadAdminPassword(f.pw, f.pwFile, true)
+	if err != nil {
+		return nil, err
+	}
+	client, err := adminHTTPClient(f.cert, f.key, f.ca)
+	if err != nil {
+		return nil, err
+	}
+	return &adminClient{
+		url:      strings.TrimSuffix(f.server, "/"),
+		password: password,
+		totp:     f.totp,
+		client:   client,
+	}, nil
+}
+
+// adminHTTPClient makes a client that presents cert, if given, and trusts ca,
+// if given, for admin listeners set up with -admin-client-ca.
+func adminHTTPClient(cert string, key string, ca string) (*http.Client, error) {
+	if cert == "" && ca == "" {
+		return &http.Client{Timeout: time.Minute}, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %v", ca)
+		}
+	}
+	return &http.Client{Timeout: time.Minute, Transport: &http.Transport{TLSClientConfig: config}}, nil
+}
+
+type adminClient struct {
+	url      string
+	password string
+	totp     string
+	client   *http.Client
+}
+
+// do sends an authenticated request, returning the response if it succeeded
+// and the server's message as the error if not.
+func (a *adminClient) do(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, a.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("admin", a.password)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		if resp.StatusCode == http.StatusForbidden && len(bytes.TrimSpace(message)) == 0 {
+			message = []byte("wrong admin password, or this address may not use admin routes")
+		}
+		return nil, fmt.Errorf("%v %v: %v %s", method, path, resp.Status, bytes.TrimSpace(message))
+	}
+	return resp, nil
+}
+
+// form encodes values, adding the TOTP code if there is one.
+func (a *adminClient) form(values url.Values) (string, io.Reader) {
+	if a.totp != "" {
+		values.Set("totp", a.totp)
+	}
+	return "application/x-www-form-urlencoded", strings.NewReader(values.Encode())
+}
+
+func runTop(flags *adminFlags, args []string) error {
+	n := flags.Int("n", 10, "how many scores to print")
+	a, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do("GET", fmt.Sprintf("/scores?top=%d", *n), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var scores []server.Score
+	if err := json.NewDecoder(resp.Body).Decode(&scores); err != nil {
+		return err
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "RANK\tNAME\tHEALTH\tTIME\tDIFFICULTY\tSCORE\tID")
+	for i, score := range scores {
+		fmt.Fprintf(out, "%d\t%s\t%d\t%.2fs\t%s\t%g\t%s\n", i+1, score.PlayerName, score.RemainingHealth, score.Elapsed, score.Difficulty, score.Normalized, score.ID)
+	}
+	return out.Flush()
+}
+
+func runReset(flags *adminFlags, args []string) error {
+	yes := flags.Bool("yes", false, "confirm clearing the board")
+	a, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		return errors.New("reset clears every score on the board; pass -yes to go ahead")
+	}
+	contentType, body := a.form(url.Values{})
+	resp, err := a.do("POST", "/reset", contentType, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Println("Cleared the board")
+	return nil
+}
+
+func runExport(flags *adminFlags, args []string) error {
+	format := flags.String("format", "csv", "csv or json")
+	path := flags.String("o", "", "file to write to (default stdout)")
+	a, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do("GET", "/admin/export?format="+url.QueryEscape(*format), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	out := io.Writer(os.Stdout)
+	if *path != "" {
+		f, err := os.Create(*path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func runImport(flags *adminFlags, args []string) error {
+	format := flags.String("format", "", "csv or json (default: csv for .csv files, else json)")
+	a, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("import needs exactly one file, or - for stdin")
+	}
+	name := flags.Arg(0)
+	in := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if *format == "" && strings.EqualFold(filepath.Ext(name), ".csv") {
+		*format = "csv"
+	}
+	contentType := "application/json"
+	if *format == "csv" {
+		contentType = "text/csv"
+	}
+
+	resp, err := a.do("POST", "/admin/import", contentType, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Imported int `json:"imported"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %v scores\n", result.Imported)
+	return nil
+}
+
+func runBan(flags *adminFlags, args []string) error {
+	reason := flags.String("reason", "", "why, for the honeypot list")
+	lift := flags.Bool("lift", false, "lift the ban instead")
+	a, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("ban needs exactly one client address, as listed by GET /admin/honeypot")
+	}
+	address := flags.Arg(0)
+	path := "/admin/honeypot/" + url.PathEscape(address)
+	if *lift {
+		resp, err := a.do("DELETE", path, "", nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		fmt.Printf("Lifted the ban on %v\n", address)
+		return nil
+	}
+
+	contentType, body := a.form(url.Values{"reason": {*reason}})
+	resp, err := a.do("POST", path, contentType, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Printf("Banned %v\n", address)
+	return nil
+}
@@ -0,0 +1,194 @@
+// Package client speaks the high score server's protocol for Go game clients
+// and test tooling: start tokens, score submission and the live board, with
+// retries and backoff so a flaky venue network doesn't lose runs.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"elevate2024/internal/api"
+	"elevate2024/internal/store"
+	"elevate2024/internal/token"
+)
+
+type (
+	Score      = store.Score
+	Token      = token.Token
+	Finish     = token.Finish
+	Submission = api.Submission
+	Submitted  = api.Submitted
+)
+
+// Defaults for a Client's retries: up to RETRIES more attempts, waiting
+// BACKOFF before the first and twice as long before each after, at most
+// MAX_BACKOFF.
+const (
+	RETRIES     = 4
+	BACKOFF     = 250 * time.Millisecond
+	MAX_BACKOFF = 10 * time.Second
+)
+
+// A Client talks to one server. The zero values of its optional fields mean
+// the defaults.
+type Client struct {
+	// URL is the server's base URL, e.g. "http://192.168.1.10:8080".
+	URL  string
+	HTTP *http.Client
+	// Retries is how many times a failed request is retried; negative
+	// disables retries.
+	Retries int
+	Backoff time.Duration
+}
+
+// New returns a Client for the server at url.
+func New(url string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/")}
+}
+
+// A StatusError is an answer the server gave that wasn't a success.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server answered %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// temporary reports whether a request answered with code could succeed if
+// sent again unchanged: the server was busy or a proxy couldn't reach it.
+func temporary(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// GetToken starts a run.
+func (c *Client) GetToken(ctx context.Context) (Token, error) {
+	var token Token
+	err := c.call(ctx, "GET", "/start", nil, true, &token)
+	return token, err
+}
+
+// FinishRun tells the server a run has ended, for it to sign the time.
+func (c *Client) FinishRun(ctx context.Context, token Token) (Finish, error) {
+	var finish Finish
+	err := c.call(ctx, "POST", "/finish", struct {
+		Token Token `json:"token"`
+	}{token}, true, &finish)
+	return finish, err
+}
+
+// SubmitScore records a run. A submission is only retried when the server
+// turned it away unread, as /record has no way to tell a retry from a second
+// run; if the connection fails after sending, the error is returned.
+func (c *Client) SubmitScore(ctx context.Context, submission Submission) (Submitted, error) {
+	var submitted Submitted
+	err := c.call(ctx, "POST", "/record", submission, false, &submitted)
+	return submitted, err
+}
+
+// call sends a JSON request and decodes the JSON answer into out. Temporary
+// statuses are always retried, and network errors too if idempotent.
+func (c *Client) call(ctx context.Context, method string, path string, in any, idempotent bool, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var wait time.Duration
+		var retry bool
+		wait, retry, err = c.try(ctx, method, path, body, idempotent, out)
+		if !retry || attempt >= c.retries() {
+			return err
+		}
+		if wait == 0 {
+			wait = c.backoff(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// try makes one attempt at a call, returning whether it may be retried and,
+// if the server said, how long to wait first.
+func (c *Client) try(ctx context.Context, method string, path string, body []byte, idempotent bool, out any) (time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return 0, idempotent && ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		err := &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+		return retryAfter(resp), temporary(resp.StatusCode), err
+	}
+	return 0, false, json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) retries() int {
+	switch {
+	case c.Retries < 0:
+		return 0
+	case c.Retries == 0:
+		return RETRIES
+	}
+	return c.Retries
+}
+
+// backoff is how long to wait before retry attempt+1.
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.Backoff
+	if wait == 0 {
+		wait = BACKOFF
+	}
+	for range attempt {
+		wait *= 2
+		if wait >= MAX_BACKOFF {
+			return MAX_BACKOFF
+		}
+	}
+	return wait
+}
+
+// retryAfter reads a Retry-After header given in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, MAX_BACKOFF)
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"elevate2024/internal/server"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	config := server.DefaultConfig()
+	config.AdminPassword = "secret"
+	config.QuarantineThreshold = 0
+	s, err := server.NewHighScoreServer(server.WithConfig(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.Routes(mux, mux, fstest.MapFS{"index.html": {Data: []byte("game")}})
+	ts := httptest.NewServer(s.SecureHeaders(mux))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestSubmitAndStream(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL + "/")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	boards, err := c.StreamScores(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board := <-boards; len(board) != 0 {
+		t.Fatalf("first board = %v, want empty", board)
+	}
+
+	for i, health := range []int{50, 10} {
+		token, err := c.GetToken(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		finish, err := c.FinishRun(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		submitted, err := c.SubmitScore(ctx, Submission{PlayerName: "AAA", RemainingHealth: health, Token: token, Finish: &finish})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if submitted.Status != "accepted" || submitted.Rank != 1 || submitted.ID == "" {
+			t.Errorf("submission %d = %+v, want accepted at rank 1", i, submitted)
+		}
+	}
+
+	for board := range boards {
+		if len(board) == 2 {
+			if board[0].RemainingHealth != 10 || board[1].RemainingHealth != 50 {
+				t.Errorf("board = %+v, want health 10 then 50", board)
+			}
+			break
+		}
+	}
+
+	var statusErr *StatusError
+	_, err = c.SubmitScore(ctx, Submission{PlayerName: "TOOLONG"})
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusBadRequest {
+		t.Errorf("bad submission: err = %v, want a 400", err)
+	}
+
+	cancel()
+	for range boards {
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"accepted","id":"x","rank":4,"url":"/s/x"}`))
+	}))
+	defer ts.Close()
+
+	c := &Client{URL: ts.URL, Backoff: time.Millisecond}
+	submitted, err := c.SubmitScore(context.Background(), Submission{PlayerName: "AAA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 || submitted.Rank != 4 || submitted.Status != "accepted" {
+		t.Errorf("after %d calls got %+v", calls.Load(), submitted)
+	}
+
+	calls.Store(0)
+	c.Retries = -1
+	if _, err := c.SubmitScore(context.Background(), Submission{}); err == nil || calls.Load() != 1 {
+		t.Errorf("without retries: err = %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{}
+	if got := c.backoff(0); got != BACKOFF {
+		t.Errorf("backoff(0) = %v, want %v", got, BACKOFF)
+	}
+	if got := c.backoff(2); got != 4*BACKOFF {
+		t.Errorf("backoff(2) = %v, want %v", got, 4*BACKOFF)
+	}
+	if got := c.backoff(20); got != MAX_BACKOFF {
+		t.Errorf("backoff(20) = %v, want %v", got, MAX_BACKOFF)
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := ": keep-alive\n\nevent: scores\ndata: [1,\ndata: 2]\n\ndata: {}\n\nevent: empty\n\n"
+	var got []string
+	err := ReadEvents(strings.NewReader(stream), func(event string, data []byte) error {
+		got = append(got, event+" "+string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"scores [1,\n2]", "message {}"}; !slices.Equal(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
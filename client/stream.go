@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"elevate2024/internal/api"
+	"elevate2024/internal/broadcast"
+	"elevate2024/internal/store"
+)
+
+// StreamScores follows the board over /events, keeping a copy up to date from
+// the server's snapshots and patches. The channel gets the whole board each
+// time it changes; a slow reader only ever sees the latest. Dropped
+// connections are reopened with backoff, and the channel is closed once ctx
+// is done. The error is for a first connection that fails outright.
+func (c *Client) StreamScores(ctx context.Context) (<-chan []Score, error) {
+	resp, err := c.openStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	boards := make(chan []Score, 1)
+	go func() {
+		defer close(boards)
+		for attempt := 0; ; {
+			if resp != nil {
+				if c.readStream(resp, boards) {
+					attempt = 0
+				}
+				resp.Body.Close()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.backoff(attempt)):
+			}
+			attempt++
+			// A failed reconnection is retried as long as the caller is
+			// still listening.
+			resp, _ = c.openStream(ctx)
+		}
+	}()
+	return boards, nil
+}
+
+func (c *Client) openStream(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.URL+"/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{Code: resp.StatusCode, Message: resp.Status}
+	}
+	return resp, nil
+}
+
+// ReadEvents reads server-sent events from r until it ends or handle returns
+// an error, which ReadEvents then returns. See broadcast.ReadEvents.
+func ReadEvents(r io.Reader, handle func(event string, data []byte) error) error {
+	return broadcast.ReadEvents(r, handle)
+}
+
+// errOutOfStep ends a board stream whose copy can't be patched.
+var errOutOfStep = errors.New("board out of step")
+
+// readStream reads board events until the connection ends, sending each new
+// board, and reports whether it got any. A patch that doesn't fit the board
+// ends the connection, so the next one starts from a fresh snapshot.
+func (c *Client) readStream(resp *http.Response, boards chan []Score) bool {
+	var board []Score
+	received := false
+	ReadEvents(resp.Body, func(event string, data []byte) error {
+		switch event {
+		case "scores":
+			var scores []Score
+			if err := json.Unmarshal(data, &scores); err != nil {
+				return err
+			}
+			board = scores
+		case "patch":
+			var patch api.BoardPatch
+			if board == nil {
+				return errOutOfStep
+			}
+			if err := json.Unmarshal(data, &patch); err != nil {
+				return err
+			}
+			var ok bool
+			if board, ok = store.Apply(board, patch.Ops); !ok {
+				return errOutOfStep
+			}
+		default:
+			return nil
+		}
+		received = true
+		send(boards, board)
+		return nil
+	})
+	return received
+}
+
+// send replaces whatever board the reader hasn't taken yet with board.
+func send(boards chan []Score, board []Score) {
+	for {
+		select {
+		case boards <- board:
+			return
+		default:
+		}
+		select {
+		case <-boards:
+		default:
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Keeps a copy of the top scores from an /events stream up to date. The
+// server sends a full "scores" snapshot on connect and now and then, and
+// "patch" events in between; onScores gets the whole board either way.
+const watchScores = (eventSource, onScores) => {
+  let scores = [];
+  eventSource.addEventListener("scores", (event) => {
+    scores = JSON.parse(event.data);
+    onScores(scores);
+  });
+  eventSource.addEventListener("patch", (event) => {
+    scores = scores.slice();
+    for (const op of JSON.parse(event.data).ops) {
+      if (op.op === "remove") {
+        scores.splice(op.rank - 1, 1);
+      } else if (op.op === "insert") {
+        scores.splice(op.rank - 1, 0, op.score);
+      }
+    }
+    onScores(scores);
+  });
+};
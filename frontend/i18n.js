@@ -0,0 +1,21 @@
+// Strings from the server in the browser's language. loadStrings fills in
+// every element with a data-i18n attribute; t formats the rest. Both keep the
+// English they were given if the server doesn't answer.
+let strings = {};
+
+const loadStrings = async () => {
+  try {
+    const res = await fetch("strings");
+    const body = await res.json();
+    strings = body.strings;
+    document.documentElement.lang = body.language;
+  } catch {
+    return;
+  }
+  for (const el of document.querySelectorAll("[data-i18n]")) {
+    el.textContent = strings[el.dataset.i18n] || el.textContent;
+  }
+};
+
+const t = (key, english, vars = {}) =>
+  (strings[key] || english).replace(/\{(\w+)\}/g, (_, name) => vars[name]);
@@ -0,0 +1,18 @@
+// Applies the event's theme from config.json: colors and fonts become the
+// CSS custom properties --theme-NAME and --font-NAME, which pages use with
+// their own colors as fallbacks. Returns the config, for the logos.
+const loadTheme = async () => {
+  try {
+    const config = await (await fetch("config.json")).json();
+    const style = document.documentElement.style;
+    for (const [name, color] of Object.entries(config.theme.colors)) {
+      style.setProperty(`--theme-${name}`, color);
+    }
+    for (const [role, font] of Object.entries(config.theme.fonts)) {
+      style.setProperty(`--font-${role}`, font);
+    }
+    return config;
+  } catch {
+    return null;
+  }
+};
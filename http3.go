@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// loadTLSConfig loads a certificate for a TLS listener. Offering "h2" is what
+// lets net/http serve HTTP/2 on it; clients that don't ask for it still get
+// HTTP/1.1.
+func loadTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// serveHTTP3 serves handler over QUIC on the UDP port matching the TCP
+// listener at addr. It returns handler wrapped to advertise HTTP/3 to TCP
+// clients with Alt-Svc, so browsers switch over on their next request.
+func serveHTTP3(addr *net.TCPAddr, config *tls.Config, handler http.Handler) (http.Handler, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone})
+	if err != nil {
+		return nil, err
+	}
+	server := &http3.Server{
+		Port:      addr.Port,
+		TLSConfig: http3.ConfigureTLSConfig(config),
+		Handler:   handler,
+	}
+	go func() {
+		panic(server.Serve(conn))
+	}()
+	log.Printf("Serving HTTP/3 on udp %v\n", conn.LocalAddr())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			server.SetQUICHeaders(w.Header())
+		}
+		handler.ServeHTTP(w, r)
+	}), nil
+}
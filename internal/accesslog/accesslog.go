@@ -0,0 +1,135 @@
+// Package accesslog writes an HTTP access log in the Combined Log Format, the
+// one Apache and nginx use, so standard tools like goaccess can read it.
+package accesslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CLF_TIME is the timestamp layout of the Common and Combined Log Formats.
+const CLF_TIME = "02/Jan/2006:15:04:05 -0700"
+
+// SECRET_PARAMS are query parameters that carry credentials, for clients
+// like EventSource that can't send them in headers. Their values are never
+// logged.
+var SECRET_PARAMS = []string{"pw", "totp", "token", "credential"}
+
+// responseRecorder notes what a handler sent, while passing it straight on.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses such as /events working through the log.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.status == 0 {
+			r.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over for WebSockets, which are logged as
+// switching protocols.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Handler logs every request to next in the Combined Log Format once it has
+// been answered. host gives the address to log for the client, so it can be
+// masked like any other stored address.
+func Handler(next http.Handler, w io.Writer, host func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: rw}
+		next.ServeHTTP(rec, r)
+		fmt.Fprint(w, line(r, host(r), start, rec.status, rec.bytes))
+	})
+}
+
+// line formats one request as
+//
+//	host ident user [time] "request" status bytes "referer" "user-agent"
+func line(r *http.Request, host string, start time.Time, status int, bytes int64) string {
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = strings.ReplaceAll(quote(name), " ", "%20")
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		orDash(host), user, start.Format(CLF_TIME),
+		r.Method, quote(redact(r.URL)), r.Proto, status, size,
+		orDash(quote(r.Referer())), orDash(quote(r.UserAgent())))
+}
+
+// redact returns the request URI with the values of SECRET_PARAMS blanked
+// out, leaving the rest of the query as the client sent it.
+func redact(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && slices.Contains(SECRET_PARAMS, name) {
+			params[i] = key + "=REDACTED"
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+	return redacted.RequestURI()
+}
+
+// quote escapes what a client sent so it can't break the line or its quoting.
+func quote(s string) string {
+	s = strconv.Quote(s)
+	return s[1 : len(s)-1]
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+package accesslog
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// COMBINED matches a Combined Log Format line as goaccess's COMBINED format
+// reads it.
+var COMBINED = regexp.MustCompile(`^(\S+) - (\S+) \[([^\]]+)\] "(\S+) (\S+) (\S+)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"\n$`)
+
+func TestHandler(t *testing.T) {
+	var log bytes.Buffer
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	}), &log, func(r *http.Request) string { return "203.0.113.7" })
+
+	req := httptest.NewRequest("GET", "/scores?top=5", nil)
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	req.SetBasicAuth("admin user", "secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/missing", nil))
+
+	lines := bytes.SplitAfter(log.Bytes(), []byte("\n"))
+	m := COMBINED.FindStringSubmatch(string(lines[0]))
+	if m == nil {
+		t.Fatalf("line %q isn't in the combined format", lines[0])
+	}
+	if _, err := time.Parse(CLF_TIME, m[3]); err != nil {
+		t.Error(err)
+	}
+	want := []string{"203.0.113.7", "admin%20user", "GET", "/scores?top=5", "HTTP/1.1", "200", "5", "https://example.com/", `curl/8.0 \"quoted\"`}
+	got := append(m[1:3], m[4:]...)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("field %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if bytes.Contains(log.Bytes(), []byte("secret")) {
+		t.Error("password logged")
+	}
+
+	if m := COMBINED.FindStringSubmatch(string(lines[1])); m == nil || m[7] != "404" || m[2] != "-" || m[9] != "-" {
+		t.Errorf("line %q, want a 404 with no user or referer", lines[1])
+	}
+}
+
+func TestHandlerRedactsSecrets(t *testing.T) {
+	var log bytes.Buffer
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &log, func(r *http.Request) string { return "203.0.113.7" })
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin/events?pw=hunter2&top=5&totp=123456&token=abc&credential=xyz", nil))
+
+	m := COMBINED.FindStringSubmatch(log.String())
+	if m == nil {
+		t.Fatalf("line %q isn't in the combined format", log.String())
+	}
+	if want := "/admin/events?pw=REDACTED&top=5&totp=REDACTED&token=REDACTED&credential=REDACTED"; m[5] != want {
+		t.Errorf("logged %q, want %q", m[5], want)
+	}
+}
+
+func TestFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := Open(path, 12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, s := range []string{"12345\n", "6789\n", "abcdef\n", "g\n"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files = %v, want one", rotated)
+	}
+	if data, _ := os.ReadFile(rotated[0]); string(data) != "12345\n6789\n" {
+		t.Errorf("rotated file = %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "abcdef\ng\n" {
+		t.Errorf("current file = %q", data)
+	}
+
+	f.Interval = time.Nanosecond
+	f.Write([]byte("h\n"))
+	if rotated, _ := filepath.Glob(path + ".*"); len(rotated) != 2 {
+		t.Errorf("rotated files after the interval = %v, want two", rotated)
+	}
+}
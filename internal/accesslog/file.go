@@ -0,0 +1,83 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A File is a log file that rotates once it grows past MaxSize bytes or has
+// been open for Interval, whichever comes first; zero turns either off. The
+// old file is renamed with the time it was opened, and kept. It is safe for
+// concurrent use.
+type File struct {
+	Path     string
+	MaxSize  int64
+	Interval time.Duration
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// Open opens path for appending, creating it if need be.
+func Open(path string, maxSize int64, interval time.Duration) (*File, error) {
+	f := &File{Path: path, MaxSize: maxSize, Interval: interval}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one. If the file
+// can't be moved, logging carries on in it.
+func (f *File) rotate() error {
+	f.file.Close()
+	name := f.Path + "." + f.opened.Format("20060102-150405")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s.%s.%d", f.Path, f.opened.Format("20060102-150405"), i)
+	}
+	os.Rename(f.Path, name)
+	return f.open()
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	old := f.Interval > 0 && time.Since(f.opened) >= f.Interval
+	if full || old {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,86 @@
+// Package api defines the request and response bodies of the server's public
+// endpoints that aren't stored types themselves. Together with store.Score
+// and the token types they are the schema shared by the server, the Go client
+// and the JavaScript client the server generates at /sdk/highscore.js.
+package api
+
+import (
+	"elevate2024/internal/store"
+	"elevate2024/internal/token"
+)
+
+// A Submission is a finished run, as sent to /record.
+type Submission struct {
+	PlayerName      string      `json:"player_name"`
+	Elapsed         float64     `json:"elapsed"`
+	RemainingHealth int         `json:"remaining_health"`
+	Team            string      `json:"team,omitempty"`
+	Difficulty      string      `json:"difficulty,omitempty"`
+	Email           string      `json:"email,omitempty"`
+	Token           token.Token `json:"token"`
+	// Finish, from /finish, lets the server time the run itself. Servers
+	// running with -finish-mode required reject submissions without it.
+	Finish *token.Finish `json:"finish,omitempty"`
+	// Telemetry is optional gameplay data for the post-event heatmap.
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
+}
+
+// Telemetry describes how a run went, for GET /stats/heatmap. It is
+// aggregated as it arrives and never stored with the score.
+type Telemetry struct {
+	// where the player died, at most MAX_DEATHS times
+	Deaths []Position `json:"deaths,omitempty"`
+	// the furthest level reached, for games that have them
+	Level int `json:"level,omitempty"`
+}
+
+// MAX_DEATHS is the most deaths a submission's telemetry may report.
+const MAX_DEATHS = 16
+
+// A Position is a point on the play area as fractions of its width and
+// height from the top left, so screens of any size can be compared.
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Submitted is the answer to a submission. Status is "accepted", or
+// "pending_review" if a moderator has to approve the score first, in which
+// case it has no ID or rank yet, or "practice" for a practice run, which
+// only has its rank on the practice board. An accepted score comes with a Receipt the
+// player can have checked at /receipts/{id}/verify. If another player
+// already had the name on the board, Warning says so and Suggestion offers a
+// variant of it nobody uses.
+type Submitted struct {
+	Status     string         `json:"status"`
+	ID         string         `json:"id,omitempty"`
+	Rank       int            `json:"rank,omitempty"`
+	URL        string         `json:"url,omitempty"`
+	Receipt    *token.Receipt `json:"receipt,omitempty"`
+	Warning    string         `json:"warning,omitempty"`
+	Suggestion string         `json:"suggestion,omitempty"`
+}
+
+// A BoardPatch is sent on /events in place of a full "scores" snapshot when
+// only a few rows changed.
+type BoardPatch struct {
+	Ops []store.PatchOp `json:"ops"`
+}
+
+// A BoardDiff is the answer to GET /scores/diff: how the board changed from
+// version From to Version, so a client that missed events can catch up
+// without redrawing the whole board.
+type BoardDiff struct {
+	From    uint64 `json:"from"`
+	Version uint64 `json:"version"`
+	// the rows now on the board that weren't at From, at their current
+	// ranks, and the rows that were but aren't any more, at their ranks then
+	Added   []RankedScore `json:"added"`
+	Removed []RankedScore `json:"removed"`
+}
+
+// A RankedScore is a score and where it stands on the board.
+type RankedScore struct {
+	Rank  int         `json:"rank"`
+	Score store.Score `json:"score"`
+}
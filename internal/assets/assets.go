@@ -0,0 +1,219 @@
+// Package assets serves the embedded frontend with pre-compressed variants
+// of its files. Precompress runs at build time (see go generate in the main
+// package), writing a .gz, and with the brotli tool a .br, next to each
+// compressible file, and a manifest of them all. The server reads the
+// manifest back from the embedded files and picks a variant per request by
+// Accept-Encoding, so nothing is compressed while hundreds of phones load the
+// page at once.
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MANIFEST is the name of the manifest in the directory Precompress ran on.
+const MANIFEST = "assets-manifest.json"
+
+// COMPRESSIBLE are the extensions of files worth compressing; images and
+// sounds already are.
+var COMPRESSIBLE = []string{".html", ".js", ".css", ".json", ".frag", ".svg", ".txt", ".md"}
+
+// An Encoding is a Content-Encoding a variant can be stored in, and the
+// extension its file has.
+type Encoding struct {
+	Name      string
+	Extension string
+}
+
+// ENCODINGS are the encodings served, most preferred first.
+var ENCODINGS = []Encoding{{"br", ".br"}, {"gzip", ".gz"}}
+
+// A File is a manifest entry: the file's size and hash, and the size of each
+// variant of it.
+type File struct {
+	Size      int64            `json:"size"`
+	SHA256    string           `json:"sha256"`
+	Encodings map[string]int64 `json:"encodings,omitempty"`
+}
+
+// A Manifest lists the files Precompress saw, by slash-separated path.
+type Manifest struct {
+	Files map[string]File `json:"files"`
+}
+
+// Precompress writes the variants of every compressible file under dir, and
+// the manifest. A variant is only kept if it is smaller than the original.
+// Brotli variants need the brotli command; without it there are only gzip
+// ones.
+func Precompress(dir string) (*Manifest, error) {
+	brotli, _ := exec.LookPath("brotli")
+	manifest := &Manifest{Files: map[string]File{}}
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isVariant(file) || d.Name() == MANIFEST {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		entry := File{Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+		if slices.Contains(COMPRESSIBLE, path.Ext(file)) {
+			entry.Encodings = map[string]int64{}
+			if err := writeGzip(file, data); err != nil {
+				return err
+			}
+			if brotli != "" {
+				if err := exec.Command(brotli, "-q", "11", "-f", "-k", "-o", file+".br", file).Run(); err != nil {
+					return err
+				}
+			} else {
+				// A variant left from an earlier run may be out of date.
+				os.Remove(file + ".br")
+			}
+			for _, encoding := range ENCODINGS {
+				info, err := os.Stat(file + encoding.Extension)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if info.Size() >= entry.Size {
+					os.Remove(file + encoding.Extension)
+					continue
+				}
+				entry.Encodings[encoding.Name] = info.Size()
+			}
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		manifest.Files[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return manifest, os.WriteFile(filepath.Join(dir, MANIFEST), append(data, '\n'), 0o644)
+}
+
+func isVariant(file string) bool {
+	for _, encoding := range ENCODINGS {
+		if strings.HasSuffix(file, encoding.Extension) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeGzip(file string, data []byte) error {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(file+".gz", buf.Bytes(), 0o644)
+}
+
+// Load reads the manifest from files, returning nil if Precompress hasn't
+// been run on them.
+func Load(files fs.FS) (*Manifest, error) {
+	data, err := fs.ReadFile(files, MANIFEST)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// Serve answers r with the file name from files, in the most preferred
+// encoding both the client and the manifest have, reporting false if the
+// manifest doesn't list it. The file's hash is its ETag, as embedded files
+// have no modification time to revalidate with.
+func (m *Manifest) Serve(w http.ResponseWriter, r *http.Request, files fs.FS, name string) bool {
+	if m == nil {
+		return false
+	}
+	entry, ok := m.Files[name]
+	if !ok {
+		return false
+	}
+
+	var chosen *Encoding
+	for _, encoding := range ENCODINGS {
+		if _, ok := entry.Encodings[encoding.Name]; ok && accepts(r.Header.Get("Accept-Encoding"), encoding.Name) {
+			chosen = &encoding
+			break
+		}
+	}
+	stored, tag := name, entry.SHA256[:16]
+	if chosen != nil {
+		stored, tag = name+chosen.Extension, tag+"-"+chosen.Name
+	}
+	data, err := fs.ReadFile(files, stored)
+	if err != nil {
+		return false
+	}
+
+	h := w.Header()
+	if entry.Encodings != nil {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if chosen != nil {
+		h.Set("Content-Encoding", chosen.Name)
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	// Each variant is a different representation, so it has its own tag.
+	h.Set("ETag", `"`+tag+`"`)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	return true
+}
+
+// accepts reports whether an Accept-Encoding header allows encoding.
+func accepts(header string, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
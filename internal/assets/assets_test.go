@@ -0,0 +1,91 @@
+package assets
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrecompressAndServe(t *testing.T) {
+	dir := t.TempDir()
+	page := strings.Repeat("<p>high scores</p>\n", 100)
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sprite.png"), []byte("not really a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Precompress(dir); err != nil {
+		t.Fatal(err)
+	}
+	files := os.DirFS(dir)
+	manifest, err := Load(files)
+	if err != nil || manifest == nil {
+		t.Fatalf("Load = %v, %v", manifest, err)
+	}
+	if _, ok := manifest.Files["index.html"].Encodings["gzip"]; !ok {
+		t.Errorf("index.html has no gzip variant: %+v", manifest.Files["index.html"])
+	}
+	if encodings := manifest.Files["sprite.png"].Encodings; encodings != nil {
+		t.Errorf("sprite.png was compressed: %v", encodings)
+	}
+
+	serve := func(name string, header http.Header) *http.Response {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/"+name, nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		if !manifest.Serve(w, r, files, name) {
+			t.Fatalf("Serve(%v) = false", name)
+		}
+		return w.Result()
+	}
+
+	resp := serve("index.html", http.Header{"Accept-Encoding": {"gzip, deflate, br;q=0"}})
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("headers = %v", resp.Header)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != page {
+		t.Errorf("decompressed body = %q", body)
+	}
+
+	plain := serve("index.html", http.Header{})
+	if plain.Header.Get("Content-Encoding") != "" || plain.Header.Get("ETag") == resp.Header.Get("ETag") {
+		t.Errorf("identity headers = %v", plain.Header)
+	}
+	if cached := serve("index.html", http.Header{"If-None-Match": {plain.Header.Get("ETag")}}); cached.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidation: status = %v", cached.Status)
+	}
+
+	if manifest.Serve(httptest.NewRecorder(), httptest.NewRequest("GET", "/new.js", nil), files, "new.js") {
+		t.Error("served a file missing from the manifest")
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		header, encoding string
+		want             bool
+	}{
+		{"gzip, br", "br", true},
+		{"gzip;q=0.5", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"*", "br", true},
+		{"deflate", "gzip", false},
+		{"", "gzip", false},
+	}
+	for _, tt := range tests {
+		if got := accepts(tt.header, tt.encoding); got != tt.want {
+			t.Errorf("accepts(%q, %q) = %v, want %v", tt.header, tt.encoding, got, tt.want)
+		}
+	}
+}
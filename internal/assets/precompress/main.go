@@ -0,0 +1,30 @@
+// Command precompress writes pre-compressed variants of the frontend's files
+// and their manifest, for the server to embed and serve. It runs with go
+// generate:
+//
+//	go generate . && go build
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"elevate2024/internal/assets"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s dir\n", os.Args[0])
+		os.Exit(2)
+	}
+	manifest, err := assets.Precompress(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	variants := 0
+	for _, file := range manifest.Files {
+		variants += len(file.Encodings)
+	}
+	log.Printf("Wrote %v variants of %v files to %v\n", variants, len(manifest.Files), os.Args[1])
+}
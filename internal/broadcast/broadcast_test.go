@@ -0,0 +1,225 @@
+package broadcast
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHubDropsForSlowSubscribers(t *testing.T) {
+	hub := Hub[int]{Buffer: 2}
+	ch := hub.Subscribe()
+	for i := range 5 {
+		hub.Publish(i)
+	}
+	if got := len(ch); got != 2 {
+		t.Fatalf("subscriber holds %v values, want 2", got)
+	}
+	if got := <-ch; got != 0 {
+		t.Errorf("first value = %v, want 0", got)
+	}
+
+	hub.Unsubscribe(ch)
+	<-ch
+	hub.Publish(9)
+	if got := len(ch); got != 0 {
+		t.Errorf("unsubscribed channel received %v values", got)
+	}
+}
+
+func TestHubLen(t *testing.T) {
+	var hub Hub[int]
+	a, _ := hub.Subscribe(), hub.Subscribe()
+	hub.Unsubscribe(a)
+	if got := hub.Len(); got != 1 {
+		t.Errorf("Len = %v, want 1", got)
+	}
+}
+
+func TestHubDefaultBuffer(t *testing.T) {
+	var hub Hub[string]
+	if got := cap(hub.Subscribe()); got != DEFAULT_BUFFER {
+		t.Errorf("cap = %v, want %v", got, DEFAULT_BUFFER)
+	}
+}
+
+type testEvent struct {
+	Type string `json:"type"`
+}
+
+func (e testEvent) EventName() string {
+	return e.Type
+}
+
+// readFrames serves stream and collects the SSE frames it writes until n have
+// arrived.
+func readFrames(t *testing.T, stream Stream[testEvent], n int) []string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Serve(w, r, stream)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	var frames []string
+	var frame strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for len(frames) < n && scanner.Scan() {
+		if scanner.Text() != "" {
+			frame.WriteString(scanner.Text() + "\n")
+			continue
+		}
+		frames = append(frames, frame.String())
+		frame.Reset()
+	}
+	return frames
+}
+
+func TestServeNamedSnapshotsAndPatches(t *testing.T) {
+	var mutex sync.Mutex
+	snapshots := []string{"[1]", "[1]", "[1,2]", "[1,2,3]"}
+	snapshot := func() ([]byte, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		data := snapshots[0]
+		if len(snapshots) > 1 {
+			snapshots = snapshots[1:]
+		}
+		return []byte(data), nil
+	}
+	patch := func(old, new []byte) ([]byte, bool) {
+		// Only the last change can be patched.
+		return []byte(`"+3"`), string(new) == "[1,2,3]"
+	}
+	events := make(chan testEvent, 1)
+	events <- testEvent{Type: "hello"}
+
+	frames := readFrames(t, Stream[testEvent]{
+		Name:      "scores",
+		Snapshot:  snapshot,
+		Patch:     patch,
+		PatchName: "patch",
+		Events:    events,
+	}, 4)
+	want := []string{
+		"event: hello\ndata: {\"type\":\"hello\"}\n",
+		"event: scores\ndata: [1]\n",
+		// the repeated [1] is skipped
+		"event: scores\ndata: [1,2]\n",
+		"event: patch\ndata: \"+3\"\n",
+	}
+	for i := range want {
+		if i >= len(frames) || frames[i] != want[i] {
+			t.Fatalf("frames = %q, want %q", frames, want)
+		}
+	}
+}
+
+func TestServeSendsInitialEventsFirst(t *testing.T) {
+	// More initial events than the channel could ever hold, which is
+	// already full.
+	var initial []testEvent
+	for i := range DEFAULT_BUFFER + 1 {
+		initial = append(initial, testEvent{Type: "e" + strconv.Itoa(i)})
+	}
+	events := make(chan testEvent, 1)
+	events <- testEvent{Type: "live"}
+
+	frames := readFrames(t, Stream[testEvent]{
+		Name:     "scores",
+		Snapshot: func() ([]byte, error) { return []byte("[]"), nil },
+		Initial:  initial,
+		Events:   events,
+	}, len(initial)+1)
+	if len(frames) != len(initial)+1 || frames[0] != "event: e0\ndata: {\"type\":\"e0\"}\n" || frames[len(initial)] != "event: live\ndata: {\"type\":\"live\"}\n" {
+		t.Errorf("frames = %q, want the initial events, then the live one", frames)
+	}
+}
+
+func TestServeUnnamedSnapshotsRepeat(t *testing.T) {
+	start := time.Now()
+	frames := readFrames(t, Stream[testEvent]{
+		Snapshot: func() ([]byte, error) { return []byte("{}"), nil },
+	}, 2)
+	for _, frame := range frames {
+		if frame != "data: {}\n" {
+			t.Errorf("frame = %q", frame)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < TICK {
+		t.Errorf("two snapshots arrived after %v, want at least one tick apart", elapsed)
+	}
+}
+
+func TestServeOutlivesServerTimeouts(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Serve(w, r, Stream[testEvent]{
+			Snapshot: func() ([]byte, error) { return []byte("{}"), nil },
+		})
+	}))
+	server.Config.ReadTimeout = TICK / 5
+	server.Config.WriteTimeout = TICK / 5
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	frames := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for frames < 3 && scanner.Scan() {
+		if scanner.Text() == "" {
+			frames++
+		}
+	}
+	if frames < 3 {
+		t.Errorf("stream ended after %v snapshots: %v", frames, scanner.Err())
+	}
+}
+
+// BenchmarkPublish fans an event out to many subscribers, as a board change
+// does to every /events client.
+func BenchmarkPublish(b *testing.B) {
+	for _, subscribers := range []int{10, 100, 1000} {
+		b.Run(strconv.Itoa(subscribers), func(b *testing.B) {
+			hub := Hub[int]{Buffer: 1}
+			var wg sync.WaitGroup
+			var channels []chan int
+			for range subscribers {
+				ch := hub.Subscribe()
+				channels = append(channels, ch)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range ch {
+					}
+				}()
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				hub.Publish(i)
+			}
+			b.StopTimer()
+			for _, ch := range channels {
+				hub.Unsubscribe(ch)
+				close(ch)
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Package broadcast fans updates out to streaming clients and writes them as
+// server-sent events.
+package broadcast
+
+import (
+	"sync"
+)
+
+// DEFAULT_BUFFER is how many values a subscriber can fall behind by before
+// it starts missing them, unless the Hub says otherwise.
+const DEFAULT_BUFFER = 16
+
+// A Hub fans values out to subscribers. Publishing never blocks: a
+// subscriber that falls behind misses values rather than holding up the
+// publisher. The zero value is ready to use.
+type Hub[T any] struct {
+	// Buffer is the channel size for new subscribers; 0 means DEFAULT_BUFFER.
+	Buffer int
+
+	mutex       sync.Mutex
+	subscribers map[chan T]struct{}
+}
+
+func (h *Hub[T]) Subscribe() chan T {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.subscribers == nil {
+		h.subscribers = map[chan T]struct{}{}
+	}
+	size := h.Buffer
+	if size == 0 {
+		size = DEFAULT_BUFFER
+	}
+	ch := make(chan T, size)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *Hub[T]) Unsubscribe(ch chan T) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.subscribers, ch)
+}
+
+func (h *Hub[T]) Publish(value T) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- value:
+		default:
+		}
+	}
+}
+
+// Len is how many subscribers there are.
+func (h *Hub[T]) Len() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.subscribers)
+}
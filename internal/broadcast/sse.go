@@ -0,0 +1,185 @@
+package broadcast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Snapshots are taken every TICK. KEEPALIVE is the longest a stream goes
+// without sending anything, and RESYNC the longest it goes without a full
+// snapshot.
+const (
+	TICK      = 500 * time.Millisecond
+	KEEPALIVE = 15 * time.Second
+	RESYNC    = 30 * time.Second
+)
+
+// WRITE_TIMEOUT bounds each write to a stream. Streams outlast any
+// server-wide timeout, so they lift it and bound every write instead, which
+// still cuts off a client that has stopped reading.
+const WRITE_TIMEOUT = 10 * time.Second
+
+// Exempt lifts the server's read and write timeouts from a long-lived
+// response, and returns a function to call before each write that gives it
+// WRITE_TIMEOUT. Responses that can't set deadlines are left as they are.
+func Exempt(w http.ResponseWriter) func() {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	extend := func() { rc.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT)) }
+	extend()
+	return extend
+}
+
+// An Event is sent to clients as a named SSE event with its JSON as data.
+type Event interface {
+	EventName() string
+}
+
+// A Stream describes what an SSE endpoint sends.
+type Stream[E Event] struct {
+	// Name names snapshot events. Unnamed snapshots are sent every TICK;
+	// named ones only when they change, with a comment every KEEPALIVE so
+	// proxies don't drop the idle connection.
+	Name     string
+	Snapshot func() ([]byte, error)
+	// Patch, if set, describes how to get from the last snapshot to the new
+	// one. The result is sent as a PatchName event when it can be, with a
+	// full snapshot at least every RESYNC.
+	Patch     func(old, new []byte) ([]byte, bool)
+	PatchName string
+	// Initial events are sent first, to catch the client up on state that
+	// only changes with events. Events are then interleaved with the
+	// snapshots.
+	Initial []E
+	Events  <-chan E
+}
+
+// Serve streams to an SSE client until it disconnects.
+func Serve[E Event](w http.ResponseWriter, r *http.Request, stream Stream[E]) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ctx := r.Context()
+	extend := Exempt(w)
+
+	ticker := time.NewTicker(TICK)
+	defer ticker.Stop()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "SSE not supported", http.StatusBadRequest)
+		return
+	}
+	send := func(event E) bool {
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		extend()
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.EventName(), data); err != nil {
+			log.Println(err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	for _, event := range stream.Initial {
+		if !send(event) {
+			return
+		}
+	}
+
+	var last []byte
+	lastSent, lastFull := time.Now(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := stream.Snapshot()
+			if err != nil {
+				// e.g. the board was busy; the client keeps what it has
+				// until a later tick succeeds.
+				log.Println(err)
+				continue
+			}
+			extend()
+			switch {
+			case stream.Name == "":
+				_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			case !bytes.Equal(data, last):
+				delta, ok := []byte(nil), false
+				if stream.Patch != nil && last != nil && time.Since(lastFull) < RESYNC {
+					delta, ok = stream.Patch(last, data)
+				}
+				if ok {
+					_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", stream.PatchName, delta)
+				} else {
+					_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", stream.Name, data)
+					lastFull = time.Now()
+				}
+				last = data
+			case time.Since(lastSent) >= KEEPALIVE:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			default:
+				continue
+			}
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			lastSent = time.Now()
+			flusher.Flush()
+		case event := <-stream.Events:
+			if !send(event) {
+				return
+			}
+			lastSent = time.Now()
+		}
+	}
+}
+
+// ReadEvents reads server-sent events from r until it ends or handle returns
+// an error, which ReadEvents then returns. Unnamed events are named
+// "message", as in browsers; comments are skipped.
+func ReadEvents(r io.Reader, handle func(event string, data []byte) error) error {
+	event, data := "", []byte(nil)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			if data != nil {
+				if event == "" {
+					event = "message"
+				}
+				if err := handle(event, data); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case field == "event":
+			event = value
+		case field == "data":
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,67 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"bufio"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Enabled reports whether this binary was built with fault injection.
+const Enabled = true
+
+// Wrap injects the faults in config into next's responses.
+func Wrap(next http.Handler, config Config) http.Handler {
+	if !config.Active() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64() < config.SlowRate {
+			select {
+			case <-time.After(rand.N(config.Delay + 1)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if rand.Float64() < config.ErrorRate {
+			http.Error(w, "chaos: injected failure", http.StatusInternalServerError)
+			return
+		}
+		if config.DropRate > 0 {
+			w = &dropper{ResponseWriter: w, rate: config.DropRate}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// A dropper drops writes to event streams. broadcast.Serve writes each frame
+// in one call, so a dropped write is a whole lost event.
+type dropper struct {
+	http.ResponseWriter
+	rate float64
+}
+
+func (d *dropper) Write(b []byte) (int, error) {
+	if strings.HasPrefix(d.Header().Get("Content-Type"), "text/event-stream") && rand.Float64() < d.rate {
+		return len(b), nil
+	}
+	return d.ResponseWriter.Write(b)
+}
+
+func (d *dropper) Flush() {
+	if f, ok := d.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (d *dropper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(d.ResponseWriter).Hijack()
+}
+
+func (d *dropper) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
@@ -0,0 +1,57 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for i := range 100 {
+		fmt.Fprintf(w, "data: %d\n\n", i)
+	}
+}
+
+func get(t *testing.T, handler http.Handler) (int, string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/events", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	return w.Code, string(body)
+}
+
+func TestWrap(t *testing.T) {
+	if code, body := get(t, Wrap(http.HandlerFunc(stream), Config{})); code != http.StatusOK || strings.Count(body, "data:") != 100 {
+		t.Errorf("no faults: status %v, %v frames", code, strings.Count(body, "data:"))
+	}
+	if code, _ := get(t, Wrap(http.HandlerFunc(stream), Config{ErrorRate: 1})); code != http.StatusInternalServerError {
+		t.Errorf("error rate 1: status %v", code)
+	}
+	if _, body := get(t, Wrap(http.HandlerFunc(stream), Config{DropRate: 1})); body != "" {
+		t.Errorf("drop rate 1: body %q", body)
+	}
+	if _, body := get(t, Wrap(http.HandlerFunc(stream), Config{DropRate: 0.5})); strings.Count(body, "data:") == 100 {
+		t.Error("drop rate 0.5 dropped nothing")
+	}
+
+	start := time.Now()
+	get(t, Wrap(http.HandlerFunc(stream), Config{SlowRate: 1, Delay: 50 * time.Millisecond}))
+	if time.Since(start) > time.Second {
+		t.Errorf("slow response took %v, longer than Delay", time.Since(start))
+	}
+}
+
+func TestDropOnlyStreams(t *testing.T) {
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	if _, body := get(t, Wrap(page, Config{DropRate: 1})); body != "hello" {
+		t.Errorf("body = %q, want hello", body)
+	}
+}
@@ -0,0 +1,41 @@
+// Package chaos injects faults into HTTP responses so that the game client's
+// and frontend's retry behavior can be exercised before the event. It only
+// does anything in binaries built with -tags chaos; otherwise Wrap returns
+// the handler untouched and the rates are rejected.
+package chaos
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config sets how often each fault is injected. Rates are probabilities
+// between 0 and 1.
+type Config struct {
+	// ErrorRate is the fraction of requests answered with a 500.
+	ErrorRate float64
+	// SlowRate is the fraction of requests held for up to Delay first.
+	SlowRate float64
+	Delay    time.Duration
+	// DropRate is the fraction of server-sent event frames silently dropped.
+	DropRate float64
+}
+
+// Active reports whether any fault is configured.
+func (c Config) Active() bool {
+	return c.ErrorRate > 0 || c.SlowRate > 0 || c.DropRate > 0
+}
+
+// Check rejects rates outside [0, 1], and any faults at all in a binary
+// built without the chaos tag.
+func (c Config) Check() error {
+	for _, rate := range []float64{c.ErrorRate, c.SlowRate, c.DropRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("chaos rate %v is not between 0 and 1", rate)
+		}
+	}
+	if c.Active() && !Enabled {
+		return fmt.Errorf("fault injection requires building with -tags chaos")
+	}
+	return nil
+}
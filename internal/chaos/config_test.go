@@ -0,0 +1,21 @@
+package chaos
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tt := range []struct {
+		config Config
+		ok     bool
+	}{
+		{Config{}, true},
+		{Config{ErrorRate: 0.1}, Enabled},
+		{Config{DropRate: 1.5}, false},
+		{Config{SlowRate: -1}, false},
+	} {
+		if err := tt.config.Check(); (err == nil) != tt.ok {
+			t.Errorf("%+v.Check() = %v", tt.config, err)
+		}
+	}
+}
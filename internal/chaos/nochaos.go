@@ -0,0 +1,15 @@
+//go:build !chaos
+
+package chaos
+
+import (
+	"net/http"
+)
+
+// Enabled reports whether this binary was built with fault injection.
+const Enabled = false
+
+// Wrap returns next: this binary was built without fault injection.
+func Wrap(next http.Handler, config Config) http.Handler {
+	return next
+}
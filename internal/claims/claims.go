@@ -0,0 +1,187 @@
+// Package claims signs and verifies compact tokens: a version, a payload of
+// claims and a signature, each base64url-encoded and joined with dots, like
+//
+//	h1.eyJzIjoxNzAwMDAwMDAwMDAwLCJuIjoiLi4uIn0.c2lnbmF0dXJl
+//
+// The version names the signature algorithm, and the signature covers it
+// along with the payload. A Key only accepts its own version, so a token
+// can't be downgraded to a weaker algorithm, or have its Ed25519 public key
+// used as an HMAC secret.
+package claims
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The token versions. Each fixes its signature algorithm.
+const (
+	VERSION_HMAC    = "h1" // HMAC-SHA256 with a shared secret
+	VERSION_ED25519 = "e1" // Ed25519, verifiable with the public key alone
+)
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrVersion   = errors.New("unexpected token version")
+	ErrSignature = errors.New("invalid signature")
+	ErrExpired   = errors.New("token expired")
+)
+
+// Claims are what a token vouches for. Times are Unix milliseconds. The JSON
+// names are short to keep tokens compact.
+type Claims struct {
+	StartMs     int64  `json:"s"`
+	Nonce       string `json:"n"`
+	ExpiresMs   int64  `json:"e,omitempty"`
+	Board       string `json:"b,omitempty"`
+	Difficulty  string `json:"d,omitempty"`
+	Fingerprint string `json:"f,omitempty"`
+	Bucket      string `json:"x,omitempty"`
+	Work        int    `json:"w,omitempty"`
+	Mode        string `json:"m,omitempty"`
+	// KeyID names the key that signed the token, for verifiers that know
+	// several.
+	KeyID string `json:"k,omitempty"`
+}
+
+// A Key signs and verifies tokens of one version. A Key made from an Ed25519
+// public key only verifies.
+type Key struct {
+	version string
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// HMACKey returns a key for VERSION_HMAC tokens.
+func HMACKey(secret []byte) *Key {
+	return &Key{version: VERSION_HMAC, secret: secret}
+}
+
+// Ed25519Key returns a key for VERSION_ED25519 tokens.
+func Ed25519Key(private ed25519.PrivateKey) *Key {
+	return &Key{version: VERSION_ED25519, private: private, public: private.Public().(ed25519.PublicKey)}
+}
+
+// Ed25519PublicKey returns a key that verifies VERSION_ED25519 tokens but
+// can't sign them.
+func Ed25519PublicKey(public ed25519.PublicKey) *Key {
+	return &Key{version: VERSION_ED25519, public: public}
+}
+
+// Version is the version of the tokens k signs and accepts.
+func (k *Key) Version() string {
+	return k.version
+}
+
+// PublicKey returns the key's Ed25519 public key, or nil for an HMAC key.
+func (k *Key) PublicKey() ed25519.PublicKey {
+	return k.public
+}
+
+// ID identifies an Ed25519 key by a hash of its public key, so verifiers can
+// tell which of the keys they know signed a token. An HMAC key has no ID, as
+// nobody else can verify its tokens anyway.
+func (k *Key) ID() string {
+	if k.public == nil {
+		return ""
+	}
+	sum := sha256.Sum256(k.public)
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+func (k *Key) signature(signed string) []byte {
+	switch k.version {
+	case VERSION_HMAC:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	case VERSION_ED25519:
+		return ed25519.Sign(k.private, []byte(signed))
+	}
+	return nil
+}
+
+// Sign encodes claims as a token. It panics for a key that can only verify.
+func (k *Key) Sign(claims Claims) string {
+	if k.version == VERSION_ED25519 && k.private == nil {
+		panic("claims: signing with a public key")
+	}
+	payload, _ := json.Marshal(claims)
+	signed := k.version + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(k.signature(signed))
+}
+
+// Verify checks a token's version and signature and returns its claims. It
+// fails with ErrExpired if the token has an expiry at or before now.
+func (k *Key) Verify(token string, now time.Time) (Claims, error) {
+	version, payload, signature, err := split(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if version != k.version {
+		return Claims{}, fmt.Errorf("%w %q, want %q", ErrVersion, version, k.version)
+	}
+	signed := token[:strings.LastIndexByte(token, '.')]
+	switch k.version {
+	case VERSION_HMAC:
+		if !hmac.Equal(signature, k.signature(signed)) {
+			return Claims{}, ErrSignature
+		}
+	case VERSION_ED25519:
+		if !ed25519.Verify(k.public, []byte(signed), signature) {
+			return Claims{}, ErrSignature
+		}
+	}
+
+	claims, err := decode(payload)
+	if err != nil {
+		return Claims{}, err
+	}
+	if claims.ExpiresMs != 0 && now.UnixMilli() >= claims.ExpiresMs {
+		return claims, ErrExpired
+	}
+	return claims, nil
+}
+
+// Parse reads a token's version and claims without verifying it, for tools
+// that only display tokens. Never trust what it returns.
+func Parse(token string) (string, Claims, error) {
+	version, payload, _, err := split(token)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	claims, err := decode(payload)
+	return version, claims, err
+}
+
+func split(token string) (string, []byte, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", nil, nil, ErrMalformed
+	}
+	payload, err := base64.RawURLEncoding.Strict().DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return parts[0], payload, signature, nil
+}
+
+func decode(payload []byte) (Claims, error) {
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,154 @@
+package claims
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.UnixMilli(1700000000123)
+
+func testKeys(t *testing.T) (*Key, *Key) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return HMACKey([]byte("secret")), Ed25519Key(private)
+}
+
+func TestRoundTrip(t *testing.T) {
+	claims := Claims{
+		StartMs:     now.UnixMilli(),
+		Nonce:       "abc",
+		ExpiresMs:   now.Add(time.Hour).UnixMilli(),
+		Board:       "demo",
+		Difficulty:  "hard",
+		Fingerprint: "fp",
+		Bucket:      "speed=fast",
+		Mode:        "practice",
+	}
+	hmacKey, edKey := testKeys(t)
+	for _, keys := range []struct{ signer, verifier *Key }{
+		{hmacKey, hmacKey},
+		{edKey, edKey},
+		{edKey, Ed25519PublicKey(edKey.PublicKey())},
+	} {
+		version := keys.verifier.Version()
+		token := keys.signer.Sign(claims)
+		if !strings.HasPrefix(token, version+".") {
+			t.Errorf("%v token %q lacks its version", version, token)
+		}
+		got, err := keys.verifier.Verify(token, now)
+		if err != nil {
+			t.Fatalf("%v: Verify = %v", version, err)
+		}
+		if got != claims {
+			t.Errorf("%v: Verify = %+v, want %+v", version, got, claims)
+		}
+		if v, parsed, err := Parse(token); err != nil || v != version || parsed != claims {
+			t.Errorf("Parse = %v, %+v, %v", v, parsed, err)
+		}
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	hmacKey, edKey := testKeys(t)
+	if id := hmacKey.ID(); id != "" {
+		t.Errorf("HMAC key ID = %q, want none", id)
+	}
+	public := Ed25519PublicKey(edKey.PublicKey())
+	if edKey.ID() == "" || edKey.ID() != public.ID() {
+		t.Errorf("key IDs %q and %q, want the same", edKey.ID(), public.ID())
+	}
+	other := Ed25519Key(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	if other.ID() == edKey.ID() {
+		t.Error("two keys have the same ID")
+	}
+}
+
+func TestForgery(t *testing.T) {
+	hmacKey, edKey := testKeys(t)
+	otherHMAC, otherEd := HMACKey([]byte("other")), Ed25519Key(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	claims := Claims{StartMs: now.UnixMilli(), Nonce: "abc"}
+
+	for _, key := range []struct{ key, other *Key }{{hmacKey, otherHMAC}, {edKey, otherEd}} {
+		token := key.key.Sign(claims)
+		parts := strings.Split(token, ".")
+		moved := claims
+		moved.StartMs -= 60000
+		forged := key.other.Sign(moved)
+
+		tests := map[string]string{
+			"other key":         key.other.Sign(claims),
+			"moved start":       parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2],
+			"spliced signature": strings.Join(strings.Split(forged, ".")[:2], ".") + "." + parts[2],
+			"no signature":      parts[0] + "." + parts[1] + ".",
+		}
+		for name, forgery := range tests {
+			if _, err := key.key.Verify(forgery, now); !errors.Is(err, ErrSignature) {
+				t.Errorf("%v %v: Verify = %v, want ErrSignature", key.key.Version(), name, err)
+			}
+		}
+	}
+}
+
+func TestDowngrade(t *testing.T) {
+	hmacKey, edKey := testKeys(t)
+	claims := Claims{StartMs: now.UnixMilli(), Nonce: "abc"}
+	payload := strings.Split(edKey.Sign(claims), ".")[1]
+
+	tests := []struct {
+		name  string
+		key   *Key
+		token string
+	}{
+		// The classic confusion: HMAC with the published public key.
+		{"hmac with the public key", edKey, HMACKey(edKey.PublicKey()).Sign(claims)},
+		{"ed25519 to hmac", hmacKey, edKey.Sign(claims)},
+		{"hmac to ed25519", edKey, hmacKey.Sign(claims)},
+		{"unsigned", edKey, "none." + payload + "."},
+		{"older version", hmacKey, "h0." + payload + "." + strings.Split(hmacKey.Sign(claims), ".")[2]},
+	}
+	for _, tt := range tests {
+		if _, err := tt.key.Verify(tt.token, now); !errors.Is(err, ErrVersion) {
+			t.Errorf("%v: Verify = %v, want ErrVersion", tt.name, err)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	key := HMACKey([]byte("secret"))
+	token := key.Sign(Claims{StartMs: now.UnixMilli(), ExpiresMs: now.Add(time.Minute).UnixMilli()})
+	if _, err := key.Verify(token, now.Add(59*time.Second)); err != nil {
+		t.Errorf("Verify before expiry = %v", err)
+	}
+	if _, err := key.Verify(token, now.Add(time.Minute)); !errors.Is(err, ErrExpired) {
+		t.Errorf("Verify at expiry = %v, want ErrExpired", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	key := HMACKey([]byte("secret"))
+	parts := strings.Split(key.Sign(Claims{Nonce: "abc"}), ".")
+	for _, token := range []string{
+		"",
+		"h1",
+		parts[0] + "." + parts[1],
+		strings.Join(append(parts, "x"), "."),
+		parts[0] + "." + parts[1] + "=." + parts[2],
+		parts[0] + ".!!." + parts[2],
+		"." + parts[1] + "." + parts[2],
+		parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte("not json")) + "." + parts[2],
+	} {
+		if _, err := key.Verify(token, now); err == nil {
+			t.Errorf("Verify(%q) succeeded", token)
+		}
+	}
+	if _, _, err := Parse("h1.!!.x"); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Parse(garbage) = %v, want ErrMalformed", err)
+	}
+}
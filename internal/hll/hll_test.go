@@ -0,0 +1,35 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	var s Sketch
+	if got := s.Estimate(); got != 0 {
+		t.Errorf("empty estimate = %v, want 0", got)
+	}
+	for _, n := range []int{10, 500, 20000} {
+		s := Sketch{Salt: []byte("salt")}
+		for i := 0; i < n; i++ {
+			// Items seen twice count once.
+			s.Add("AAA", fmt.Sprint(i))
+			s.Add("AAA", fmt.Sprint(i))
+		}
+		got := s.Estimate()
+		if math.Abs(float64(got)-float64(n)) > 0.05*float64(n) {
+			t.Errorf("estimate of %v = %v", n, got)
+		}
+	}
+}
+
+func TestAddParts(t *testing.T) {
+	var s Sketch
+	s.Add("ab", "c")
+	s.Add("a", "bc")
+	if got := s.Estimate(); got != 2 {
+		t.Errorf("estimate = %v, want 2", got)
+	}
+}
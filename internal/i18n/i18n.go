@@ -0,0 +1,145 @@
+// Package i18n translates what the server says to players: error messages,
+// and the strings it hands the frontend. The language comes from the
+// client's Accept-Language.
+//
+// English is the source language. Messages are looked up by their English
+// format string, so code reads as it always has and an untranslated message
+// falls back to English; frontend strings are looked up by key.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// SOURCE_LANGUAGE is what messages are written in and what clients get when
+// nothing else matches.
+const SOURCE_LANGUAGE = "en"
+
+type locale struct {
+	Messages map[string]string `json:"messages"`
+	Strings  map[string]string `json:"strings"`
+}
+
+// A Catalog holds the translations for every supported language. It is safe
+// for concurrent use.
+type Catalog struct {
+	locales map[string]locale
+	tags    []language.Tag
+	matcher language.Matcher
+}
+
+// Load reads the embedded translation files, one per language, named by
+// their BCP 47 tag.
+func Load() (*Catalog, error) {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{locales: map[string]locale{}}
+	for _, file := range files {
+		data, err := locales.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var l locale
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, fmt.Errorf("%v: %w", file.Name(), err)
+		}
+		tag, err := language.Parse(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", file.Name(), err)
+		}
+		c.locales[tag.String()] = l
+		c.tags = append(c.tags, tag)
+	}
+	if _, ok := c.locales[SOURCE_LANGUAGE]; !ok {
+		return nil, fmt.Errorf("missing %v translations", SOURCE_LANGUAGE)
+	}
+
+	// The first tag is the matcher's fallback.
+	slices.SortFunc(c.tags, func(a, b language.Tag) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	i := slices.Index(c.tags, language.Make(SOURCE_LANGUAGE))
+	source := c.tags[i]
+	c.tags = slices.Insert(slices.Delete(c.tags, i, i+1), 0, source)
+	c.matcher = language.NewMatcher(c.tags)
+	return c, nil
+}
+
+// Languages lists the supported languages, the source language first.
+func (c *Catalog) Languages() []string {
+	var langs []string
+	for _, tag := range c.tags {
+		langs = append(langs, tag.String())
+	}
+	return langs
+}
+
+// Match picks the supported language that best fits an Accept-Language
+// header, or the source language if none does.
+func (c *Catalog) Match(acceptLanguage string) string {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, _ := c.matcher.Match(tags...)
+	return c.tags[i].String()
+}
+
+// Sprintf formats the translation of format into lang. Translations may
+// reorder the arguments with explicit indexes such as %[2]v.
+func (c *Catalog) Sprintf(lang string, format string, args ...any) string {
+	if translated := c.locales[lang].Messages[format]; translated != "" {
+		format = translated
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Message renders err in lang if it is, or wraps, an Error, and as is
+// otherwise.
+func (c *Catalog) Message(lang string, err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return c.Sprintf(lang, e.Format, e.Args...)
+	}
+	return err.Error()
+}
+
+// Strings returns the frontend strings for lang, with English for any it
+// doesn't translate.
+func (c *Catalog) Strings(lang string) map[string]string {
+	translated := maps.Clone(c.locales[SOURCE_LANGUAGE].Strings)
+	for key, s := range c.locales[lang].Strings {
+		if s != "" {
+			translated[key] = s
+		}
+	}
+	return translated
+}
+
+// An Error is a message for a player that can be translated. It reads in
+// English wherever it isn't, such as in logs.
+type Error struct {
+	Format string
+	Args   []any
+}
+
+// Errorf returns an Error for format and args. Translations are looked up by
+// format, so it should be a constant.
+func Errorf(format string, args ...any) error {
+	return &Error{Format: format, Args: args}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf(e.Format, e.Args...)
+}
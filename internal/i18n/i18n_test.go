@@ -0,0 +1,95 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		acceptLanguage, want string
+	}{
+		{"", "en"},
+		{"es-MX,es;q=0.9,en;q=0.8", "es"},
+		{"fr-CA", "fr"},
+		{"de;q=0.5, ja", "de"},
+		{"ja, zh", "en"},
+		{"garbage;;", "en"},
+	}
+	for _, tt := range tests {
+		if got := c.Match(tt.acceptLanguage); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Errorf("remaining health %v exceeds the maximum of %v", 2000, 1000)
+	if got, want := e.Error(), "remaining health 2000 exceeds the maximum of 1000"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if got, want := c.Message("es", fmt.Errorf("wrapped: %w", e)), "la salud restante 2000 supera el máximo de 1000"; got != want {
+		t.Errorf("Message(es) = %q, want %q", got, want)
+	}
+	if got, want := c.Message("es", errors.New("not for players")), "not for players"; got != want {
+		t.Errorf("Message(es, plain error) = %q, want %q", got, want)
+	}
+}
+
+var VERB = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*[0-9.]*[a-zA-Z]`)
+var PLACEHOLDER = regexp.MustCompile(`\{\w+\}`)
+
+// TestTranslations checks every translation against the English it stands
+// in for: messages take the same number of arguments, and strings the same
+// placeholders.
+func TestTranslations(t *testing.T) {
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := c.locales[SOURCE_LANGUAGE]
+	for _, lang := range c.Languages()[1:] {
+		for format, translated := range c.locales[lang].Messages {
+			args := make([]any, len(VERB.FindAllString(format, -1)))
+			for i := range args {
+				args[i] = i
+			}
+			if got := fmt.Sprintf(translated, args...); strings.Contains(got, "%!") {
+				t.Errorf("%v: %q formats as %q", lang, translated, got)
+			}
+		}
+		for key, translated := range c.locales[lang].Strings {
+			english, ok := source.Strings[key]
+			if !ok {
+				t.Errorf("%v: string %q isn't in %v", lang, key, SOURCE_LANGUAGE)
+				continue
+			}
+			want := PLACEHOLDER.FindAllString(english, -1)
+			got := PLACEHOLDER.FindAllString(translated, -1)
+			slices.Sort(want)
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("%v: string %q has placeholders %v, want %v", lang, key, got, want)
+			}
+		}
+		if missing := len(source.Strings) - len(c.locales[lang].Strings); missing > 0 {
+			t.Logf("%v: %d strings fall back to %v", lang, missing, SOURCE_LANGUAGE)
+		}
+	}
+	if got := c.Strings("xx"); !maps.Equal(got, source.Strings) {
+		t.Errorf("Strings(unsupported) = %v, want the %v strings", got, SOURCE_LANGUAGE)
+	}
+}
@@ -0,0 +1,79 @@
+// Package logging routes the server's logs through a level that can be
+// changed while it runs. Plain log.Printf calls count as info; quieter levels
+// drop them, and debug adds detail such as every submission decision.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Level is the least severe level that is logged. It is safe to change at
+// any time.
+var Level = new(slog.LevelVar)
+
+// LEVELS are the accepted -log-level settings.
+var LEVELS = []string{"debug", "info", "warn", "error"}
+
+// ParseLevel reads one of LEVELS.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (supported: %s)", s, strings.Join(LEVELS, ", "))
+	}
+	return level, nil
+}
+
+// Setup makes the log and slog packages both write to w at Level, in the
+// log package's usual format, with the level in front of anything but info.
+func Setup(w io.Writer) {
+	slog.SetDefault(slog.New(&handler{logger: log.New(w, "", log.LstdFlags)}))
+}
+
+type handler struct {
+	logger *log.Logger
+	attrs  string
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= Level.Level()
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String() + " ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		b.WriteString(formatAttr(a))
+		return true
+	})
+	return h.logger.Output(0, b.String())
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	s := h.attrs
+	for _, a := range attrs {
+		s += formatAttr(a)
+	}
+	return &handler{logger: h.logger, attrs: s}
+}
+
+// WithGroup is never used by the server, so groups are flattened away.
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+func formatAttr(a slog.Attr) string {
+	v := a.Value.Resolve().String()
+	if v == "" || strings.ContainsAny(v, " \"=") {
+		v = fmt.Sprintf("%q", v)
+	}
+	return " " + a.Key + "=" + v
+}
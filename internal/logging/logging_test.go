@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	Setup(&buf)
+	defer Level.Set(slog.LevelInfo)
+
+	log.Printf("plain %d\n", 1)
+	slog.Debug("hidden")
+	Level.Set(slog.LevelDebug)
+	slog.Debug("Rejected submission", "player", "AAA", "reason", "too fast")
+	Level.Set(slog.LevelWarn)
+	log.Printf("quiet")
+	slog.Warn("Store unavailable")
+
+	got := buf.String()
+	for _, want := range []string{"plain 1\n", `DEBUG Rejected submission player=AAA reason="too fast"` + "\n", "WARN Store unavailable\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log %q is missing %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"hidden", "quiet", "INFO"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("log %q contains %q", got, unwanted)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range LEVELS {
+		if _, err := ParseLevel(s); err != nil {
+			t.Errorf("ParseLevel(%q) = %v", s, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("ParseLevel(loud) succeeded")
+	}
+}
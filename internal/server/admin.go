@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"elevate2024/internal/broadcast"
+)
+
+// hashPassword derives the value admin passwords are compared by, so that
+// comparisons take the same time regardless of where the inputs differ.
+func hashPassword(pw string) []byte {
+	sum := sha256.Sum256([]byte(pw))
+	return sum[:]
+}
+
+// isAdmin reports whether the request carries the admin password, either as
+// the pw form/query value or as the HTTP basic auth password. The query form
+// is needed for EventSource, which can't set headers.
+func (s *HighScoreServer) isAdmin(r *http.Request) bool {
+	_, pw, ok := r.BasicAuth()
+	if !ok {
+		pw = r.FormValue("pw")
+	}
+	return subtle.ConstantTimeCompare(hashPassword(pw), s.adminPasswordHash) == 1
+}
+
+// parseCIDRs parses a comma-separated list of networks. Bare addresses are
+// treated as single-host networks.
+func parseCIDRs(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// restrictAdmin only lets requests from the -admin-allow-cidr networks through
+// to an admin handler. It runs before the password is checked so that other
+// networks can't even attempt to authenticate.
+func (s *HighScoreServer) restrictAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.adminNetworks) > 0 {
+			addr, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err != nil || !slices.ContainsFunc(s.adminNetworks, func(p netip.Prefix) bool {
+				return p.Contains(addr.Addr().Unmap())
+			}) {
+				log.Printf("Rejected admin request for %v from %v\n", r.URL.Path, r.RemoteAddr)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// A SubmissionEvent describes a single /record attempt for the admin tail.
+type SubmissionEvent struct {
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Accepted  bool      `json:"accepted"`
+	Reason    string    `json:"reason,omitempty"`
+	Rank      int       `json:"rank,omitempty"`
+	Score     Score     `json:"score"`
+	// suspicious signals that didn't cause a rejection
+	Flags     []string `json:"flags,omitempty"`
+	Suspicion float64  `json:"suspicion,omitempty"`
+	// the quarantine entry, if the score was held for review
+	Quarantined string `json:"quarantined,omitempty"`
+}
+
+func (s *HighScoreServer) submissionEvent(r *http.Request, score Score, err error) SubmissionEvent {
+	event := SubmissionEvent{
+		Time:      s.now(),
+		IP:        s.clientIP(r),
+		UserAgent: r.UserAgent(),
+		Accepted:  err == nil,
+		Score:     score,
+	}
+	if err != nil {
+		event.Reason = err.Error()
+	}
+	return event
+}
+
+// STATUS_INTERVAL is how often /admin/events sends a "status" event.
+const STATUS_INTERVAL = 2 * time.Second
+
+// A ServerStatus is a snapshot of the load on the server, sent to admin
+// stream subscribers as a named "status" event.
+type ServerStatus struct {
+	// clients following /events
+	Watchers int        `json:"watchers"`
+	Playing  int        `json:"playing"`
+	Queue    QueueStats `json:"queue"`
+	// load on the routes -route-limit caps
+	Routes []RouteStats `json:"routes,omitempty"`
+}
+
+func (s *HighScoreServer) status() ServerStatus {
+	return ServerStatus{
+		Watchers: s.live.Len(),
+		Playing:  s.runs.current().Count,
+		Queue:    s.submissions.stats(),
+		Routes:   s.routeStats(),
+	}
+}
+
+// adminEvents streams every submission attempt as an unnamed event, with a
+// "status" event every STATUS_INTERVAL.
+func (s *HighScoreServer) adminEvents(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "SSE not supported", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	extend := broadcast.Exempt(w)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := s.tail.Subscribe()
+	defer s.tail.Unsubscribe(events)
+
+	// The status events also keep idle connections from being dropped by
+	// proxies.
+	status := time.NewTicker(STATUS_INTERVAL)
+	defer status.Stop()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-status.C:
+			extend()
+			data, err := json.Marshal(s.status())
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		case event := <-events:
+			extend()
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of what the game allows, used to spot impossible scores. The boss
+// starts with BOSS_HEALTH; MAX_DAMAGE_RATE is well above what power-ups and
+// insane mode together can reach.
+const (
+	BOSS_HEALTH     = 1000
+	MAX_DAMAGE_RATE = 500 // health per second
+)
+
+// A suspicionSignal rates one aspect of a submission from 0 (nothing odd) to
+// 1 (certainly wrong), with a short explanation when it is above 0. Its weight
+// scales its contribution to the submission's suspicion score.
+type suspicionSignal struct {
+	Name   string
+	Weight float64
+	check  func(s *HighScoreServer, c submissionCheck) (float64, string)
+}
+
+type submissionCheck struct {
+	Score Score
+	Token Token
+	IP    string
+	// the submitting client's fingerprint, to compare with the token's
+	Fingerprint string
+	// the elapsed time the client claimed, which differs from the score's
+	// when the server timed the run
+	Claimed float64
+	// the registered device that submitted it, if any
+	Device string
+}
+
+// SUSPICION_SIGNALS is the pipeline every accepted submission goes through.
+var SUSPICION_SIGNALS = []suspicionSignal{
+	{Name: "impossible", Weight: 1, check: checkImpossible},
+	{Name: "missing_heartbeats", Weight: 0.5, check: checkHeartbeats},
+	{Name: "submission_rate", Weight: 0.5, check: checkSubmissionRate},
+	{Name: "ip_history", Weight: 0.5, check: checkIPHistory},
+	{Name: "elapsed_mismatch", Weight: 0.5, check: checkElapsedClaim},
+	{Name: "checkpoints", Weight: 1, check: checkCheckpoints},
+	{Name: "fingerprint", Weight: 0.5, check: checkFingerprint},
+	{Name: "duplicate", Weight: 1, check: checkDuplicate},
+}
+
+func checkImpossible(s *HighScoreServer, c submissionCheck) (float64, string) {
+	if c.Score.RemainingHealth > BOSS_HEALTH {
+		return 1, fmt.Sprintf("remaining health %d exceeds the boss's %d", c.Score.RemainingHealth, BOSS_HEALTH)
+	}
+	damage := float64(BOSS_HEALTH - c.Score.RemainingHealth)
+	if damage > 0 && damage > c.Score.Elapsed*MAX_DAMAGE_RATE {
+		return 1, fmt.Sprintf("%.0f damage in %.2fs", damage, c.Score.Elapsed)
+	}
+	return 0, ""
+}
+
+func checkHeartbeats(s *HighScoreServer, c submissionCheck) (float64, string) {
+	if s.runs.missedHeartbeats(c.Token, c.Score.Elapsed) {
+		return 1, fmt.Sprintf("too few heartbeats for a %.0fs run", c.Score.Elapsed)
+	}
+	return 0, ""
+}
+
+func checkSubmissionRate(s *HighScoreServer, c submissionCheck) (float64, string) {
+	// Booth kiosks submit all day.
+	if c.Device != "" {
+		return 0, ""
+	}
+	n, _ := s.history.recent(c.IP, time.Minute)
+	// A couple of quick deaths in a row is normal; a steady stream isn't.
+	if n <= 2 {
+		return 0, ""
+	}
+	return min(1, float64(n-2)/4), fmt.Sprintf("%d submissions in the last minute", n)
+}
+
+func checkIPHistory(s *HighScoreServer, c submissionCheck) (float64, string) {
+	if c.Device != "" {
+		return 0, ""
+	}
+	_, rejected := s.history.recent(c.IP, time.Hour)
+	if rejected == 0 {
+		return 0, ""
+	}
+	return min(1, float64(rejected)/5), fmt.Sprintf("%d rejected submissions in the last hour", rejected)
+}
+
+func checkElapsedClaim(s *HighScoreServer, c submissionCheck) (float64, string) {
+	timed := c.Score.Elapsed
+	tolerance := max(FINISH_TOLERANCE.Seconds(), timed*FINISH_TOLERANCE_RATIO)
+	off := math.Abs(c.Claimed - timed)
+	if off <= tolerance {
+		return 0, ""
+	}
+	return min(1, (off-tolerance)/tolerance), fmt.Sprintf("client claimed %.2fs for a %.2fs run", c.Claimed, timed)
+}
+
+// checkCheckpoints holds a WebSocket run to the checkpoints it sent along the
+// way: the boss never heals, the client's clock keeps pace with the server's,
+// and the checkpoints don't stop for long, as they would if the game were
+// paused or the run replayed. Breaking the first is certain; the others only
+// count half, as a congested venue network can delay checkpoints too.
+func checkCheckpoints(s *HighScoreServer, c submissionCheck) (float64, string) {
+	checkpoints := s.runs.checkpointsOf(c.Token)
+	if len(checkpoints) == 0 {
+		return 0, ""
+	}
+	first, last := checkpoints[0], checkpoints[len(checkpoints)-1]
+
+	health := BOSS_HEALTH
+	for _, checkpoint := range append(checkpoints, checkpoint{bossHealth: c.Score.RemainingHealth}) {
+		if checkpoint.bossHealth > health {
+			return 1, fmt.Sprintf("boss health rose from %d to %d", health, checkpoint.bossHealth)
+		}
+		health = checkpoint.bossHealth
+	}
+
+	received := last.received.Sub(first.received).Seconds()
+	claimed := last.elapsed - first.elapsed
+	tolerance := max(FINISH_TOLERANCE.Seconds(), received*FINISH_TOLERANCE_RATIO)
+	if off := math.Abs(claimed - received); off > tolerance {
+		return min(0.5, (off-tolerance)/tolerance), fmt.Sprintf("checkpoints claim %.1fs of play over %.1fs", claimed, received)
+	}
+
+	gap := c.Score.Elapsed - last.elapsed
+	for i := 1; i < len(checkpoints); i++ {
+		gap = max(gap, checkpoints[i].received.Sub(checkpoints[i-1].received).Seconds())
+	}
+	if limit := CHECKPOINT_GAP.Seconds(); gap > limit {
+		return min(0.5, (gap-limit)/limit), fmt.Sprintf("%.0fs without a checkpoint", gap)
+	}
+	return 0, ""
+}
+
+// checkFingerprint notices a run submitted by a different client than started
+// it. A phone that switches networks mid-run does that too, so it only counts
+// half.
+func checkFingerprint(s *HighScoreServer, c submissionCheck) (float64, string) {
+	if c.Token.Fingerprint == "" || c.Token.Fingerprint == c.Fingerprint {
+		return 0, ""
+	}
+	return 1, "submitted by a different client than started the run"
+}
+
+type SignalHit struct {
+	Name   string  `json:"name"`
+	Value  float64 `json:"value"`
+	Detail string  `json:"detail"`
+}
+
+type Suspicion struct {
+	Score   float64     `json:"score"`
+	Signals []SignalHit `json:"signals,omitempty"`
+}
+
+func (s Suspicion) names() []string {
+	var names []string
+	for _, hit := range s.Signals {
+		names = append(names, hit.Name)
+	}
+	return names
+}
+
+// assess runs a submission through the signal pipeline.
+func (s *HighScoreServer) assess(c submissionCheck) Suspicion {
+	var suspicion Suspicion
+	for _, signal := range SUSPICION_SIGNALS {
+		value, detail := signal.check(s, c)
+		if value <= 0 {
+			continue
+		}
+		suspicion.Score += signal.Weight * value
+		suspicion.Signals = append(suspicion.Signals, SignalHit{signal.Name, value, detail})
+	}
+	return suspicion
+}
+
+// submissionHistory remembers recent submission attempts per client for the
+// rate and history signals.
+type submissionHistory struct {
+	mutex    sync.Mutex
+	attempts map[string][]attempt
+}
+
+type attempt struct {
+	time     time.Time
+	accepted bool
+}
+
+func (h *submissionHistory) record(ip string, accepted bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.attempts == nil {
+		h.attempts = map[string][]attempt{}
+	}
+	now := time.Now()
+	// Only the last hour is ever looked at.
+	kept := slices.DeleteFunc(h.attempts[ip], func(a attempt) bool { return now.Sub(a.time) > time.Hour })
+	h.attempts[ip] = append(kept, attempt{now, accepted})
+}
+
+// forget drops the history of clients with no attempts since cutoff,
+// returning how many there were.
+func (h *submissionHistory) forget(cutoff time.Time) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	forgotten := 0
+	for ip, attempts := range h.attempts {
+		if len(attempts) == 0 || attempts[len(attempts)-1].time.Before(cutoff) {
+			delete(h.attempts, ip)
+			forgotten++
+		}
+	}
+	return forgotten
+}
+
+// recent counts a client's attempts and rejections within window.
+func (h *submissionHistory) recent(ip string, window time.Duration) (int, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	total, rejected := 0, 0
+	for _, a := range h.attempts[ip] {
+		if time.Since(a.time) <= window {
+			total++
+			if !a.accepted {
+				rejected++
+			}
+		}
+	}
+	return total, rejected
+}
+
+// publishSubmission reports a submission attempt to the admin tail and
+// remembers it for the suspicion signals. At debug level every decision is
+// also logged, so staff can follow a misbehaving kiosk from the console.
+func (s *HighScoreServer) publishSubmission(event SubmissionEvent) {
+	slog.Debug("Submission", "accepted", event.Accepted, "reason", event.Reason,
+		"ip", event.IP, "player", event.Score.PlayerName, "health", event.Score.RemainingHealth,
+		"elapsed", event.Score.Elapsed, "rank", event.Rank, "flags", strings.Join(event.Flags, ","),
+		"suspicion", event.Suspicion, "quarantined", event.Quarantined)
+	s.history.record(event.IP, event.Accepted)
+	s.tail.Publish(event)
+}
+
+// A QuarantinedScore is a submission held back from the board because it
+// looked suspicious, waiting for a moderator.
+type QuarantinedScore struct {
+	ID        string    `json:"id"`
+	Score     Score     `json:"score"`
+	Suspicion Suspicion `json:"suspicion"`
+	Submitted time.Time `json:"submitted"`
+	IP        string    `json:"ip"`
+	Email     string    `json:"email,omitempty"`
+}
+
+type quarantine struct {
+	mutex     sync.Mutex
+	threshold float64 // 0 disables quarantine
+	entries   map[string]QuarantinedScore
+}
+
+func (q *quarantine) holds(suspicion Suspicion) bool {
+	return q.threshold > 0 && suspicion.Score >= q.threshold
+}
+
+func (q *quarantine) add(entry QuarantinedScore) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.entries == nil {
+		q.entries = map[string]QuarantinedScore{}
+	}
+	q.entries[entry.ID] = entry
+}
+
+func (q *quarantine) take(id string) (QuarantinedScore, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	entry, ok := q.entries[id]
+	delete(q.entries, id)
+	return entry, ok
+}
+
+// quarantineScore holds a suspicious score for review instead of adding it
+// to the board.
+func (s *HighScoreServer) quarantineScore(ctx context.Context, score Score, suspicion Suspicion, ip string, email string) (string, error) {
+	id, err := newScoreID()
+	if err != nil {
+		return "", err
+	}
+	entry := QuarantinedScore{
+		ID:        id,
+		Score:     score,
+		Suspicion: suspicion,
+		Submitted: s.now(),
+		IP:        ip,
+		Email:     email,
+	}
+	if _, err := s.apply(ctx, boardCommand{Op: COMMAND_QUARANTINE, Quarantined: &entry, Time: entry.Submitted}); err != nil {
+		return "", err
+	}
+	log.Printf("Quarantined score %v from %v (suspicion %.2f)\n", id, ip, suspicion.Score)
+	return id, nil
+}
+
+// unquarantine takes the score with the given ID out of quarantine.
+func (s *HighScoreServer) unquarantine(ctx context.Context, id string) (QuarantinedScore, bool, error) {
+	result, err := s.apply(ctx, boardCommand{Op: COMMAND_UNQUARANTINE, ID: id, Time: s.now()})
+	return result.Quarantined, result.Found, err
+}
+
+func (s *HighScoreServer) listQuarantine(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.quarantine.mutex.Lock()
+	entries := make([]QuarantinedScore, 0, len(s.quarantine.entries))
+	for _, entry := range s.quarantine.entries {
+		entries = append(entries, entry)
+	}
+	s.quarantine.mutex.Unlock()
+	slices.SortFunc(entries, func(a, b QuarantinedScore) int { return a.Submitted.Compare(b.Submitted) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// approveQuarantined publishes a held score to the board as if it had just
+// been submitted.
+func (s *HighScoreServer) approveQuarantined(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	entry, ok, err := s.unquarantine(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	score, rank, err := s.insertScore(r.Context(), entry.Score)
+	if err != nil {
+		// Put it back so the approval can be retried.
+		if _, err := s.apply(context.WithoutCancel(r.Context()), boardCommand{Op: COMMAND_QUARANTINE, Quarantined: &entry, Time: s.now()}); err != nil {
+			log.Printf("Lost quarantined score %v: %v\n", entry.ID, err)
+		}
+		writeStoreError(w, err)
+		return
+	}
+	s.setResultEmail(score.ID, entry.Email)
+	s.celebrations.accepted(score, rank)
+	s.audit.record(s.clientIP(r), "quarantine-approve", score.ID, entry.Score, score)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID   string `json:"id"`
+		Rank int    `json:"rank"`
+	}{score.ID, rank})
+}
+
+// rejectQuarantined drops a held score for good, so it takes a TOTP code like
+// other destructive actions.
+func (s *HighScoreServer) rejectQuarantined(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !s.requireTOTP(w, r) {
+		return
+	}
+
+	entry, ok, err := s.unquarantine(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.audit.record(s.clientIP(r), "quarantine-reject", entry.ID, entry.Score, nil)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An AuditEntry records an admin action that changed the board.
+//
+// Entries form a hash chain: each Hash covers the previous entry's hash, so
+// editing or dropping any entry breaks every hash after it. The actor and the
+// before/after values are covered through separate digests. The actor, a
+// client address, can be blanked once it is past retention without breaking
+// the chain, and only its own check is waived then. Anonymizing a player's
+// scores in Before/After marks the entry Redacted and chains it afresh.
+type AuditEntry struct {
+	Seq         int       `json:"seq"`
+	Time        time.Time `json:"time"`
+	Actor       string    `json:"actor"`
+	Action      string    `json:"action"`
+	ScoreID     string    `json:"score_id,omitempty"`
+	Before      any       `json:"before,omitempty"`
+	After       any       `json:"after,omitempty"`
+	Redacted    bool      `json:"redacted,omitempty"`
+	ActorDigest string    `json:"actor_digest"`
+	Digest      string    `json:"digest"`
+	Prev        string    `json:"prev"`
+	Hash        string    `json:"hash"`
+}
+
+// auditDigest hashes v as JSON.
+func auditDigest(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// contentDigest hashes an entry's before/after values.
+func (e *AuditEntry) contentDigest() (string, error) {
+	return auditDigest(struct {
+		Before any `json:"before"`
+		After  any `json:"after"`
+	}{e.Before, e.After})
+}
+
+// seal digests an entry's actor and content and chains it to prev.
+func (e *AuditEntry) seal(prev string) {
+	e.Prev = prev
+	e.ActorDigest, _ = auditDigest(e.Actor)
+	digest, err := e.contentDigest()
+	if err != nil {
+		// Everything recorded here is plain data, so this can't happen in
+		// practice; keep the chain intact regardless.
+		digest = "unencodable: " + err.Error()
+	}
+	e.Digest = digest
+	e.Hash = e.chainHash()
+}
+
+// chainHash hashes an entry's fixed fields together with the previous hash.
+func (e *AuditEntry) chainHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.Prev,
+		strconv.Itoa(e.Seq),
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Action,
+		e.ScoreID,
+		strconv.FormatBool(e.Redacted),
+		e.ActorDigest,
+		e.Digest,
+	} {
+		// length-prefixed so fields can't bleed into each other
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type auditLog struct {
+	now func() time.Time // the server's clock
+
+	mutex   sync.Mutex
+	entries []AuditEntry
+}
+
+// record appends an entry attributed to actor, the client's masked address.
+func (a *auditLog) record(actor string, action string, scoreID string, before any, after any) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entry := AuditEntry{
+		Seq:     len(a.entries),
+		Time:    a.now(),
+		Actor:   actor,
+		Action:  action,
+		ScoreID: scoreID,
+		Before:  before,
+		After:   after,
+	}
+	prev := ""
+	if n := len(a.entries); n > 0 {
+		prev = a.entries[n-1].Hash
+	}
+	entry.seal(prev)
+	a.entries = append(a.entries, entry)
+}
+
+// anonymize scrubs a player's name from the scores captured in before/after
+// values, marking the entries it changes Redacted. Their digests no longer
+// hold, so the chain is sealed afresh from the first of them on: a head hash
+// published before the erasure won't match any more, and the erasure's own
+// entry records when that happened. It returns how many entries changed.
+func (a *auditLog) anonymize(name string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	changed := 0
+	first := len(a.entries)
+	for i := range a.entries {
+		var changedBefore, changedAfter bool
+		a.entries[i].Before, changedBefore = anonymizeAudit(a.entries[i].Before, name)
+		a.entries[i].After, changedAfter = anonymizeAudit(a.entries[i].After, name)
+		if changedBefore || changedAfter {
+			a.entries[i].Redacted = true
+			first = min(first, i)
+			changed++
+		}
+	}
+	for i := first; i < len(a.entries); i++ {
+		prev := ""
+		if i > 0 {
+			prev = a.entries[i-1].Hash
+		}
+		a.entries[i].seal(prev)
+	}
+	return changed
+}
+
+// purgeActors blanks the actor of entries recorded before cutoff, returning
+// how many it blanked. The actor digest stays, so everything else in the
+// entry is still verified.
+func (a *auditLog) purgeActors(cutoff time.Time) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	purged := 0
+	for i := range a.entries {
+		if a.entries[i].Time.Before(cutoff) && a.entries[i].Actor != "" {
+			a.entries[i].Actor = ""
+			purged++
+		}
+	}
+	return purged
+}
+
+type AuditVerification struct {
+	OK       bool   `json:"ok"`
+	Entries  int    `json:"entries"`
+	Redacted int    `json:"redacted"`
+	Head     string `json:"head"`
+	BadSeq   *int   `json:"bad_seq,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// verify walks the chain, checking every hash link, that the content still
+// matches its digest and, unless it has been purged, that the actor does too.
+func (a *auditLog) verify() AuditVerification {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	v := AuditVerification{OK: true, Entries: len(a.entries)}
+	fail := func(seq int, format string, args ...any) AuditVerification {
+		v.OK = false
+		v.BadSeq = &seq
+		v.Error = fmt.Sprintf(format, args...)
+		return v
+	}
+
+	prev := ""
+	for i := range a.entries {
+		e := &a.entries[i]
+		if e.Seq != i {
+			return fail(i, "entry %d has sequence number %d", i, e.Seq)
+		}
+		if e.Prev != prev {
+			return fail(i, "entry %d does not follow the previous entry", i)
+		}
+		if e.chainHash() != e.Hash {
+			return fail(i, "entry %d hash mismatch", i)
+		}
+		if digest, err := e.contentDigest(); err != nil || digest != e.Digest {
+			return fail(i, "entry %d content does not match its digest", i)
+		}
+		if e.Actor != "" {
+			if digest, _ := auditDigest(e.Actor); digest != e.ActorDigest {
+				return fail(i, "entry %d actor does not match its digest", i)
+			}
+		}
+		if e.Redacted || e.Actor == "" {
+			v.Redacted++
+		}
+		prev = e.Hash
+	}
+	v.Head = prev
+	return v
+}
+
+func (s *HighScoreServer) auditEntries(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.audit.mutex.Lock()
+	entries := make([]AuditEntry, len(s.audit.entries))
+	copy(entries, s.audit.entries)
+	s.audit.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// auditVerify reports whether the audit chain is intact. Its head hash can be
+// published (e.g. on the big screen at close) so that later tampering with the
+// log is detectable by anyone who noted it.
+func (s *HighScoreServer) auditVerify(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.audit.verify())
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"elevate2024/internal/assets"
+)
+
+// INDEX_BASE is the <base> tag in index.html, which points the game's relative
+// URLs at the root even on deep links. Under a base path it is rewritten to
+// point there instead.
+const INDEX_BASE = `<base href="/" />`
+
+// checkBasePath validates -base-path: empty, or a clean path such as /arcade.
+func checkBasePath(base string) error {
+	if base != "" && (!strings.HasPrefix(base, "/") || base == "/" || path.Clean(base) != base) {
+		return fmt.Errorf("base path %q must be a path such as /arcade, without a trailing slash", base)
+	}
+	return nil
+}
+
+// Mount serves next under the configured base path, for a server behind a
+// reverse proxy that forwards e.g. /arcade/ to it without stripping the
+// prefix. Handlers see paths as if served from the root, and links the server
+// generates include the prefix. Without a base path it returns next as is.
+func (s *HighScoreServer) Mount(next http.Handler) http.Handler {
+	if s.basePath == "" {
+		return next
+	}
+	stripped := http.StripPrefix(s.basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == s.basePath {
+			http.Redirect(w, r, s.basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, s.basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
+
+// serveIndex serves the game's page, with its <base> under the base path.
+// Only the page as built can be served precompressed.
+func (s *HighScoreServer) serveIndex(w http.ResponseWriter, r *http.Request, static fs.FS, manifest *assets.Manifest) {
+	if s.basePath == "" {
+		if !manifest.Serve(w, r, static, "index.html") {
+			http.ServeFileFS(w, r, static, "index.html")
+		}
+		return
+	}
+	data, err := fs.ReadFile(static, "index.html")
+	if err != nil {
+		s.errorPage(w, r, http.StatusNotFound)
+		return
+	}
+	data = bytes.Replace(data, []byte(INDEX_BASE), []byte(`<base href="`+s.basePath+`/" />`), 1)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newBenchServer(b *testing.B) *HighScoreServer {
+	config := DefaultConfig()
+	config.AdminPassword = TEST_PASSWORD
+	s, err := NewHighScoreServer(WithConfig(config))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+// BenchmarkInsertScore adds accepted scores with all the bookkeeping that
+// goes with them: results, the feed, the cutoff and events.
+func BenchmarkInsertScore(b *testing.B) {
+	s := newBenchServer(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := range b.N {
+		if _, _, err := s.insertScore(ctx, Score{PlayerName: "AAA", RemainingHealth: i % 100}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStreamTick takes board snapshots from 1000 goroutines, as a tick
+// of 1000 /events clients does, while scores keep arriving.
+func BenchmarkStreamTick(b *testing.B) {
+	s := newBenchServer(b)
+	for i := range s.boardSize {
+		s.board.Add(Score{ID: strconv.Itoa(i), PlayerName: "AAA", RemainingHealth: i})
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				s.insertScore(context.Background(), Score{PlayerName: "BBB", RemainingHealth: i % 40})
+			}
+		}
+	}()
+
+	snapshots := map[string]func() ([]byte, error){
+		// what every client did before the board was shared
+		"per-client": func() ([]byte, error) {
+			scores, err := s.topScores(context.Background(), s.boardSize)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(scores)
+		},
+		"shared": s.boardSnapshot,
+	}
+	for _, name := range []string{"per-client", "shared"} {
+		snapshot := snapshots[name]
+		b.Run(name, func(b *testing.B) {
+			b.SetParallelism(max(1, 1000/runtime.GOMAXPROCS(0)))
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := snapshot(); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"elevate2024/internal/store"
+)
+
+// A TeamStanding is one team's line on the team board.
+type TeamStanding struct {
+	Team string `json:"team"`
+	// the team's best run, which the board is ranked by
+	Best    Score `json:"best"`
+	Players int   `json:"players"`
+	Runs    int   `json:"runs"`
+}
+
+// heldScores returns every score the server holds that the public may see,
+// best first, including those that have fallen off the bottom of the board.
+func (s *HighScoreServer) heldScores(r *http.Request, op string) ([]Score, error) {
+	if err := s.lockBoard(r.Context(), op); err != nil {
+		return nil, err
+	}
+	scores := make([]Score, 0, len(s.results))
+	for _, result := range s.results {
+		if result.Deleted.IsZero() && !result.Shadow {
+			scores = append(scores, result.Score)
+		}
+	}
+	s.mutex.Unlock()
+	slices.SortFunc(scores, store.Cmp)
+	return scores, nil
+}
+
+// startOfDay returns the midnight, in the server's time zone, that began the
+// day now falls in.
+func startOfDay(now time.Time) time.Time {
+	year, month, day := now.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+}
+
+// teamStandings ranks the teams in scores, which are best first, by their
+// best run. Scores without a team are left out.
+func teamStandings(scores []Score) []TeamStanding {
+	standings := []TeamStanding{}
+	index := map[string]int{}
+	players := map[string]map[string]bool{}
+	for _, score := range scores {
+		if score.Team == "" {
+			continue
+		}
+		i, ok := index[score.Team]
+		if !ok {
+			i = len(standings)
+			index[score.Team] = i
+			standings = append(standings, TeamStanding{Team: score.Team, Best: score})
+			players[score.Team] = map[string]bool{}
+		}
+		standings[i].Runs++
+		if !players[score.Team][score.PlayerName] {
+			players[score.Team][score.PlayerName] = true
+			standings[i].Players++
+		}
+	}
+	return standings
+}
+
+// getDailyScores serves GET /scores/daily: the best of today's runs, taking
+// the same ?board=, ?top= and ?team= as /scores.
+func (s *HighScoreServer) getDailyScores(w http.ResponseWriter, r *http.Request) {
+	filter, err := s.parseBoardFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scores, err := s.heldScores(r, "daily")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	since := startOfDay(s.now())
+	scores = slices.DeleteFunc(scores, func(score Score) bool {
+		return score.Submitted.Before(since)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(filter.apply(scores))
+}
+
+// getTeams serves GET /teams, the team board.
+func (s *HighScoreServer) getTeams(w http.ResponseWriter, r *http.Request) {
+	top, err := intParam(r, "top", s.boardSize, s.boardSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scores, err := s.heldScores(r, "teams")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	standings := teamStandings(scores)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(standings[:min(top, len(standings))])
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"elevate2024/internal/broadcast"
+)
+
+// A BracketMatch is one game in a single-elimination bracket. An empty player
+// is a bye, and the other player advances without playing.
+type BracketMatch struct {
+	ID      string `json:"id"`
+	PlayerA string `json:"player_a"`
+	PlayerB string `json:"player_b"`
+	Winner  string `json:"winner,omitempty"`
+}
+
+type Bracket struct {
+	Created  time.Time        `json:"created"`
+	Rounds   [][]BracketMatch `json:"rounds"`
+	Champion string           `json:"champion,omitempty"`
+}
+
+type bracketState struct {
+	mutex   sync.Mutex
+	bracket *Bracket
+}
+
+// seedOrder returns the seeds 1..n (a power of two) in bracket order, so that
+// the top seeds can only meet in the final rounds: 1 8 4 5 2 7 3 6 for n = 8.
+func seedOrder(n int) []int {
+	order := []int{1}
+	for len(order) < n {
+		next := make([]int, 0, len(order)*2)
+		for _, seed := range order {
+			next = append(next, seed, len(order)*2+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// newBracket seeds the qualifiers, best first, into a bracket padded with
+// byes up to the next power of two, created at the given time.
+func newBracket(qualifiers []string, created time.Time) *Bracket {
+	size := 1
+	for size < len(qualifiers) {
+		size *= 2
+	}
+	order := seedOrder(size)
+	player := func(seed int) string {
+		if seed > len(qualifiers) {
+			return ""
+		}
+		return qualifiers[seed-1]
+	}
+
+	var round []BracketMatch
+	for i := 0; i < len(order); i += 2 {
+		round = append(round, BracketMatch{
+			ID:      fmt.Sprintf("r1m%d", len(round)+1),
+			PlayerA: player(order[i]),
+			PlayerB: player(order[i+1]),
+		})
+	}
+	b := &Bracket{Created: created, Rounds: [][]BracketMatch{round}}
+	b.settleByes()
+	return b
+}
+
+// clone copies the bracket, which reporting results changes in place.
+func (b *Bracket) clone() *Bracket {
+	if b == nil {
+		return nil
+	}
+	copied := *b
+	copied.Rounds = make([][]BracketMatch, len(b.Rounds))
+	for i, round := range b.Rounds {
+		copied.Rounds[i] = slices.Clone(round)
+	}
+	return &copied
+}
+
+func (b *Bracket) current() []BracketMatch {
+	return b.Rounds[len(b.Rounds)-1]
+}
+
+// settleByes advances players who have no opponent in the current round.
+func (b *Bracket) settleByes() {
+	round := b.current()
+	for i := range round {
+		switch {
+		case round[i].PlayerB == "":
+			round[i].Winner = round[i].PlayerA
+		case round[i].PlayerA == "":
+			round[i].Winner = round[i].PlayerB
+		}
+	}
+}
+
+// report records the winner of a match in the current round.
+func (b *Bracket) report(id string, winner string) (BracketMatch, error) {
+	round := b.current()
+	for i := range round {
+		if round[i].ID != id {
+			continue
+		}
+		if winner == "" || (winner != round[i].PlayerA && winner != round[i].PlayerB) {
+			return BracketMatch{}, errors.New("winner must be one of the players")
+		}
+		if round[i].Winner != "" {
+			return BracketMatch{}, fmt.Errorf("match %v already has a winner", id)
+		}
+		round[i].Winner = winner
+		return round[i], nil
+	}
+	return BracketMatch{}, fmt.Errorf("no match %q in the current round", id)
+}
+
+// advance starts the next round once every match in the current one has a
+// winner, or crowns the champion after the final.
+func (b *Bracket) advance() error {
+	if b.Champion != "" {
+		return errors.New("the bracket is finished")
+	}
+	round := b.current()
+	for _, match := range round {
+		if match.Winner == "" {
+			return fmt.Errorf("match %v has no winner yet", match.ID)
+		}
+	}
+	if len(round) == 1 {
+		b.Champion = round[0].Winner
+		return nil
+	}
+
+	n := len(b.Rounds) + 1
+	var next []BracketMatch
+	for i := 0; i < len(round); i += 2 {
+		next = append(next, BracketMatch{
+			ID:      fmt.Sprintf("r%dm%d", n, len(next)+1),
+			PlayerA: round[i].Winner,
+			PlayerB: round[i+1].Winner,
+		})
+	}
+	b.Rounds = append(b.Rounds, next)
+	return nil
+}
+
+// qualifiers returns the best n distinct players on the board.
+func (s *HighScoreServer) qualifiers(ctx context.Context, n int) ([]string, error) {
+	scores, err := s.topScores(ctx, s.boardSize)
+	if err != nil {
+		return nil, err
+	}
+	var players []string
+	for _, score := range qualifyingScores(scores, n) {
+		players = append(players, score.PlayerName)
+	}
+	return players, nil
+}
+
+// createBracket seeds a new bracket from the top of the board, replacing any
+// existing one, so it takes a TOTP code like other destructive actions.
+func (s *HighScoreServer) createBracket(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !s.requireTOTP(w, r) {
+		return
+	}
+
+	size, err := intParam(r, "size", 8, s.boardSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	qualifiers, err := s.qualifiers(r.Context(), size)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if len(qualifiers) < 2 {
+		http.Error(w, "need at least two players on the board", http.StatusConflict)
+		return
+	}
+
+	if _, err := s.apply(r.Context(), boardCommand{Op: COMMAND_BRACKET_CREATE, Players: qualifiers, Time: s.now()}); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "bracket-create", "", nil, qualifiers)
+	s.writeBracket(w)
+}
+
+// reportBracketMatch records a winner in the current round. Bracket games
+// also count towards the head-to-head ladder, as of when they are reported.
+func (s *HighScoreServer) reportBracketMatch(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Winner string `json:"winner"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_BRACKET_RESULT, ID: r.PathValue("id"), Winner: body.Winner, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !result.Found {
+		http.NotFound(w, r)
+		return
+	}
+	if result.Refused != nil {
+		http.Error(w, result.Refused.Error(), http.StatusBadRequest)
+		return
+	}
+	match := result.Match
+
+	s.audit.record(s.clientIP(r), "bracket-result", "", nil, match)
+	s.writeBracket(w)
+}
+
+func (s *HighScoreServer) advanceBracket(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_BRACKET_ADVANCE, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !result.Found {
+		http.NotFound(w, r)
+		return
+	}
+	if result.Refused != nil {
+		http.Error(w, result.Refused.Error(), http.StatusConflict)
+		return
+	}
+	s.bracket.mutex.Lock()
+	rounds := len(s.bracket.bracket.Rounds)
+	s.bracket.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "bracket-advance", "", nil, rounds)
+	s.writeBracket(w)
+}
+
+// bracketSnapshot returns the bracket as JSON, or null if there isn't one.
+func (s *HighScoreServer) bracketSnapshot() ([]byte, error) {
+	s.bracket.mutex.Lock()
+	defer s.bracket.mutex.Unlock()
+	return json.Marshal(s.bracket.bracket)
+}
+
+func (s *HighScoreServer) writeBracket(w http.ResponseWriter) {
+	data, err := s.bracketSnapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+func (s *HighScoreServer) getBracket(w http.ResponseWriter, r *http.Request) {
+	s.writeBracket(w)
+}
+
+func (s *HighScoreServer) bracketStream(w http.ResponseWriter, r *http.Request) {
+	broadcast.Serve(w, r, broadcast.Stream[BoardEvent]{Snapshot: s.bracketSnapshot})
+}
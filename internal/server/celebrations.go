@@ -0,0 +1,105 @@
+package server
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Celebration kinds, in order of precedence: when milestones pile up within
+// CELEBRATION_GAP, the first of these wins.
+const (
+	CELEBRATE_RUNS       = "runs"       // every CelebrateEvery-th accepted run
+	CELEBRATE_FAST_RUN   = "fast_run"   // the first run under CelebrateUnder
+	CELEBRATE_NEW_LEADER = "new_leader" // a run that took first place
+)
+
+// CELEBRATIONS are the celebration kinds, most important first.
+var CELEBRATIONS = []string{CELEBRATE_RUNS, CELEBRATE_FAST_RUN, CELEBRATE_NEW_LEADER}
+
+// CELEBRATION_GAP is the least time between celebrations, long enough for a
+// fanfare to finish before the next begins.
+const CELEBRATION_GAP = 6 * time.Second
+
+// A Celebration is a milestone for displays to mark with a sound or an
+// animation.
+type Celebration struct {
+	Kind string `json:"kind"`
+	// the run that reached it
+	Score Score `json:"score"`
+	Rank  int   `json:"rank"`
+	// how many runs have been accepted, for run milestones
+	Runs int `json:"runs,omitempty"`
+}
+
+// celebrations spots milestones as runs are accepted and spaces them out.
+// Only one waits at a time: a more important milestone replaces it, and a
+// lesser one is dropped.
+type celebrations struct {
+	mutex sync.Mutex
+	// accept a run under under, and every every-th run; 0 turns either off
+	under time.Duration
+	every int
+	gap   time.Duration
+
+	runs    int
+	fast    bool
+	pending *Celebration
+	// when the last celebration was sent
+	last time.Time
+}
+
+// accepted counts a run accepted at rank, queueing its most important
+// milestone, if any.
+func (c *celebrations) accepted(score Score, rank int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.runs++
+	var reached []string
+	if c.every > 0 && c.runs%c.every == 0 {
+		reached = append(reached, CELEBRATE_RUNS)
+	}
+	if c.under > 0 && !c.fast && score.Elapsed < c.under.Seconds() {
+		c.fast = true
+		reached = append(reached, CELEBRATE_FAST_RUN)
+	}
+	if rank == 1 {
+		reached = append(reached, CELEBRATE_NEW_LEADER)
+	}
+	if len(reached) == 0 {
+		return
+	}
+	kind := reached[0]
+	if c.pending != nil && slices.Index(CELEBRATIONS, c.pending.Kind) < slices.Index(CELEBRATIONS, kind) {
+		return
+	}
+	c.pending = &Celebration{Kind: kind, Score: score, Rank: rank, Runs: c.runs}
+}
+
+// due returns the celebration to send at now, if one is waiting and the last
+// has had its time.
+func (c *celebrations) due(now time.Time) (*Celebration, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.pending == nil || now.Before(c.last.Add(c.gap)) {
+		return nil, false
+	}
+	celebration := c.pending
+	c.pending, c.last = nil, now
+	return celebration, true
+}
+
+// broadcastCelebrations sends a "celebration" event to /events subscribers
+// for each milestone, at most one per gap so a burst of submissions doesn't
+// set off overlapping fanfares.
+func (s *HighScoreServer) broadcastCelebrations(interval time.Duration) {
+	for range time.Tick(interval) {
+		celebration, ok := s.celebrations.due(time.Now())
+		if !ok {
+			continue
+		}
+		s.live.Publish(BoardEvent{Type: EVENT_CELEBRATION, Time: s.now(), Celebration: celebration})
+	}
+}
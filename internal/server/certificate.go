@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// US Letter, landscape, in PDF points.
+const (
+	pdfPageWidth  = 792
+	pdfPageHeight = 612
+)
+
+// pdfString escapes s for use as a PDF literal string. The standard fonts only
+// cover Latin-1, so anything outside printable ASCII is replaced.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, c := range s {
+		switch {
+		case c == '\\' || c == '(' || c == ')':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < ' ' || c > '~':
+			b.WriteByte('?')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// centredText emits a line of Courier text centred on the page. Courier is
+// monospaced at 0.6em per glyph, so no font metrics are needed.
+func centredText(b *bytes.Buffer, font string, size float64, y float64, s string) {
+	width := 0.6 * size * float64(len([]rune(s)))
+	fmt.Fprintf(b, "BT /%s %g Tf %g %g Td %s Tj ET\n", font, size, (pdfPageWidth-width)/2, y, pdfString(s))
+}
+
+// certificatePDF builds a single-page PDF by hand; the document is small and
+// fixed enough that pulling in a PDF library isn't worthwhile.
+func certificatePDF(event string, score Score, rank int, date time.Time) []byte {
+	var content bytes.Buffer
+	// double border
+	content.WriteString("0.2 0.45 0.2 RG 6 w 24 24 744 564 re S 1.5 w 36 36 720 540 re S\n")
+	content.WriteString("0 0 0 rg\n")
+	centredText(&content, "F2", 36, 480, "CERTIFICATE OF ACHIEVEMENT")
+	centredText(&content, "F1", 18, 420, "This certifies that")
+	centredText(&content, "F2", 96, 320, score.PlayerName)
+	centredText(&content, "F1", 24, 250, fmt.Sprintf("placed #%d", rank))
+	centredText(&content, "F1", 16, 215, fmt.Sprintf("with %d health remaining in %.2f seconds", score.RemainingHealth, score.Elapsed))
+	centredText(&content, "F2", 20, 140, event)
+	centredText(&content, "F1", 14, 110, date.Format("January 2, 2006"))
+	return singlePagePDF(pdfPageWidth, pdfPageHeight, content.Bytes())
+}
+
+// singlePagePDF wraps drawing operators in a one-page document of the given
+// size, with Courier as /F1 and Courier-Bold as /F2.
+func singlePagePDF(width int, height int, content []byte) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>",
+			width, height),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// currentRank returns the score's position on the board, or its rank at
+// submission if it has since dropped off.
+func (s *HighScoreServer) currentRank(ctx context.Context, result Result) (int, error) {
+	scores, err := s.topScores(ctx, s.boardSize)
+	if err != nil {
+		return 0, err
+	}
+	for i, score := range scores {
+		if score.ID == result.Score.ID {
+			return i + 1, nil
+		}
+	}
+	return result.Rank, nil
+}
+
+func (s *HighScoreServer) certificate(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(r.PathValue("file"), ".pdf")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	result, ok, err := s.lookupResult(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	rank, err := s.currentRank(r.Context(), result)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	pdf := certificatePDF(s.eventName, result.Score, rank, result.Submitted)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "certificate-"+id+".pdf"))
+	w.Write(pdf)
+}
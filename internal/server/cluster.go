@@ -0,0 +1,487 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// A cluster of servers replicates the board with Raft, so the board stays up
+// when a machine dies. Every change to the board and its results goes
+// through the leader as a boardCommand, and each node applies it to its own
+// copy once a majority has it, so any node can serve reads and /events.
+// Writes sent to a follower are forwarded to the leader.
+//
+// Replicated are the board, results and feed, and what decides the
+// competition around them: quarantine, reserved names, draws, the locked
+// prizes, the head-to-head ladder and the bracket, and so are the leader's
+// retention purges of them. Audit entries, emails, replays, honeypot shadows
+// and federation state stay with the node that handled the request, which is
+// the leader at the time. The cluster keeps its log in memory, like the
+// board: a node that restarts catches up from the others, but the board is
+// lost if they all do.
+
+// CLUSTER_APPLY_TIMEOUT is how long a change waits to be committed by the
+// cluster when the store timeout doesn't say.
+const CLUSTER_APPLY_TIMEOUT = 10 * time.Second
+
+// CLUSTER_CLIENT_HEADER carries the address of the client a follower
+// forwarded a write for, so the leader rate limits and audits the client
+// rather than the follower.
+const CLUSTER_CLIENT_HEADER = "X-Cluster-Client"
+
+type clusterPeer struct {
+	id   raft.ServerID
+	addr raft.ServerAddress
+	// where the peer serves HTTP, and its admin routes if elsewhere, for
+	// forwarding writes to it
+	url   *url.URL
+	proxy *httputil.ReverseProxy
+	admin *httputil.ReverseProxy
+}
+
+type cluster struct {
+	id    raft.ServerID
+	peers []clusterPeer
+	// hosts peers connect from, whose forwarded client addresses are
+	// trusted
+	trusted   map[string]bool
+	transport *raft.NetworkTransport
+	logger    hclog.Logger
+	timeout   time.Duration
+
+	// set once Start has begun running Raft
+	node atomic.Pointer[raft.Raft]
+}
+
+// parseRaftPeers parses a comma-separated list of
+// "ID=RAFT_ADDR=HTTP_URL[=ADMIN_URL]" cluster nodes.
+func parseRaftPeers(spec string) ([]clusterPeer, error) {
+	parseURL := func(field string, s string) (*url.URL, error) {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("raft peer %q: %q is not an http(s) URL", field, s)
+		}
+		return u, nil
+	}
+
+	var peers []clusterPeer
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, "=", 4)
+		if len(parts) < 3 || parts[0] == "" {
+			return nil, fmt.Errorf("raft peer %q must be ID=RAFT_ADDR=HTTP_URL[=ADMIN_URL]", field)
+		}
+		if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+			return nil, fmt.Errorf("raft peer %q: %v", field, err)
+		}
+		u, err := parseURL(field, parts[2])
+		if err != nil {
+			return nil, err
+		}
+		id := raft.ServerID(parts[0])
+		if slices.ContainsFunc(peers, func(p clusterPeer) bool { return p.id == id }) {
+			return nil, fmt.Errorf("raft peer %q given twice", id)
+		}
+		peer := clusterPeer{
+			id:    id,
+			addr:  raft.ServerAddress(parts[1]),
+			url:   u,
+			proxy: httputil.NewSingleHostReverseProxy(u),
+		}
+		peer.admin = peer.proxy
+		if len(parts) == 4 {
+			admin, err := parseURL(field, parts[3])
+			if err != nil {
+				return nil, err
+			}
+			peer.admin = httputil.NewSingleHostReverseProxy(admin)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// newCluster sets up this node's part in the cluster config describes,
+// listening for Raft traffic, or returns nil if config doesn't describe one.
+// Raft itself starts with the server.
+func newCluster(config Config) (*cluster, error) {
+	if config.RaftID == "" {
+		if config.RaftPeers != "" || config.RaftBind != "" {
+			return nil, errors.New("raft peers need the ID of this node")
+		}
+		return nil, nil
+	}
+	// Any node may mint a token another ends up verifying.
+	if config.TokenKeys == "" {
+		return nil, errors.New("a cluster needs token keys shared by every node")
+	}
+	if config.MirrorOf != "" {
+		return nil, errors.New("a mirror can't be part of a cluster")
+	}
+	peers, err := parseRaftPeers(config.RaftPeers)
+	if err != nil {
+		return nil, err
+	}
+	id := raft.ServerID(config.RaftID)
+	i := slices.IndexFunc(peers, func(p clusterPeer) bool { return p.id == id })
+	if i < 0 {
+		return nil, fmt.Errorf("raft ID %q is not among the peers", id)
+	}
+
+	c := &cluster{
+		id:      id,
+		peers:   peers,
+		trusted: map[string]bool{},
+		logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "raft",
+			Level:  hclog.Warn,
+			Output: log.Writer(),
+		}),
+		timeout: CLUSTER_APPLY_TIMEOUT,
+	}
+	if config.StoreTimeout > 0 {
+		c.timeout = config.StoreTimeout
+	}
+	for _, peer := range peers {
+		if peer.id == id {
+			continue
+		}
+		host, _, _ := net.SplitHostPort(string(peer.addr))
+		if net.ParseIP(host) != nil {
+			c.trusted[host] = true
+			continue
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			log.Printf("Can't resolve raft peer %v, so writes it forwards are attributed to it: %v\n", peer.id, err)
+		}
+		for _, addr := range addrs {
+			c.trusted[addr] = true
+		}
+	}
+
+	advertise, err := net.ResolveTCPAddr("tcp", string(peers[i].addr))
+	if err != nil {
+		return nil, err
+	}
+	bind := config.RaftBind
+	if bind == "" {
+		bind = string(peers[i].addr)
+	}
+	c.transport, err = raft.NewTCPTransportWithLogger(bind, advertise, 3, 10*time.Second, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// start runs Raft with fsm, bootstrapping the cluster from the configured
+// peers. Every node bootstraps the same way, so it doesn't matter which
+// comes up first.
+func (c *cluster) start(fsm raft.FSM) error {
+	conf := raft.DefaultConfig()
+	conf.LocalID = c.id
+	conf.Logger = c.logger
+	node, err := raft.NewRaft(conf, fsm, raft.NewInmemStore(), raft.NewInmemStore(), raft.NewInmemSnapshotStore(), c.transport)
+	if err != nil {
+		return err
+	}
+	var servers []raft.Server
+	for _, peer := range c.peers {
+		servers = append(servers, raft.Server{ID: peer.id, Address: peer.addr})
+	}
+	err = node.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+		node.Shutdown()
+		return err
+	}
+	c.node.Store(node)
+	return nil
+}
+
+// shutdown stops this node taking part in the cluster.
+func (c *cluster) shutdown() error {
+	if node := c.node.Load(); node != nil {
+		if err := node.Shutdown().Error(); err != nil {
+			return err
+		}
+	}
+	return c.transport.Close()
+}
+
+// leader returns the peer leading the cluster, or local if it is this node.
+// Neither is set while there is no leader.
+func (c *cluster) leader() (peer *clusterPeer, local bool) {
+	node := c.node.Load()
+	if node == nil {
+		return nil, false
+	}
+	if node.State() == raft.Leader {
+		return nil, true
+	}
+	_, id := node.LeaderWithID()
+	for i := range c.peers {
+		if c.peers[i].id == id {
+			return &c.peers[i], false
+		}
+	}
+	return nil, false
+}
+
+// following reports whether this node is in a cluster it doesn't lead.
+func (c *cluster) following() bool {
+	if c == nil {
+		return false
+	}
+	_, local := c.leader()
+	return !local
+}
+
+// trusts reports whether a request from remoteAddr came from a peer.
+func (c *cluster) trusts(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	return err == nil && c.trusted[host]
+}
+
+type clusterResponse struct {
+	result commandResult
+	err    error
+}
+
+// replicate applies cmd on every node through the cluster, returning what it
+// did on this one, which must be the leader.
+func (c *cluster) replicate(ctx context.Context, cmd boardCommand) (commandResult, error) {
+	node := c.node.Load()
+	if node == nil {
+		return commandResult{}, &StoreError{Op: cmd.Op, Err: errors.New("cluster not started")}
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return commandResult{}, err
+	}
+	// Raft takes a zero timeout to mean no timeout at all.
+	if err := ctx.Err(); err != nil {
+		return commandResult{}, &StoreError{Op: cmd.Op, Err: err}
+	}
+	timeout := c.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+		if timeout <= 0 {
+			return commandResult{}, &StoreError{Op: cmd.Op, Err: context.DeadlineExceeded}
+		}
+	}
+	future := node.Apply(data, timeout)
+	if err := future.Error(); err != nil {
+		return commandResult{}, &StoreError{Op: cmd.Op, Err: err}
+	}
+	response := future.Response().(clusterResponse)
+	return response.result, response.err
+}
+
+// boardFSM applies the cluster's log to the server's board.
+type boardFSM struct {
+	s *HighScoreServer
+}
+
+func (f boardFSM) Apply(entry *raft.Log) any {
+	var cmd boardCommand
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		return clusterResponse{err: err}
+	}
+	f.s.mutex.Lock()
+	result, err := f.s.applyLocked(cmd)
+	f.s.mutex.Unlock()
+	if err == nil && result.after != nil {
+		result.after()
+		result.after = nil
+	}
+	return clusterResponse{result, err}
+}
+
+// A clusterSnapshot is the replicated state, for catching up nodes that have
+// fallen too far behind the log.
+type clusterSnapshot struct {
+	Scores  []Score
+	Results map[string]Result
+	Feed    []feedEntry
+	FeedSeq int
+
+	Quarantine  map[string]QuarantinedScore
+	Reserved    []string
+	DrawSeed    string
+	DrawPending *DrawCommitment
+	Draws       []Draw
+	Prizes      *LockedResult
+	Ratings     map[string]*Rating
+	Matches     []Match
+	Bracket     *Bracket
+}
+
+func (f boardFSM) Snapshot() (raft.FSMSnapshot, error) {
+	s := f.s
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := &clusterSnapshot{
+		Scores:  s.board.Scores(),
+		Results: make(map[string]Result, len(s.results)),
+		Feed:    slices.Clone(s.feed),
+		FeedSeq: s.feedSeq,
+	}
+	for id, result := range s.results {
+		// Replays stay with the node that recorded them.
+		result.Replay = nil
+		snapshot.Results[id] = result
+	}
+
+	// Everything below changes only through commands, applied with s.mutex
+	// held, but readers take the finer locks, so they are copied under them
+	// too. Snapshots are encoded later, outside the locks.
+	s.quarantine.mutex.Lock()
+	snapshot.Quarantine = maps.Clone(s.quarantine.entries)
+	s.quarantine.mutex.Unlock()
+	s.reserved.mutex.Lock()
+	for name := range s.reserved.names {
+		snapshot.Reserved = append(snapshot.Reserved, name)
+	}
+	s.reserved.mutex.Unlock()
+	s.draws.mutex.Lock()
+	snapshot.DrawSeed, snapshot.DrawPending = s.draws.seed, s.draws.pending
+	snapshot.Draws = slices.Clone(s.draws.draws)
+	s.draws.mutex.Unlock()
+	s.prizes.mutex.Lock()
+	snapshot.Prizes = s.prizes.result
+	s.prizes.mutex.Unlock()
+	s.ladder.mutex.Lock()
+	snapshot.Ratings = make(map[string]*Rating, len(s.ladder.ratings))
+	for name, rating := range s.ladder.ratings {
+		copied := *rating
+		snapshot.Ratings[name] = &copied
+	}
+	snapshot.Matches = slices.Clone(s.ladder.matches)
+	s.ladder.mutex.Unlock()
+	s.bracket.mutex.Lock()
+	snapshot.Bracket = s.bracket.bracket.clone()
+	s.bracket.mutex.Unlock()
+	return snapshot, nil
+}
+
+func (f boardFSM) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	var snapshot clusterSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+	if snapshot.Results == nil {
+		snapshot.Results = map[string]Result{}
+	}
+
+	s := f.s
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.board.Replace(snapshot.Scores)
+	s.results = snapshot.Results
+	s.feed = snapshot.Feed
+	s.feedSeq = snapshot.FeedSeq
+
+	s.quarantine.mutex.Lock()
+	s.quarantine.entries = snapshot.Quarantine
+	s.quarantine.mutex.Unlock()
+	s.reserved.mutex.Lock()
+	s.reserved.names = map[string]bool{}
+	for _, name := range snapshot.Reserved {
+		s.reserved.names[name] = true
+	}
+	s.reserved.mutex.Unlock()
+	s.draws.mutex.Lock()
+	s.draws.seed, s.draws.pending, s.draws.draws = snapshot.DrawSeed, snapshot.DrawPending, snapshot.Draws
+	s.draws.mutex.Unlock()
+	s.prizes.mutex.Lock()
+	s.prizes.result = snapshot.Prizes
+	s.prizes.mutex.Unlock()
+	s.ladder.mutex.Lock()
+	s.ladder.ratings, s.ladder.matches = snapshot.Ratings, snapshot.Matches
+	s.ladder.mutex.Unlock()
+	s.bracket.mutex.Lock()
+	s.bracket.bracket = snapshot.Bracket
+	s.bracket.mutex.Unlock()
+	return nil
+}
+
+func (s *clusterSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *clusterSnapshot) Release() {}
+
+// ForwardWrites wraps h to send writes, and WebSocket sessions, to the
+// cluster leader when this server is a follower, answering 503 while the
+// cluster has no leader. Reads, including /events, are served by every node.
+// Without a cluster, h is returned as is.
+func (s *HighScoreServer) ForwardWrites(h http.Handler) http.Handler {
+	return s.forwardWrites(h, func(peer *clusterPeer) *httputil.ReverseProxy { return peer.proxy })
+}
+
+// ForwardAdminWrites is ForwardWrites for a separate admin listener, sending
+// writes to the leader's admin URL.
+func (s *HighScoreServer) ForwardAdminWrites(h http.Handler) http.Handler {
+	return s.forwardWrites(h, func(peer *clusterPeer) *httputil.ReverseProxy { return peer.admin })
+}
+
+func (s *HighScoreServer) forwardWrites(h http.Handler, proxy func(*clusterPeer) *httputil.ReverseProxy) http.Handler {
+	c := s.cluster
+	if c == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded := false
+		if client := r.Header.Get(CLUSTER_CLIENT_HEADER); client != "" && c.trusts(r.RemoteAddr) {
+			r.RemoteAddr = client
+			forwarded = true
+		}
+		// A write forwarded to a node that has since lost the lead fails
+		// there rather than going round again. WebSocket sessions submit
+		// runs, so they are played on the leader too.
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if forwarded || (read && !strings.EqualFold(r.Header.Get("Upgrade"), "websocket")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		leader, local := c.leader()
+		if local {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if leader == nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "no cluster leader", http.StatusServiceUnavailable)
+			return
+		}
+		r.Header.Set(CLUSTER_CLIENT_HEADER, r.RemoteAddr)
+		proxy(leader).ServeHTTP(w, r)
+	})
+}
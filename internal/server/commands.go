@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Board commands, the changes to the board and the competition around it that
+// a cluster replicates, named as the operation a 503 reports when the board
+// is unavailable. Everything else a node keeps, such as its audit log and
+// live tallies, is its own.
+const (
+	COMMAND_INSERT        = "insert"
+	COMMAND_RESET         = "reset"
+	COMMAND_DELETE        = "delete"
+	COMMAND_RESTORE       = "restore"
+	COMMAND_UPDATE        = "update"
+	COMMAND_DELETE_PLAYER = "delete-player"
+
+	COMMAND_QUARANTINE   = "quarantine"
+	COMMAND_UNQUARANTINE = "unquarantine"
+	COMMAND_RESERVE      = "reserve"
+	COMMAND_RELEASE      = "release"
+	COMMAND_DRAW_COMMIT  = "draw-commit"
+	COMMAND_DRAW         = "draw"
+	COMMAND_LOCK         = "lock"
+
+	COMMAND_MATCH           = "match"
+	COMMAND_BRACKET_CREATE  = "bracket-create"
+	COMMAND_BRACKET_RESULT  = "bracket-result"
+	COMMAND_BRACKET_ADVANCE = "bracket-advance"
+
+	COMMAND_PURGE = "purge"
+)
+
+// A boardCommand describes a change to the board and the results behind it.
+// Commands carry everything they need, such as the time of a deletion, so
+// every node applying one ends up with the same board.
+type boardCommand struct {
+	Op    string      `json:"op"`
+	Score *Score      `json:"score,omitempty"`
+	ID    string      `json:"id,omitempty"`
+	Name  string      `json:"name,omitempty"`
+	Patch *ScorePatch `json:"patch,omitempty"`
+	Time  time.Time   `json:"time"`
+
+	Quarantined *QuarantinedScore `json:"quarantined,omitempty"`
+	// a draw's seed and its commitment, and the draw made with them
+	Seed       string          `json:"seed,omitempty"`
+	Commitment *DrawCommitment `json:"commitment,omitempty"`
+	Draw       *Draw           `json:"draw,omitempty"`
+	Match      *Match          `json:"match,omitempty"`
+	// a bracket's qualifiers, best first, and a bracket match's winner
+	Players []string `json:"players,omitempty"`
+	Winner  string   `json:"winner,omitempty"`
+	// the retention cutoffs to purge the board with
+	Purge []retentionCutoff `json:"purge,omitempty"`
+}
+
+// A commandResult is what applying a boardCommand did.
+type commandResult struct {
+	// the score placed, deleted, restored or edited, as it is now
+	Score Score
+	// an edited score as it was before
+	Before Score
+	// where a placed score went on the board
+	Rank int
+	// whether the score a command named was found
+	Found bool
+	// the scores a reset cleared from the board
+	Cleared []Score
+	// what an erasure removed from the board, results, feed and quarantine
+	Erased DeletionReport
+	// a score taken out of quarantine
+	Quarantined QuarantinedScore
+	// the players' ratings after a match
+	Ratings []Rating
+	// a bracket match as reported
+	Match BracketMatch
+	// the prizes as awarded
+	Locked LockedResult
+	// how many items each retention rule purged
+	Purged map[string]int
+	// why the command changed nothing, for the client
+	Refused error
+
+	// run once s.mutex is released, to tell everyone about the change
+	after func()
+}
+
+// apply makes a change to the board: through the cluster when there is one,
+// so every node makes it, or directly otherwise.
+func (s *HighScoreServer) apply(ctx context.Context, cmd boardCommand) (commandResult, error) {
+	if s.cluster != nil {
+		return s.cluster.replicate(ctx, cmd)
+	}
+	if err := s.lockBoard(ctx, cmd.Op); err != nil {
+		return commandResult{}, err
+	}
+	result, err := s.applyLocked(cmd)
+	s.mutex.Unlock()
+	if err == nil && result.after != nil {
+		result.after()
+	}
+	return result, err
+}
+
+// applyLocked applies cmd to this node's board. The caller holds s.mutex, and
+// runs the result's after once it has released it.
+func (s *HighScoreServer) applyLocked(cmd boardCommand) (commandResult, error) {
+	switch cmd.Op {
+	case COMMAND_INSERT:
+		if cmd.Score == nil {
+			return commandResult{}, fmt.Errorf("%v command without a score", cmd.Op)
+		}
+		return s.placeLocked(*cmd.Score, cmd.Time), nil
+	case COMMAND_RESET:
+		return s.resetLocked(), nil
+	case COMMAND_DELETE:
+		return s.deleteLocked(cmd.ID, cmd.Time), nil
+	case COMMAND_RESTORE:
+		return s.restoreLocked(cmd.ID), nil
+	case COMMAND_UPDATE:
+		if cmd.Patch == nil {
+			return commandResult{}, fmt.Errorf("%v command without a patch", cmd.Op)
+		}
+		return s.editLocked(cmd.ID, *cmd.Patch), nil
+	case COMMAND_DELETE_PLAYER:
+		return s.eraseLocked(cmd.Name), nil
+	case COMMAND_QUARANTINE:
+		if cmd.Quarantined == nil {
+			return commandResult{}, fmt.Errorf("%v command without a score", cmd.Op)
+		}
+		s.quarantine.add(*cmd.Quarantined)
+		return commandResult{Found: true}, nil
+	case COMMAND_UNQUARANTINE:
+		entry, ok := s.quarantine.take(cmd.ID)
+		return commandResult{Quarantined: entry, Found: ok}, nil
+	case COMMAND_RESERVE:
+		return commandResult{Found: s.reserved.add(cmd.Name)}, nil
+	case COMMAND_RELEASE:
+		return commandResult{Found: s.reserved.release(cmd.Name)}, nil
+	case COMMAND_DRAW_COMMIT:
+		if cmd.Commitment == nil {
+			return commandResult{}, fmt.Errorf("%v command without a commitment", cmd.Op)
+		}
+		return commandResult{Refused: s.draws.commit(cmd.Seed, *cmd.Commitment)}, nil
+	case COMMAND_DRAW:
+		if cmd.Draw == nil {
+			return commandResult{}, fmt.Errorf("%v command without a draw", cmd.Op)
+		}
+		return commandResult{Refused: s.draws.record(*cmd.Draw)}, nil
+	case COMMAND_LOCK:
+		result, err := s.lockLocked(cmd.Time)
+		return commandResult{Locked: result, Refused: err}, nil
+	case COMMAND_MATCH:
+		if cmd.Match == nil {
+			return commandResult{}, fmt.Errorf("%v command without a match", cmd.Op)
+		}
+		a, b := s.ladder.record(*cmd.Match)
+		return commandResult{Ratings: []Rating{a, b}}, nil
+	case COMMAND_BRACKET_CREATE:
+		s.bracket.mutex.Lock()
+		s.bracket.bracket = newBracket(cmd.Players, cmd.Time)
+		s.bracket.mutex.Unlock()
+		return commandResult{Found: true}, nil
+	case COMMAND_BRACKET_RESULT:
+		return s.bracketResultLocked(cmd.ID, cmd.Winner, cmd.Time), nil
+	case COMMAND_BRACKET_ADVANCE:
+		return s.bracketAdvanceLocked(), nil
+	case COMMAND_PURGE:
+		return commandResult{Purged: s.purgeLocked(cmd.Purge)}, nil
+	}
+	return commandResult{}, fmt.Errorf("unknown board command %q", cmd.Op)
+}
+
+// placeLocked adds a normalized score that already has its ID to the board,
+// noting it in the feed as of at.
+func (s *HighScoreServer) placeLocked(score Score, at time.Time) commandResult {
+	rank := s.board.Rank(score)
+	previous, hadLeader := s.board.Leader()
+	s.recordFeed(score, rank, at)
+	s.results[score.ID] = Result{
+		Score:     score,
+		Rank:      rank,
+		Submitted: score.Submitted,
+	}
+	before := s.board.Scores()
+	s.board.Add(score)
+	knockedOut := s.checkCutoff(before, s.board.Scores(), at)
+
+	return commandResult{Score: score, Rank: rank, Found: true, after: func() {
+		s.timeseries.add(score)
+
+		now := s.now()
+		s.emit(BoardEvent{Type: EVENT_SCORE, Time: now, Score: &score, Rank: rank})
+		if rank == 1 && hadLeader {
+			s.emit(BoardEvent{Type: EVENT_LEAD_CHANGE, Time: now, Score: &score, Rank: rank, Previous: &previous})
+		}
+		for _, out := range knockedOut {
+			s.emit(BoardEvent{Type: EVENT_BUMPED, Time: now, Score: &score, Rank: rank, Previous: &out})
+		}
+	}}
+}
+
+// resetLocked clears the board. Results are kept, so share links still work.
+func (s *HighScoreServer) resetLocked() commandResult {
+	return commandResult{Cleared: s.board.Reset(), after: func() {
+		s.reactions.reset()
+		s.emit(BoardEvent{Type: EVENT_RESET, Time: s.now()})
+	}}
+}
+
+// deleteLocked hides a score from the board as of at, keeping its result so
+// it can be restored.
+func (s *HighScoreServer) deleteLocked(id string, at time.Time) commandResult {
+	result, ok := s.results[id]
+	if !ok || !result.Deleted.IsZero() {
+		return commandResult{}
+	}
+	result.Deleted = at
+	s.results[id] = result
+	s.board.Remove(func(score Score) bool { return score.ID == id })
+	return commandResult{Score: result.Score, Found: true}
+}
+
+func (s *HighScoreServer) restoreLocked(id string) commandResult {
+	result, ok := s.results[id]
+	if !ok || result.Deleted.IsZero() {
+		return commandResult{}
+	}
+	result.Deleted = time.Time{}
+	s.results[id] = result
+	s.board.Add(result.Score)
+	return commandResult{Score: result.Score, Found: true}
+}
+
+// editLocked applies patch to the score with the given ID, both on the board
+// and in the stored results.
+func (s *HighScoreServer) editLocked(id string, patch ScorePatch) commandResult {
+	result, ok := s.results[id]
+	if !ok {
+		return commandResult{}
+	}
+	fn := func(score *Score) {
+		if patch.PlayerName != nil {
+			score.PlayerName = *patch.PlayerName
+		}
+		if patch.Team != nil {
+			score.Team = *patch.Team
+		}
+	}
+	before := result.Score
+	fn(&result.Score)
+	s.results[id] = result
+	s.board.Update(id, fn)
+	return commandResult{Score: result.Score, Before: before, Found: true}
+}
+
+// eraseLocked removes a player's scores from the board, results and
+// quarantine, and their entries from the feed, anonymizing them where they
+// took the lead from someone else.
+func (s *HighScoreServer) eraseLocked(name string) commandResult {
+	matches := func(score Score) bool { return score.PlayerName == name }
+	var report DeletionReport
+
+	report.Scores = s.board.Remove(matches)
+	for id, result := range s.results {
+		if matches(result.Score) {
+			delete(s.results, id)
+			report.Results++
+		}
+	}
+
+	before := len(s.feed)
+	s.feed = slices.DeleteFunc(s.feed, func(e feedEntry) bool { return matches(e.Score) })
+	report.FeedEntries = before - len(s.feed)
+	for i := range s.feed {
+		if leader := s.feed[i].Leader; leader != nil && matches(*leader) {
+			anonymized := *leader
+			anonymized.PlayerName = ANONYMIZED_NAME
+			s.feed[i].Leader = &anonymized
+			report.FeedLeaders++
+		}
+	}
+
+	s.quarantine.mutex.Lock()
+	for id, entry := range s.quarantine.entries {
+		if matches(entry.Score) {
+			delete(s.quarantine.entries, id)
+			report.Quarantined++
+		}
+	}
+	s.quarantine.mutex.Unlock()
+	return commandResult{Erased: report}
+}
+
+// lockLocked closes the event as of at and awards the prizes from the board
+// as it stands, with the seed of the committed draw. Standings are taken here,
+// with the board held, so they are exactly the board at the moment it was
+// locked, on every node.
+func (s *HighScoreServer) lockLocked(at time.Time) (LockedResult, error) {
+	s.prizes.mutex.Lock()
+	defer s.prizes.mutex.Unlock()
+
+	if s.prizes.result != nil {
+		return LockedResult{}, errPrizesLocked
+	}
+	commitment, seed, ok := s.draws.reveal()
+	if !ok {
+		return LockedResult{}, errNoCommitment
+	}
+	last := 0
+	for _, tier := range s.prizes.tiers {
+		last = max(last, tier.Last)
+	}
+	standings := qualifyingScores(s.prizeEligible(s.board.Scores()), last)
+	result := LockedResult{
+		Locked:     at,
+		Tiers:      s.prizes.tiers,
+		Commitment: commitment.Commitment,
+		Committed:  commitment.Committed,
+		Seed:       seed,
+		Standings:  standings,
+		Winners:    drawWinners(s.prizes.tiers, standings, seed),
+	}
+	result.Hash = result.hash()
+	s.prizes.result = &result
+	return result, nil
+}
+
+// bracketResultLocked records the winner of a bracket match, which also
+// counts towards the head-to-head ladder.
+func (s *HighScoreServer) bracketResultLocked(id string, winner string, at time.Time) commandResult {
+	s.bracket.mutex.Lock()
+	if s.bracket.bracket == nil {
+		s.bracket.mutex.Unlock()
+		return commandResult{}
+	}
+	match, err := s.bracket.bracket.report(id, winner)
+	s.bracket.mutex.Unlock()
+	if err != nil {
+		return commandResult{Found: true, Refused: err}
+	}
+	s.ladder.record(Match{PlayerA: match.PlayerA, PlayerB: match.PlayerB, Winner: match.Winner, Time: at})
+	return commandResult{Found: true, Match: match}
+}
+
+func (s *HighScoreServer) bracketAdvanceLocked() commandResult {
+	s.bracket.mutex.Lock()
+	defer s.bracket.mutex.Unlock()
+
+	if s.bracket.bracket == nil {
+		return commandResult{}
+	}
+	return commandResult{Found: true, Refused: s.bracket.bracket.advance()}
+}
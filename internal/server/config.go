@@ -0,0 +1,470 @@
+package server
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+
+	"elevate2024/internal/hll"
+	"elevate2024/internal/i18n"
+	"elevate2024/internal/store"
+)
+
+// Config is everything WithConfig sets to build a server, as given on the command
+// line. Empty values leave the corresponding feature off.
+type Config struct {
+	AdminPassword  string
+	AdminAllowCIDR string
+	TOTPSecret     string
+	StationKeys    string // file of "station-id key" lines
+	// the most runs a minute a client that isn't a registered device may
+	// submit from one address; 0 is unlimited
+	UntrustedSubmitLimit int
+	// the board this server hosts, named in its tokens, and a file of
+	// "board key" lines to sign them with its key
+	Board     string
+	TokenKeys string
+	// how start tokens are signed; one of TOKEN_SIGNING
+	TokenSigning string
+	// the bits of proof of work a client must do for a start token; 0 asks
+	// for none
+	ProofOfWork int
+
+	DeleteRetention     time.Duration
+	IPRetention         time.Duration
+	ReplayRetention     time.Duration
+	ResultRetention     time.Duration
+	ResultRetentionKeep int
+	IPMode              string
+
+	NATSURL      string
+	NATSSubject  string
+	MQTTURL      string
+	MQTTPrefix   string
+	MQTTQoS      int
+	KafkaBrokers string
+	KafkaTopic   string
+	Notify       []string
+	// the time of day, HH:MM, a report on the last day is sent to the
+	// notifiers; empty sends none
+	ReportAt string
+
+	QuarantineThreshold float64
+	QualifyTop          int
+	QualifyDeadline     string
+	PayloadMode         string
+	PayloadKey          string // file with the base64 private key
+	SMTPURL             string
+	SMTPFrom            string
+
+	// how long score operations wait for the board; 0 waits as long as
+	// the request does
+	StoreTimeout time.Duration
+	// the most health and elapsed time a score may have; 0 is unbounded
+	MaxHealth  int
+	MaxElapsed time.Duration
+	// whether /record takes the elapsed time from a signed /finish; one of
+	// FINISH_MODES
+	FinishMode string
+	// name=multiplier pairs scaling each difficulty's normalized score
+	Difficulties string
+	// the Content-Security-Policy sent with every response; see DEFAULT_CSP
+	ContentSecurityPolicy string
+	// how many submissions are processed at once, and how many more may
+	// wait their turn
+	SubmitWorkers int
+	SubmitQueue   int
+
+	EventName     string
+	KioskViews    string
+	KioskInterval time.Duration
+	Announcements []string
+	// celebrate the first run under CelebrateUnder and every
+	// CelebrateEvery-th run; 0 turns either off
+	CelebrateUnder time.Duration
+	CelebrateEvery int
+	// participation goals, each "METRIC=TARGET[:LABEL]"
+	Goals []string
+	// prize tiers, each "NAME=FIRST[-LAST][,draw=N]", awarded in order
+	Prizes []string
+	// warn players who take a name another client already has on the board,
+	// and suggest a variant
+	SuggestNames bool
+	// names reserved for staff and VIP demo runs, which win no prizes and,
+	// with HideReserved, stay off the public board
+	ReservedNames []string
+	HideReserved  bool
+
+	// A/B experiments, each "name:variant=weight,..."
+	Experiments []string
+
+	// a file of "venue key" lines for federation; this server's venue, and
+	// the primary a satellite syncs with and how often
+	FederationKeys     string
+	FederationVenue    string
+	FederationPrimary  string
+	FederationInterval time.Duration
+
+	// the server whose board this one mirrors, read-only
+	MirrorOf string
+
+	// this node's ID in a Raft cluster replicating the board, the address
+	// it listens on for Raft traffic if not its own in RaftPeers, and the
+	// cluster's nodes, each "ID=RAFT_ADDR=HTTP_URL[=ADMIN_URL]"; empty runs
+	// standalone
+	RaftID    string
+	RaftBind  string
+	RaftPeers string
+
+	// per-route caps on requests in flight and timeouts, each
+	// "PATH=MAX[,TIMEOUT]"
+	RouteLimits []string
+
+	// the path the server is mounted under behind a reverse proxy, such as
+	// /arcade; see Mount
+	BasePath string
+}
+
+// DefaultConfig returns the configuration the command line starts from.
+func DefaultConfig() Config {
+	return Config{
+		DeleteRetention:       24 * time.Hour,
+		IPRetention:           24 * time.Hour,
+		ReplayRetention:       48 * time.Hour,
+		ResultRetention:       7 * 24 * time.Hour,
+		ResultRetentionKeep:   100,
+		IPMode:                "raw",
+		NATSSubject:           "highscore",
+		MQTTPrefix:            "highscore",
+		KafkaTopic:            "highscore-submissions",
+		QuarantineThreshold:   1,
+		PayloadMode:           "off",
+		EventName:             "Elevate 2024",
+		KioskViews:            strings.Join(DEFAULT_KIOSK_VIEWS, ","),
+		KioskInterval:         15 * time.Second,
+		CelebrateEvery:        100,
+		StoreTimeout:          STORE_TIMEOUT,
+		MaxHealth:             MAX_HEALTH,
+		MaxElapsed:            MAX_ELAPSED,
+		FinishMode:            "optional",
+		TokenSigning:          "hmac",
+		Difficulties:          DEFAULT_DIFFICULTIES,
+		ContentSecurityPolicy: DEFAULT_CSP,
+		SubmitWorkers:         runtime.NumCPU(),
+		SubmitQueue:           SUBMIT_QUEUE,
+		FederationInterval:    FEDERATION_INTERVAL,
+	}
+}
+
+// NewHighScoreServer builds a server from DefaultConfig and opts, connecting
+// to any configured brokers. Background work doesn't begin until Start.
+func NewHighScoreServer(opts ...Option) (*HighScoreServer, error) {
+	o := options{
+		config:    DefaultConfig(),
+		boardSize: DEFAULT_BOARD_SIZE,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	config := o.config
+
+	// An empty password would match requests that don't send one at all.
+	if config.AdminPassword == "" {
+		return nil, errors.New("an admin password is required")
+	}
+	if config.SubmitWorkers < 1 || config.SubmitQueue < 0 {
+		return nil, fmt.Errorf("need at least one submission worker and a non-negative queue, got %v and %v", config.SubmitWorkers, config.SubmitQueue)
+	}
+	if err := checkFinishMode(config.FinishMode); err != nil {
+		return nil, err
+	}
+	if err := checkBasePath(config.BasePath); err != nil {
+		return nil, err
+	}
+	if err := checkWork(config.ProofOfWork); err != nil {
+		return nil, err
+	}
+	if o.boardSize < 1 {
+		return nil, fmt.Errorf("board size must be positive, got %v", o.boardSize)
+	}
+
+	started := o.now()
+	tokens, err := newMinter(config, o.key, started)
+	if err != nil {
+		return nil, err
+	}
+	federation, err := newFederation(config)
+	if err != nil {
+		return nil, err
+	}
+	routeLimits, err := parseRouteLimits(config.RouteLimits)
+	if err != nil {
+		return nil, err
+	}
+	board := o.board
+	if board == nil {
+		board = &store.Board{}
+	}
+	board.Limit = o.boardSize
+
+	adminNetworks, err := parseCIDRs(config.AdminAllowCIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	ipSalt := make([]byte, 16)
+	if _, err := rand.Read(ipSalt); err != nil {
+		return nil, err
+	}
+	ips, err := newIPMasker(config.IPMode, ipSalt)
+	if err != nil {
+		return nil, err
+	}
+	uniqueSalt := make([]byte, 16)
+	if _, err := rand.Read(uniqueSalt); err != nil {
+		return nil, err
+	}
+
+	difficulties, err := parseDifficulties(config.Difficulties)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := i18n.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	views, err := parseKioskViews(config.KioskViews)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := newCluster(config)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &HighScoreServer{
+		board:             board,
+		boardSize:         o.boardSize,
+		tokens:            tokens,
+		work:              config.ProofOfWork,
+		federation:        federation,
+		mirror:            newMirror(config.MirrorOf),
+		cluster:           cluster,
+		routeLimits:       routeLimits,
+		features:          features(config),
+		federationEvery:   config.FederationInterval,
+		now:               o.now,
+		storeTimeout:      config.StoreTimeout,
+		maxHealth:         config.MaxHealth,
+		maxElapsed:        config.MaxElapsed,
+		finishMode:        config.FinishMode,
+		difficulties:      difficulties,
+		i18n:              catalog,
+		csp:               config.ContentSecurityPolicy,
+		submissions:       newSubmitQueue(config.SubmitWorkers, config.SubmitQueue),
+		validators:        o.validators,
+		adminPasswordHash: hashPassword(config.AdminPassword),
+		adminNetworks:     adminNetworks,
+		ips:               ips,
+		uniques:           uniquePlayers{sketch: hll.Sketch{Salt: uniqueSalt}},
+		eventName:         config.EventName,
+		basePath:          config.BasePath,
+		started:           started,
+		results:           map[string]Result{},
+		audit:             auditLog{now: o.now},
+		reactions:         reactionTally{limiter: newRateLimiter(1, 5)},
+		quarantine:        quarantine{threshold: config.QuarantineThreshold},
+		celebrations:      celebrations{under: config.CelebrateUnder, every: config.CelebrateEvery, gap: CELEBRATION_GAP},
+		kiosk: KioskConfig{
+			Views:         views,
+			Interval:      config.KioskInterval.Seconds(),
+			Announcements: config.Announcements,
+		},
+	}
+	// Preloaded scores without a difficulty predate normalization.
+	for _, score := range board.Scores() {
+		if score.Difficulty == "" {
+			board.Update(score.ID, server.normalize)
+		}
+	}
+	server.tail.Buffer = 64
+	server.retention.rules = server.retentionRules(config.DeleteRetention, config.IPRetention, config.ReplayRetention, config.ResultRetention, config.ResultRetentionKeep)
+	server.retention.status = retentionStatus{Rules: server.retention.rules, Purged: map[string]int{}}
+
+	if config.StationKeys != "" {
+		server.stations.keys, err = loadKeyFile(config.StationKeys, "station-id")
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %v station keys\n", len(server.stations.keys))
+	}
+	if config.UntrustedSubmitLimit > 0 {
+		server.devices.limiter = newRateLimiter(float64(config.UntrustedSubmitLimit)/60, config.UntrustedSubmitLimit)
+	}
+	if config.TOTPSecret != "" {
+		server.totp.secret, err = parseTOTPSecret(config.TOTPSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.NATSURL != "" {
+		nats, err := newNATSPublisher(config.NATSURL, config.NATSSubject)
+		if err != nil {
+			return nil, err
+		}
+		server.sinks = append(server.sinks, nats)
+		server.brokers = append(server.brokers, nats.run)
+	}
+
+	for _, spec := range config.Goals {
+		goal, err := parseGoal(spec)
+		if err != nil {
+			return nil, err
+		}
+		server.goals = append(server.goals, goal)
+	}
+
+	server.suggestNames = config.SuggestNames
+	server.practice.board.Limit = PRACTICE_BOARD_SIZE
+	server.reserved.hide = config.HideReserved
+	for _, name := range config.ReservedNames {
+		if len(name) < 1 || len(name) > 3 {
+			return nil, fmt.Errorf("reserved name %q must be 1-3 characters", name)
+		}
+		server.reserved.add(name)
+	}
+	for _, spec := range config.Prizes {
+		tier, err := parsePrizeTier(spec)
+		if err != nil {
+			return nil, err
+		}
+		server.prizes.tiers = append(server.prizes.tiers, tier)
+	}
+
+	for _, spec := range config.Experiments {
+		e, err := parseExperiment(spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range server.experiments.list {
+			if other.name == e.name {
+				return nil, fmt.Errorf("experiment %q given twice", e.name)
+			}
+		}
+		server.experiments.list = append(server.experiments.list, e)
+	}
+
+	if config.ReportAt != "" {
+		server.reports.at, err = parseReportAt(config.ReportAt)
+		if err != nil {
+			return nil, err
+		}
+		server.reports.daily = true
+	}
+
+	if len(config.Notify) > 0 {
+		var targets []*notifyTarget
+		for _, spec := range config.Notify {
+			target, err := parseNotifyTarget(spec, config.EventName)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, target)
+		}
+		server.sinks = append(server.sinks, newNotifyDispatcher(targets))
+	}
+
+	server.sinks = append(server.sinks, o.sinks...)
+
+	if config.QualifyTop > 0 {
+		deadline, err := parseDeadline(config.QualifyDeadline, server.now())
+		if err != nil {
+			return nil, err
+		}
+		server.qualifying = &qualifyingRule{top: config.QualifyTop, deadline: deadline}
+	}
+
+	server.payload, err = loadPayloadKeys(config.PayloadMode, config.PayloadKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SMTPURL != "" {
+		server.mailer, err = newSMTPMailer(config.SMTPURL, config.SMTPFrom)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MQTTURL != "" {
+		mqtt, err := newMQTTMirror(config.MQTTURL, config.MQTTPrefix, config.MQTTQoS, server.boardSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		server.brokers = append(server.brokers, mqtt.run)
+	}
+
+	if config.KafkaBrokers != "" {
+		kafka := newKafkaSink(config.KafkaBrokers, config.KafkaTopic)
+		events := server.tail.Subscribe()
+		server.brokers = append(server.brokers, func() { kafka.run(events) })
+	}
+	return server, nil
+}
+
+// Start begins the server's background work: the submission workers, live
+// updates for streaming clients, broker connections, the retention janitor
+// and its part in any cluster. Submissions wait until it is called. It fails
+// only if the server can't join its cluster.
+func (s *HighScoreServer) Start() error {
+	if s.cluster != nil {
+		if err := s.cluster.start(boardFSM{s}); err != nil {
+			return fmt.Errorf("joining the cluster: %w", err)
+		}
+	}
+	for _, run := range s.brokers {
+		go run()
+	}
+	// A mirror's board only changes with the upstream's.
+	if s.mirror != nil {
+		go s.follow()
+		return nil
+	}
+	s.submissions.start()
+	go s.broadcastReactions(time.Second)
+	go s.broadcastNowPlaying(time.Second)
+	go s.broadcastCountdown(time.Second)
+	go s.broadcastLeader(500 * time.Millisecond)
+	go s.broadcastSponsors(time.Second)
+	go s.broadcastCelebrations(500 * time.Millisecond)
+	if len(s.goals) > 0 {
+		go s.broadcastGoals(time.Second)
+	}
+	go s.runJanitor(s.retention.rules, time.Minute)
+	if s.reports.daily {
+		go s.scheduleReports()
+	}
+	if s.federation != nil && s.federation.primary != "" {
+		go s.syncFederation(s.federationEvery)
+	}
+	return nil
+}
+
+// SetPublicURL sets the URL players use to reach the server, once it is
+// known.
+func (s *HighScoreServer) SetPublicURL(url string) {
+	s.publicURL = url
+}
+
+func (s *HighScoreServer) PublicURL() string {
+	return s.publicURL
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"elevate2024/internal/broadcast"
+)
+
+// CONTROL_ACTIONS are what an admin can have a kiosk do: reload the page,
+// show one of KIOSK_VIEWS, or put a message over whatever is showing.
+var CONTROL_ACTIONS = []string{"refresh", "view", "message"}
+
+// CONTROL_SECONDS is how long a view or message stays up unless the command
+// says otherwise, and MAX_CONTROL_SECONDS the longest it may ask for.
+const (
+	CONTROL_SECONDS     = 30
+	MAX_CONTROL_SECONDS = 3600
+)
+
+// A ControlCommand is sent to a kiosk on its control channel, as an event
+// named after its action.
+type ControlCommand struct {
+	Action  string  `json:"action"`
+	View    string  `json:"view,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Seconds float64 `json:"seconds,omitempty"`
+}
+
+func (c ControlCommand) EventName() string {
+	return c.Action
+}
+
+func (c *ControlCommand) validate() error {
+	if !slices.Contains(CONTROL_ACTIONS, c.Action) {
+		return fmt.Errorf("unknown action %q (supported: %s)", c.Action, strings.Join(CONTROL_ACTIONS, ", "))
+	}
+	switch c.Action {
+	case "view":
+		if !slices.Contains(KIOSK_VIEWS, c.View) {
+			return fmt.Errorf("unknown kiosk view %q (supported: %s)", c.View, strings.Join(KIOSK_VIEWS, ", "))
+		}
+	case "message":
+		c.Message = strings.TrimSpace(c.Message)
+		if c.Message == "" || len(c.Message) > 280 {
+			return fmt.Errorf("message must be 1-280 characters")
+		}
+	}
+	if c.Action == "refresh" {
+		c.Seconds = 0
+	} else if c.Seconds == 0 {
+		c.Seconds = CONTROL_SECONDS
+	}
+	if c.Seconds < 0 || c.Seconds > MAX_CONTROL_SECONDS {
+		return fmt.Errorf("seconds must be 0 to %v", MAX_CONTROL_SECONDS)
+	}
+	return nil
+}
+
+// controlHub returns the hub a device's control channels listen on. The
+// caller holds the device registry's lock.
+func (s *HighScoreServer) controlHub(id string) *broadcast.Hub[ControlCommand] {
+	if s.devices.control == nil {
+		s.devices.control = map[string]*broadcast.Hub[ControlCommand]{}
+	}
+	hub, ok := s.devices.control[id]
+	if !ok {
+		hub = &broadcast.Hub[ControlCommand]{}
+		s.devices.control[id] = hub
+	}
+	return hub
+}
+
+// controlStream serves GET /devices/control: the commands admins send to
+// the registered kiosk making the request. Browsers can't set headers on an
+// EventSource, so the credential may come as ?credential= instead.
+func (s *HighScoreServer) controlStream(w http.ResponseWriter, r *http.Request) {
+	credential := r.Header.Get(DEVICE_HEADER)
+	if credential == "" {
+		credential = r.URL.Query().Get("credential")
+	}
+	id, ok := s.checkDevice(credential)
+	if !ok {
+		http.Error(w, "not a registered device", http.StatusForbidden)
+		return
+	}
+
+	s.devices.mutex.Lock()
+	hub := s.controlHub(id)
+	s.devices.mutex.Unlock()
+	commands := hub.Subscribe()
+	defer hub.Unsubscribe(commands)
+
+	// The device itself is the snapshot, so the kiosk knows what it's
+	// registered as.
+	broadcast.Serve(w, r, broadcast.Stream[ControlCommand]{
+		Name: "device",
+		Snapshot: func() ([]byte, error) {
+			s.devices.mutex.Lock()
+			device := *s.devices.devices[id]
+			s.devices.mutex.Unlock()
+			return json.Marshal(device)
+		},
+		Events: commands,
+	})
+}
+
+// sendControl serves POST /admin/devices/{id}/control with a
+// ControlCommand, answering how many of the device's pages got it.
+func (s *HighScoreServer) sendControl(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var command ControlCommand
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&command); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := command.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	s.devices.mutex.Lock()
+	device, ok := s.devices.devices[id]
+	revoked := ok && device.Revoked
+	hub := s.devices.control[id]
+	s.devices.mutex.Unlock()
+	if !ok {
+		http.Error(w, "unknown device", http.StatusNotFound)
+		return
+	}
+	if revoked {
+		http.Error(w, "device is revoked", http.StatusConflict)
+		return
+	}
+	delivered := 0
+	if hub != nil {
+		delivered = hub.Len()
+		hub.Publish(command)
+	}
+
+	s.audit.record(s.clientIP(r), "device-control", id, nil, command)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Delivered int `json:"delivered"`
+	}{delivered})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"elevate2024/internal/store"
+)
+
+// A qualifyingRule decides who advances from the open play to the finals:
+// the best top distinct players as of the deadline.
+type qualifyingRule struct {
+	top      int
+	deadline time.Time // zero for no deadline
+
+	mutex sync.Mutex
+	// the qualifying scores when the deadline passed, once known
+	frozen bool
+	final  []Score
+}
+
+// parseDeadline accepts either a full RFC 3339 time or a time of day such as
+// "18:00", which means today in the server's time zone.
+func parseDeadline(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("15:04", s, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid qualifying deadline %q (use RFC 3339 or HH:MM)", s)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location()), nil
+}
+
+// qualifyingScores returns each player's best score, best first, for the top
+// n players.
+func qualifyingScores(scores []Score, n int) []Score {
+	sorted := slices.Clone(scores)
+	slices.SortStableFunc(sorted, store.Cmp)
+
+	best := []Score{}
+	seen := map[string]bool{}
+	for _, score := range sorted {
+		if len(best) == n {
+			break
+		}
+		if !seen[score.PlayerName] {
+			seen[score.PlayerName] = true
+			best = append(best, score)
+		}
+	}
+	return best
+}
+
+// bumped returns the players who qualified before but not after.
+func bumped(before []Score, after []Score) []Score {
+	var out []Score
+	for _, score := range before {
+		if !slices.ContainsFunc(after, func(s Score) bool { return s.PlayerName == score.PlayerName }) {
+			out = append(out, score)
+		}
+	}
+	return out
+}
+
+// checkCutoff works out who a new score pushed out of qualifying position,
+// given the board just before and after it was added at the given time. The
+// caller holds s.mutex. Once the deadline has passed the qualifiers are
+// frozen and nobody can be bumped.
+func (s *HighScoreServer) checkCutoff(before []Score, after []Score, at time.Time) []Score {
+	q := s.qualifying
+	if q == nil {
+		return nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.frozen {
+		return nil
+	}
+	old := qualifyingScores(before, q.top)
+	if !q.deadline.IsZero() && at.After(q.deadline) {
+		q.frozen, q.final = true, old
+		return nil
+	}
+	return bumped(old, qualifyingScores(after, q.top))
+}
+
+type Cutoff struct {
+	Top        int        `json:"top"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	Closed     bool       `json:"closed"`
+	Qualifiers []Score    `json:"qualifiers"`
+	// the score a player must beat to qualify, once the spots are full
+	Bubble *Score `json:"bubble,omitempty"`
+}
+
+func (s *HighScoreServer) cutoff(w http.ResponseWriter, r *http.Request) {
+	q := s.qualifying
+	if q == nil {
+		http.Error(w, "no qualifying rule configured", http.StatusNotFound)
+		return
+	}
+
+	current := qualifyingScores(s.board.Scores(), q.top)
+
+	cutoff := Cutoff{Top: q.top, Qualifiers: current}
+	if !q.deadline.IsZero() {
+		cutoff.Deadline = &q.deadline
+		cutoff.Closed = s.now().After(q.deadline)
+	}
+	q.mutex.Lock()
+	if q.frozen {
+		cutoff.Qualifiers = q.final
+	}
+	q.mutex.Unlock()
+	if len(cutoff.Qualifiers) == q.top {
+		cutoff.Bubble = &cutoff.Qualifiers[q.top-1]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(cutoff)
+}
+
+type Countdown struct {
+	Deadline         time.Time `json:"deadline"`
+	RemainingSeconds int       `json:"remaining_seconds"`
+	Closed           bool      `json:"closed"`
+}
+
+func (c *Countdown) minutesLeft() int {
+	return (c.RemainingSeconds + 59) / 60
+}
+
+// countdown returns the time left until the qualifying deadline, or nil if
+// there is none.
+func (s *HighScoreServer) countdown() *Countdown {
+	q := s.qualifying
+	if q == nil || q.deadline.IsZero() {
+		return nil
+	}
+	remaining := max(0, time.Until(q.deadline))
+	return &Countdown{
+		Deadline:         q.deadline,
+		RemainingSeconds: int(remaining.Seconds()),
+		Closed:           remaining == 0,
+	}
+}
+
+// broadcastCountdown sends a "countdown" event to /events subscribers once a
+// minute until the qualifying deadline, and once more when it passes.
+func (s *HighScoreServer) broadcastCountdown(interval time.Duration) {
+	// New subscribers get the current countdown when they connect.
+	countdown := s.countdown()
+	if countdown == nil {
+		return
+	}
+	last := countdown.minutesLeft()
+	for range time.Tick(interval) {
+		countdown = s.countdown()
+		if countdown.minutesLeft() == last {
+			continue
+		}
+		last = countdown.minutesLeft()
+		s.live.Publish(BoardEvent{Type: EVENT_COUNTDOWN, Time: s.now(), Countdown: countdown})
+		if countdown.Closed {
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+)
+
+// deleteScore hides a score from the board. The result is kept, so a mistaken
+// deletion can be restored until it is purged after the retention window,
+// but federated servers erase their copies for good.
+func (s *HighScoreServer) deleteScore(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !s.requireTOTP(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_DELETE, ID: id, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !result.Found {
+		http.NotFound(w, r)
+		return
+	}
+	s.federation.erase(func(score Score) bool { return score.ID == id })
+
+	s.audit.record(s.clientIP(r), "delete", id, result.Score, nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *HighScoreServer) restoreScore(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !s.requireTOTP(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_RESTORE, ID: id, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !result.Found {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "restore", id, nil, result.Score)
+	w.WriteHeader(http.StatusOK)
+}
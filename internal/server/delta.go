@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"elevate2024/internal/api"
+	"elevate2024/internal/store"
+)
+
+// boardPatch is the patch function for /events, on boardSnapshot output.
+func boardPatch(old []byte, new []byte) ([]byte, bool) {
+	var before, after []Score
+	if json.Unmarshal(old, &before) != nil || json.Unmarshal(new, &after) != nil {
+		return nil, false
+	}
+	ops, ok := store.Diff(before, after)
+	if !ok {
+		return nil, false
+	}
+	data, err := json.Marshal(BoardPatch{Ops: ops})
+	return data, err == nil
+}
+
+// getScoresDiff serves GET /scores/diff?from=<version>, the rows added to and
+// removed from the board since the version a reconnecting display last saw
+// in X-Board-Version. Versions older than the board keeps, or from before a
+// restart, are gone: the client refetches /scores.
+func (s *HighScoreServer) getScoresDiff(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	scores, version := s.board.View()
+	before, ok := s.board.At(from)
+	if !ok {
+		http.Error(w, "board version no longer available; fetch /scores", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("X-Board-Version", strconv.FormatUint(version, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(BoardDiff{
+		From:    from,
+		Version: version,
+		Added:   missingFrom(scores, before),
+		Removed: missingFrom(before, scores),
+	})
+}
+
+// missingFrom returns the scores in board that other lacks, with their ranks
+// in board. Edited scores count as missing, as they do for patches.
+func missingFrom(board []Score, other []Score) []api.RankedScore {
+	in := map[Score]bool{}
+	for _, score := range other {
+		in[score] = true
+	}
+	missing := []api.RankedScore{}
+	for i, score := range board {
+		if !in[score] {
+			missing = append(missing, api.RankedScore{Rank: i + 1, Score: score})
+		}
+	}
+	return missing
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"elevate2024/internal/broadcast"
+)
+
+// Registered booth kiosks send their credential in DEVICE_HEADER with every
+// request.
+const DEVICE_HEADER = "X-Device-Credential"
+
+// PAIRING_TTL is how long a pairing code from the admin API can be used to
+// register a device.
+const PAIRING_TTL = 10 * time.Minute
+
+// A Device is a booth kiosk registered with a pairing code. Its submissions
+// are trusted not to be a bot: the checks for a client submitting too often
+// are skipped, since a kiosk does that all day.
+type Device struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// when it registered, or zero if it registered before the server last
+	// started and has been seen since
+	Registered time.Time `json:"registered,omitempty"`
+	LastSeen   time.Time `json:"last_seen,omitempty"`
+	Revoked    bool      `json:"revoked"`
+	// how many control channels it has open
+	Connected int `json:"connected"`
+}
+
+// deviceRegistry holds the outstanding pairing codes and the devices known
+// since the server started. Credentials are signed, so a device registered
+// before a restart is still recognized, as long as the token key is kept.
+type deviceRegistry struct {
+	mutex   sync.Mutex
+	pairing map[string]time.Time
+	devices map[string]*Device
+	// limits the submissions of clients that aren't registered devices; nil
+	// leaves them unlimited
+	limiter *rateLimiter
+	// the control channels of connected kiosks, by device ID
+	control map[string]*broadcast.Hub[ControlCommand]
+}
+
+// device returns the ID of the registered device making r, if it is one.
+func (s *HighScoreServer) device(r *http.Request) (string, bool) {
+	return s.checkDevice(r.Header.Get(DEVICE_HEADER))
+}
+
+// checkDevice returns the ID of the registered device a credential is for,
+// unless it has been revoked.
+func (s *HighScoreServer) checkDevice(credential string) (string, bool) {
+	if credential == "" {
+		return "", false
+	}
+	id, err := s.tokens.CheckDevice(credential)
+	if err != nil {
+		return "", false
+	}
+
+	s.devices.mutex.Lock()
+	defer s.devices.mutex.Unlock()
+	device, ok := s.devices.devices[id]
+	if !ok {
+		device = &Device{ID: id}
+		if s.devices.devices == nil {
+			s.devices.devices = map[string]*Device{}
+		}
+		s.devices.devices[id] = device
+	}
+	if device.Revoked {
+		return "", false
+	}
+	device.LastSeen = s.now()
+	return id, true
+}
+
+// allowUntrusted reports whether a client that isn't a registered device may
+// submit now.
+func (s *HighScoreServer) allowUntrusted(r *http.Request) bool {
+	if s.devices.limiter == nil {
+		return true
+	}
+	if _, ok := s.device(r); ok {
+		return true
+	}
+	return s.devices.limiter.allow(s.clientIP(r))
+}
+
+// newPairingCode serves POST /admin/devices/pairing: a one-time code a booth
+// kiosk registers with.
+func (s *HighScoreServer) newPairingCode(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := totpEncoding.EncodeToString(b)
+	expires := s.now().Add(PAIRING_TTL)
+
+	s.devices.mutex.Lock()
+	if s.devices.pairing == nil {
+		s.devices.pairing = map[string]time.Time{}
+	}
+	for other, at := range s.devices.pairing {
+		if s.now().After(at) {
+			delete(s.devices.pairing, other)
+		}
+	}
+	s.devices.pairing[code] = expires
+	s.devices.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "device-pairing", "", nil, nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Code    string    `json:"code"`
+		Expires time.Time `json:"expires"`
+	}{code, expires})
+}
+
+// registerDevice serves POST /devices/register with {"code": ..., "name":
+// ...}, trading a pairing code for a device credential.
+func (s *HighScoreServer) registerDevice(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 64 {
+		http.Error(w, "name must be 1-64 characters", http.StatusBadRequest)
+		return
+	}
+	id, err := newScoreID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(body.Code))
+	s.devices.mutex.Lock()
+	expires, ok := s.devices.pairing[code]
+	delete(s.devices.pairing, code)
+	valid := ok && !s.now().After(expires)
+	if valid {
+		if s.devices.devices == nil {
+			s.devices.devices = map[string]*Device{}
+		}
+		s.devices.devices[id] = &Device{ID: id, Name: name, Registered: s.now()}
+	}
+	s.devices.mutex.Unlock()
+	if !valid {
+		http.Error(w, "invalid or expired pairing code", http.StatusForbidden)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "device-register", id, nil, name)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ID         string `json:"id"`
+		Credential string `json:"credential"`
+	}{id, s.tokens.Device(id)})
+}
+
+func (s *HighScoreServer) listDevices(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.devices.mutex.Lock()
+	devices := []Device{}
+	for _, device := range s.devices.devices {
+		listed := *device
+		if hub, ok := s.devices.control[device.ID]; ok {
+			listed.Connected = hub.Len()
+		}
+		devices = append(devices, listed)
+	}
+	s.devices.mutex.Unlock()
+	slices.SortFunc(devices, func(a, b Device) int { return strings.Compare(a.ID, b.ID) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
+
+// setDeviceRevoked revokes or restores a device's credential. A device that
+// registered before the server last started can be revoked by ID before it
+// is next seen.
+func (s *HighScoreServer) setDeviceRevoked(revoked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		id := r.PathValue("id")
+		s.devices.mutex.Lock()
+		if s.devices.devices == nil {
+			s.devices.devices = map[string]*Device{}
+		}
+		device, ok := s.devices.devices[id]
+		if !ok {
+			device = &Device{ID: id}
+			s.devices.devices[id] = device
+		}
+		device.Revoked = revoked
+		s.devices.mutex.Unlock()
+
+		action := "device-revoke"
+		if !revoked {
+			action = "device-unrevoke"
+		}
+		s.audit.record(s.clientIP(r), action, id, nil, nil)
+		w.WriteHeader(http.StatusOK)
+	}
+}
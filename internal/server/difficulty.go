@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"elevate2024/internal/i18n"
+)
+
+// Scores that don't name a difficulty were played on DEFAULT_DIFFICULTY.
+// DEFAULT_DIFFICULTIES is the multiplier table unless Config.Difficulties
+// says otherwise.
+const (
+	DEFAULT_DIFFICULTY   = "normal"
+	DEFAULT_DIFFICULTIES = "normal=1"
+)
+
+// parseDifficulties reads a multiplier table of comma-separated name=multiplier
+// pairs, such as "normal=1,insane=1.5". The default difficulty must be in it.
+func parseDifficulties(s string) (map[string]float64, error) {
+	difficulties := map[string]float64{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("difficulty %q: want name=multiplier", pair)
+		}
+		name = strings.TrimSpace(name)
+		multiplier, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || multiplier <= 0 || math.IsInf(multiplier, 0) {
+			return nil, fmt.Errorf("difficulty %q: multiplier must be a positive number", name)
+		}
+		difficulties[name] = multiplier
+	}
+	if _, ok := difficulties[DEFAULT_DIFFICULTY]; !ok {
+		return nil, fmt.Errorf("no multiplier for the %q difficulty", DEFAULT_DIFFICULTY)
+	}
+	return difficulties, nil
+}
+
+func (s *HighScoreServer) checkDifficulty(score Score) error {
+	if score.Difficulty == "" {
+		return nil
+	}
+	if _, ok := s.difficulties[score.Difficulty]; !ok {
+		return i18n.Errorf("unknown difficulty %q", score.Difficulty)
+	}
+	return nil
+}
+
+// normalize scores a run by the damage it did to the boss, scaled by its
+// difficulty's multiplier, so harder modes rank fairly against easier ones.
+// With every multiplier at 1 the board ranks exactly as it does on raw health.
+func (s *HighScoreServer) normalize(score *Score) {
+	if score.Difficulty == "" {
+		score.Difficulty = DEFAULT_DIFFICULTY
+	}
+	damage := max(0, BOSS_HEALTH-score.RemainingHealth)
+	score.Normalized = math.Round(float64(damage)*s.difficulties[score.Difficulty]*100) / 100
+}
+
+// getDifficulties lists the difficulties scores may name and their
+// multipliers.
+func (s *HighScoreServer) getDifficulties(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.difficulties)
+}
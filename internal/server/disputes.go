@@ -0,0 +1,266 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dispute states. A dispute is open until an admin looks at it, and resolved
+// once they uphold it (and put right whatever was done to the score) or
+// reject it.
+const (
+	DISPUTE_OPEN      = "open"
+	DISPUTE_REVIEWING = "reviewing"
+	DISPUTE_UPHELD    = "upheld"
+	DISPUTE_REJECTED  = "rejected"
+)
+
+var DISPUTE_STATES = []string{DISPUTE_OPEN, DISPUTE_REVIEWING, DISPUTE_UPHELD, DISPUTE_REJECTED}
+
+// MAX_DISPUTE_REASON is the longest explanation a player can give.
+const MAX_DISPUTE_REASON = 1000
+
+// A Dispute is a player contesting what was done to their score, backed by
+// the receipt they got when it was accepted.
+type Dispute struct {
+	ID      string    `json:"id"`
+	Receipt Receipt   `json:"receipt"`
+	Reason  string    `json:"reason"`
+	Filed   time.Time `json:"filed"`
+	Status  string    `json:"status"`
+	// the admins' answer, shown to the player
+	Resolution string    `json:"resolution,omitempty"`
+	Resolved   time.Time `json:"resolved,omitempty"`
+}
+
+func (d Dispute) resolved() bool {
+	return d.Status == DISPUTE_UPHELD || d.Status == DISPUTE_REJECTED
+}
+
+// A ReplayFrame is a checkpoint a run reported while it was played.
+type ReplayFrame struct {
+	Received   time.Time `json:"received"`
+	Elapsed    float64   `json:"elapsed"`
+	BossHealth int       `json:"boss_health"`
+}
+
+// DisputeEvidence is what the admin queue shows next to a dispute: what has
+// become of the score, the run as it was played, and every admin action on
+// it.
+type DisputeEvidence struct {
+	ScoreStatus string        `json:"score_status"`
+	Score       *Score        `json:"score,omitempty"`
+	Deleted     time.Time     `json:"deleted,omitempty"`
+	Replay      []ReplayFrame `json:"replay"`
+	Audit       []AuditEntry  `json:"audit"`
+}
+
+// A QueuedDispute is a dispute with its evidence.
+type QueuedDispute struct {
+	Dispute
+	Evidence DisputeEvidence `json:"evidence"`
+}
+
+type disputeQueue struct {
+	mutex   sync.Mutex
+	entries map[string]*Dispute
+}
+
+// setResultReplay keeps the checkpoints of an accepted run with its result.
+func (s *HighScoreServer) setResultReplay(id string, replay []checkpoint) {
+	if len(replay) == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if result, ok := s.results[id]; ok {
+		result.Replay = replay
+		s.results[id] = result
+	}
+}
+
+// fileDispute serves POST /disputes with {"receipt": ..., "reason": ...}.
+// Only the holder of a score's receipt can contest it, and only once at a
+// time.
+func (s *HighScoreServer) fileDispute(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Receipt Receipt `json:"receipt"`
+		Reason  string  `json:"reason"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.tokens.CheckReceipt(body.Receipt); err != nil {
+		http.Error(w, "invalid receipt", http.StatusForbidden)
+		return
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" || len(reason) > MAX_DISPUTE_REASON {
+		http.Error(w, fmt.Sprintf("reason must be 1-%v characters", MAX_DISPUTE_REASON), http.StatusBadRequest)
+		return
+	}
+	id, err := newScoreID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dispute := Dispute{ID: id, Receipt: body.Receipt, Reason: reason, Filed: s.now(), Status: DISPUTE_OPEN}
+	s.disputes.mutex.Lock()
+	for _, other := range s.disputes.entries {
+		if other.Receipt.ID == body.Receipt.ID && !other.resolved() {
+			s.disputes.mutex.Unlock()
+			http.Error(w, "this score already has an open dispute: "+other.ID, http.StatusConflict)
+			return
+		}
+	}
+	if s.disputes.entries == nil {
+		s.disputes.entries = map[string]*Dispute{}
+	}
+	s.disputes.entries[id] = &dispute
+	s.disputes.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "dispute-file", body.Receipt.ID, nil, reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", s.basePath+"/disputes/"+id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dispute)
+}
+
+// getDispute serves GET /disputes/{id}, so a player can follow their
+// dispute. The ID is only known to whoever filed it.
+func (s *HighScoreServer) getDispute(w http.ResponseWriter, r *http.Request) {
+	s.disputes.mutex.Lock()
+	dispute, ok := s.disputes.entries[r.PathValue("id")]
+	var copied Dispute
+	if ok {
+		copied = *dispute
+	}
+	s.disputes.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(copied)
+}
+
+// listDisputes serves GET /admin/disputes?status=, the oldest first, each
+// with its evidence.
+func (s *HighScoreServer) listDisputes(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(DISPUTE_STATES, status) {
+		http.Error(w, fmt.Sprintf("unknown status %q (supported: %s)", status, strings.Join(DISPUTE_STATES, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	s.disputes.mutex.Lock()
+	queue := []QueuedDispute{}
+	for _, dispute := range s.disputes.entries {
+		if status == "" || dispute.Status == status {
+			queue = append(queue, QueuedDispute{Dispute: *dispute})
+		}
+	}
+	s.disputes.mutex.Unlock()
+	slices.SortFunc(queue, func(a, b QueuedDispute) int { return a.Filed.Compare(b.Filed) })
+
+	for i := range queue {
+		evidence, err := s.disputeEvidence(r, queue[i].Receipt.ID)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		queue[i].Evidence = evidence
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// disputeEvidence gathers what is known about the score with the given ID.
+func (s *HighScoreServer) disputeEvidence(r *http.Request, id string) (DisputeEvidence, error) {
+	evidence := DisputeEvidence{Replay: []ReplayFrame{}, Audit: []AuditEntry{}}
+	var err error
+	evidence.ScoreStatus, _, err = s.receiptStatus(r.Context(), id)
+	if err != nil {
+		return evidence, err
+	}
+
+	if err := s.lockBoard(r.Context(), "lookup"); err != nil {
+		return evidence, err
+	}
+	if result, ok := s.results[id]; ok {
+		score := result.Score
+		evidence.Score = &score
+		evidence.Deleted = result.Deleted
+		for _, c := range result.Replay {
+			evidence.Replay = append(evidence.Replay, ReplayFrame{c.received, c.elapsed, c.bossHealth})
+		}
+	}
+	s.mutex.Unlock()
+
+	s.audit.mutex.Lock()
+	for _, entry := range s.audit.entries {
+		if entry.ScoreID == id {
+			evidence.Audit = append(evidence.Audit, entry)
+		}
+	}
+	s.audit.mutex.Unlock()
+	return evidence, nil
+}
+
+// resolveDispute serves POST /admin/disputes/{id} with {"status": ...,
+// "resolution": ...}. Upholding a dispute only records the decision; the
+// admin puts the score right with the usual restore or edit.
+func (s *HighScoreServer) resolveDispute(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Status     string `json:"status"`
+		Resolution string `json:"resolution"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(DISPUTE_STATES, body.Status) {
+		http.Error(w, fmt.Sprintf("unknown status %q (supported: %s)", body.Status, strings.Join(DISPUTE_STATES, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	s.disputes.mutex.Lock()
+	dispute, ok := s.disputes.entries[id]
+	if !ok {
+		s.disputes.mutex.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	before := *dispute
+	dispute.Status = body.Status
+	dispute.Resolution = strings.TrimSpace(body.Resolution)
+	dispute.Resolved = time.Time{}
+	if dispute.resolved() {
+		dispute.Resolved = s.now()
+	}
+	after := *dispute
+	s.disputes.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "dispute-"+after.Status, after.Receipt.ID, before.Status, after.Resolution)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(after)
+}
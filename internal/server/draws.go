@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Draw is a raffle among the players on the board, made with a seed the
+// server committed to before anyone knew who would be eligible. To check
+// it, hash the seed's text with SHA-256 to get the commitment published
+// beforehand, then shuffle the eligible players with seededRandom and take
+// the first Count.
+type Draw struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Commitment string    `json:"commitment"`
+	Committed  time.Time `json:"committed"`
+	Seed       string    `json:"seed"`
+	Drawn      time.Time `json:"drawn"`
+	Count      int       `json:"count"`
+	// every player who could win, best first, and those who did
+	Eligible []string `json:"eligible"`
+	Winners  []string `json:"winners"`
+}
+
+// A DrawCommitment is the hash of the seed the next draw will use,
+// published so the seed can't be picked once the entrants are known.
+type DrawCommitment struct {
+	Commitment string    `json:"commitment"`
+	Committed  time.Time `json:"committed"`
+}
+
+type drawState struct {
+	mutex sync.Mutex
+	// the seed of the next draw, kept secret until it is made
+	seed    string
+	pending *DrawCommitment
+	draws   []Draw
+}
+
+// reveal takes the pending commitment and its seed, so they are used once.
+func (d *drawState) reveal() (DrawCommitment, string, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending == nil {
+		return DrawCommitment{}, "", false
+	}
+	commitment, seed := *d.pending, d.seed
+	d.seed, d.pending = "", nil
+	return commitment, seed, true
+}
+
+// peek returns the pending commitment and its seed, leaving them pending.
+func (d *drawState) peek() (DrawCommitment, string, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending == nil {
+		return DrawCommitment{}, "", false
+	}
+	return *d.pending, d.seed, true
+}
+
+// spend uses up the pending commitment if it is the one given, reporting
+// whether it was.
+func (d *drawState) spend(commitment string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending == nil || d.pending.Commitment != commitment {
+		return false
+	}
+	d.seed, d.pending = "", nil
+	return true
+}
+
+// commit makes seed the next draw's, unless one is already committed to.
+func (d *drawState) commit(seed string, commitment DrawCommitment) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending != nil {
+		return fmt.Errorf("a draw is already committed to %v", d.pending.Commitment)
+	}
+	d.seed, d.pending = seed, &commitment
+	return nil
+}
+
+// record adds a draw made with the pending commitment, using it up.
+func (d *drawState) record(draw Draw) error {
+	if !d.spend(draw.Commitment) {
+		return errNoCommitment
+	}
+	d.mutex.Lock()
+	d.draws = append(d.draws, draw)
+	d.mutex.Unlock()
+	return nil
+}
+
+// drawPlayers shuffles eligible with the seed and returns the first count.
+func drawPlayers(eligible []string, count int, seed string) []string {
+	shuffled := slices.Clone(eligible)
+	seededRandom(seed).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return shuffled[:min(count, len(shuffled))]
+}
+
+// commitDraw serves POST /admin/draw/commit: it picks the seed for the next
+// draw, or for locking the prizes, and publishes its commitment on /draws.
+// There is only ever one, so an unwelcome seed can't be quietly swapped for
+// another.
+func (s *HighScoreServer) commitDraw(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	seed := hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(seed))
+
+	commitment := DrawCommitment{Commitment: hex.EncodeToString(sum[:]), Committed: s.now()}
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_DRAW_COMMIT, Seed: seed, Commitment: &commitment, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if result.Refused != nil {
+		http.Error(w, result.Refused.Error(), http.StatusConflict)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "draw-commit", "", nil, commitment.Commitment)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(commitment)
+}
+
+// makeDraw serves POST /admin/draw with {"name": ..., "count": N, "top": M}:
+// N winners drawn from the best M players on the board, or all of them, with
+// the committed seed, which is revealed with the result.
+func (s *HighScoreServer) makeDraw(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+		Top   int    `json:"top"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 64 {
+		http.Error(w, "name must be 1-64 characters", http.StatusBadRequest)
+		return
+	}
+	if body.Count < 1 || body.Top < 0 {
+		http.Error(w, "count must be positive and top not negative", http.StatusBadRequest)
+		return
+	}
+	id, err := newScoreID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.lockBoard(r.Context(), "draw"); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	scores := s.board.Scores()
+	s.mutex.Unlock()
+	top := body.Top
+	if top == 0 {
+		top = len(scores)
+	}
+	eligible := []string{}
+	for _, score := range qualifyingScores(s.prizeEligible(scores), top) {
+		eligible = append(eligible, score.PlayerName)
+	}
+
+	commitment, seed, ok := s.draws.peek()
+	if !ok {
+		http.Error(w, errNoCommitment.Error(), http.StatusConflict)
+		return
+	}
+	draw := Draw{
+		ID:         id,
+		Name:       name,
+		Commitment: commitment.Commitment,
+		Committed:  commitment.Committed,
+		Seed:       seed,
+		Drawn:      s.now(),
+		Count:      body.Count,
+		Eligible:   eligible,
+		Winners:    drawPlayers(eligible, body.Count, seed),
+	}
+	// Someone else may have used the seed since, which only shows once the
+	// draw is applied.
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_DRAW, Draw: &draw, Time: draw.Drawn})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if result.Refused != nil {
+		http.Error(w, result.Refused.Error(), http.StatusConflict)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "draw", "", nil, draw.Winners)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", s.basePath+"/draws/"+id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(draw)
+}
+
+// listDraws serves GET /draws: the commitment for the next draw, if there
+// is one, and every draw made so far.
+func (s *HighScoreServer) listDraws(w http.ResponseWriter, r *http.Request) {
+	s.draws.mutex.Lock()
+	var pending *DrawCommitment
+	if s.draws.pending != nil {
+		commitment := *s.draws.pending
+		pending = &commitment
+	}
+	draws := slices.Clone(s.draws.draws)
+	s.draws.mutex.Unlock()
+	if draws == nil {
+		draws = []Draw{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(struct {
+		Pending *DrawCommitment `json:"pending,omitempty"`
+		Draws   []Draw          `json:"draws"`
+	}{pending, draws})
+}
+
+// getDraw serves GET /draws/{id}.
+func (s *HighScoreServer) getDraw(w http.ResponseWriter, r *http.Request) {
+	s.draws.mutex.Lock()
+	i := slices.IndexFunc(s.draws.draws, func(draw Draw) bool { return draw.ID == r.PathValue("id") })
+	var draw Draw
+	if i >= 0 {
+		draw = s.draws.draws[i]
+	}
+	s.draws.mutex.Unlock()
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draw)
+}
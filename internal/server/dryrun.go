@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// dryRunScore answers POST /record/validate: a dry run of /record for game
+// developers testing their submission code against a live server. The body
+// goes through the same checks, and the answer is what /record would have
+// said, but nothing is stored, published or counted against the client.
+func (s *HighScoreServer) dryRunScore(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.submissions.do(func() { s.processDryRun(w, r, body) }); err != nil {
+		writeBusy(w)
+	}
+}
+
+func (s *HighScoreServer) processDryRun(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Context().Err() != nil {
+		return
+	}
+
+	sub, status, err := s.checkSubmission(r, body)
+	if err != nil {
+		slog.Debug("Dry run rejected", "ip", s.clientIP(r), "reason", err)
+		if status == http.StatusForbidden {
+			w.WriteHeader(status)
+		} else {
+			s.httpError(w, r, err, status)
+		}
+		return
+	}
+
+	// Which signals fired stays private, as it does for /record; only the
+	// outcome is reported.
+	device, _ := s.device(r)
+	suspicion := s.assess(submissionCheck{Score: sub.Score, Token: sub.Score.Token, IP: s.clientIP(r), Fingerprint: s.fingerprint(r), Claimed: sub.Claimed, Device: device})
+	result := "accepted"
+	if s.quarantine.holds(suspicion) {
+		result = "pending_review"
+	}
+
+	score := sub.Score
+	s.normalize(&score)
+	if err := s.lockBoard(r.Context(), "validate"); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	rank := s.board.Rank(score)
+	s.mutex.Unlock()
+	slog.Debug("Dry run", "ip", s.clientIP(r), "player", score.PlayerName, "status", result, "rank", rank)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status     string  `json:"status"`
+		Rank       int     `json:"rank"`
+		Elapsed    float64 `json:"elapsed"`
+		Normalized float64 `json:"normalized"`
+	}{result, rank, score.Elapsed, score.Normalized})
+}
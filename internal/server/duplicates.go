@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DUPLICATE_WINDOW is how long a submission is remembered to compare later
+// ones against.
+const DUPLICATE_WINDOW = 10 * time.Second
+
+// recentScores remembers the last DUPLICATE_WINDOW of submissions, to spot
+// the same run sent twice.
+type recentScores struct {
+	mutex  sync.Mutex
+	scores []recentScore
+}
+
+type recentScore struct {
+	time   time.Time
+	name   string
+	ip     string
+	micros int64
+	health int
+}
+
+func (r *recentScores) add(now time.Time, score Score, ip string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.scores = slices.DeleteFunc(r.scores, func(s recentScore) bool { return now.Sub(s.time) > DUPLICATE_WINDOW })
+	r.scores = append(r.scores, recentScore{now, score.PlayerName, ip, micros(score.Elapsed), score.RemainingHealth})
+}
+
+// forget drops the scores submitted before cutoff, returning how many there
+// were.
+func (r *recentScores) forget(cutoff time.Time) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	before := len(r.scores)
+	r.scores = slices.DeleteFunc(r.scores, func(s recentScore) bool { return s.time.Before(cutoff) })
+	return before - len(r.scores)
+}
+
+// match returns a submission in the window with the same elapsed time, to
+// the microsecond, and health as score, from another name or the same
+// client.
+func (r *recentScores) match(now time.Time, score Score, ip string) (recentScore, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, s := range r.scores {
+		if now.Sub(s.time) > DUPLICATE_WINDOW || s.micros != micros(score.Elapsed) || s.health != score.RemainingHealth {
+			continue
+		}
+		if s.name != score.PlayerName || s.ip == ip {
+			return s, true
+		}
+	}
+	return recentScore{}, false
+}
+
+func micros(seconds float64) int64 {
+	return int64(math.Round(seconds * 1e6))
+}
+
+// checkDuplicate notices a run identical to one submitted seconds before.
+// Real runs practically never end on the same microsecond with the same
+// health, so it is most likely a replayed network capture.
+func checkDuplicate(s *HighScoreServer, c submissionCheck) (float64, string) {
+	earlier, ok := s.duplicates.match(s.now(), c.Score, c.IP)
+	if !ok {
+		return 0, ""
+	}
+	return 1, fmt.Sprintf("same elapsed time and health as %s's run %.1fs earlier", earlier.name, s.now().Sub(earlier.time).Seconds())
+}
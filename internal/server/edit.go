@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"elevate2024/internal/i18n"
+)
+
+// A ScorePatch lists the fields an admin may correct on an existing score.
+// Fields left out of the request are unchanged.
+type ScorePatch struct {
+	PlayerName *string `json:"player_name"`
+	Team       *string `json:"team"`
+}
+
+func (s *HighScoreServer) patchScore(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var patch ScorePatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if patch.PlayerName != nil && (len(*patch.PlayerName) < 1 || len(*patch.PlayerName) > 3) {
+		http.Error(w, "player name must be 1-3 characters", http.StatusBadRequest)
+		return
+	}
+	if patch.Team != nil {
+		if err := checkTeam(*patch.Team); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := r.PathValue("id")
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_UPDATE, ID: id, Patch: &patch, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !result.Found {
+		http.NotFound(w, r)
+		return
+	}
+	s.audit.record(s.clientIP(r), "edit", id, result.Before, result.Score)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result.Score)
+}
+
+func checkTeam(team string) error {
+	if len(team) > 32 {
+		return i18n.Errorf("team must be at most 32 characters")
+	}
+	return nil
+}
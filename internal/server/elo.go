@@ -0,0 +1,153 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"elevate2024/internal/broadcast"
+	"elevate2024/internal/i18n"
+)
+
+// Elo parameters for the head-to-head ladder.
+const (
+	ELO_INITIAL = 1000
+	ELO_K       = 32
+)
+
+// A Match is a reported head-to-head game between two players.
+type Match struct {
+	PlayerA string    `json:"player_a"`
+	PlayerB string    `json:"player_b"`
+	Winner  string    `json:"winner"`
+	Token   Token     `json:"token"`
+	Time    time.Time `json:"time"`
+}
+
+type Rating struct {
+	PlayerName string  `json:"player_name"`
+	Rating     float64 `json:"rating"`
+	Wins       int     `json:"wins"`
+	Losses     int     `json:"losses"`
+}
+
+// A ladder keeps Elo ratings for the 1v1 portion of the event, separately
+// from the high score board.
+type ladder struct {
+	mutex   sync.Mutex
+	ratings map[string]*Rating
+	matches []Match
+}
+
+func (l *ladder) rating(name string) *Rating {
+	if l.ratings == nil {
+		l.ratings = map[string]*Rating{}
+	}
+	r, ok := l.ratings[name]
+	if !ok {
+		r = &Rating{PlayerName: name, Rating: ELO_INITIAL}
+		l.ratings[name] = r
+	}
+	return r
+}
+
+// record applies a match result and returns both players' new ratings.
+func (l *ladder) record(match Match) (Rating, Rating) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	winner, loser := l.rating(match.PlayerA), l.rating(match.PlayerB)
+	if match.Winner == match.PlayerB {
+		winner, loser = loser, winner
+	}
+	// expected score of the winner
+	expected := 1 / (1 + math.Pow(10, (loser.Rating-winner.Rating)/400))
+	delta := ELO_K * (1 - expected)
+	winner.Rating += delta
+	loser.Rating -= delta
+	winner.Wins++
+	loser.Losses++
+
+	l.matches = append(l.matches, match)
+	return *l.ratings[match.PlayerA], *l.ratings[match.PlayerB]
+}
+
+// rankings returns the ladder, best rated first.
+func (l *ladder) rankings() []Rating {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	rankings := make([]Rating, 0, len(l.ratings))
+	for _, r := range l.ratings {
+		rankings = append(rankings, *r)
+	}
+	slices.SortFunc(rankings, func(a, b Rating) int {
+		return cmp.Or(
+			-cmp.Compare(a.Rating, b.Rating),
+			cmp.Compare(a.PlayerName, b.PlayerName),
+		)
+	})
+	return rankings
+}
+
+func (s *HighScoreServer) validateMatch(match Match) error {
+	if err := s.checkToken(match.Token); err != nil {
+		return err
+	}
+	for _, name := range []string{match.PlayerA, match.PlayerB} {
+		if len(name) < 1 || len(name) > 3 {
+			return i18n.Errorf("player name must be 1-3 characters")
+		}
+	}
+	if match.PlayerA == match.PlayerB {
+		return i18n.Errorf("a player can't play themselves")
+	}
+	if match.Winner != match.PlayerA && match.Winner != match.PlayerB {
+		return i18n.Errorf("winner must be one of the players")
+	}
+	return nil
+}
+
+// reportMatch records a head-to-head result. Like scores, matches need a
+// token from /start so they come from a game client.
+func (s *HighScoreServer) reportMatch(w http.ResponseWriter, r *http.Request) {
+	var match Match
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&match); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.validateMatch(match); err != nil {
+		s.httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	match.Token = Token{}
+	match.Time = s.now()
+
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_MATCH, Match: &match, Time: match.Time})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result.Ratings)
+}
+
+func (s *HighScoreServer) rankingsSnapshot() ([]byte, error) {
+	return json.Marshal(s.ladder.rankings())
+}
+
+func (s *HighScoreServer) rankings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(s.ladder.rankings())
+}
+
+func (s *HighScoreServer) rankingsStream(w http.ResponseWriter, r *http.Request) {
+	broadcast.Serve(w, r, broadcast.Stream[BoardEvent]{Snapshot: s.rankingsSnapshot})
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Board event types, shared by every output that reports board changes.
+const (
+	EVENT_SCORE        = "score"
+	EVENT_LEAD_CHANGE  = "lead_change"
+	EVENT_RESET        = "reset"
+	EVENT_BUMPED       = "bumped"
+	EVENT_REACTIONS    = "reactions"
+	EVENT_NOW_PLAYING  = "now_playing"
+	EVENT_TAMPER       = "tamper"
+	EVENT_SCORES       = "scores"
+	EVENT_ANNOUNCEMENT = "announcement"
+	EVENT_COUNTDOWN    = "countdown"
+	EVENT_PATCH        = "patch"
+	EVENT_NEW_LEADER   = "new_leader"
+	EVENT_SPONSOR      = "sponsor"
+	EVENT_CELEBRATION  = "celebration"
+	EVENT_GOALS        = "goals"
+	EVENT_REPORT       = "report"
+)
+
+// /events sends every update as a named SSE event, so clients only handle
+// what they care about:
+//
+//	scores        the top scores on the board (narrowed by ?top= and ?team=)
+//	              as a JSON array of Score, sent on connect, when the board
+//	              changes and can't be patched, and at least every
+//	              broadcast.RESYNC while it keeps changing
+//	patch         a BoardPatch: ops to apply in order to the last scores
+//	              to bring them up to date
+//	reset         a BoardEvent; the board was cleared
+//	bumped        a BoardEvent with score (the new score) and previous (the
+//	              best score of the player pushed out of qualifying)
+//	new_leader    a BoardEvent with score (the new first place) and previous
+//	              (the one it replaced, absent for the first score), sent
+//	              once per change however it happened
+//	announcement  a BoardEvent with message, posted by an organizer
+//	celebration   a BoardEvent with celebration, a milestone to mark with
+//	              a sound or animation, sent at most once per
+//	              CELEBRATION_GAP
+//	sponsor       a BoardEvent with sponsor, a slide to show for its
+//	              seconds, sent whenever an organizer's sponsor comes due
+//	countdown     a BoardEvent with countdown, sent on connect, once a
+//	              minute until the qualifying deadline and when it passes
+//	goals         a BoardEvent with goals, progress towards each
+//	              participation goal, sent on connect and when it changes,
+//	              if any goals are set
+//	reactions     a BoardEvent with reactions, sent on connect and at most
+//	              once a second while spectators react
+//	now_playing   a BoardEvent with now_playing, sent on connect and when
+//	              runs start or end
+//
+// Every BoardEvent also carries type and time.
+
+// A boardFilter narrows the board a /events client receives, so small
+// displays aren't sent rows they can't show.
+type boardFilter struct {
+	top  int
+	team string
+	// the difficulty whose runs to keep
+	board string
+}
+
+// parseBoardFilter reads the filter from /events?board=hard&top=5&team=acme,
+// where board names a difficulty. Ranks in the filtered board, and in patches
+// to it, are positions within the filter.
+func (s *HighScoreServer) parseBoardFilter(r *http.Request) (boardFilter, error) {
+	top, err := intParam(r, "top", s.boardSize, s.boardSize)
+	if err != nil {
+		return boardFilter{}, err
+	}
+	team := r.URL.Query().Get("team")
+	if err := checkTeam(team); err != nil {
+		return boardFilter{}, err
+	}
+	board := r.URL.Query().Get("board")
+	if _, ok := s.difficulties[board]; board != "" && !ok {
+		return boardFilter{}, fmt.Errorf("unknown board %q", board)
+	}
+	return boardFilter{top: top, team: team, board: board}, nil
+}
+
+func (f boardFilter) apply(scores []Score) []Score {
+	filtered := []Score{}
+	for _, score := range scores {
+		if len(filtered) == f.top {
+			break
+		}
+		if (f.team == "" || score.Team == f.team) && (f.board == "" || score.Difficulty == f.board) {
+			filtered = append(filtered, score)
+		}
+	}
+	return filtered
+}
+
+// SSE_EVENTS are the board events also sent to /events subscribers.
+var SSE_EVENTS = []string{EVENT_RESET, EVENT_BUMPED}
+
+// A BoardEvent describes a change to the board for external consumers.
+type BoardEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// the accepted score, or the new leader for lead changes
+	Score *Score `json:"score,omitempty"`
+	Rank  int    `json:"rank,omitempty"`
+	// the leader that was overtaken, for lead changes, or the best score of
+	// the player pushed out of qualifying, for bumps
+	Previous *Score `json:"previous,omitempty"`
+	// aggregated spectator reactions, for reaction updates
+	Reactions *ReactionCounts `json:"reactions,omitempty"`
+	// runs in progress, for now playing updates
+	NowPlaying *NowPlaying `json:"now_playing,omitempty"`
+	// what a client did to trip the honeypot, for tamper warnings
+	Detail string `json:"detail,omitempty"`
+	// the text of an announcement
+	Message string `json:"message,omitempty"`
+	// time left until the qualifying deadline, for countdowns
+	Countdown *Countdown `json:"countdown,omitempty"`
+	// the slide to show, for sponsor events
+	Sponsor *SponsorSlide `json:"sponsor,omitempty"`
+	// the milestone reached, for celebrations
+	Celebration *Celebration `json:"celebration,omitempty"`
+	// progress towards the participation goals, for goal updates
+	Goals []GoalProgress `json:"goals,omitempty"`
+	// the day's summary, for daily reports
+	Report *Report `json:"report,omitempty"`
+}
+
+func (e BoardEvent) EventName() string {
+	return e.Type
+}
+
+// An EventSink forwards board events somewhere outside the server. Publish is
+// called synchronously from request handlers and must not block.
+type EventSink interface {
+	Publish(event BoardEvent)
+}
+
+// emit sends events to the sinks and, if they are for displays, to /events
+// subscribers. In a cluster every node tells its own subscribers, but only
+// the leader tells the sinks, so they hear about each change once.
+func (s *HighScoreServer) emit(events ...BoardEvent) {
+	following := s.cluster.following()
+	for _, event := range events {
+		if !following {
+			for _, sink := range s.sinks {
+				sink.Publish(event)
+			}
+		}
+		if slices.Contains(SSE_EVENTS, event.Type) {
+			s.live.Publish(event)
+		}
+	}
+}
+
+// broadcastLeader sends a "new_leader" event to /events subscribers whenever
+// a different score takes first place. Watching the board rather than
+// submissions also catches edits and deletions of the old leader. Nothing is
+// sent when the board is emptied; the reset event covers that.
+func (s *HighScoreServer) broadcastLeader(interval time.Duration) {
+	last, hadLeader := s.board.Leader()
+
+	for range time.Tick(interval) {
+		leader, ok := s.board.Leader()
+		if !ok {
+			hadLeader = false
+			continue
+		}
+		if hadLeader && leader.ID == last.ID {
+			continue
+		}
+
+		event := BoardEvent{Type: EVENT_NEW_LEADER, Time: s.now(), Score: &leader, Rank: 1}
+		if hadLeader {
+			previous := last
+			event.Previous = &previous
+		}
+		s.live.Publish(event)
+		last, hadLeader = leader, true
+	}
+}
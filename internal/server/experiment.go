@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+
+	"elevate2024/internal/store"
+)
+
+// An experiment splits players between variants of the game's tuning, in
+// proportion to their weights.
+type experiment struct {
+	name     string
+	variants []variant
+	total    int
+}
+
+type variant struct {
+	name   string
+	weight int
+}
+
+// parseExperiment reads an experiment given as "name:variant=weight,...". A
+// variant's weight defaults to 1.
+func parseExperiment(spec string) (experiment, error) {
+	name, list, ok := strings.Cut(spec, ":")
+	name = strings.TrimSpace(name)
+	if !ok || !validExperimentName(name) {
+		return experiment{}, fmt.Errorf("experiment %q: want name:variant=weight,...", spec)
+	}
+	e := experiment{name: name}
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		v := variant{weight: 1}
+		v.name, _, _ = strings.Cut(pair, "=")
+		v.name = strings.TrimSpace(v.name)
+		if _, weight, ok := strings.Cut(pair, "="); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(weight))
+			if err != nil || n < 1 {
+				return experiment{}, fmt.Errorf("experiment %q: variant %q needs a positive whole weight", name, v.name)
+			}
+			v.weight = n
+		}
+		if !validExperimentName(v.name) {
+			return experiment{}, fmt.Errorf("experiment %q: bad variant name %q", name, v.name)
+		}
+		for _, other := range e.variants {
+			if other.name == v.name {
+				return experiment{}, fmt.Errorf("experiment %q: variant %q given twice", name, v.name)
+			}
+		}
+		e.variants = append(e.variants, v)
+		e.total += v.weight
+	}
+	if len(e.variants) < 2 {
+		return experiment{}, fmt.Errorf("experiment %q needs at least two variants", name)
+	}
+	return e, nil
+}
+
+// validExperimentName keeps names clear of the separators buckets are written
+// with.
+func validExperimentName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ":=, \t")
+}
+
+// assign picks a variant for a client. The same client always lands in the
+// same variant, so a player keeps the tuning they started with.
+func (e experiment) assign(client string) string {
+	h := fnv.New64a()
+	h.Write([]byte(e.name))
+	h.Write([]byte{0})
+	h.Write([]byte(client))
+	n := int(h.Sum64() % uint64(e.total))
+	for _, v := range e.variants {
+		if n < v.weight {
+			return v.name
+		}
+		n -= v.weight
+	}
+	return e.variants[len(e.variants)-1].name
+}
+
+// experiments assigns runs to buckets and counts the runs started in each
+// variant, for /stats.
+type experiments struct {
+	list    []experiment
+	mutex   sync.Mutex
+	started map[string]int
+}
+
+// bucket assigns a client a variant of every experiment, written as
+// "experiment=variant,..." in the order the experiments were configured.
+func (x *experiments) bucket(client string) string {
+	if len(x.list) == 0 {
+		return ""
+	}
+	assigned := make([]string, len(x.list))
+	for i, e := range x.list {
+		assigned[i] = e.name + "=" + e.assign(client)
+	}
+	bucket := strings.Join(assigned, ",")
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.started == nil {
+		x.started = map[string]int{}
+	}
+	for _, pair := range assigned {
+		x.started[pair]++
+	}
+	return bucket
+}
+
+// variantOf returns the variant of an experiment a bucket names.
+func variantOf(bucket string, experiment string) string {
+	for _, pair := range strings.Split(bucket, ",") {
+		if name, v, ok := strings.Cut(pair, "="); ok && name == experiment {
+			return v
+		}
+	}
+	return ""
+}
+
+// ExperimentStats compares an experiment's variants.
+type ExperimentStats struct {
+	Name     string         `json:"name"`
+	Variants []VariantStats `json:"variants"`
+}
+
+// VariantStats describes the runs played on one variant. Cleared counts the
+// runs that beat the boss.
+type VariantStats struct {
+	Name        string  `json:"name"`
+	Weight      int     `json:"weight"`
+	Started     int     `json:"started"`
+	Submissions int     `json:"submissions"`
+	Players     int     `json:"players"`
+	Cleared     int     `json:"cleared"`
+	MeanHealth  float64 `json:"mean_remaining_health"`
+	MeanElapsed float64 `json:"mean_elapsed"`
+	Best        *Score  `json:"best"`
+}
+
+// experimentStats segments the submitted scores by variant. s.mutex must be
+// held.
+func (s *HighScoreServer) experimentStats() []ExperimentStats {
+	if len(s.experiments.list) == 0 {
+		return nil
+	}
+	s.experiments.mutex.Lock()
+	started := map[string]int{}
+	for pair, n := range s.experiments.started {
+		started[pair] = n
+	}
+	s.experiments.mutex.Unlock()
+
+	var stats []ExperimentStats
+	for _, e := range s.experiments.list {
+		es := ExperimentStats{Name: e.name}
+		for _, v := range e.variants {
+			vs := VariantStats{Name: v.name, Weight: v.weight, Started: started[e.name+"="+v.name]}
+			players := map[string]bool{}
+			for _, result := range s.results {
+				score := result.Score
+				if result.Shadow || variantOf(score.Bucket, e.name) != v.name {
+					continue
+				}
+				vs.Submissions++
+				players[score.PlayerName] = true
+				if score.RemainingHealth == 0 {
+					vs.Cleared++
+				}
+				vs.MeanHealth += float64(score.RemainingHealth)
+				vs.MeanElapsed += score.Elapsed
+				if vs.Best == nil || store.Cmp(score, *vs.Best) < 0 {
+					best := score
+					vs.Best = &best
+				}
+			}
+			vs.Players = len(players)
+			if vs.Submissions > 0 {
+				vs.MeanHealth /= float64(vs.Submissions)
+				vs.MeanElapsed /= float64(vs.Submissions)
+			}
+			es.Variants = append(es.Variants, vs)
+		}
+		stats = append(stats, es)
+	}
+	return stats
+}
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Federation lets satellite servers at remote venues take scores while cut
+// off and sync them with a primary whenever they can reach it. Each server
+// keeps the set of every score it has seen, keyed by ID, and only ever adds
+// to it, so merging is a union: syncs can repeat, overlap or come in any
+// order and every server still ends up with the same scores. Deleting a
+// score or erasing a player leaves a tombstone, which syncs like a score and
+// erases it everywhere; as an ID can't rejoin the set once erased, restoring
+// a deleted score only puts it back on the local board.
+//
+// A satellite POSTs to the primary's /federation/sync the scores it has added
+// since its last sync, and the primary answers with the scores it has added
+// since the satellite last heard from it. Both sides sign their bodies with
+// the venue's pre-shared key, so each knows the other is who it claims to be.
+const (
+	FEDERATION_VENUE_HEADER     = "X-Federation-Venue"
+	FEDERATION_SIGNATURE_HEADER = "X-Federation-Signature"
+)
+
+// FEDERATION_INTERVAL is how often a satellite syncs by default.
+const FEDERATION_INTERVAL = 30 * time.Second
+
+// MIN_FEDERATION_KEY is the shortest key a venue may have.
+const MIN_FEDERATION_KEY = 16
+
+// MAX_FEDERATION_SYNC is the largest sync body either side reads.
+const MAX_FEDERATION_SYNC = 16 << 20
+
+// scoreSet is the grow-only set of scores a federated server has seen, with
+// tombstones for those since erased. Joins and erasures are numbered in the
+// order they happened, so a peer can ask for those after the last it has.
+// An erased score keeps only its ID.
+type scoreSet struct {
+	mutex sync.Mutex
+	// every ID ever seen, erased or not
+	ids    map[string]bool
+	scores map[string]Score
+	log    []string
+}
+
+func (g *scoreSet) init() {
+	if g.ids == nil {
+		g.ids = map[string]bool{}
+		g.scores = map[string]Score{}
+	}
+}
+
+// add puts score in the set, reporting whether it is new.
+func (g *scoreSet) add(score Score) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.ids[score.ID] {
+		return false
+	}
+	g.init()
+	g.ids[score.ID] = true
+	g.scores[score.ID] = score
+	g.log = append(g.log, score.ID)
+	return true
+}
+
+// erase drops the scores that match, leaving tombstones, and returns their
+// IDs.
+func (g *scoreSet) erase(match func(Score) bool) []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var erased []string
+	for id, score := range g.scores {
+		if match(score) {
+			delete(g.scores, id)
+			g.log = append(g.log, id)
+			erased = append(erased, id)
+		}
+	}
+	return erased
+}
+
+// eraseIDs records tombstones from a peer, including for scores not seen
+// yet, so they are refused should they turn up later. It returns the IDs
+// that weren't already erased.
+func (g *scoreSet) eraseIDs(ids []string) []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.init()
+	var erased []string
+	for _, id := range ids {
+		if _, live := g.scores[id]; g.ids[id] && !live {
+			continue
+		}
+		g.ids[id] = true
+		delete(g.scores, id)
+		g.log = append(g.log, id)
+		erased = append(erased, id)
+	}
+	return erased
+}
+
+// since returns the scores that joined after the first seq and are still
+// in the set, the IDs of those erased, and how many entries there are now.
+func (g *scoreSet) since(seq int) ([]Score, []string, int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	seq = min(max(seq, 0), len(g.log))
+	scores, erased := []Score{}, []string{}
+	seen := map[string]bool{}
+	for _, id := range g.log[seq:] {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if score, ok := g.scores[id]; ok {
+			scores = append(scores, score)
+		} else {
+			erased = append(erased, id)
+		}
+	}
+	return scores, erased, len(g.log)
+}
+
+// federation is a server's part in a federation: the venues it accepts syncs
+// from, and, on a satellite, the primary it syncs with and how far each side
+// has got.
+type federation struct {
+	set     scoreSet
+	keys    map[string][]byte
+	venue   string
+	primary string
+	// how many of our scores the primary has, and of its scores we have
+	sent     int
+	received int
+}
+
+// PRIMARY_VENUE is what scores played at a primary with no -federation-venue
+// of its own are labeled with elsewhere.
+const PRIMARY_VENUE = "primary"
+
+// add puts a score in the set, reporting whether it is new. A nil federation
+// is federation being off, and ignores it.
+func (f *federation) add(score Score) bool {
+	if f == nil {
+		return false
+	}
+	return f.set.add(score)
+}
+
+// erase leaves tombstones for the scores that match, returning how many
+// there were. A nil federation ignores it.
+func (f *federation) erase(match func(Score) bool) int {
+	if f == nil {
+		return 0
+	}
+	return len(f.set.erase(match))
+}
+
+// A FederationSync is the body of a sync request and its answer: new scores
+// and the IDs of erased ones, and on the request how far through the
+// primary's set the satellite already is, or on the answer how far the
+// primary's set goes.
+type FederationSync struct {
+	Scores []Score  `json:"scores"`
+	Erased []string `json:"erased,omitempty"`
+	Since  int      `json:"since"`
+}
+
+func newFederation(config Config) (*federation, error) {
+	if config.FederationKeys == "" {
+		if config.FederationPrimary != "" {
+			return nil, errors.New("syncing with a federation primary needs -federation-keys")
+		}
+		return nil, nil
+	}
+	keys, err := loadKeyFile(config.FederationKeys, "venue")
+	if err != nil {
+		return nil, err
+	}
+	for venue, key := range keys {
+		if len(key) < MIN_FEDERATION_KEY {
+			return nil, fmt.Errorf("%s: the key for venue %q is shorter than %d characters", config.FederationKeys, venue, MIN_FEDERATION_KEY)
+		}
+	}
+	f := &federation{keys: keys, venue: config.FederationVenue, primary: strings.TrimSuffix(config.FederationPrimary, "/")}
+	if f.primary != "" && keys[f.venue] == nil {
+		return nil, fmt.Errorf("%s: no key for this server's venue %q", config.FederationKeys, f.venue)
+	}
+	if f.primary != "" && config.FederationInterval <= 0 {
+		return nil, fmt.Errorf("federation interval must be positive, got %v", config.FederationInterval)
+	}
+	return f, nil
+}
+
+// federationSignature signs a sync body. An answer's signature also covers
+// the request's, so it can't be replayed as the answer to another.
+func federationSignature(key []byte, body []byte, request string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(request))
+	mac.Write([]byte{0})
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func checkFederationSignature(key []byte, body []byte, request string, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(federationSignature(key, body, request)))
+}
+
+// merge erases the scores with tombstones in erased, then adds the scores
+// new to the set to the board as they are, keeping their IDs, returning how
+// many there were.
+func (s *HighScoreServer) merge(ctx context.Context, venue string, scores []Score, erased []string) (int, error) {
+	if ids := s.federation.set.eraseIDs(erased); len(ids) > 0 {
+		if err := s.lockBoard(ctx, "erase"); err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			s.board.Remove(func(score Score) bool { return score.ID == id })
+			delete(s.results, id)
+		}
+		s.mutex.Unlock()
+		log.Printf("Federation: erased %v scores from %v\n", len(ids), venue)
+	}
+
+	merged := 0
+	for _, score := range scores {
+		if score.ID == "" || s.checkImported(score) != nil {
+			log.Printf("Federation: skipping malformed score %q from %v\n", score.ID, venue)
+			continue
+		}
+		if score.Venue == "" {
+			score.Venue = venue
+		}
+		if !s.federation.add(score) {
+			continue
+		}
+		if _, _, err := s.placeScore(ctx, score); err != nil {
+			return merged, err
+		}
+		merged++
+	}
+	return merged, nil
+}
+
+// federationSync serves POST /federation/sync on the primary.
+func (s *HighScoreServer) federationSync(w http.ResponseWriter, r *http.Request) {
+	if s.federation == nil {
+		http.Error(w, "federation is off", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_FEDERATION_SYNC))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	venue := r.Header.Get(FEDERATION_VENUE_HEADER)
+	key := s.federation.keys[venue]
+	signature := r.Header.Get(FEDERATION_SIGNATURE_HEADER)
+	if key == nil || !checkFederationSignature(key, body, "", signature) {
+		http.Error(w, "unknown venue or invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var sync FederationSync
+	if err := json.Unmarshal(body, &sync); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i := range sync.Scores {
+		sync.Scores[i].Venue = venue
+	}
+	merged, err := s.merge(r.Context(), venue, sync.Scores, sync.Erased)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if merged > 0 {
+		log.Printf("Federation: merged %v scores from %v\n", merged, venue)
+	}
+
+	// The venue has its own scores already.
+	scores, erased, total := s.federation.set.since(sync.Since)
+	answer := FederationSync{Scores: []Score{}, Erased: erased, Since: total}
+	for _, score := range scores {
+		if score.Venue == "" {
+			score.Venue = cmp.Or(s.federation.venue, PRIMARY_VENUE)
+		}
+		if score.Venue != venue {
+			answer.Scores = append(answer.Scores, score)
+		}
+	}
+	out, _ := json.Marshal(answer)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(FEDERATION_SIGNATURE_HEADER, federationSignature(key, out, signature))
+	w.Write(out)
+}
+
+// syncFederation syncs with the primary every interval.
+func (s *HighScoreServer) syncFederation(interval time.Duration) {
+	for {
+		if err := s.syncPrimary(context.Background()); err != nil {
+			log.Printf("Federation: syncing with %v: %v\n", s.federation.primary, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// syncPrimary sends the primary the scores it hasn't had from us and merges
+// the ones we haven't had from it. Only syncFederation calls it, so the
+// progress it keeps isn't shared. A failed sync is simply tried again.
+func (s *HighScoreServer) syncPrimary(ctx context.Context) error {
+	f := s.federation
+	key := f.keys[f.venue]
+	scores, erased, total := f.set.since(f.sent)
+	// Only our own scores: the primary has the rest. Tombstones go either
+	// way, whoever's scores they are for.
+	request := FederationSync{Scores: []Score{}, Erased: erased, Since: f.received}
+	for _, score := range scores {
+		if score.Venue == "" {
+			score.Venue = f.venue
+			request.Scores = append(request.Scores, score)
+		}
+	}
+	body, _ := json.Marshal(request)
+	signature := federationSignature(key, body, "")
+
+	ctx, cancel := context.WithTimeout(ctx, FEDERATION_INTERVAL)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", f.primary+"/federation/sync", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(FEDERATION_VENUE_HEADER, f.venue)
+	req.Header.Set(FEDERATION_SIGNATURE_HEADER, signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(io.LimitReader(resp.Body, MAX_FEDERATION_SYNC))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary answered %v: %s", resp.Status, bytes.TrimSpace(out))
+	}
+	if !checkFederationSignature(key, out, signature, resp.Header.Get(FEDERATION_SIGNATURE_HEADER)) {
+		return errors.New("invalid signature on the primary's answer")
+	}
+	var answer FederationSync
+	if err := json.Unmarshal(out, &answer); err != nil {
+		return err
+	}
+
+	f.sent = total
+	merged, err := s.merge(ctx, PRIMARY_VENUE, answer.Scores, answer.Erased)
+	if err != nil {
+		return err
+	}
+	f.received = answer.Since
+	if merged > 0 || len(request.Scores) > 0 || len(request.Erased) > 0 {
+		log.Printf("Federation: sent %v scores to %v and merged %v\n", len(request.Scores), f.primary, merged)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const FEED_RANK = 10
+const FEED_LENGTH = 50
+
+type feedEntry struct {
+	Seq    int
+	Time   time.Time
+	Score  Score
+	Rank   int
+	Leader *Score // previous leader, set when the entry took over #1
+}
+
+// recordFeed notes an accepted score in the feed if it made the top of the
+// board at the given time. The caller must hold s.mutex and call this before
+// appending score.
+func (s *HighScoreServer) recordFeed(score Score, rank int, at time.Time) {
+	if rank > FEED_RANK {
+		return
+	}
+
+	entry := feedEntry{
+		Seq:   s.feedSeq,
+		Time:  at,
+		Score: score,
+		Rank:  rank,
+	}
+	s.feedSeq++
+	if leader, ok := s.board.Leader(); ok && rank == 1 {
+		entry.Leader = &leader
+	}
+
+	s.feed = append(s.feed, entry)
+	if len(s.feed) > FEED_LENGTH {
+		s.feed = s.feed[len(s.feed)-FEED_LENGTH:]
+	}
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Summary atomText `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func (s *HighScoreServer) atomFeed(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	entries := make([]feedEntry, len(s.feed))
+	copy(entries, s.feed)
+	s.mutex.Unlock()
+
+	base := s.baseURL(r)
+
+	feed := atomFeed{
+		ID:      base + "feed.atom",
+		Title:   "High Scores",
+		Updated: s.started.UTC().Format(time.RFC3339),
+		Author:  "High Score Server",
+		Links: []atomLink{
+			{Href: base + "feed.atom", Rel: "self"},
+			{Href: base},
+		},
+	}
+
+	// newest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		title := fmt.Sprintf("%s placed #%d", e.Score.PlayerName, e.Rank)
+		summary := fmt.Sprintf("%s placed #%d with %d health remaining in %.2fs.",
+			e.Score.PlayerName, e.Rank, e.Score.RemainingHealth, e.Score.Elapsed)
+		if e.Leader != nil {
+			title = fmt.Sprintf("%s takes the lead from %s", e.Score.PlayerName, e.Leader.PlayerName)
+			summary = fmt.Sprintf("%s takes the lead from %s with %d health remaining in %.2fs.",
+				e.Score.PlayerName, e.Leader.PlayerName, e.Score.RemainingHealth, e.Score.Elapsed)
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			// The start time keeps ids unique across server restarts.
+			ID:      fmt.Sprintf("%s#%d-%d", feed.ID, s.started.Unix(), e.Seq),
+			Title:   title,
+			Updated: e.Time.UTC().Format(time.RFC3339),
+			Summary: atomText{Type: "text", Body: summary},
+		})
+	}
+	if len(entries) > 0 {
+		feed.Updated = entries[len(entries)-1].Time.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"elevate2024/internal/i18n"
+)
+
+// FINISH_MODES are the accepted -finish-mode settings: whether /record
+// ignores, takes or insists on a finish from /finish.
+var FINISH_MODES = []string{"off", "optional", "required"}
+
+// The game's own timer never quite matches the server's: it starts once
+// /start has answered and /finish goes out after it stops. A claimed elapsed
+// time within FINISH_TOLERANCE of the server's, or FINISH_TOLERANCE_RATIO of
+// it for long runs, passes the cross-check.
+const (
+	FINISH_TOLERANCE       = 2 * time.Second
+	FINISH_TOLERANCE_RATIO = 0.1
+)
+
+func checkFinishMode(mode string) error {
+	if !slices.Contains(FINISH_MODES, mode) {
+		return fmt.Errorf("unknown finish mode %q (supported: %s)", mode, strings.Join(FINISH_MODES, ", "))
+	}
+	return nil
+}
+
+// finishRun answers the game's call the moment a run ends with a finish
+// signed against the run's token. Only the first call for a run counts, so
+// asking again later can't stretch it.
+func (s *HighScoreServer) finishRun(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token Token `json:"token"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkToken(req.Token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	finish := s.runs.end(req.Token, func() Finish {
+		return s.tokens.Finish(req.Token, s.now())
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(finish)
+}
+
+// timeRun sets a score's elapsed time from the finish it was submitted with,
+// in hundredths of a second as the game reports it. It rounds down, so a run
+// is never longer than its token is old.
+func (s *HighScoreServer) timeRun(score *Score, body []byte) error {
+	if s.finishMode == "off" {
+		return nil
+	}
+
+	var submission struct {
+		Finish *Finish `json:"finish"`
+	}
+	if err := json.Unmarshal(body, &submission); err != nil {
+		return err
+	}
+	if submission.Finish == nil {
+		if s.finishMode == "required" {
+			return i18n.Errorf("missing finish")
+		}
+		return nil
+	}
+	if err := s.tokens.CheckFinish(*submission.Finish, score.Token); err != nil {
+		return err
+	}
+	score.Elapsed = math.Floor(submission.Finish.Elapsed().Seconds()*100) / 100
+	return nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GOAL_METRICS are what participation goals can count: distinct initials,
+// the estimate of distinct people from uniquePlayers, and accepted runs.
+var GOAL_METRICS = []string{"players", "unique_players", "submissions"}
+
+// A Goal is a participation target set by the organizers, such as 500
+// players, shown as a progress bar to encourage attendees to play.
+type Goal struct {
+	Metric string `json:"metric"`
+	Target int    `json:"target"`
+	// what displays call it, if not the metric
+	Label string `json:"label,omitempty"`
+}
+
+// parseGoal reads a goal from "METRIC=TARGET[:LABEL]", e.g.
+// "players=500:Help us reach 500 players!".
+func parseGoal(spec string) (Goal, error) {
+	metric, rest, ok := strings.Cut(spec, "=")
+	if !ok {
+		return Goal{}, fmt.Errorf("goal %q is not METRIC=TARGET[:LABEL]", spec)
+	}
+	if !slices.Contains(GOAL_METRICS, metric) {
+		return Goal{}, fmt.Errorf("goal %q counts %q, not one of %s", spec, metric, strings.Join(GOAL_METRICS, ", "))
+	}
+	target, label, _ := strings.Cut(rest, ":")
+	n, err := strconv.Atoi(target)
+	if err != nil || n < 1 {
+		return Goal{}, fmt.Errorf("goal %q needs a positive target", spec)
+	}
+	return Goal{Metric: metric, Target: n, Label: strings.TrimSpace(label)}, nil
+}
+
+// GoalProgress is how far along a goal is.
+type GoalProgress struct {
+	Goal
+	Current int  `json:"current"`
+	Reached bool `json:"reached"`
+}
+
+// participation counts the accepted runs and the players behind them. The
+// caller holds the board lock.
+func (s *HighScoreServer) participation() map[string]int {
+	players := map[string]bool{}
+	for _, result := range s.results {
+		players[result.Score.PlayerName] = true
+	}
+	return map[string]int{
+		"players":        len(players),
+		"unique_players": int(s.uniques.estimate()),
+		"submissions":    len(s.results),
+	}
+}
+
+// goalProgress reports on each goal, given the counts from participation.
+func (s *HighScoreServer) goalProgress(counts map[string]int) []GoalProgress {
+	var progress []GoalProgress
+	for _, goal := range s.goals {
+		current := counts[goal.Metric]
+		progress = append(progress, GoalProgress{Goal: goal, Current: current, Reached: current >= goal.Target})
+	}
+	return progress
+}
+
+// broadcastGoals sends a "goals" event to /events subscribers whenever
+// progress towards a goal changes.
+func (s *HighScoreServer) broadcastGoals(interval time.Duration) {
+	var last []GoalProgress
+	for range time.Tick(interval) {
+		s.mutex.Lock()
+		progress := s.goalProgress(s.participation())
+		s.mutex.Unlock()
+		if slices.Equal(progress, last) {
+			continue
+		}
+		s.live.Publish(BoardEvent{Type: EVENT_GOALS, Time: s.now(), Goals: progress})
+		last = progress
+	}
+}
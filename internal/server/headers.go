@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"elevate2024/internal/accesslog"
+)
+
+// DEFAULT_CSP is the Content-Security-Policy unless Config.ContentSecurityPolicy
+// says otherwise. 'inline-scripts' stands for the hashes of the inline
+// scripts in the frontend's pages, which are worked out when Routes is given
+// them; the game pulls its libraries from unpkg.
+const DEFAULT_CSP = "default-src 'self'; script-src 'self' https://unpkg.com 'inline-scripts'; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data: blob:; media-src 'self' data: blob:; font-src 'self' data:; " +
+	"connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// FRAMEABLE_PATHS are pages other sites may embed.
+var FRAMEABLE_PATHS = []string{"/widget"}
+
+var (
+	inlineScript   = regexp.MustCompile(`(?s)<script([^>]*)>(.*?)</script>`)
+	frameAncestors = regexp.MustCompile(`frame-ancestors[^;]*`)
+)
+
+// inlineScriptHashes lists the CSP hashes of every inline script in the
+// HTML files in static.
+func inlineScriptHashes(static fs.FS) ([]string, error) {
+	var hashes []string
+	err := fs.WalkDir(static, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".html" {
+			return err
+		}
+		data, err := fs.ReadFile(static, name)
+		if err != nil {
+			return err
+		}
+		for _, m := range inlineScript.FindAllSubmatch(data, -1) {
+			if strings.Contains(string(m[1]), "src=") {
+				continue
+			}
+			sum := sha256.Sum256(m[2])
+			hashes = append(hashes, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+		}
+		return nil
+	})
+	slices.Sort(hashes)
+	return slices.Compact(hashes), err
+}
+
+// setScriptHashes fills the inline script hashes from static into the
+// configured policy.
+func (s *HighScoreServer) setScriptHashes(static fs.FS) {
+	if !strings.Contains(s.csp, "'inline-scripts'") {
+		return
+	}
+	hashes, err := inlineScriptHashes(static)
+	if err != nil {
+		log.Printf("Inline scripts will be blocked: %v\n", err)
+	}
+	s.csp = strings.ReplaceAll(s.csp, "'inline-scripts'", strings.Join(hashes, " "))
+}
+
+// SecureHeaders sets security headers on every response from next, since the
+// server is often reachable by anyone on a venue's network. Pages in
+// FRAMEABLE_PATHS may be framed by any site; everything else may not be.
+func (s *HighScoreServer) SecureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=()")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+
+		policy := s.csp
+		if slices.Contains(FRAMEABLE_PATHS, r.URL.Path) {
+			policy = frameAncestors.ReplaceAllString(policy, "frame-ancestors *")
+		} else {
+			h.Set("X-Frame-Options", "DENY")
+		}
+		if policy != "" {
+			h.Set("Content-Security-Policy", policy)
+		}
+		if s.refuseWrite(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LogAccess writes a Combined Log Format line to w for every request to
+// next, with client addresses masked the same way as everywhere else.
+// Truncated addresses are logged without their prefix length, which log
+// analyzers won't take as a host; hashed ones need their IP validation off.
+func (s *HighScoreServer) LogAccess(next http.Handler, w io.Writer) http.Handler {
+	return accesslog.Handler(next, w, func(r *http.Request) string {
+		host, _, _ := strings.Cut(s.clientIP(r), "/")
+		return host
+	})
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"elevate2024/internal/i18n"
+)
+
+// Game clients send a heartbeat every HEARTBEAT_INTERVAL while a run is in
+// progress. A run counts as live until HEARTBEAT_TIMEOUT passes without one,
+// and its record is kept for HEARTBEAT_RETENTION so the submission can be
+// checked against it.
+const (
+	HEARTBEAT_INTERVAL  = 5 * time.Second
+	HEARTBEAT_TIMEOUT   = 3 * HEARTBEAT_INTERVAL
+	HEARTBEAT_RETENTION = time.Hour
+)
+
+type run struct {
+	player      string
+	first       time.Time
+	last        time.Time
+	beats       int
+	end         *Finish
+	checkpoints []checkpoint
+}
+
+// A checkpoint is a client's account of a run in progress, noted when it
+// arrived. Only WebSocket sessions send them.
+type checkpoint struct {
+	received   time.Time
+	elapsed    float64
+	bossHealth int
+}
+
+type NowPlaying struct {
+	Count   int      `json:"count"`
+	Players []string `json:"players"`
+}
+
+// runTracker follows runs in progress by their start token.
+type runTracker struct {
+	mutex sync.Mutex
+	runs  map[string]*run
+	last  NowPlaying
+}
+
+func (t *runTracker) beat(token Token, player string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.touch(token, player)
+}
+
+// touch counts a heartbeat for a run, returning it. t.mutex must be held.
+func (t *runTracker) touch(token Token, player string) *run {
+	if t.runs == nil {
+		t.runs = map[string]*run{}
+	}
+	now := time.Now()
+	r, ok := t.runs[token.Nonce]
+	if !ok {
+		r = &run{first: now}
+		t.runs[token.Nonce] = r
+	}
+	if player != "" {
+		r.player = player
+	}
+	r.last = now
+	r.beats++
+	return r
+}
+
+// nowPlaying lists live runs, forgetting runs too old to be submitted. It
+// reports whether the list changed since the last call.
+func (t *runTracker) nowPlaying() (NowPlaying, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	playing := NowPlaying{Players: []string{}}
+	for key, r := range t.runs {
+		age := now.Sub(r.last)
+		if age > HEARTBEAT_RETENTION {
+			delete(t.runs, key)
+			continue
+		}
+		if age > HEARTBEAT_TIMEOUT || r.end != nil {
+			continue
+		}
+		playing.Count++
+		if r.player != "" {
+			playing.Players = append(playing.Players, r.player)
+		}
+	}
+	slices.Sort(playing.Players)
+
+	changed := playing.Count != t.last.Count || !slices.Equal(playing.Players, t.last.Players)
+	t.last = playing
+	return playing, changed
+}
+
+// current returns the live runs as of the last broadcast.
+func (t *runTracker) current() NowPlaying {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.last.Players == nil {
+		return NowPlaying{Players: []string{}}
+	}
+	return t.last
+}
+
+// end returns the finish for a run, minting it the first time it is asked for.
+func (t *runTracker) end(token Token, mint func() Finish) Finish {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.runs == nil {
+		t.runs = map[string]*run{}
+	}
+	now := time.Now()
+	r, ok := t.runs[token.Nonce]
+	if !ok {
+		r = &run{first: now}
+		t.runs[token.Nonce] = r
+	}
+	if r.end == nil {
+		finish := mint()
+		r.end = &finish
+	}
+	r.last = now
+	return *r.end
+}
+
+// checkpoint records a checkpoint, which also counts as a heartbeat.
+func (t *runTracker) checkpoint(token Token, player string, c checkpoint) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r := t.touch(token, player)
+	r.checkpoints = append(r.checkpoints, c)
+}
+
+// checkpointsOf returns the checkpoints a run has sent.
+func (t *runTracker) checkpointsOf(token Token) []checkpoint {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if r, ok := t.runs[token.Nonce]; ok {
+		return slices.Clone(r.checkpoints)
+	}
+	return nil
+}
+
+// finish drops a run once its score has been submitted.
+func (t *runTracker) finish(token Token) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.runs, token.Nonce)
+}
+
+// missedHeartbeats reports whether a run claiming to have taken elapsed
+// seconds sent fewer than half the heartbeats it should have. Runs shorter
+// than two intervals aren't judged.
+func (t *runTracker) missedHeartbeats(token Token, elapsed float64) bool {
+	expected := int(elapsed / HEARTBEAT_INTERVAL.Seconds())
+	if expected < 2 {
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.runs[token.Nonce]
+	return !ok || r.beats < expected/2
+}
+
+// heartbeat marks a run as in progress. The player's initials are optional,
+// since they are usually only entered at the end.
+func (s *HighScoreServer) heartbeat(w http.ResponseWriter, r *http.Request) {
+	var beat struct {
+		Token      Token  `json:"token"`
+		PlayerName string `json:"player_name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&beat); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkToken(beat.Token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(beat.PlayerName) > 3 {
+		s.httpError(w, r, i18n.Errorf("player name must be 1-3 characters"), http.StatusBadRequest)
+		return
+	}
+
+	s.runs.beat(beat.Token, beat.PlayerName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// broadcastNowPlaying sends the live runs to /events subscribers as a named
+// "now_playing" event whenever they change.
+func (s *HighScoreServer) broadcastNowPlaying(interval time.Duration) {
+	for range time.Tick(interval) {
+		playing, changed := s.runs.nowPlaying()
+		if changed {
+			s.live.Publish(BoardEvent{Type: EVENT_NOW_PLAYING, Time: s.now(), NowPlaying: &playing})
+		}
+	}
+}
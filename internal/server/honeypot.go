@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HONEYPOT_PATHS are decoy endpoints no game client ever calls. They look
+// like the kind of thing someone poking at the API would try.
+var HONEYPOT_PATHS = []string{
+	"/api/v1/scores",
+	"/record/override",
+	"/admin/setScore",
+}
+
+// A honeypotToken is what /start hands out: a real token plus a decoy field
+// that the game passes back untouched. Anyone who flips it is tampering.
+type honeypotToken struct {
+	Token
+	Verified bool `json:"verified"`
+}
+
+type HoneypotHit struct {
+	IP     string    `json:"ip"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Hits   int       `json:"hits"`
+	Reason string    `json:"reason"`
+}
+
+// honeypot tracks clients that have touched a decoy. Their scores are
+// shadow-hidden: accepted as far as they can tell, but never shown on the
+// board.
+type honeypot struct {
+	mutex   sync.Mutex
+	flagged map[string]*HoneypotHit
+}
+
+// flag records a hit at now, returning true the first time a client is
+// flagged.
+func (h *honeypot) flag(ip string, reason string, now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.flagged == nil {
+		h.flagged = map[string]*HoneypotHit{}
+	}
+	hit, ok := h.flagged[ip]
+	if !ok {
+		hit = &HoneypotHit{IP: ip, First: now, Reason: reason}
+		h.flagged[ip] = hit
+	}
+	hit.Last = now
+	hit.Hits++
+	return !ok
+}
+
+func (h *honeypot) isFlagged(ip string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	_, ok := h.flagged[ip]
+	return ok
+}
+
+// flagClient marks a client as tampering, warning organizers the first time.
+func (s *HighScoreServer) flagClient(ip string, reason string) {
+	if !s.honeypot.flag(ip, reason, s.now()) {
+		return
+	}
+	log.Printf("Honeypot: flagged %v (%v)\n", ip, reason)
+	s.emit(BoardEvent{Type: EVENT_TAMPER, Time: s.now(), Detail: reason})
+}
+
+// honeypotMarker reports why a submission carries a honeypot marker, if it
+// does.
+func honeypotMarker(body []byte) string {
+	var submission struct {
+		Token struct {
+			Verified bool `json:"verified"`
+		} `json:"token"`
+	}
+	if json.Unmarshal(body, &submission) == nil && submission.Token.Verified {
+		return "submitted a token marked verified"
+	}
+	return ""
+}
+
+// decoy answers a honeypot endpoint as if it had worked.
+func (s *HighScoreServer) decoy(w http.ResponseWriter, r *http.Request) {
+	s.flagClient(s.clientIP(r), "requested "+r.Method+" "+r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// shadowScore pretends to accept a score from a flagged client. It gets an ID
+// and rank so its share link works for the submitter, but it is kept off the
+// board and out of every event.
+func (s *HighScoreServer) shadowScore(ctx context.Context, score Score) (Score, int, error) {
+	id, err := newScoreID()
+	if err != nil {
+		return score, 0, err
+	}
+	score.ID = id
+	if score.Submitted.IsZero() {
+		score.Submitted = s.submittedAt()
+	}
+	s.normalize(&score)
+
+	if err := s.lockBoard(ctx, "insert"); err != nil {
+		return score, 0, err
+	}
+	defer s.mutex.Unlock()
+
+	rank := s.board.Rank(score)
+	s.results[id] = Result{
+		Score:     score,
+		Rank:      rank,
+		Submitted: score.Submitted,
+		Shadow:    true,
+	}
+	return score, rank, nil
+}
+
+func (s *HighScoreServer) listHoneypot(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.honeypot.mutex.Lock()
+	hits := make([]HoneypotHit, 0, len(s.honeypot.flagged))
+	for _, hit := range s.honeypot.flagged {
+		hits = append(hits, *hit)
+	}
+	s.honeypot.mutex.Unlock()
+	slices.SortFunc(hits, func(a, b HoneypotHit) int { return a.First.Compare(b.First) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hits)
+}
+
+// banClient lets an organizer shadow-hide a client's scores from now on, as if
+// it had touched a decoy, without it being announced as tampering.
+func (s *HighScoreServer) banClient(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ip := r.PathValue("ip")
+	reason := r.FormValue("reason")
+	if reason == "" {
+		reason = "banned by an organizer"
+	}
+	if s.honeypot.isFlagged(ip) {
+		http.Error(w, "already flagged", http.StatusConflict)
+		return
+	}
+	s.honeypot.flag(ip, reason, s.now())
+	log.Printf("Honeypot: banned %v (%v)\n", ip, reason)
+	s.audit.record(s.clientIP(r), "honeypot-ban", "", nil, ip)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// unflagClient clears a flag, e.g. when a whole venue shares one address.
+// Scores already shadow-hidden stay hidden.
+func (s *HighScoreServer) unflagClient(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ip := r.PathValue("ip")
+	s.honeypot.mutex.Lock()
+	hit, ok := s.honeypot.flagged[ip]
+	delete(s.honeypot.flagged, ip)
+	s.honeypot.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "honeypot-unflag", "", *hit, nil)
+	w.WriteHeader(http.StatusOK)
+}
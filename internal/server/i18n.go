@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// language picks the language to answer r in and says so on the response.
+func (s *HighScoreServer) language(w http.ResponseWriter, r *http.Request) string {
+	lang := s.i18n.Match(r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	return lang
+}
+
+// httpError replies with err's message in the client's language, if it has
+// one.
+func (s *HighScoreServer) httpError(w http.ResponseWriter, r *http.Request, err error, code int) {
+	http.Error(w, s.i18n.Message(s.language(w, r), err), code)
+}
+
+// getStrings serves the frontend's strings in the client's language, so an
+// event can run in another language without changing the frontend.
+func (s *HighScoreServer) getStrings(w http.ResponseWriter, r *http.Request) {
+	lang := s.language(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(struct {
+		Language  string            `json:"language"`
+		Languages []string          `json:"languages"`
+		Strings   map[string]string `json:"strings"`
+	}{lang, s.i18n.Languages(), s.i18n.Strings(lang)})
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkImported validates the fields of a score that didn't come with a token.
+func (s *HighScoreServer) checkImported(score Score) error {
+	if len(score.PlayerName) < 1 || len(score.PlayerName) > 3 {
+		return errors.New("player name must be 1-3 characters")
+	}
+	if err := s.checkDifficulty(score); err != nil {
+		return err
+	}
+	return s.checkBounds(score)
+}
+
+// parseScoresCSV reads scores from CSV with a header row naming the
+// player_name, elapsed and remaining_health columns, in any order, and
+// optionally a difficulty column.
+func parseScoresCSV(r io.Reader) ([]Score, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header row")
+	}
+
+	columns := map[string]int{}
+	for _, name := range []string{"player_name", "elapsed", "remaining_health"} {
+		i := slices.IndexFunc(records[0], func(h string) bool {
+			return strings.EqualFold(strings.TrimSpace(h), name)
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+		columns[name] = i
+	}
+	difficulty := slices.IndexFunc(records[0], func(h string) bool {
+		return strings.EqualFold(strings.TrimSpace(h), "difficulty")
+	})
+
+	var scores []Score
+	for line, record := range records[1:] {
+		elapsed, err := strconv.ParseFloat(strings.TrimSpace(record[columns["elapsed"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", line+2, err)
+		}
+		health, err := strconv.Atoi(strings.TrimSpace(record[columns["remaining_health"]]))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", line+2, err)
+		}
+		score := Score{
+			PlayerName:      strings.TrimSpace(record[columns["player_name"]]),
+			Elapsed:         elapsed,
+			RemainingHealth: health,
+		}
+		if difficulty >= 0 {
+			score.Difficulty = strings.TrimSpace(record[difficulty])
+		}
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// importScores merges externally collected scores, such as a paper backup
+// round, into the board. They skip token validation and are flagged as
+// imported. Either every row is imported or none are.
+func (s *HighScoreServer) importScores(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, 1<<20)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var scores []Score
+	var err error
+	switch mediaType {
+	case "text/csv":
+		scores, err = parseScoresCSV(body)
+	case "application/json", "":
+		err = json.NewDecoder(body).Decode(&scores)
+	default:
+		http.Error(w, "expected text/csv or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i, score := range scores {
+		if err := s.checkImported(score); err != nil {
+			http.Error(w, fmt.Sprintf("score %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, score := range scores {
+		// Only the scoring fields are taken from the input.
+		score = Score{
+			PlayerName:      score.PlayerName,
+			Elapsed:         score.Elapsed,
+			RemainingHealth: score.RemainingHealth,
+			Difficulty:      score.Difficulty,
+			Imported:        true,
+		}
+		score, _, err := s.insertScore(r.Context(), score)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		s.audit.record(s.clientIP(r), "import", score.ID, nil, score)
+	}
+	log.Printf("Imported %v scores\n", len(scores))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Imported int `json:"imported"`
+	}{
+		Imported: len(scores),
+	})
+}
+
+// EXPORT_COLUMNS are the CSV columns of /admin/export. The first four are the
+// ones /admin/import reads, so an export can be imported elsewhere.
+var EXPORT_COLUMNS = []string{"player_name", "elapsed", "remaining_health", "difficulty", "normalized", "team", "station", "imported", "id", "submitted"}
+
+// exportScores serves every accepted score the server still holds, best
+// first: those that fell off the bottom of the board and those cleared by a
+// reset too, but not deleted ones. It is CSV with ?format=csv, otherwise
+// JSON. With
+// ?format=timeseries it serves the activity over the day instead, as CSV
+// in buckets of ?bucket= like /stats/timeseries.
+func (s *HighScoreServer) exportScores(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "timeseries" {
+		s.exportTimeseries(w, r)
+		return
+	}
+
+	scores, err := s.heldScores(r, "export")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="scores.csv"`)
+		out := csv.NewWriter(w)
+		out.Write(EXPORT_COLUMNS)
+		for _, score := range scores {
+			out.Write([]string{
+				score.PlayerName,
+				strconv.FormatFloat(score.Elapsed, 'f', -1, 64),
+				strconv.Itoa(score.RemainingHealth),
+				score.Difficulty,
+				strconv.FormatFloat(score.Normalized, 'f', -1, 64),
+				score.Team,
+				score.Station,
+				strconv.FormatBool(score.Imported),
+				score.ID,
+				score.Submitted.Format(time.RFC3339Nano),
+			})
+		}
+		out.Flush()
+	case "json", "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scores)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q (supported: csv, json, timeseries)", format), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink writes every submission attempt, accepted or rejected, to a Kafka
+// topic as JSON for post-event analysis. It reads from the same hub as the
+// admin tail, so it sees exactly what moderators see.
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func newKafkaSink(brokers string, topic string) *kafkaSink {
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(strings.Split(brokers, ",")...),
+			Topic:                  topic,
+			Balancer:               &kafka.Hash{},
+			BatchTimeout:           time.Second,
+			Async:                  true,
+			AllowAutoTopicCreation: true,
+			ErrorLogger: kafka.LoggerFunc(func(format string, args ...any) {
+				log.Printf("Kafka: "+format+"\n", args...)
+			}),
+		},
+	}
+}
+
+// run forwards submission events until the channel is closed.
+func (k *kafkaSink) run(events <-chan SubmissionEvent) {
+	for event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		// Keying by client keeps each client's attempts in order on one
+		// partition.
+		err = k.writer.WriteMessages(context.Background(), kafka.Message{
+			Key:   []byte(event.IP),
+			Value: value,
+			Time:  event.Time,
+		})
+		if err != nil {
+			log.Printf("Kafka: %v\n", err)
+		}
+	}
+}
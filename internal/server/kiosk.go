@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// KIOSK_VIEWS lists the views the kiosk page knows how to render.
+var KIOSK_VIEWS = []string{"top", "daily", "teams", "stats", "announcements", "qr", "bracket"}
+
+// DEFAULT_KIOSK_VIEWS leaves out the team board, which is empty unless players
+// give a team, and the bracket, which only exists for the finals.
+var DEFAULT_KIOSK_VIEWS = []string{"top", "daily", "stats", "announcements", "qr"}
+
+type KioskConfig struct {
+	Views         []string `json:"views"`
+	Interval      float64  `json:"interval_seconds"`
+	Announcements []string `json:"announcements"`
+	PublicURL     string   `json:"public_url"`
+	// whether players can leave an email for their final placement
+	EmailResults bool `json:"email_results"`
+}
+
+// parseKioskViews validates a comma-separated list of kiosk views.
+func parseKioskViews(s string) ([]string, error) {
+	var views []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !slices.Contains(KIOSK_VIEWS, v) {
+			return nil, fmt.Errorf("unknown kiosk view %q (supported: %s)", v, strings.Join(KIOSK_VIEWS, ", "))
+		}
+		views = append(views, v)
+	}
+	if len(views) == 0 {
+		return nil, fmt.Errorf("no kiosk views configured")
+	}
+	return views, nil
+}
+
+func (s *HighScoreServer) kioskConfig(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	announcements := slices.Clone(s.kiosk.Announcements)
+	s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(KioskConfig{
+		Views:         s.kiosk.Views,
+		Interval:      s.kiosk.Interval,
+		Announcements: announcements,
+		PublicURL:     s.publicURL,
+		EmailResults:  s.mailer != nil,
+	})
+}
+
+// announce adds an announcement to the kiosk rotation and pushes it to
+// /events subscribers straight away.
+func (s *HighScoreServer) announce(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	message := strings.TrimSpace(body.Message)
+	if message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	s.kiosk.Announcements = append(s.kiosk.Announcements, message)
+	s.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "announce", "", nil, message)
+	s.live.Publish(BoardEvent{Type: EVENT_ANNOUNCEMENT, Time: s.now(), Message: message})
+	w.WriteHeader(http.StatusOK)
+}
+
+type Stats struct {
+	Submissions int `json:"submissions"`
+	Players     int `json:"players"`
+	// about how many different people played, telling apart players who
+	// share initials by their client; see uniquePlayers
+	UniquePlayers uint64 `json:"unique_players"`
+	Best          *Score `json:"best"`
+	Uptime        string `json:"uptime"`
+	// how runs started through /runs ended, to judge how hard the game is
+	Runs RunStats `json:"runs"`
+	// the configured experiments, variant by variant
+	Experiments []ExperimentStats `json:"experiments,omitempty"`
+	// progress towards the participation goals
+	Goals []GoalProgress `json:"goals,omitempty"`
+}
+
+func (s *HighScoreServer) stats(w http.ResponseWriter, r *http.Request) {
+	scores, err := s.topScores(r.Context(), s.boardSize)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	s.mutex.Lock()
+	counts := s.participation()
+	stats := Stats{
+		Submissions:   counts["submissions"],
+		Players:       counts["players"],
+		UniquePlayers: s.uniques.estimate(),
+		Uptime:        time.Since(s.started).Round(time.Second).String(),
+		Runs:          s.sessions.stats(),
+		Experiments:   s.experimentStats(),
+		Goals:         s.goalProgress(counts),
+	}
+	if len(scores) > 0 {
+		best := scores[0]
+		stats.Best = &best
+	}
+	s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A routeLimit caps the requests one route serves at once and how long each
+// may take, so a slow dependency behind one route can't tie up the whole
+// server. A request over the cap is turned away at once rather than queued.
+type routeLimit struct {
+	pattern string
+	// 0 means no cap on either
+	max     int
+	timeout time.Duration
+
+	slots    chan struct{}
+	inFlight atomic.Int64
+	rejected atomic.Int64
+	timedOut atomic.Int64
+}
+
+// parseRouteLimit reads a -route-limit: "PATH=MAX" or "PATH=MAX,TIMEOUT",
+// such as "/record=200,2s". A PATH ending in / covers everything under it,
+// as with http.ServeMux; a MAX of 0 leaves the route uncapped.
+func parseRouteLimit(spec string) (*routeLimit, error) {
+	pattern, rest, ok := strings.Cut(spec, "=")
+	if !ok || !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("route limit %q: want PATH=MAX[,TIMEOUT]", spec)
+	}
+	limit := &routeLimit{pattern: pattern}
+	max, timeout, hasTimeout := strings.Cut(rest, ",")
+	n, err := strconv.Atoi(max)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("route limit %q: MAX must be a non-negative integer", spec)
+	}
+	limit.max = n
+	if hasTimeout {
+		if limit.timeout, err = time.ParseDuration(timeout); err != nil || limit.timeout < 0 {
+			return nil, fmt.Errorf("route limit %q: bad timeout %q", spec, timeout)
+		}
+	}
+	if limit.max > 0 {
+		limit.slots = make(chan struct{}, limit.max)
+	}
+	return limit, nil
+}
+
+func parseRouteLimits(specs []string) ([]*routeLimit, error) {
+	var limits []*routeLimit
+	for _, spec := range specs {
+		limit, err := parseRouteLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range limits {
+			if other.pattern == limit.pattern {
+				return nil, fmt.Errorf("route limit for %v given twice", limit.pattern)
+			}
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+func (l *routeLimit) matches(path string) bool {
+	if strings.HasSuffix(l.pattern, "/") {
+		return strings.HasPrefix(path, l.pattern)
+	}
+	return path == l.pattern
+}
+
+// handler applies the limit to next. A request holds its slot until next
+// returns, even after it has timed out, so the cap bounds the work actually
+// being done and not just the requests still waiting for an answer.
+func (l *routeLimit) handler(next http.Handler) http.Handler {
+	capped := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+			default:
+				l.rejected.Add(1)
+				writeRouteBusy(w)
+				return
+			}
+		}
+		l.inFlight.Add(1)
+		defer l.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+		// TimeoutHandler ends the context when it gives up on the request.
+		if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			l.timedOut.Add(1)
+		}
+	})
+	if l.timeout == 0 {
+		return capped
+	}
+	return http.TimeoutHandler(capped, l.timeout, `{"error":"timeout"}`)
+}
+
+func writeRouteBusy(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{"route_busy"})
+}
+
+// LimitRoutes applies the -route-limit caps and timeouts to next: for each
+// request, the limit with the longest pattern matching its path, if any.
+// Timeouts buffer the whole response, so streaming routes like /events and
+// /ws should only be capped.
+func (s *HighScoreServer) LimitRoutes(next http.Handler) http.Handler {
+	if len(s.routeLimits) == 0 {
+		return next
+	}
+	handlers := make([]http.Handler, len(s.routeLimits))
+	for i, limit := range s.routeLimits {
+		handlers[i] = limit.handler(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		best := -1
+		for i, limit := range s.routeLimits {
+			if limit.matches(r.URL.Path) && (best < 0 || len(limit.pattern) > len(s.routeLimits[best].pattern)) {
+				best = i
+			}
+		}
+		if best < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		handlers[best].ServeHTTP(w, r)
+	})
+}
+
+// RouteStats describes the load on a limited route, for the admin status.
+type RouteStats struct {
+	Pattern  string  `json:"pattern"`
+	Max      int     `json:"max,omitempty"`
+	Timeout  float64 `json:"timeout,omitempty"`
+	InFlight int64   `json:"in_flight"`
+	Rejected int64   `json:"rejected"`
+	TimedOut int64   `json:"timed_out"`
+}
+
+func (s *HighScoreServer) routeStats() []RouteStats {
+	var stats []RouteStats
+	for _, limit := range s.routeLimits {
+		stats = append(stats, RouteStats{
+			Pattern:  limit.pattern,
+			Max:      limit.max,
+			Timeout:  limit.timeout.Seconds(),
+			InFlight: limit.inFlight.Load(),
+			Rejected: limit.rejected.Load(),
+			TimedOut: limit.timedOut.Load(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"elevate2024/internal/logging"
+)
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// getLogLevel reports the current log level.
+func (s *HighScoreServer) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logLevel{Level: levelName(logging.Level.Level())})
+}
+
+// setLogLevel changes how verbose the server is without restarting it, e.g.
+// to debug every validation decision while a kiosk misbehaves.
+func (s *HighScoreServer) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body logLevel
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level, err := logging.ParseLevel(body.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before := levelName(logging.Level.Level())
+	s.audit.record(s.clientIP(r), "log_level", "", before, levelName(level))
+	// log the change at whichever of the two levels is more verbose
+	if level > logging.Level.Level() {
+		slog.Warn("Log level changed", "from", before, "to", levelName(level))
+		logging.Level.Set(level)
+	} else {
+		logging.Level.Set(level)
+		slog.Warn("Log level changed", "from", before, "to", levelName(level))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logLevel{Level: levelName(level)})
+}
+
+func levelName(level slog.Level) string {
+	text, _ := level.MarshalText()
+	return strings.ToLower(string(text))
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"elevate2024/internal/api"
+	"elevate2024/internal/broadcast"
+	"elevate2024/internal/i18n"
+	"elevate2024/internal/store"
+)
+
+// A mirror serves a read-only copy of another server's board, for displays
+// in parts of the venue network that can't reach the main server. It follows
+// the upstream's /events: snapshots and patches keep the board up to date,
+// and every other event is passed on to the mirror's own /events clients.
+// Nothing is ever submitted to a mirror.
+type mirror struct {
+	upstream string
+
+	mutex sync.Mutex
+	// the latest of the events /events sends new clients on connect
+	latest map[string]BoardEvent
+}
+
+// MIRROR_BACKOFF and MAX_MIRROR_BACKOFF bound how long a mirror waits before
+// reconnecting to its upstream, doubling each failed attempt.
+const (
+	MIRROR_BACKOFF     = time.Second
+	MAX_MIRROR_BACKOFF = 30 * time.Second
+)
+
+// MIRROR_CURRENT are the events that describe what's going on now rather
+// than something that happened, which a mirror replays to new clients.
+var MIRROR_CURRENT = []string{EVENT_REACTIONS, EVENT_NOW_PLAYING, EVENT_COUNTDOWN}
+
+func newMirror(upstream string) *mirror {
+	if upstream == "" {
+		return nil
+	}
+	return &mirror{upstream: strings.TrimSuffix(upstream, "/"), latest: map[string]BoardEvent{}}
+}
+
+// current returns the latest events MIRROR_CURRENT names, for a new client.
+func (m *mirror) current() []BoardEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var events []BoardEvent
+	for _, name := range MIRROR_CURRENT {
+		if event, ok := m.latest[name]; ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// errOutOfStep ends an upstream connection whose patch doesn't fit the
+// mirrored board, so the next one starts from a snapshot.
+var errOutOfStep = errors.New("mirrored board out of step")
+
+// follow keeps the board in step with the upstream for as long as the server
+// runs, reconnecting with backoff whenever the connection drops.
+func (s *HighScoreServer) follow() {
+	wait := MIRROR_BACKOFF
+	for {
+		received, err := s.followOnce(context.Background())
+		if received {
+			wait = MIRROR_BACKOFF
+		}
+		log.Printf("Mirror: lost %v: %v; reconnecting in %v\n", s.mirror.upstream, err, wait)
+		time.Sleep(wait)
+		wait = min(wait*2, MAX_MIRROR_BACKOFF)
+	}
+}
+
+// followOnce reads the upstream's /events until the connection ends,
+// reporting whether anything came of it.
+func (s *HighScoreServer) followOnce(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.mirror.upstream+"/events", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("upstream answered %v", resp.Status)
+	}
+
+	received := false
+	err = broadcast.ReadEvents(resp.Body, func(event string, data []byte) error {
+		if err := s.mirrorEvent(event, data); err != nil {
+			return err
+		}
+		if !received {
+			log.Printf("Mirror: following %v\n", s.mirror.upstream)
+		}
+		received = true
+		return nil
+	})
+	if err == nil {
+		err = errors.New("upstream closed the stream")
+	}
+	return received, err
+}
+
+// mirrorEvent applies one event from the upstream.
+func (s *HighScoreServer) mirrorEvent(event string, data []byte) error {
+	switch event {
+	case EVENT_SCORES:
+		var scores []Score
+		if err := json.Unmarshal(data, &scores); err != nil {
+			return err
+		}
+		s.board.Replace(scores)
+	case EVENT_PATCH:
+		var patch api.BoardPatch
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return err
+		}
+		scores, ok := store.Apply(s.board.Scores(), patch.Ops)
+		if !ok {
+			return errOutOfStep
+		}
+		s.board.Replace(scores)
+	default:
+		var e BoardEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		if slices.Contains(MIRROR_CURRENT, event) {
+			s.mirror.mutex.Lock()
+			s.mirror.latest[event] = e
+			s.mirror.mutex.Unlock()
+		}
+		s.live.Publish(e)
+	}
+	return nil
+}
+
+// refuseWrite answers requests that would change a mirror's state, which
+// only the upstream takes, reporting whether it did. Runs can't be started
+// either, as they could never be submitted.
+func (s *HighScoreServer) refuseWrite(w http.ResponseWriter, r *http.Request) bool {
+	read := r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+	if s.mirror == nil || read && r.URL.Path != "/start" && r.URL.Path != "/ws" {
+		return false
+	}
+	s.httpError(w, r, i18n.Errorf("this board is a read-only mirror"), http.StatusForbidden)
+	return true
+}
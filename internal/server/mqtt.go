@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	mqttConnect   = 0x10
+	mqttConnAck   = 0x20
+	mqttPublish   = 0x30
+	mqttPubAck    = 0x40
+	mqttPingReq   = 0xc0
+	mqttKeepAlive = 60 * time.Second
+	// how long a write to the broker may block before the connection is
+	// given up on
+	mqttWriteTimeout = 10 * time.Second
+)
+
+// mqttMirror publishes the same top scores the SSE stream carries to an MQTT
+// broker as a retained message, so microcontroller displays can subscribe to
+// <prefix>/scores and get the current board as soon as they connect. It
+// implements just enough of MQTT 3.1.1 to do that.
+type mqttMirror struct {
+	addr     string
+	useTLS   bool
+	user     string
+	pass     string
+	hasUser  bool
+	hasPass  bool
+	topic    string
+	qos      byte
+	snapshot func() ([]byte, error)
+}
+
+func newMQTTMirror(rawURL string, prefix string, qos int, snapshot func() ([]byte, error)) (*mqttMirror, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	m := &mqttMirror{
+		addr:     u.Host,
+		topic:    prefix + "/scores",
+		qos:      byte(qos),
+		snapshot: snapshot,
+	}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if u.Port() == "" {
+			m.addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	case "mqtts", "ssl":
+		m.useTLS = true
+		if u.Port() == "" {
+			m.addr = net.JoinHostPort(u.Hostname(), "8883")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported MQTT URL scheme %q", u.Scheme)
+	}
+	if qos != 0 && qos != 1 {
+		return nil, fmt.Errorf("unsupported MQTT QoS %d (use 0 or 1)", qos)
+	}
+	if u.User != nil {
+		m.user, m.hasUser = u.User.Username(), true
+		m.pass, m.hasPass = u.User.Password()
+	}
+	return m, nil
+}
+
+func mqttString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+// mqttPacket frames a control packet with its variable-length size.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+// run keeps a session with the broker going, backing off while it can't
+// connect. The backoff starts over once the broker has accepted a connection.
+func (m *mqttMirror) run() {
+	backoff := time.Second
+	for {
+		conn, err := m.dial()
+		if err == nil {
+			var connected bool
+			connected, err = m.session(conn)
+			if connected {
+				backoff = time.Second
+			}
+		}
+		log.Printf("MQTT connection to %v lost: %v\n", m.addr, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+func (m *mqttMirror) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if m.useTLS {
+		host, _, _ := net.SplitHostPort(m.addr)
+		return tls.DialWithDialer(dialer, "tcp", m.addr, &tls.Config{ServerName: host})
+	}
+	return dialer.Dial("tcp", m.addr)
+}
+
+// session publishes the board over conn until the connection fails,
+// reporting whether the broker accepted the connection first.
+func (m *mqttMirror) session(conn net.Conn) (bool, error) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	write := func(packet []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(mqttWriteTimeout))
+		_, err := conn.Write(packet)
+		return err
+	}
+
+	var connect bytes.Buffer
+	mqttString(&connect, "MQTT")
+	connect.WriteByte(4) // protocol level 3.1.1
+	flags := byte(0x02)  // clean session
+	if m.hasUser {
+		flags |= 0x80
+	}
+	if m.hasPass {
+		flags |= 0x40
+	}
+	connect.WriteByte(flags)
+	binary.Write(&connect, binary.BigEndian, uint16(mqttKeepAlive/time.Second))
+	mqttString(&connect, fmt.Sprintf("highscore-%d", time.Now().UnixNano()%1_000_000))
+	if m.hasUser {
+		mqttString(&connect, m.user)
+	}
+	if m.hasPass {
+		mqttString(&connect, m.pass)
+	}
+	if err := write(mqttPacket(mqttConnect, connect.Bytes())); err != nil {
+		return false, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	header, body, err := readMQTTPacket(r)
+	if err != nil {
+		return false, err
+	}
+	if header != mqttConnAck || len(body) != 2 {
+		return false, fmt.Errorf("expected CONNACK, got packet type %#x", header)
+	}
+	if body[1] != 0 {
+		return false, fmt.Errorf("broker refused connection (return code %d)", body[1])
+	}
+	conn.SetReadDeadline(time.Time{})
+	log.Printf("Connected to MQTT broker at %v\n", m.addr)
+
+	errs := make(chan error, 1)
+	acks := make(chan uint16, 1)
+	go func() {
+		for {
+			header, body, err := readMQTTPacket(r)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if header&0xf0 == mqttPubAck && len(body) == 2 {
+				select {
+				case acks <- binary.BigEndian.Uint16(body):
+				default:
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	lastSent := time.Now()
+	var last []byte
+	var packetID uint16
+
+	for {
+		select {
+		case err := <-errs:
+			return true, err
+		case <-ticker.C:
+		}
+
+		data, err := m.snapshot()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			if time.Since(lastSent) > mqttKeepAlive/2 {
+				if err := write([]byte{mqttPingReq, 0}); err != nil {
+					return true, err
+				}
+				lastSent = time.Now()
+			}
+			continue
+		}
+
+		var publish bytes.Buffer
+		mqttString(&publish, m.topic)
+		if m.qos > 0 {
+			packetID++
+			if packetID == 0 {
+				packetID = 1
+			}
+			binary.Write(&publish, binary.BigEndian, packetID)
+		}
+		publish.Write(data)
+		header := byte(mqttPublish) | m.qos<<1 | 0x01 // retained
+		if err := write(mqttPacket(header, publish.Bytes())); err != nil {
+			return true, err
+		}
+		lastSent = time.Now()
+
+		if m.qos > 0 {
+			select {
+			case id := <-acks:
+				if id != packetID {
+					return true, fmt.Errorf("PUBACK for packet %d, expected %d", id, packetID)
+				}
+			case err := <-errs:
+				return true, err
+			case <-time.After(10 * time.Second):
+				return true, errors.New("timed out waiting for PUBACK")
+			}
+		}
+		last = data
+	}
+}
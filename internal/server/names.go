@@ -0,0 +1,75 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+)
+
+// nameOwners remembers which client first put each name on the board, so a
+// different client picking the same initials can be warned that they would
+// be mistaken for someone else.
+type nameOwners struct {
+	mutex  sync.Mutex
+	owners map[string]string
+}
+
+// claim records fingerprint as the owner of name if it has none yet, and
+// returns the owner.
+func (n *nameOwners) claim(name, fingerprint string) string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if owner, ok := n.owners[name]; ok {
+		return owner
+	}
+	if n.owners == nil {
+		n.owners = map[string]string{}
+	}
+	n.owners[name] = fingerprint
+	return fingerprint
+}
+
+// suggestName returns a variant of name no one on the board uses, made by
+// putting a number after its first one or two letters while staying within
+// the three bytes a name may take, or "" if every variant is taken.
+func suggestName(name string, taken func(string) bool) string {
+	// Slicing by rune keeps a multibyte initial whole.
+	runes := []rune(name)
+	prefixes := []string{string(runes[:min(len(runes), 2)]), string(runes[:1])}
+	for _, prefix := range prefixes {
+		for n := 2; n < 100; n++ {
+			variant := prefix + strconv.Itoa(n)
+			if len(variant) > 3 {
+				break
+			}
+			if !taken(variant) {
+				return variant
+			}
+		}
+	}
+	return ""
+}
+
+// nameWarning checks a name about to be accepted from the client with the
+// given fingerprint. If someone else already has it on the board, it returns
+// a warning in lang and a variant to play under next time instead.
+func (s *HighScoreServer) nameWarning(lang, name, fingerprint string) (warning, suggestion string) {
+	if !s.suggestNames || s.names.claim(name, fingerprint) == fingerprint {
+		return "", ""
+	}
+
+	s.mutex.Lock()
+	names := map[string]bool{}
+	for _, score := range s.board.Scores() {
+		names[score.PlayerName] = true
+	}
+	s.mutex.Unlock()
+	if !names[name] {
+		return "", ""
+	}
+	suggestion = suggestName(name, func(variant string) bool { return names[variant] })
+	if suggestion == "" {
+		return s.i18n.Sprintf(lang, "%v is already on the board from another player", name), ""
+	}
+	return s.i18n.Sprintf(lang, "%v is already on the board from another player; try %v next time", name, suggestion), suggestion
+}
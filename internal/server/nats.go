@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NATS_WRITE_TIMEOUT is how long a write to the NATS server may block before
+// the connection is given up on.
+const NATS_WRITE_TIMEOUT = 10 * time.Second
+
+// natsPublisher speaks just enough of the NATS client protocol to publish
+// board events: CONNECT, PUB and answering the server's PINGs. Events are
+// queued and sent from a single goroutine that reconnects as needed; if NATS
+// is unreachable for long enough to fill the queue, new events are dropped.
+type natsPublisher struct {
+	addr    string
+	user    string
+	pass    string
+	token   string
+	subject string
+	queue   chan BoardEvent
+}
+
+// newNATSPublisher parses a nats:// URL. A username without a password is
+// treated as an auth token, matching the official clients.
+func newNATSPublisher(rawURL string, subject string) (*natsPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported NATS URL scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	n := &natsPublisher{
+		addr:    addr,
+		subject: subject,
+		queue:   make(chan BoardEvent, 256),
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			n.user, n.pass = u.User.Username(), pass
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+	return n, nil
+}
+
+func (n *natsPublisher) Publish(event BoardEvent) {
+	select {
+	case n.queue <- event:
+	default:
+		log.Printf("NATS queue full, dropping %v event\n", event.Type)
+	}
+}
+
+// run keeps a session with the server going, backing off while it can't
+// connect. The backoff starts over once the server has accepted a connection.
+func (n *natsPublisher) run() {
+	backoff := time.Second
+	for {
+		conn, err := net.DialTimeout("tcp", n.addr, 10*time.Second)
+		if err == nil {
+			var connected bool
+			connected, err = n.session(conn)
+			if connected {
+				backoff = time.Second
+			}
+		}
+		log.Printf("NATS connection to %v lost: %v\n", n.addr, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// session publishes queued events over conn until the connection fails,
+// reporting whether the server accepted the connection first.
+func (n *natsPublisher) session(conn net.Conn) (bool, error) {
+	defer conn.Close()
+	write := func(format string, args ...any) error {
+		conn.SetWriteDeadline(time.Now().Add(NATS_WRITE_TIMEOUT))
+		_, err := fmt.Fprintf(conn, format, args...)
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	info, err := r.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		return false, fmt.Errorf("unexpected greeting %q", strings.TrimSpace(info))
+	}
+
+	connect, err := json.Marshal(struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		Name     string `json:"name"`
+		Lang     string `json:"lang"`
+		Version  string `json:"version"`
+		User     string `json:"user,omitempty"`
+		Pass     string `json:"pass,omitempty"`
+		Token    string `json:"auth_token,omitempty"`
+	}{
+		Name:    "highscore",
+		Lang:    "go",
+		Version: "0",
+		User:    n.user,
+		Pass:    n.pass,
+		Token:   n.token,
+	})
+	if err != nil {
+		return false, err
+	}
+	// The PING makes the server report authentication errors before we
+	// start publishing.
+	if err := write("CONNECT %s\r\nPING\r\n", connect); err != nil {
+		return false, err
+	}
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	if strings.HasPrefix(reply, "-ERR") {
+		return false, fmt.Errorf("server refused connection: %s", strings.TrimSpace(reply))
+	}
+	log.Printf("Connected to NATS at %v\n", n.addr)
+
+	// Answer server PINGs from a reader goroutine; the server closes idle
+	// clients that don't.
+	errs := make(chan error, 1)
+	pongs := make(chan struct{}, 1)
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				errs <- err
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				select {
+				case pongs <- struct{}{}:
+				default:
+				}
+			case strings.HasPrefix(line, "-ERR"):
+				errs <- fmt.Errorf("server error: %s", strings.TrimSpace(line))
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			return true, err
+		case <-pongs:
+			if err := write("PONG\r\n"); err != nil {
+				return true, err
+			}
+		case event := <-n.queue:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			subject := n.subject + "." + event.Type
+			if err := write("PUB %s %d\r\n%s\r\n", subject, len(payload), payload); err != nil {
+				// The event is lost along with the connection.
+				return true, err
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NOTIFY_KINDS lists the notifier types -notify accepts.
+var NOTIFY_KINDS = []string{"webhook", "slack", "discord"}
+
+// A Notifier delivers board events to people rather than machines, e.g. a
+// chat channel. Unlike an EventSink, Notify may block and fail; the
+// dispatcher takes care of queueing, retries and rate limits.
+type Notifier interface {
+	Notify(event BoardEvent) error
+}
+
+// describeEvent renders an event as a one-line message.
+func describeEvent(eventName string, event BoardEvent) string {
+	switch event.Type {
+	case EVENT_SCORE:
+		return fmt.Sprintf("%s: %s placed #%d with %d health remaining in %.2fs.",
+			eventName, event.Score.PlayerName, event.Rank, event.Score.RemainingHealth, event.Score.Elapsed)
+	case EVENT_LEAD_CHANGE:
+		return fmt.Sprintf("%s: %s takes the lead from %s with %d health remaining in %.2fs.",
+			eventName, event.Score.PlayerName, event.Previous.PlayerName, event.Score.RemainingHealth, event.Score.Elapsed)
+	case EVENT_RESET:
+		return fmt.Sprintf("%s: the board was reset.", eventName)
+	case EVENT_TAMPER:
+		return fmt.Sprintf("%s: possible tampering: a client %s.", eventName, event.Detail)
+	case EVENT_REPORT:
+		return describeReport(eventName, event.Report)
+	case EVENT_BUMPED:
+		return fmt.Sprintf("%s: %s is out of qualifying position after %s placed #%d.",
+			eventName, event.Previous.PlayerName, event.Score.PlayerName, event.Rank)
+	}
+	return fmt.Sprintf("%s: %s", eventName, event.Type)
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(target string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(target, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
+
+// webhookNotifier POSTs the raw event as JSON.
+type webhookNotifier struct {
+	url string
+}
+
+func (n webhookNotifier) Notify(event BoardEvent) error {
+	return postJSON(n.url, event)
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct {
+	url       string
+	eventName string
+}
+
+func (n slackNotifier) Notify(event BoardEvent) error {
+	return postJSON(n.url, map[string]string{"text": describeEvent(n.eventName, event)})
+}
+
+// discordNotifier posts to a Discord channel webhook.
+type discordNotifier struct {
+	url       string
+	eventName string
+}
+
+func (n discordNotifier) Notify(event BoardEvent) error {
+	return postJSON(n.url, map[string]string{"content": describeEvent(n.eventName, event)})
+}
+
+// A notifyTarget is one configured notifier with its own filter, queue and
+// rate limit, so a slow or failing target doesn't hold up the others.
+type notifyTarget struct {
+	name     string
+	notifier Notifier
+	// event types to deliver; empty means all of them
+	events []string
+	// only deliver score events ranked this high or better; 0 means any
+	top      int
+	interval time.Duration
+	retries  int
+	queue    chan BoardEvent
+}
+
+// parseNotifyTarget parses a -notify value: a kind, a URL and optional
+// key=value settings, separated by spaces, e.g.
+//
+//	slack https://hooks.slack.com/services/... events=lead_change,reset rate=30s
+func parseNotifyTarget(spec string, eventName string) (*notifyTarget, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("notify target %q needs a kind and a URL", spec)
+	}
+	kind, rawURL := fields[0], fields[1]
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("notify target %q needs an http(s) URL", spec)
+	}
+	t := &notifyTarget{
+		// webhook URLs embed their credentials, so only log the host
+		name:     kind + " " + u.Host,
+		interval: 2 * time.Second,
+		retries:  3,
+		queue:    make(chan BoardEvent, 64),
+	}
+	switch kind {
+	case "webhook":
+		t.notifier = webhookNotifier{rawURL}
+	case "slack":
+		t.notifier = slackNotifier{rawURL, eventName}
+	case "discord":
+		t.notifier = discordNotifier{rawURL, eventName}
+	default:
+		return nil, fmt.Errorf("unknown notify kind %q (expected one of %v)", kind, strings.Join(NOTIFY_KINDS, ", "))
+	}
+
+	for _, option := range fields[2:] {
+		key, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return nil, fmt.Errorf("notify option %q is not key=value", option)
+		}
+		switch key {
+		case "events":
+			t.events = strings.Split(value, ",")
+			for _, e := range t.events {
+				if e != EVENT_SCORE && e != EVENT_LEAD_CHANGE && e != EVENT_RESET && e != EVENT_BUMPED && e != EVENT_TAMPER && e != EVENT_REPORT {
+					return nil, fmt.Errorf("unknown event type %q", e)
+				}
+			}
+		case "top":
+			t.top, err = strconv.Atoi(value)
+		case "rate":
+			t.interval, err = time.ParseDuration(value)
+		case "retries":
+			t.retries, err = strconv.Atoi(value)
+		default:
+			return nil, fmt.Errorf("unknown notify option %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("notify option %q: %w", option, err)
+		}
+	}
+	return t, nil
+}
+
+func (t *notifyTarget) wants(event BoardEvent) bool {
+	if len(t.events) > 0 && !slices.Contains(t.events, event.Type) {
+		return false
+	}
+	if t.top > 0 && event.Type == EVENT_SCORE && event.Rank > t.top {
+		return false
+	}
+	return true
+}
+
+// run delivers queued events no more often than the target's interval,
+// retrying failures with exponential backoff before giving up on an event.
+func (t *notifyTarget) run() {
+	for event := range t.queue {
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			err := t.notifier.Notify(event)
+			if err == nil {
+				break
+			}
+			if attempt >= t.retries {
+				log.Printf("Giving up notifying %v of %v event: %v\n", t.name, event.Type, err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		time.Sleep(t.interval)
+	}
+}
+
+// notifyDispatcher fans board events out to every notify target that wants
+// them. Events for a target whose queue is full are dropped.
+type notifyDispatcher struct {
+	targets []*notifyTarget
+}
+
+func newNotifyDispatcher(targets []*notifyTarget) *notifyDispatcher {
+	for _, t := range targets {
+		go t.run()
+	}
+	return &notifyDispatcher{targets: targets}
+}
+
+func (d *notifyDispatcher) Publish(event BoardEvent) {
+	for _, t := range d.targets {
+		if !t.wants(event) {
+			continue
+		}
+		select {
+		case t.queue <- event:
+		default:
+			log.Printf("Notify queue for %v full, dropping %v event\n", t.name, event.Type)
+		}
+	}
+}
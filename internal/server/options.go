@@ -0,0 +1,66 @@
+package server
+
+import (
+	"time"
+
+	"elevate2024/internal/store"
+)
+
+// A Validator gets the last word on a submitted score once the built-in
+// checks have passed; returning an error rejects it.
+type Validator func(score Score) error
+
+type options struct {
+	config     Config
+	key        []byte
+	board      *store.Board
+	now        func() time.Time
+	boardSize  int
+	validators []Validator
+	sinks      []EventSink
+}
+
+// An Option customizes a server built by NewHighScoreServer.
+type Option func(*options)
+
+// WithConfig replaces DefaultConfig, as the command line does with its flags.
+func WithConfig(config Config) Option {
+	return func(o *options) { o.config = config }
+}
+
+// WithKey signs start tokens with key instead of a random per-run one, so
+// tokens survive a restart or can be minted ahead of time in tests.
+func WithKey(key []byte) Option {
+	return func(o *options) { o.key = key }
+}
+
+// WithStore serves board instead of an empty one, e.g. one preloaded with
+// scores. Its Limit is set to the board size, and scores on it without a
+// difficulty are normalized as the default one.
+func WithStore(board *store.Board) Option {
+	return func(o *options) { o.board = board }
+}
+
+// WithClock replaces time.Now for minting tokens, checking elapsed times and
+// timestamping results.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// WithBoardSize sets how many scores the board keeps, in place of
+// DEFAULT_BOARD_SIZE.
+func WithBoardSize(n int) Option {
+	return func(o *options) { o.boardSize = n }
+}
+
+// WithValidators adds checks every submission must pass. They run in order
+// after the built-in ones.
+func WithValidators(validators ...Validator) Option {
+	return func(o *options) { o.validators = append(o.validators, validators...) }
+}
+
+// WithNotifier forwards board events to sink, alongside any brokers and
+// -notify targets from the config.
+func WithNotifier(sink EventSink) Option {
+	return func(o *options) { o.sinks = append(o.sinks, sink) }
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+
+	"elevate2024/internal/i18n"
+)
+
+// PAYLOAD_MODES are the accepted -payload-encryption settings.
+var PAYLOAD_MODES = []string{"off", "optional", "required"}
+
+// An EncryptedPayload is a score submission sealed to the server's public key
+// with a NaCl box from a one-off client key pair. It stops anyone on the path
+// from editing scores in flight when TLS ends before the server, but a
+// determined player can still encrypt whatever they like.
+type EncryptedPayload struct {
+	EphemeralKey string `json:"ephemeral_key"`
+	Nonce        string `json:"nonce"`
+	Box          string `json:"box"`
+}
+
+type payloadKeys struct {
+	mode    string
+	public  *[32]byte
+	private *[32]byte
+}
+
+// loadPayloadKeys reads a base64 private key from path, or generates a key
+// pair for this run if path is empty. Clients fetch the public key from
+// /payload-key, so a generated key only needs pinning in native builds.
+func loadPayloadKeys(mode string, path string) (*payloadKeys, error) {
+	switch mode {
+	case "off":
+		return nil, nil
+	case "optional", "required":
+	default:
+		return nil, fmt.Errorf("unknown payload encryption mode %q (supported: %s)", mode, strings.Join(PAYLOAD_MODES, ", "))
+	}
+
+	if path == "" {
+		public, private, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &payloadKeys{mode: mode, public: public, private: private}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("%v: expected a base64 32-byte private key", path)
+	}
+	public, err := curve25519.X25519(raw, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadKeys{mode: mode, public: (*[32]byte)(public), private: (*[32]byte)(raw)}, nil
+}
+
+func decodeKey(s string, size int) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != size {
+		return nil, fmt.Errorf("expected %d base64 bytes", size)
+	}
+	return b, nil
+}
+
+// open returns the plaintext submission in body. Plain submissions pass
+// through unless encryption is required.
+func (k *payloadKeys) open(body []byte) ([]byte, error) {
+	if k == nil {
+		return body, nil
+	}
+	var payload EncryptedPayload
+	if json.Unmarshal(body, &payload) != nil || payload.Box == "" {
+		if k.mode == "required" {
+			return nil, i18n.Errorf("submission must be encrypted")
+		}
+		return body, nil
+	}
+
+	key, err := decodeKey(payload.EphemeralKey, 32)
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral_key: %w", err)
+	}
+	nonce, err := decodeKey(payload.Nonce, 24)
+	if err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload.Box)
+	if err != nil {
+		return nil, fmt.Errorf("box: %w", err)
+	}
+	plain, ok := box.Open(nil, sealed, (*[24]byte)(nonce), (*[32]byte)(key), k.private)
+	if !ok {
+		return nil, i18n.Errorf("submission could not be decrypted")
+	}
+	return plain, nil
+}
+
+func (s *HighScoreServer) payloadKey(w http.ResponseWriter, r *http.Request) {
+	if s.payload == nil {
+		http.Error(w, "payload encryption is off", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		PublicKey string `json:"public_key"`
+		Required  bool   `json:"required"`
+	}{
+		PublicKey: base64.StdEncoding.EncodeToString(s.payload.public[:]),
+		Required:  s.payload.mode == "required",
+	})
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// ANONYMIZED_NAME replaces a player's initials where a record can't simply be
+// removed without losing context for other players.
+const ANONYMIZED_NAME = "???"
+
+type DeletionReport struct {
+	Scores       int `json:"scores"`
+	Quarantined  int `json:"quarantined"`
+	Results      int `json:"results"`
+	FeedEntries  int `json:"feed_entries"`
+	FeedLeaders  int `json:"feed_leaders_anonymized"`
+	AuditEntries int `json:"audit_entries_anonymized"`
+	// tombstones left for federated servers to erase their copies with
+	Federated int `json:"federated"`
+}
+
+// anonymizeAudit scrubs a player's name from the scores captured in an audit
+// entry's before/after values, reporting whether anything changed.
+func anonymizeAudit(v any, name string) (any, bool) {
+	switch v := v.(type) {
+	case Score:
+		if v.PlayerName == name {
+			v.PlayerName = ANONYMIZED_NAME
+			return v, true
+		}
+	case []Score:
+		changed := false
+		scores := slices.Clone(v)
+		for i := range scores {
+			if scores[i].PlayerName == name {
+				scores[i].PlayerName = ANONYMIZED_NAME
+				changed = true
+			}
+		}
+		return scores, changed
+	}
+	return v, false
+}
+
+// deletePlayer erases everything stored about a set of initials: board
+// entries and results are removed, while feed and audit history that also
+// concerns other players is kept with the name anonymized.
+func (s *HighScoreServer) deletePlayer(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !s.requireTOTP(w, r) {
+		return
+	}
+
+	name := r.PathValue("name")
+	matches := func(score Score) bool { return score.PlayerName == name }
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_DELETE_PLAYER, Name: name, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	report := result.Erased
+
+	report.Federated = s.federation.erase(matches)
+
+	report.AuditEntries = s.audit.anonymize(name)
+
+	// The deletion itself is audited, but without the name being erased.
+	s.audit.record(s.clientIP(r), "delete-player", "", nil, report)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
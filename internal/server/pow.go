@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"elevate2024/internal/i18n"
+)
+
+// MAX_WORK is the hardest proof of work that may be asked for; each bit
+// doubles how long a client takes.
+const MAX_WORK = 32
+
+// errWorkRequired is returned for a start without a proof of work when one is
+// required; the client should fetch /start/challenge, solve it and try again.
+var errWorkRequired = i18n.Errorf("proof of work required")
+
+// checkWork makes sure a proof of work is at most MAX_WORK bits.
+func checkWork(bits int) error {
+	if bits < 0 || bits > MAX_WORK {
+		return fmt.Errorf("proof of work must be 0 to %v bits, got %v", MAX_WORK, bits)
+	}
+	return nil
+}
+
+// spentChallenges remembers the solved challenges that have been redeemed,
+// until they expire, so each buys only one token.
+type spentChallenges struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+// spend marks a challenge redeemed, reporting false if it already was.
+func (c *spentChallenges) spend(challenge string, expires time.Time, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.expires == nil {
+		c.expires = map[string]time.Time{}
+	}
+	for spent, at := range c.expires {
+		if now.After(at) {
+			delete(c.expires, spent)
+		}
+	}
+	if _, ok := c.expires[challenge]; ok {
+		return false
+	}
+	c.expires[challenge] = expires
+	return true
+}
+
+// getChallenge serves GET /start/challenge: a proof of work the client must
+// solve before /start gives it a token.
+func (s *HighScoreServer) getChallenge(w http.ResponseWriter, r *http.Request) {
+	if s.work == 0 {
+		http.Error(w, "proof of work is off", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.tokens.Challenge(s.now(), s.work, s.fingerprint(r)))
+}
+
+// checkProofOfWork redeems the solved challenge a start request carries as
+// ?challenge=&nonce=, if proof of work is on, returning its difficulty.
+func (s *HighScoreServer) checkProofOfWork(r *http.Request) (int, error) {
+	if s.work == 0 {
+		return 0, nil
+	}
+	query := r.URL.Query()
+	if query.Get("challenge") == "" {
+		return 0, errWorkRequired
+	}
+	now := s.now()
+	challenge, err := s.tokens.CheckWork(query.Get("challenge"), query.Get("nonce"), s.fingerprint(r), now)
+	if err != nil {
+		return 0, err
+	}
+	if challenge.Difficulty < s.work {
+		return 0, errors.New("challenge: too easy")
+	}
+	if !s.challenges.spend(challenge.Challenge, time.UnixMilli(challenge.ExpiresMs), now) {
+		return 0, errors.New("challenge: already redeemed")
+	}
+	return challenge.Difficulty, nil
+}
+
+// startStatus is the status to answer a failed start with.
+func startStatus(err error) int {
+	if errors.Is(err, errWorkRequired) {
+		return http.StatusPreconditionRequired
+	}
+	return http.StatusBadRequest
+}
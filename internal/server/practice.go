@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"elevate2024/internal/i18n"
+	"elevate2024/internal/store"
+)
+
+// Run modes, chosen by the game when it starts a run. A practice run only
+// counts on the practice board, which is wiped every hour, so warming up
+// never clutters the competition board. A token without a mode is a
+// competition run.
+const (
+	MODE_COMPETITION = "competition"
+	MODE_PRACTICE    = "practice"
+)
+
+var RUN_MODES = []string{MODE_COMPETITION, MODE_PRACTICE}
+
+// PRACTICE_BOARD_SIZE is how many practice scores are kept within the hour.
+const PRACTICE_BOARD_SIZE = 100
+
+type practiceBoard struct {
+	mutex sync.Mutex
+	// limited to PRACTICE_BOARD_SIZE
+	board store.Board
+	// the start of the hour the board holds scores from
+	hour time.Time
+}
+
+// current returns the board for the hour now falls in, clearing it first if
+// it holds an earlier hour's scores. The caller holds the lock.
+func (p *practiceBoard) current(now time.Time) *store.Board {
+	if hour := now.Truncate(time.Hour); !hour.Equal(p.hour) {
+		p.board.Reset()
+		p.hour = hour
+	}
+	return &p.board
+}
+
+// checkMode rejects a run mode the server doesn't know.
+func checkMode(mode string) error {
+	if mode != "" && !slices.Contains(RUN_MODES, mode) {
+		return i18n.Errorf("unknown mode %q", mode)
+	}
+	return nil
+}
+
+// practiceScore puts a practice run on the practice board, answering with
+// its rank there. It gets no ID or receipt, as it is gone within the hour.
+func (s *HighScoreServer) practiceScore(w http.ResponseWriter, score Score) {
+	score.Token = Token{}
+	score.Submitted = s.submittedAt()
+	s.normalize(&score)
+
+	s.practice.mutex.Lock()
+	board := s.practice.current(s.now())
+	rank := board.Rank(score)
+	board.Add(score)
+	s.practice.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Submitted{Status: MODE_PRACTICE, Rank: rank})
+}
+
+// getPractice serves GET /practice: this hour's practice board, and when it
+// will be cleared.
+func (s *HighScoreServer) getPractice(w http.ResponseWriter, r *http.Request) {
+	s.practice.mutex.Lock()
+	scores := s.practice.current(s.now()).Scores()
+	clears := s.practice.hour.Add(time.Hour)
+	s.practice.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(struct {
+		Scores []Score   `json:"scores"`
+		Clears time.Time `json:"clears"`
+	}{scores, clears})
+}
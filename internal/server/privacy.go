@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// IP_MODES lists how client addresses may be stored: as-is, as a salted hash
+// that still tells clients apart, or truncated to the surrounding network.
+var IP_MODES = []string{"raw", "hash", "truncate"}
+
+type ipMasker struct {
+	mode string
+	salt []byte
+}
+
+func newIPMasker(mode string, salt []byte) (ipMasker, error) {
+	switch mode {
+	case "raw", "hash", "truncate":
+		return ipMasker{mode: mode, salt: salt}, nil
+	}
+	return ipMasker{}, fmt.Errorf("unknown IP mode %q (supported: %v)", mode, IP_MODES)
+}
+
+// mask converts an address into the form it may be stored in. Truncation
+// keeps the /24 for IPv4 and the /48 for IPv6, which is usually enough to spot
+// a single venue network or household submitting in bulk.
+func (m ipMasker) mask(ip string) string {
+	switch m.mode {
+	case "hash":
+		mac := hmac.New(sha256.New, m.salt)
+		mac.Write([]byte(ip))
+		return "h:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	case "truncate":
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return ""
+		}
+		bits := 48
+		if addr.Unmap().Is4() {
+			addr, bits = addr.Unmap(), 24
+		}
+		prefix, _ := addr.Prefix(bits)
+		return prefix.String()
+	}
+	return ip
+}
+
+// clientIP returns the request's remote address in its storable form.
+func (s *HighScoreServer) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return s.ips.mask(ip)
+}
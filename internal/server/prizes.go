@@ -0,0 +1,196 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"elevate2024/internal/i18n"
+)
+
+// errEventClosed turns submissions away once the winners are locked in.
+var errEventClosed = i18n.Errorf("the event is closed")
+
+var (
+	errPrizesLocked = errors.New("the prizes are already locked")
+	errNoCommitment = errors.New("commit to a seed with /admin/draw/commit first")
+)
+
+// A PrizeTier awards a prize to the players ranked First to Last, or, if
+// Draw is set, to that many of them drawn at random. Players are ranked by
+// their best score, and each wins at most one prize: the first tier that
+// picks them.
+type PrizeTier struct {
+	Name  string `json:"name"`
+	First int    `json:"first"`
+	Last  int    `json:"last"`
+	Draw  int    `json:"draw,omitempty"`
+}
+
+// parsePrizeTier reads a tier from "NAME=FIRST[-LAST][,draw=N]", e.g.
+// "Gold=1", "Podium=2-3" or "Raffle=1-50,draw=5".
+func parsePrizeTier(spec string) (PrizeTier, error) {
+	name, rest, ok := strings.Cut(spec, "=")
+	tier := PrizeTier{Name: strings.TrimSpace(name)}
+	if !ok || tier.Name == "" {
+		return tier, fmt.Errorf("prize %q is not NAME=FIRST[-LAST][,draw=N]", spec)
+	}
+	ranks, draw, hasDraw := strings.Cut(rest, ",")
+	first, last, isRange := strings.Cut(ranks, "-")
+	var err error
+	if tier.First, err = strconv.Atoi(first); err != nil || tier.First < 1 {
+		return tier, fmt.Errorf("prize %q needs a positive first rank", spec)
+	}
+	tier.Last = tier.First
+	if isRange {
+		if tier.Last, err = strconv.Atoi(last); err != nil || tier.Last < tier.First {
+			return tier, fmt.Errorf("prize %q needs a last rank no better than its first", spec)
+		}
+	}
+	if hasDraw {
+		n, ok := strings.CutPrefix(draw, "draw=")
+		if tier.Draw, err = strconv.Atoi(n); !ok || err != nil || tier.Draw < 1 {
+			return tier, fmt.Errorf("prize %q needs draw=N with a positive N", spec)
+		}
+	}
+	return tier, nil
+}
+
+// A PrizeWinner is a player awarded a tier's prize, with the score that
+// earned it and the rank it had when the board was locked.
+type PrizeWinner struct {
+	Tier  string `json:"tier"`
+	Rank  int    `json:"rank"`
+	Score Score  `json:"score"`
+}
+
+// A LockedResult is the outcome of the event, fixed when an admin locks the
+// board. The seed is the one committed to on /draws before the board was
+// locked, so anyone can check it against the commitment and the draws by
+// running drawWinners on the standings with it. The hash is recorded in the
+// audit log, so a result changed afterwards is caught.
+type LockedResult struct {
+	Locked     time.Time     `json:"locked"`
+	Tiers      []PrizeTier   `json:"tiers"`
+	Commitment string        `json:"commitment"`
+	Committed  time.Time     `json:"committed"`
+	Seed       string        `json:"seed"`
+	Standings  []Score       `json:"standings"`
+	Winners    []PrizeWinner `json:"winners"`
+	Hash       string        `json:"hash"`
+}
+
+// hash covers everything in the result but the hash itself.
+func (l LockedResult) hash() string {
+	l.Hash = ""
+	b, _ := json.Marshal(l)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+type prizeState struct {
+	tiers []PrizeTier
+
+	mutex  sync.Mutex
+	result *LockedResult
+}
+
+// locked reports whether the winners have been locked in.
+func (p *prizeState) locked() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.result != nil
+}
+
+// seededRandom returns the random numbers draws are made with: a ChaCha8
+// stream keyed with the SHA-256 of the seed, so anyone with the seed can
+// make the same draw.
+func seededRandom(seed string) *mathrand.Rand {
+	return mathrand.New(mathrand.NewChaCha8(sha256.Sum256([]byte(seed))))
+}
+
+// drawWinners awards each tier in turn from standings, each player's best
+// score best first. Draws pick from the tier's ranks that haven't won yet,
+// shuffled by seededRandom.
+func drawWinners(tiers []PrizeTier, standings []Score, seed string) []PrizeWinner {
+	random := seededRandom(seed)
+	won := map[string]bool{}
+	winners := []PrizeWinner{}
+	for _, tier := range tiers {
+		var eligible []int
+		for rank := tier.First; rank <= min(tier.Last, len(standings)); rank++ {
+			if !won[standings[rank-1].PlayerName] {
+				eligible = append(eligible, rank)
+			}
+		}
+		if tier.Draw > 0 {
+			random.Shuffle(len(eligible), func(i, j int) { eligible[i], eligible[j] = eligible[j], eligible[i] })
+			eligible = eligible[:min(tier.Draw, len(eligible))]
+		}
+		for _, rank := range eligible {
+			score := standings[rank-1]
+			won[score.PlayerName] = true
+			winners = append(winners, PrizeWinner{Tier: tier.Name, Rank: rank, Score: score})
+		}
+	}
+	return winners
+}
+
+// getPrizes serves GET /prizes: the tiers, and the locked result once there
+// is one.
+func (s *HighScoreServer) getPrizes(w http.ResponseWriter, r *http.Request) {
+	s.prizes.mutex.Lock()
+	result := s.prizes.result
+	s.prizes.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(struct {
+		Tiers  []PrizeTier   `json:"tiers"`
+		Result *LockedResult `json:"result,omitempty"`
+	}{s.prizes.tiers, result})
+}
+
+// lockPrizes serves POST /admin/prizes/lock: it closes the event to
+// submissions and awards the prizes from the board as it stands, once and
+// for all, drawing with the seed committed to with /admin/draw/commit. The
+// seed has to be committed to first, so nobody can pick one that favors
+// whoever they like once the standings are known.
+func (s *HighScoreServer) lockPrizes(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !s.requireTOTP(w, r) {
+		return
+	}
+	if len(s.prizes.tiers) == 0 {
+		http.Error(w, "no prizes configured", http.StatusNotFound)
+		return
+	}
+
+	applied, err := s.apply(r.Context(), boardCommand{Op: COMMAND_LOCK, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if applied.Refused != nil {
+		http.Error(w, applied.Refused.Error(), http.StatusConflict)
+		return
+	}
+	result := applied.Locked
+
+	s.audit.record(s.clientIP(r), "lock", "", nil, result.Hash)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
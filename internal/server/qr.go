@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+func (s *HighScoreServer) qrCode(w http.ResponseWriter, r *http.Request) {
+	size, err := intParam(r, "size", 256, 2048)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(s.publicURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Pre-fill the board/event so the scanned link lands in the right place.
+	q := target.Query()
+	for _, key := range []string{"board", "event"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	target.RawQuery = q.Encode()
+
+	png, err := qrcode.Encode(target.String(), qrcode.Medium, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(png)
+}
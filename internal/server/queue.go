@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SUBMIT_QUEUE is how many submissions may wait for a worker before /record
+// starts turning them away, unless Config.SubmitQueue says otherwise.
+const SUBMIT_QUEUE = 256
+
+var errQueueFull = errors.New("submission queue is full")
+
+// A submitQueue bounds the work /record does at once. Requests still get a
+// goroutine each from net/http, but only the workers verify, decode and
+// insert; the rest wait in the queue or are turned away.
+type submitQueue struct {
+	jobs    chan func()
+	workers int
+
+	waiting   atomic.Int64
+	maxDepth  atomic.Int64
+	processed atomic.Int64
+	rejected  atomic.Int64
+
+	recent recentSubmissions
+}
+
+// QUEUE_WINDOW is how far back /queue reports on submissions.
+const QUEUE_WINDOW = time.Minute
+
+// recentSubmissions counts the submissions processed in each of the last
+// QUEUE_WINDOW's seconds, and how long they took from arriving to being
+// answered.
+type recentSubmissions struct {
+	mutex   sync.Mutex
+	buckets [int(QUEUE_WINDOW / time.Second)]struct {
+		second  int64
+		count   int64
+		latency time.Duration
+	}
+}
+
+func (r *recentSubmissions) add(now time.Time, latency time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	second := now.Unix()
+	b := &r.buckets[second%int64(len(r.buckets))]
+	if b.second != second {
+		b.second, b.count, b.latency = second, 0, 0
+	}
+	b.count++
+	b.latency += latency
+}
+
+// window returns how many submissions were processed in the QUEUE_WINDOW
+// before now, and their total latency.
+func (r *recentSubmissions) window(now time.Time) (int64, time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var count int64
+	var latency time.Duration
+	for _, b := range r.buckets {
+		if now.Unix()-b.second < int64(len(r.buckets)) {
+			count += b.count
+			latency += b.latency
+		}
+	}
+	return count, latency
+}
+
+func newSubmitQueue(workers int, capacity int) *submitQueue {
+	return &submitQueue{jobs: make(chan func(), capacity), workers: workers}
+}
+
+func (q *submitQueue) start() {
+	for range q.workers {
+		go func() {
+			for job := range q.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// do runs job on a worker and waits for it, or returns errQueueFull straight
+// away if too many submissions are already waiting.
+func (q *submitQueue) do(job func()) error {
+	done := make(chan struct{})
+	arrived := time.Now()
+	depth := q.waiting.Add(1)
+	select {
+	case q.jobs <- func() {
+		defer close(done)
+		q.waiting.Add(-1)
+		job()
+		q.processed.Add(1)
+		now := time.Now()
+		q.recent.add(now, now.Sub(arrived))
+	}:
+	default:
+		q.waiting.Add(-1)
+		q.rejected.Add(1)
+		return errQueueFull
+	}
+	for {
+		max := q.maxDepth.Load()
+		if depth <= max || q.maxDepth.CompareAndSwap(max, depth) {
+			break
+		}
+	}
+	<-done
+	return nil
+}
+
+type QueueStats struct {
+	Workers   int   `json:"workers"`
+	Capacity  int   `json:"capacity"`
+	Depth     int64 `json:"depth"`
+	MaxDepth  int64 `json:"max_depth"`
+	Processed int64 `json:"processed"`
+	Rejected  int64 `json:"rejected"`
+}
+
+func (q *submitQueue) stats() QueueStats {
+	return QueueStats{
+		Workers:   q.workers,
+		Capacity:  cap(q.jobs),
+		Depth:     q.waiting.Load(),
+		MaxDepth:  q.maxDepth.Load(),
+		Processed: q.processed.Load(),
+		Rejected:  q.rejected.Load(),
+	}
+}
+
+func (s *HighScoreServer) queueStats(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(s.submissions.stats())
+}
+
+// A QueueActivity is the answer to GET /queue, telling players at a busy booth
+// that submissions are getting through even while the board catches up.
+type QueueActivity struct {
+	// submissions waiting for a worker now
+	Waiting int64 `json:"waiting"`
+	// submissions processed in the last QUEUE_WINDOW, and how long they
+	// took on average from arriving to being answered
+	ProcessedLastMinute int64   `json:"processed_last_minute"`
+	AverageLatencyMs    float64 `json:"average_latency_ms"`
+}
+
+func (q *submitQueue) activity(now time.Time) QueueActivity {
+	count, latency := q.recent.window(now)
+	activity := QueueActivity{Waiting: q.waiting.Load(), ProcessedLastMinute: count}
+	if count > 0 {
+		activity.AverageLatencyMs = float64(latency.Microseconds()) / 1000 / float64(count)
+	}
+	return activity
+}
+
+// queueActivity serves GET /queue.
+func (s *HighScoreServer) queueActivity(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(s.submissions.activity(time.Now()))
+}
+
+// writeBusy tells a client its submission was turned away and when to retry.
+func writeBusy(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{"queue_full"})
+}
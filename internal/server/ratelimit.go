@@ -0,0 +1,72 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a per-key token bucket: each key may make burst requests at
+// once, refilled at rate per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}}
+}
+
+// forget drops the buckets of keys last seen before cutoff, returning how
+// many there were. A nil limiter has none.
+func (l *rateLimiter) forget(cutoff time.Time) int {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	forgotten := 0
+	for key, b := range l.buckets {
+		if b.last.Before(cutoff) {
+			delete(l.buckets, key)
+			forgotten++
+		}
+	}
+	return forgotten
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		// Full buckets carry no state, so forget them rather than letting the
+		// map grow with every client ever seen.
+		if len(l.buckets) > 10000 {
+			for k, b := range l.buckets {
+				if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// REACTIONS are the emoji spectators can send with POST /react.
+var REACTIONS = []string{"👏", "🔥", "😱", "🎉", "🐛"}
+
+// ReactionCounts aggregates spectator reactions, overall and per score on the
+// board.
+type ReactionCounts struct {
+	Total  map[string]int            `json:"total"`
+	Scores map[string]map[string]int `json:"scores"`
+}
+
+type reactionTally struct {
+	mutex   sync.Mutex
+	counts  ReactionCounts
+	changed bool
+	limiter *rateLimiter
+}
+
+func (t *reactionTally) add(emoji string, scoreID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.counts.Total == nil {
+		t.counts.Total = map[string]int{}
+		t.counts.Scores = map[string]map[string]int{}
+	}
+	t.counts.Total[emoji]++
+	if scoreID != "" {
+		if t.counts.Scores[scoreID] == nil {
+			t.counts.Scores[scoreID] = map[string]int{}
+		}
+		t.counts.Scores[scoreID][emoji]++
+	}
+	t.changed = true
+}
+
+// snapshot returns a copy of the counts if they changed since the last call.
+func (t *reactionTally) snapshot() (ReactionCounts, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.changed {
+		return ReactionCounts{}, false
+	}
+	t.changed = false
+	return t.copy(), true
+}
+
+// current returns a copy of the counts, for clients that just connected.
+func (t *reactionTally) current() ReactionCounts {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.copy()
+}
+
+func (t *reactionTally) copy() ReactionCounts {
+	counts := ReactionCounts{
+		Total:  map[string]int{},
+		Scores: map[string]map[string]int{},
+	}
+	maps.Copy(counts.Total, t.counts.Total)
+	for id, c := range t.counts.Scores {
+		counts.Scores[id] = maps.Clone(c)
+	}
+	return counts
+}
+
+func (t *reactionTally) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.counts = ReactionCounts{}
+	t.changed = true
+}
+
+// react records a spectator's reaction, optionally to a particular score on
+// the board. Each client gets a small burst and then one reaction a second.
+func (s *HighScoreServer) react(w http.ResponseWriter, r *http.Request) {
+	var reaction struct {
+		Emoji   string `json:"emoji"`
+		ScoreID string `json:"score_id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&reaction); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(REACTIONS, reaction.Emoji) {
+		http.Error(w, "unsupported reaction", http.StatusBadRequest)
+		return
+	}
+	if reaction.ScoreID != "" {
+		scores, err := s.topScores(r.Context(), s.boardSize)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		onBoard := slices.ContainsFunc(scores, func(score Score) bool {
+			return score.ID == reaction.ScoreID
+		})
+		if !onBoard {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if !s.reactions.limiter.allow(s.clientIP(r)) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	s.reactions.add(reaction.Emoji, reaction.ScoreID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// broadcastReactions sends the counts to /events subscribers as a named
+// "reactions" event whenever they change, at most once per interval. They
+// skip the event sinks, which have no use for every cheer.
+func (s *HighScoreServer) broadcastReactions(interval time.Duration) {
+	for range time.Tick(interval) {
+		counts, changed := s.reactions.snapshot()
+		if !changed {
+			continue
+		}
+		s.live.Publish(BoardEvent{Type: EVENT_REACTIONS, Time: s.now(), Reactions: &counts})
+	}
+}
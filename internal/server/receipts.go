@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Receipt states, saying what has become of the score a receipt is for.
+const (
+	RECEIPT_ON_BOARD  = "on_board"  // still on the board
+	RECEIPT_OFF_BOARD = "off_board" // recorded, but pushed off the board
+	RECEIPT_DELETED   = "deleted"   // removed by an admin
+	RECEIPT_UNKNOWN   = "unknown"   // purged, or never recorded here
+)
+
+// A ReceiptCheck is the answer to GET /receipts/{id}/verify: whether the
+// server issued the receipt, and what has happened to its score since.
+type ReceiptCheck struct {
+	Valid   bool    `json:"valid"`
+	Receipt Receipt `json:"receipt"`
+	Status  string  `json:"status,omitempty"`
+	// the score's rank now, while it is on the board
+	Rank int `json:"rank,omitempty"`
+}
+
+// verifyReceipt serves GET /receipts/{id}/verify?rank=&submitted_ms=&signature=
+// with the fields of a receipt from /record. A valid receipt proves what the
+// server acknowledged, whatever has happened to the score since, so it is
+// checked on its signature alone. Receipts are signed with the token key, so
+// they only verify for as long as tokens do.
+func (s *HighScoreServer) verifyReceipt(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	receipt := Receipt{ID: r.PathValue("id"), Signature: query.Get("signature")}
+	var err error
+	if receipt.Rank, err = strconv.Atoi(query.Get("rank")); err != nil {
+		http.Error(w, "invalid rank", http.StatusBadRequest)
+		return
+	}
+	if receipt.SubmittedMs, err = strconv.ParseInt(query.Get("submitted_ms"), 10, 64); err != nil {
+		http.Error(w, "invalid submitted_ms", http.StatusBadRequest)
+		return
+	}
+
+	check := ReceiptCheck{Receipt: receipt, Valid: s.tokens.CheckReceipt(receipt) == nil}
+	if check.Valid {
+		check.Status, check.Rank, err = s.receiptStatus(r.Context(), receipt.ID)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(check)
+}
+
+// receiptStatus says what has become of the score with the given ID, and its
+// rank if it is on the board.
+func (s *HighScoreServer) receiptStatus(ctx context.Context, id string) (string, int, error) {
+	if err := s.lockBoard(ctx, "lookup"); err != nil {
+		return "", 0, err
+	}
+	defer s.mutex.Unlock()
+
+	if i := slices.IndexFunc(s.board.Scores(), func(score Score) bool { return score.ID == id }); i >= 0 {
+		return RECEIPT_ON_BOARD, i + 1, nil
+	}
+	result, ok := s.results[id]
+	switch {
+	case !ok:
+		return RECEIPT_UNKNOWN, 0, nil
+	case !result.Deleted.IsZero():
+		return RECEIPT_DELETED, 0, nil
+	}
+	return RECEIPT_OFF_BOARD, 0, nil
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"elevate2024/internal/store"
+)
+
+// REPORT_STANDINGS is how many of the board's top scores a report lists, and
+// REPORTS_KEPT how many reports are kept to be downloaded again.
+const (
+	REPORT_STANDINGS = 10
+	REPORTS_KEPT     = 14
+)
+
+// A Report summarizes a day of the event for the organizers: the standings
+// at its end, the day's activity, and anything that needed moderating.
+type Report struct {
+	// names the report's files, e.g. 2024-06-01T0700.html
+	ID   string    `json:"id"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// the runs accepted during the day, and the players behind them
+	Submissions int    `json:"submissions"`
+	Players     int    `json:"players"`
+	Best        *Score `json:"best,omitempty"`
+	// the top of the board when the report was made
+	Standings []Score         `json:"standings"`
+	Anomalies ReportAnomalies `json:"anomalies"`
+	// where the full report can be read, if the public URL is known
+	URL string `json:"url,omitempty"`
+}
+
+// ReportAnomalies counts what a moderator may want to look at.
+type ReportAnomalies struct {
+	// scores waiting in quarantine, and clients flagged by the honeypot
+	Held    int `json:"held"`
+	Flagged int `json:"flagged"`
+	// admin actions during the day
+	Deleted  int `json:"deleted"`
+	Rejected int `json:"rejected"`
+	// disputes still waiting for an answer
+	Disputes int `json:"disputes"`
+}
+
+type reportArchive struct {
+	mutex sync.Mutex
+	// the time of day reports are made, if they are
+	daily   bool
+	at      time.Duration
+	reports []Report
+}
+
+// parseReportAt reads the time of day for -report-at, e.g. "07:00".
+func parseReportAt(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid report time %q (use HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// nextReport returns the first time after now that is at into a day.
+func nextReport(now time.Time, at time.Duration) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(at)
+	if !next.After(now) {
+		next = midnight.AddDate(0, 0, 1).Add(at)
+	}
+	return next
+}
+
+// scheduleReports makes and delivers a report for the last day every day at
+// the configured time, forever.
+func (s *HighScoreServer) scheduleReports() {
+	for {
+		next := nextReport(time.Now(), s.reports.at)
+		time.Sleep(time.Until(next))
+		s.deliverReport(next)
+	}
+}
+
+// deliverReport makes the report for the day up to to, keeps it and sends it
+// to the notifiers as a "report" event.
+func (s *HighScoreServer) deliverReport(to time.Time) Report {
+	report := s.report(to.AddDate(0, 0, -1), to)
+
+	s.reports.mutex.Lock()
+	s.reports.reports = append(s.reports.reports, report)
+	if len(s.reports.reports) > REPORTS_KEPT {
+		s.reports.reports = slices.Delete(s.reports.reports, 0, len(s.reports.reports)-REPORTS_KEPT)
+	}
+	s.reports.mutex.Unlock()
+
+	s.emit(BoardEvent{Type: EVENT_REPORT, Time: to, Report: &report})
+	return report
+}
+
+// report summarizes the day from from to to.
+func (s *HighScoreServer) report(from time.Time, to time.Time) Report {
+	report := Report{ID: to.Format("2006-01-02T1504"), From: from, To: to}
+	if s.publicURL != "" {
+		report.URL = strings.TrimSuffix(s.publicURL, "/") + "/admin/reports/" + report.ID + ".html"
+	}
+
+	s.mutex.Lock()
+	players := map[string]bool{}
+	for _, result := range s.results {
+		if result.Shadow || result.Submitted.Before(from) || !result.Submitted.Before(to) {
+			continue
+		}
+		report.Submissions++
+		players[result.Score.PlayerName] = true
+		if report.Best == nil || store.Cmp(result.Score, *report.Best) < 0 {
+			best := result.Score
+			report.Best = &best
+		}
+	}
+	scores := s.board.Scores()
+	report.Standings = slices.Clone(scores[:min(REPORT_STANDINGS, len(scores))])
+	s.mutex.Unlock()
+	report.Players = len(players)
+
+	s.quarantine.mutex.Lock()
+	report.Anomalies.Held = len(s.quarantine.entries)
+	s.quarantine.mutex.Unlock()
+	s.honeypot.mutex.Lock()
+	report.Anomalies.Flagged = len(s.honeypot.flagged)
+	s.honeypot.mutex.Unlock()
+	s.audit.mutex.Lock()
+	for _, entry := range s.audit.entries {
+		if entry.Time.Before(from) || !entry.Time.Before(to) {
+			continue
+		}
+		switch entry.Action {
+		case "delete":
+			report.Anomalies.Deleted++
+		case "quarantine-reject":
+			report.Anomalies.Rejected++
+		}
+	}
+	s.audit.mutex.Unlock()
+	s.disputes.mutex.Lock()
+	for _, dispute := range s.disputes.entries {
+		if !dispute.resolved() {
+			report.Anomalies.Disputes++
+		}
+	}
+	s.disputes.mutex.Unlock()
+	return report
+}
+
+// describeReport renders a report as a short message for chat notifiers.
+func describeReport(eventName string, report *Report) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s daily report: %d runs by %d players since %s.",
+		eventName, report.Submissions, report.Players, report.From.Format("Jan 2 15:04"))
+	if report.Best != nil {
+		fmt.Fprintf(&b, " Best of the day: %s (%d health remaining in %.2fs).",
+			report.Best.PlayerName, report.Best.RemainingHealth, report.Best.Elapsed)
+	}
+	if len(report.Standings) > 0 {
+		fmt.Fprintf(&b, " Leading: %s.", report.Standings[0].PlayerName)
+	}
+	a := report.Anomalies
+	fmt.Fprintf(&b, " Held for review: %d, flagged clients: %d, deleted: %d, rejected: %d, open disputes: %d.",
+		a.Held, a.Flagged, a.Deleted, a.Rejected, a.Disputes)
+	if report.URL != "" {
+		fmt.Fprintf(&b, " Full report: %s", report.URL)
+	}
+	return b.String()
+}
+
+var reportPage = template.Must(template.New("report").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <title>{{.Event}} daily report</title>
+    <style>
+      body {
+        font-family: sans-serif;
+        max-width: 40em;
+        margin: 2em auto;
+      }
+      td,
+      th {
+        padding: 0.2em 1em;
+        text-align: left;
+      }
+    </style>
+  </head>
+  <body>
+    <h1>{{.Event}} daily report</h1>
+    <p>{{.From.Format "Jan 2 15:04"}} to {{.To.Format "Jan 2 15:04 MST"}}</p>
+    <h2>The day</h2>
+    <p>{{.Submissions}} runs by {{.Players}} players.</p>
+    {{with .Best}}<p>Best of the day: {{.PlayerName}}, {{.RemainingHealth}} health remaining in {{printf "%.2f" .Elapsed}}s.</p>{{end}}
+    <h2>Standings</h2>
+    <table>
+      <tr><th>#</th><th>Player</th><th>Health left</th><th>Time</th></tr>
+      {{range $i, $score := .Standings}}<tr><td>{{inc $i}}</td><td>{{$score.PlayerName}}</td><td>{{$score.RemainingHealth}}</td><td>{{printf "%.2f" $score.Elapsed}}s</td></tr>
+      {{end}}
+    </table>
+    <h2>Moderation</h2>
+    <ul>
+      <li>Held for review: {{.Anomalies.Held}}</li>
+      <li>Clients flagged by the honeypot: {{.Anomalies.Flagged}}</li>
+      <li>Scores deleted: {{.Anomalies.Deleted}}</li>
+      <li>Quarantined scores rejected: {{.Anomalies.Rejected}}</li>
+      <li>Open disputes: {{.Anomalies.Disputes}}</li>
+    </ul>
+  </body>
+</html>
+`))
+
+// reportPDF lays a report out on a portrait page.
+func reportPDF(event string, report Report) []byte {
+	var content bytes.Buffer
+	// portrait, so the page is pdfPageWidth tall
+	y := float64(pdfPageWidth - 72)
+	line := func(font string, size float64, s string) {
+		fmt.Fprintf(&content, "BT /%s %g Tf 72 %g Td %s Tj ET\n", font, size, y, pdfString(s))
+		y -= size * 1.5
+	}
+	line("F2", 20, event+" daily report")
+	line("F1", 11, report.From.Format("Jan 2 15:04")+" to "+report.To.Format("Jan 2 15:04 MST"))
+	y -= 12
+	line("F1", 12, fmt.Sprintf("%d runs by %d players.", report.Submissions, report.Players))
+	if report.Best != nil {
+		line("F1", 12, fmt.Sprintf("Best of the day: %s, %d health remaining in %.2fs.",
+			report.Best.PlayerName, report.Best.RemainingHealth, report.Best.Elapsed))
+	}
+	y -= 12
+	line("F2", 14, "Standings")
+	for i, score := range report.Standings {
+		line("F1", 12, fmt.Sprintf("%2d. %-3s %6d %9.2fs", i+1, score.PlayerName, score.RemainingHealth, score.Elapsed))
+	}
+	y -= 12
+	a := report.Anomalies
+	line("F2", 14, "Moderation")
+	line("F1", 12, fmt.Sprintf("Held for review: %d", a.Held))
+	line("F1", 12, fmt.Sprintf("Clients flagged by the honeypot: %d", a.Flagged))
+	line("F1", 12, fmt.Sprintf("Scores deleted: %d", a.Deleted))
+	line("F1", 12, fmt.Sprintf("Quarantined scores rejected: %d", a.Rejected))
+	line("F1", 12, fmt.Sprintf("Open disputes: %d", a.Disputes))
+	return singlePagePDF(pdfPageHeight, pdfPageWidth, content.Bytes())
+}
+
+// listReports serves GET /admin/reports, the newest first.
+func (s *HighScoreServer) listReports(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.reports.mutex.Lock()
+	reports := slices.Clone(s.reports.reports)
+	s.reports.mutex.Unlock()
+	slices.Reverse(reports)
+	if reports == nil {
+		reports = []Report{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// makeReport serves POST /admin/reports: a report for the last day, made and
+// delivered now rather than waiting for the schedule.
+func (s *HighScoreServer) makeReport(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	report := s.deliverReport(s.now())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// getReport serves GET /admin/reports/{file}, a kept report as ID.html,
+// ID.pdf or ID.json.
+func (s *HighScoreServer) getReport(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	file := r.PathValue("file")
+	id, format, _ := strings.Cut(file, ".")
+	s.reports.mutex.Lock()
+	i := slices.IndexFunc(s.reports.reports, func(report Report) bool { return report.ID == id })
+	var report Report
+	if i >= 0 {
+		report = s.reports.reports[i]
+	}
+	s.reports.mutex.Unlock()
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch format {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		reportPage.Execute(w, struct {
+			Report
+			Event string
+		}{report, s.eventName})
+	case "pdf":
+		pdf := reportPDF(s.eventName, report)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "report-"+file))
+		w.Write(pdf)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// reservedNames are names set aside for staff and VIP demo runs. Scores
+// played under them are tagged Reserved and never win a prize, and if hide
+// is set they are kept off the public board altogether.
+type reservedNames struct {
+	hide bool
+
+	mutex sync.Mutex
+	names map[string]bool
+}
+
+// has reports whether name is reserved.
+func (n *reservedNames) has(name string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.names[name]
+}
+
+func (n *reservedNames) add(name string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.names[name] {
+		return false
+	}
+	if n.names == nil {
+		n.names = map[string]bool{}
+	}
+	n.names[name] = true
+	return true
+}
+
+func (n *reservedNames) release(name string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	ok := n.names[name]
+	delete(n.names, name)
+	return ok
+}
+
+// prizeEligible filters staff scores out of what prizes and draws are awarded
+// from: those tagged when they were played, and any under a name reserved
+// since.
+func (s *HighScoreServer) prizeEligible(scores []Score) []Score {
+	return slices.DeleteFunc(slices.Clone(scores), func(score Score) bool {
+		return score.Reserved || s.reserved.has(score.PlayerName)
+	})
+}
+
+// listReserved serves GET /admin/reserved, the reserved names in order.
+func (s *HighScoreServer) listReserved(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.reserved.mutex.Lock()
+	names := []string{}
+	for name := range s.reserved.names {
+		names = append(names, name)
+	}
+	s.reserved.mutex.Unlock()
+	slices.Sort(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Names  []string `json:"names"`
+		Hidden bool     `json:"hidden"`
+	}{names, s.reserved.hide})
+}
+
+// reserveName serves PUT /admin/reserved/{name}. Only runs submitted from
+// then on are kept off the board, but earlier ones lose their prizes too.
+func (s *HighScoreServer) reserveName(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	name := strings.TrimSpace(r.PathValue("name"))
+	if len(name) < 1 || len(name) > 3 {
+		http.Error(w, "name must be 1-3 characters", http.StatusBadRequest)
+		return
+	}
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_RESERVE, Name: name, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !result.Found {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "reserve", "", nil, name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// releaseName serves DELETE /admin/reserved/{name}. Scores already tagged
+// stay that way.
+func (s *HighScoreServer) releaseName(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	name := r.PathValue("name")
+	result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_RELEASE, Name: name, Time: s.now()})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !result.Found {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "release", "", name, nil)
+	w.WriteHeader(http.StatusOK)
+}
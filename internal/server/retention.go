@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"elevate2024/internal/store"
+)
+
+// Retention rules, by the name their purges are tallied under.
+const (
+	RETENTION_DELETED = "deleted_scores"
+	RETENTION_IPS     = "ips"
+	RETENTION_REPLAYS = "replays"
+	RETENTION_RESULTS = "results"
+)
+
+// A retentionRule purges one kind of stored data once it is older than maxAge.
+// What it purges from the board, results and quarantine is purged through a
+// COMMAND_PURGE, so every node in a cluster drops the same data; local purges
+// what this node keeps for itself, if anything, and returns how many items it
+// purged.
+type retentionRule struct {
+	Name   string
+	MaxAge time.Duration
+	// how many of the best results to keep regardless of age
+	keep  int
+	local func(cutoff time.Time) int
+}
+
+func (r retentionRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name   string `json:"name"`
+		MaxAge string `json:"max_age"`
+	}{r.Name, r.MaxAge.String()})
+}
+
+// A retentionCutoff is a rule's cutoff as of one sweep, as a COMMAND_PURGE
+// carries it.
+type retentionCutoff struct {
+	Rule   string    `json:"rule"`
+	Cutoff time.Time `json:"cutoff"`
+	Keep   int       `json:"keep,omitempty"`
+}
+
+type retentionStatus struct {
+	Rules   []retentionRule `json:"rules"`
+	Purged  map[string]int  `json:"purged"`
+	LastRun time.Time       `json:"last_run"`
+}
+
+type retentionPolicy struct {
+	rules []retentionRule // set up once by New
+
+	mutex  sync.Mutex
+	status retentionStatus
+}
+
+// retentionRules builds the enabled rules; a zero max age disables a rule.
+func (s *HighScoreServer) retentionRules(deleted time.Duration, ips time.Duration, replays time.Duration, results time.Duration, keepTop int) []retentionRule {
+	var rules []retentionRule
+	if deleted > 0 {
+		rules = append(rules, retentionRule{Name: RETENTION_DELETED, MaxAge: deleted})
+	}
+	if ips > 0 {
+		rules = append(rules, retentionRule{Name: RETENTION_IPS, MaxAge: ips, local: s.purgeLocalIPs})
+	}
+	if replays > 0 {
+		rules = append(rules, retentionRule{Name: RETENTION_REPLAYS, MaxAge: replays})
+	}
+	if results > 0 {
+		rules = append(rules, retentionRule{Name: RETENTION_RESULTS, MaxAge: results, keep: keepTop})
+	}
+	return rules
+}
+
+// purgeLocked applies each cutoff to the board, results and quarantine in
+// turn, returning how many items each rule purged. The caller holds s.mutex.
+func (s *HighScoreServer) purgeLocked(cutoffs []retentionCutoff) map[string]int {
+	purged := map[string]int{}
+	for _, c := range cutoffs {
+		switch c.Rule {
+		case RETENTION_DELETED:
+			purged[c.Rule] += s.purgeDeleted(c.Cutoff)
+		case RETENTION_IPS:
+			purged[c.Rule] += s.purgeQuarantinedIPs(c.Cutoff)
+		case RETENTION_REPLAYS:
+			purged[c.Rule] += s.purgeReplays(c.Cutoff)
+		case RETENTION_RESULTS:
+			purged[c.Rule] += s.purgeResults(c.Cutoff, c.Keep)
+		}
+	}
+	return purged
+}
+
+// purgeDeleted permanently removes scores an admin deleted before cutoff.
+// The caller holds s.mutex.
+func (s *HighScoreServer) purgeDeleted(cutoff time.Time) int {
+	purged := 0
+	for id, result := range s.results {
+		if !result.Deleted.IsZero() && result.Deleted.Before(cutoff) {
+			delete(s.results, id)
+			purged++
+		}
+	}
+	return purged
+}
+
+// purgeQuarantinedIPs blanks the client addresses of scores quarantined
+// before cutoff. The caller holds s.mutex.
+func (s *HighScoreServer) purgeQuarantinedIPs(cutoff time.Time) int {
+	s.quarantine.mutex.Lock()
+	defer s.quarantine.mutex.Unlock()
+
+	purged := 0
+	for id, entry := range s.quarantine.entries {
+		if entry.Submitted.Before(cutoff) && entry.IP != "" {
+			entry.IP = ""
+			s.quarantine.entries[id] = entry
+			purged++
+		}
+	}
+	return purged
+}
+
+// purgeLocalIPs blanks the actors of old audit entries, and forgets clients
+// last seen before cutoff wherever this node tracks them by address: the
+// submission history, recent scores kept to spot duplicates, honeypot flags
+// and rate limits.
+func (s *HighScoreServer) purgeLocalIPs(cutoff time.Time) int {
+	purged := s.audit.purgeActors(cutoff)
+	purged += s.history.forget(cutoff)
+	purged += s.duplicates.forget(cutoff)
+	s.honeypot.mutex.Lock()
+	for ip, hit := range s.honeypot.flagged {
+		if hit.Last.Before(cutoff) {
+			delete(s.honeypot.flagged, ip)
+			purged++
+		}
+	}
+	s.honeypot.mutex.Unlock()
+	for _, limiter := range []*rateLimiter{s.reactions.limiter, s.devices.limiter} {
+		purged += limiter.forget(cutoff)
+	}
+	return purged
+}
+
+// purgeReplays drops the checkpoints kept with results submitted before
+// cutoff. The caller holds s.mutex.
+func (s *HighScoreServer) purgeReplays(cutoff time.Time) int {
+	purged := 0
+	for id, result := range s.results {
+		if result.Replay != nil && result.Submitted.Before(cutoff) {
+			result.Replay = nil
+			s.results[id] = result
+			purged++
+		}
+	}
+	return purged
+}
+
+// purgeResults drops results submitted before cutoff, unless they are among
+// the keepTop best and so may still matter for prizes and certificates. The
+// caller holds s.mutex.
+func (s *HighScoreServer) purgeResults(cutoff time.Time, keepTop int) int {
+	ranked := make([]Result, 0, len(s.results))
+	for _, result := range s.results {
+		if result.Deleted.IsZero() {
+			ranked = append(ranked, result)
+		}
+	}
+	slices.SortFunc(ranked, func(a, b Result) int { return store.Cmp(a.Score, b.Score) })
+
+	purged := 0
+	for _, result := range ranked[min(keepTop, len(ranked)):] {
+		if result.Submitted.Before(cutoff) {
+			delete(s.results, result.Score.ID)
+			purged++
+		}
+	}
+	return purged
+}
+
+// runJanitor applies the retention rules every interval, forever.
+func (s *HighScoreServer) runJanitor(rules []retentionRule, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.sweep(rules, s.now())
+	}
+}
+
+// sweep applies each rule once as of now, tallying what it purged. In a
+// cluster only the leader purges the board, for every node; followers purge
+// only what they keep themselves.
+func (s *HighScoreServer) sweep(rules []retentionRule, now time.Time) {
+	purged := map[string]int{}
+	if len(rules) > 0 && !s.cluster.following() {
+		cmd := boardCommand{Op: COMMAND_PURGE, Time: now}
+		for _, rule := range rules {
+			cmd.Purge = append(cmd.Purge, retentionCutoff{Rule: rule.Name, Cutoff: now.Add(-rule.MaxAge), Keep: rule.keep})
+		}
+		if result, err := s.apply(context.Background(), cmd); err != nil {
+			log.Printf("Retention: %v\n", err)
+		} else {
+			purged = result.Purged
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.local != nil {
+			purged[rule.Name] += rule.local(now.Add(-rule.MaxAge))
+		}
+		if purged[rule.Name] > 0 {
+			log.Printf("Retention: purged %v %v\n", purged[rule.Name], rule.Name)
+		}
+
+		s.retention.mutex.Lock()
+		s.retention.status.Purged[rule.Name] += purged[rule.Name]
+		s.retention.mutex.Unlock()
+	}
+
+	s.retention.mutex.Lock()
+	s.retention.status.LastRun = now
+	s.retention.mutex.Unlock()
+}
+
+func (s *HighScoreServer) retentionReport(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.retention.mutex.Lock()
+	data, err := json.Marshal(s.retention.status)
+	s.retention.mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
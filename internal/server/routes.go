@@ -0,0 +1,146 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+)
+
+// Routes registers the public handlers on mux and the admin handlers on
+// adminMux, which may be the same mux. static holds the frontend files.
+// Serve both muxes through SecureHeaders.
+func (s *HighScoreServer) Routes(mux *http.ServeMux, adminMux *http.ServeMux, static fs.FS) {
+	s.setScriptHashes(static)
+	mux.Handle("/", s.frontend(static))
+
+	// Embeddable leaderboard for partner sites; styling is driven by query
+	// parameters and handled client-side.
+	mux.HandleFunc("/widget", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, static, "widget.html")
+	})
+
+	// Set up streaming server
+	mux.HandleFunc("/events", s.stream)
+	mux.HandleFunc("GET /scores", s.getScores)
+	mux.HandleFunc("GET /scores/diff", s.getScoresDiff)
+	mux.HandleFunc("GET /scores/daily", s.getDailyScores)
+	mux.HandleFunc("GET /teams", s.getTeams)
+	mux.HandleFunc("GET /difficulties", s.getDifficulties)
+	mux.HandleFunc("GET /version", s.version)
+	mux.HandleFunc("GET /strings", s.getStrings)
+	mux.HandleFunc("GET /sdk/{file}", s.sdkHandler())
+
+	mux.HandleFunc("/start", s.getToken)
+	mux.HandleFunc("GET /start/challenge", s.getChallenge)
+	mux.HandleFunc("POST /heartbeat", s.heartbeat)
+	mux.HandleFunc("POST /finish", s.finishRun)
+	mux.HandleFunc("GET /payload-key", s.payloadKey)
+	mux.HandleFunc("GET /.well-known/highscore-keys.json", s.tokenKeys)
+	mux.HandleFunc("/record", s.addScore)
+	mux.HandleFunc("GET /queue", s.queueActivity)
+	mux.HandleFunc("POST /record/validate", s.dryRunScore)
+	mux.HandleFunc("GET /receipts/{id}/verify", s.verifyReceipt)
+	mux.HandleFunc("POST /disputes", s.fileDispute)
+	mux.HandleFunc("GET /disputes/{id}", s.getDispute)
+	mux.HandleFunc("POST /devices/register", s.registerDevice)
+	mux.HandleFunc("GET /devices/control", s.controlStream)
+	mux.Handle("GET /ws", s.websocket())
+	mux.HandleFunc("POST /runs", s.startRun)
+	mux.HandleFunc("GET /runs/{id}", s.getRun)
+	mux.HandleFunc("POST /runs/{id}/events", s.runEvent)
+	mux.HandleFunc("POST /runs/{id}/finish", s.finishSession)
+	mux.HandleFunc("POST /federation/sync", s.federationSync)
+	for _, path := range HONEYPOT_PATHS {
+		mux.HandleFunc(path, s.decoy)
+	}
+
+	mux.HandleFunc("/scoreboard.png", s.scoreboardPNG)
+	mux.HandleFunc("/feed.atom", s.atomFeed)
+	mux.HandleFunc("GET /s/{id}", s.shareScore)
+	mux.HandleFunc("GET /s/{id}/og.png", s.shareImage)
+	mux.HandleFunc("GET /certificate/{file}", s.certificate)
+	mux.HandleFunc("/qr.png", s.qrCode)
+	mux.HandleFunc("/stats", s.stats)
+	mux.HandleFunc("GET /stats/heatmap", s.getHeatmap)
+	mux.HandleFunc("GET /stats/timeseries", s.getTimeseries)
+	mux.HandleFunc("GET /cutoff", s.cutoff)
+	mux.HandleFunc("GET /practice", s.getPractice)
+	mux.HandleFunc("GET /prizes", s.getPrizes)
+	mux.HandleFunc("GET /draws", s.listDraws)
+	mux.HandleFunc("GET /draws/{id}", s.getDraw)
+	mux.HandleFunc("POST /react", s.react)
+	mux.HandleFunc("/cheer", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, static, "cheer.html")
+	})
+
+	// Head-to-head ladder for the 1v1 portion of the event.
+	mux.HandleFunc("POST /matches", s.reportMatch)
+	mux.HandleFunc("GET /rankings", s.rankings)
+	mux.HandleFunc("GET /rankings/events", s.rankingsStream)
+	mux.HandleFunc("GET /bracket", s.getBracket)
+	mux.HandleFunc("GET /bracket/events", s.bracketStream)
+	mux.HandleFunc("/ladder", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, static, "ladder.html")
+	})
+
+	// Full-screen display that rotates through views on the big screen.
+	mux.HandleFunc("/kiosk", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, static, "kiosk.html")
+	})
+	mux.HandleFunc("/kiosk/config.json", s.kioskConfig)
+	mux.HandleFunc("GET /config.json", s.frontendConfig)
+	mux.HandleFunc("GET /theme/logos/{name}", s.getLogo)
+
+	adminMux.HandleFunc("/reset", s.restrictAdmin(s.resetScore))
+	adminMux.HandleFunc("GET /admin/events", s.restrictAdmin(s.adminEvents))
+	adminMux.HandleFunc("GET /admin/queue", s.restrictAdmin(s.queueStats))
+	adminMux.HandleFunc("GET /admin/log-level", s.restrictAdmin(s.getLogLevel))
+	adminMux.HandleFunc("PUT /admin/log-level", s.restrictAdmin(s.setLogLevel))
+	adminMux.HandleFunc("POST /admin/announcements", s.restrictAdmin(s.announce))
+	adminMux.HandleFunc("GET /admin/sponsors", s.restrictAdmin(s.listSponsors))
+	adminMux.HandleFunc("POST /admin/sponsors", s.restrictAdmin(s.addSponsor))
+	adminMux.HandleFunc("DELETE /admin/sponsors/{id}", s.restrictAdmin(s.deleteSponsor))
+	adminMux.HandleFunc("PUT /admin/theme", s.restrictAdmin(s.setTheme))
+	adminMux.HandleFunc("PUT /admin/theme/logos/{name}", s.restrictAdmin(s.putLogo))
+	adminMux.HandleFunc("DELETE /admin/theme/logos/{name}", s.restrictAdmin(s.deleteLogo))
+	adminMux.HandleFunc("POST /admin/totp/enroll", s.restrictAdmin(s.totpEnroll))
+	adminMux.HandleFunc("POST /admin/totp/confirm", s.restrictAdmin(s.totpConfirm))
+	adminMux.HandleFunc("POST /admin/totp/disable", s.restrictAdmin(s.totpDisable))
+	adminMux.HandleFunc("POST /admin/import", s.restrictAdmin(s.importScores))
+	adminMux.HandleFunc("GET /admin/export", s.restrictAdmin(s.exportScores))
+	adminMux.HandleFunc("PATCH /admin/scores/{id}", s.restrictAdmin(s.patchScore))
+	adminMux.HandleFunc("DELETE /admin/scores/{id}", s.restrictAdmin(s.deleteScore))
+	adminMux.HandleFunc("POST /admin/scores/{id}/restore", s.restrictAdmin(s.restoreScore))
+	adminMux.HandleFunc("DELETE /admin/players/{name}", s.restrictAdmin(s.deletePlayer))
+	adminMux.HandleFunc("GET /admin/audit", s.restrictAdmin(s.auditEntries))
+	adminMux.HandleFunc("GET /admin/audit/verify", s.restrictAdmin(s.auditVerify))
+	adminMux.HandleFunc("POST /admin/results/send", s.restrictAdmin(s.sendResults))
+	adminMux.HandleFunc("GET /admin/quarantine", s.restrictAdmin(s.listQuarantine))
+	adminMux.HandleFunc("POST /admin/quarantine/{id}/approve", s.restrictAdmin(s.approveQuarantined))
+	adminMux.HandleFunc("DELETE /admin/quarantine/{id}", s.restrictAdmin(s.rejectQuarantined))
+	adminMux.HandleFunc("GET /admin/disputes", s.restrictAdmin(s.listDisputes))
+	adminMux.HandleFunc("POST /admin/disputes/{id}", s.restrictAdmin(s.resolveDispute))
+	adminMux.HandleFunc("GET /admin/honeypot", s.restrictAdmin(s.listHoneypot))
+	adminMux.HandleFunc("POST /admin/honeypot/{ip}", s.restrictAdmin(s.banClient))
+	adminMux.HandleFunc("DELETE /admin/honeypot/{ip}", s.restrictAdmin(s.unflagClient))
+	adminMux.HandleFunc("POST /admin/bracket", s.restrictAdmin(s.createBracket))
+	adminMux.HandleFunc("POST /admin/bracket/matches/{id}", s.restrictAdmin(s.reportBracketMatch))
+	adminMux.HandleFunc("POST /admin/bracket/advance", s.restrictAdmin(s.advanceBracket))
+	adminMux.HandleFunc("GET /admin/reserved", s.restrictAdmin(s.listReserved))
+	adminMux.HandleFunc("PUT /admin/reserved/{name}", s.restrictAdmin(s.reserveName))
+	adminMux.HandleFunc("DELETE /admin/reserved/{name}", s.restrictAdmin(s.releaseName))
+	adminMux.HandleFunc("POST /admin/prizes/lock", s.restrictAdmin(s.lockPrizes))
+	adminMux.HandleFunc("POST /admin/draw/commit", s.restrictAdmin(s.commitDraw))
+	adminMux.HandleFunc("POST /admin/draw", s.restrictAdmin(s.makeDraw))
+	adminMux.HandleFunc("GET /admin/reports", s.restrictAdmin(s.listReports))
+	adminMux.HandleFunc("POST /admin/reports", s.restrictAdmin(s.makeReport))
+	adminMux.HandleFunc("GET /admin/reports/{file}", s.restrictAdmin(s.getReport))
+	adminMux.HandleFunc("GET /admin/retention", s.restrictAdmin(s.retentionReport))
+	adminMux.HandleFunc("GET /admin/stations", s.restrictAdmin(s.listStations))
+	adminMux.HandleFunc("POST /admin/stations/{id}/revoke", s.restrictAdmin(s.setStationRevoked(true)))
+	adminMux.HandleFunc("POST /admin/stations/{id}/unrevoke", s.restrictAdmin(s.setStationRevoked(false)))
+	adminMux.HandleFunc("POST /admin/devices/pairing", s.restrictAdmin(s.newPairingCode))
+	adminMux.HandleFunc("GET /admin/devices", s.restrictAdmin(s.listDevices))
+	adminMux.HandleFunc("POST /admin/devices/{id}/revoke", s.restrictAdmin(s.setDeviceRevoked(true)))
+	adminMux.HandleFunc("POST /admin/devices/{id}/unrevoke", s.restrictAdmin(s.setDeviceRevoked(false)))
+	adminMux.HandleFunc("POST /admin/devices/{id}/control", s.restrictAdmin(s.sendControl))
+}
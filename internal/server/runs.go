@@ -0,0 +1,332 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"elevate2024/internal/i18n"
+)
+
+// A run that sends nothing for RUN_TIMEOUT is abandoned and expires. Expired
+// and finished runs are remembered for HEARTBEAT_RETENTION, so a late request
+// gets a clear answer.
+const RUN_TIMEOUT = 2 * time.Minute
+
+// The states a run moves through. A run is "submitting" while its score is
+// being checked, so it can't be finished twice at once; a rejected score puts
+// it back to "started" for the client to fix and retry.
+const (
+	RUN_STARTED    = "started"
+	RUN_SUBMITTING = "submitting"
+	RUN_FINISHED   = "finished"
+	RUN_EXPIRED    = "expired"
+)
+
+// A Run is a game in progress as the /runs API shows it. Its token never
+// leaves the server, so a score can only be submitted once per run and only
+// through the run.
+type Run struct {
+	ID      string    `json:"id"`
+	State   string    `json:"state"`
+	Started time.Time `json:"started"`
+	Expires time.Time `json:"expires"`
+	// ScoreID is the submitted score's ID once the run is finished.
+	ScoreID string `json:"score_id,omitempty"`
+
+	token    Token
+	last     time.Time
+	progress *checkpoint
+}
+
+// runSessions holds the runs started through the /runs API, and counts how
+// they ended for /stats.
+type runSessions struct {
+	mutex     sync.Mutex
+	runs      map[string]*Run
+	started   int
+	finished  int
+	abandoned int
+	// dropOff counts abandoned runs by the boss health they last reported, in
+	// DROP_OFF_BUCKETS equal bands from 0 to BOSS_HEALTH.
+	dropOff [DROP_OFF_BUCKETS]int
+	// the abandoned runs that reported progress, and their total elapsed time
+	reported         int
+	abandonedElapsed float64
+}
+
+// DROP_OFF_BUCKETS is how finely /stats breaks down where runs were abandoned.
+const DROP_OFF_BUCKETS = 10
+
+// start begins a run with token.
+func (rs *runSessions) start(token Token) (Run, error) {
+	id, err := newScoreID()
+	if err != nil {
+		return Run{}, err
+	}
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if rs.runs == nil {
+		rs.runs = map[string]*Run{}
+	}
+	now := time.Now()
+	rs.expire(now)
+	run := &Run{ID: id, State: RUN_STARTED, Started: now, token: token, last: now}
+	rs.runs[id] = run
+	rs.started++
+	return run.view(), nil
+}
+
+// expire marks abandoned runs expired and forgets old ones. rs.mutex must be
+// held.
+func (rs *runSessions) expire(now time.Time) {
+	for id, run := range rs.runs {
+		idle := now.Sub(run.last)
+		if idle > HEARTBEAT_RETENTION {
+			delete(rs.runs, id)
+		} else if idle > RUN_TIMEOUT && run.State == RUN_STARTED {
+			run.State = RUN_EXPIRED
+			rs.abandon(run)
+		}
+	}
+}
+
+// abandon counts an expired run. One that never reported progress dropped
+// off before hurting the boss at all. rs.mutex must be held.
+func (rs *runSessions) abandon(run *Run) {
+	rs.abandoned++
+	health := BOSS_HEALTH
+	if run.progress != nil {
+		health = run.progress.bossHealth
+		rs.reported++
+		rs.abandonedElapsed += run.progress.elapsed
+	}
+	rs.dropOff[min(max(health*DROP_OFF_BUCKETS/BOSS_HEALTH, 0), DROP_OFF_BUCKETS-1)]++
+}
+
+// view is a copy of the run for the client, with when it will expire if
+// nothing more is heard from it.
+func (run *Run) view() Run {
+	v := *run
+	if v.State == RUN_STARTED || v.State == RUN_SUBMITTING {
+		v.Expires = v.last.Add(RUN_TIMEOUT)
+	}
+	return v
+}
+
+// transition moves a run from one state to another, keeping it alive. It
+// fails with the status to answer with if the run isn't in the from state.
+func (rs *runSessions) transition(id string, from string, to string) (Run, int, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	now := time.Now()
+	rs.expire(now)
+	run, ok := rs.runs[id]
+	switch {
+	case !ok:
+		return Run{}, http.StatusNotFound, i18n.Errorf("no such run")
+	case run.State == RUN_EXPIRED:
+		return run.view(), http.StatusGone, i18n.Errorf("run expired")
+	case run.State != from:
+		return run.view(), http.StatusConflict, i18n.Errorf("run is %v", run.State)
+	}
+	run.State = to
+	run.last = now
+	return run.view(), 0, nil
+}
+
+// submitted records the outcome of a run's submission.
+func (rs *runSessions) submitted(id string, scoreID string, accepted bool) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if run, ok := rs.runs[id]; ok {
+		run.State = RUN_STARTED
+		if accepted {
+			run.State = RUN_FINISHED
+			run.ScoreID = scoreID
+			rs.finished++
+		}
+		run.last = time.Now()
+	}
+}
+
+// progress notes how far a run has got.
+func (rs *runSessions) progress(id string, c checkpoint) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if run, ok := rs.runs[id]; ok {
+		run.progress = &c
+	}
+}
+
+func (rs *runSessions) get(id string) (Run, bool) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.expire(time.Now())
+	run, ok := rs.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+	return run.view(), true
+}
+
+func writeRun(w http.ResponseWriter, status int, run Run) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(run)
+}
+
+// startRun serves POST /runs, the resource-style equivalent of /start.
+func (s *HighScoreServer) startRun(w http.ResponseWriter, r *http.Request) {
+	token, err := s.mintToken(r)
+	if err != nil {
+		s.httpError(w, r, err, startStatus(err))
+		return
+	}
+	run, err := s.sessions.start(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", s.basePath+"/runs/"+run.ID)
+	writeRun(w, http.StatusCreated, run)
+}
+
+func (s *HighScoreServer) getRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.sessions.get(r.PathValue("id"))
+	if !ok {
+		s.httpError(w, r, i18n.Errorf("no such run"), http.StatusNotFound)
+		return
+	}
+	writeRun(w, http.StatusOK, run)
+}
+
+// runEvent serves POST /runs/{id}/events, where the game reports its progress:
+// the elapsed time and boss health so far, and the player's initials if they
+// are known. Events keep the run alive and are checked like the checkpoints
+// sent over /ws.
+func (s *HighScoreServer) runEvent(w http.ResponseWriter, r *http.Request) {
+	var event struct {
+		PlayerName string  `json:"player_name"`
+		Elapsed    float64 `json:"elapsed"`
+		BossHealth *int    `json:"boss_health"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event.BossHealth == nil {
+		s.httpError(w, r, i18n.Errorf("missing boss_health"), http.StatusBadRequest)
+		return
+	}
+	if len(event.PlayerName) > 3 {
+		s.httpError(w, r, i18n.Errorf("player name must be 1-3 characters"), http.StatusBadRequest)
+		return
+	}
+
+	run, status, err := s.sessions.transition(r.PathValue("id"), RUN_STARTED, RUN_STARTED)
+	if err != nil {
+		s.httpError(w, r, err, status)
+		return
+	}
+	progress := checkpoint{
+		received:   s.now(),
+		elapsed:    event.Elapsed,
+		bossHealth: *event.BossHealth,
+	}
+	s.runs.checkpoint(run.token, event.PlayerName, progress)
+	s.sessions.progress(run.ID, progress)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishSession serves POST /runs/{id}/finish with the final score: the body
+// /record takes, less the token and finish, which come from the run. The
+// server times the run from its start to this request. The answer is the
+// one /record would give.
+func (s *HighScoreServer) finishSession(w http.ResponseWriter, r *http.Request) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	run, status, err := s.sessions.transition(id, RUN_STARTED, RUN_SUBMITTING)
+	if err != nil {
+		s.httpError(w, r, err, status)
+		return
+	}
+	finish := s.runs.end(run.token, func() Finish {
+		return s.tokens.Finish(run.token, s.now())
+	})
+	fields["token"], _ = json.Marshal(run.token)
+	fields["finish"], _ = json.Marshal(finish)
+	body, _ := json.Marshal(fields)
+
+	var response bufferedResponse
+	if err := s.submissions.do(func() { s.processScore(&response, r, body) }); err != nil {
+		writeBusy(&response)
+	}
+	var submitted Submitted
+	accepted := response.status/100 == 2
+	if accepted {
+		json.Unmarshal(response.body.Bytes(), &submitted)
+	}
+	s.sessions.submitted(id, submitted.ID, accepted)
+	response.writeTo(w)
+}
+
+// RunStats describes how runs started through /runs ended. Runs still in
+// progress don't count toward the completion rate.
+type RunStats struct {
+	Started        int     `json:"started"`
+	Finished       int     `json:"finished"`
+	Abandoned      int     `json:"abandoned"`
+	InProgress     int     `json:"in_progress"`
+	CompletionRate float64 `json:"completion_rate"`
+	// the mean elapsed time abandoned runs last reported
+	AbandonedElapsed float64   `json:"abandoned_elapsed"`
+	DropOff          []DropOff `json:"drop_off"`
+}
+
+// A DropOff counts the runs abandoned with the boss's health in a band.
+type DropOff struct {
+	MinHealth int `json:"min_health"`
+	MaxHealth int `json:"max_health"`
+	Runs      int `json:"runs"`
+}
+
+func (rs *runSessions) stats() RunStats {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.expire(time.Now())
+	stats := RunStats{
+		Started:   rs.started,
+		Finished:  rs.finished,
+		Abandoned: rs.abandoned,
+		DropOff:   make([]DropOff, DROP_OFF_BUCKETS),
+	}
+	stats.InProgress = stats.Started - stats.Finished - stats.Abandoned
+	if ended := stats.Finished + stats.Abandoned; ended > 0 {
+		stats.CompletionRate = float64(stats.Finished) / float64(ended)
+	}
+	if rs.reported > 0 {
+		stats.AbandonedElapsed = rs.abandonedElapsed / float64(rs.reported)
+	}
+	for i := range stats.DropOff {
+		stats.DropOff[i] = DropOff{
+			MinHealth: BOSS_HEALTH * i / DROP_OFF_BUCKETS,
+			MaxHealth: BOSS_HEALTH * (i + 1) / DROP_OFF_BUCKETS,
+			Runs:      rs.dropOff[i],
+		}
+	}
+	return stats
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	scoreboardLineHeight = 16
+	scoreboardPadding    = 8
+	scoreboardBaseWidth  = 200
+
+	// Rendering is done per request, so it is capped at 1080p, which covers
+	// any screen a venue is likely to put the board on.
+	scoreboardMaxWidth  = 1920
+	scoreboardMaxHeight = 1080
+	// how many renders of one board version are kept
+	scoreboardCacheSize = 32
+)
+
+type scoreboardTheme struct {
+	background color.Color
+	foreground color.Color
+	muted      color.Color
+	accent     color.Color
+}
+
+var scoreboardThemes = map[string]scoreboardTheme{
+	"dark": {
+		background: color.Black,
+		foreground: color.White,
+		muted:      color.Gray{Y: 0x88},
+		accent:     color.RGBA{R: 0x7f, G: 0xff, B: 0x7f, A: 0xff},
+	},
+	"light": {
+		background: color.White,
+		foreground: color.Gray{Y: 0x11},
+		muted:      color.Gray{Y: 0x66},
+		accent:     color.RGBA{R: 0x20, G: 0x70, B: 0x20, A: 0xff},
+	},
+}
+
+type scoreboardKey struct {
+	n, width, height int
+	theme            string
+}
+
+// scoreboardCache keeps the PNGs rendered from the current board version, so
+// displays polling the same image don't each pay for encoding it.
+type scoreboardCache struct {
+	mutex   sync.Mutex
+	version uint64
+	renders map[scoreboardKey][]byte
+}
+
+func (c *scoreboardCache) get(version uint64, key scoreboardKey) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.version != version {
+		return nil, false
+	}
+	data, ok := c.renders[key]
+	return data, ok
+}
+
+// put stores a render, dropping any from older versions and, once full, the
+// rest of this version's too.
+func (c *scoreboardCache) put(version uint64, key scoreboardKey, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.renders == nil || c.version != version || len(c.renders) >= scoreboardCacheSize {
+		c.version = version
+		c.renders = map[scoreboardKey][]byte{}
+	}
+	c.renders[key] = data
+}
+
+// intParam parses a positive integer query parameter, falling back to def
+// when it is missing and clamping it to max.
+func intParam(r *http.Request, name string, def int, max int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return min(n, max), nil
+}
+
+// renderScoreboard draws the scores at a fixed low resolution using the
+// built-in bitmap font; callers scale the result up to the requested size.
+func renderScoreboard(scores []Score, theme scoreboardTheme) *image.RGBA {
+	height := scoreboardPadding*2 + scoreboardLineHeight*(len(scores)+1)
+	img := image.NewRGBA(image.Rect(0, 0, scoreboardBaseWidth, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.background), image.Point{}, draw.Src)
+
+	d := &font.Drawer{Dst: img, Face: basicfont.Face7x13}
+	text := func(c color.Color, x int, line int, s string) {
+		d.Src = image.NewUniform(c)
+		d.Dot = fixed.P(x, scoreboardPadding+scoreboardLineHeight*line+basicfont.Face7x13.Ascent)
+		d.DrawString(s)
+	}
+	// right-aligns s so that it ends at x
+	rtext := func(c color.Color, x int, line int, s string) {
+		text(c, x-d.MeasureString(s).Round(), line, s)
+	}
+
+	text(theme.accent, scoreboardPadding, 0, "HIGH SCORES")
+	for i, score := range scores {
+		line := i + 1
+		name := theme.foreground
+		if i == 0 {
+			name = theme.accent
+		}
+		rtext(theme.muted, scoreboardPadding+21, line, fmt.Sprintf("%d.", line))
+		text(name, scoreboardPadding+28, line, score.PlayerName)
+		rtext(theme.foreground, scoreboardPadding+112, line, strconv.Itoa(score.RemainingHealth))
+		rtext(theme.muted, scoreboardBaseWidth-scoreboardPadding, line, fmt.Sprintf("%.2fs", score.Elapsed))
+	}
+	return img
+}
+
+func (s *HighScoreServer) scoreboardPNG(w http.ResponseWriter, r *http.Request) {
+	n, err := intParam(r, "n", 10, s.boardSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	width, err := intParam(r, "width", 800, scoreboardMaxWidth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	height, err := intParam(r, "height", 600, scoreboardMaxHeight)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	themeName := r.URL.Query().Get("theme")
+	if themeName == "" {
+		themeName = "dark"
+	}
+	theme, ok := scoreboardThemes[themeName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown theme: %q", themeName), http.StatusBadRequest)
+		return
+	}
+
+	key := scoreboardKey{n: n, width: width, height: height, theme: themeName}
+	scores, version := s.board.View()
+	data, ok := s.scoreboards.get(version, key)
+	if !ok {
+		data, err = encodeScoreboard(scores[:min(n, len(scores))], theme, width, height)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.scoreboards.put(version, key, data)
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+// encodeScoreboard renders scores as a PNG of exactly width by height.
+func encodeScoreboard(scores []Score, theme scoreboardTheme, width int, height int) ([]byte, error) {
+	board := renderScoreboard(scores, theme)
+
+	// Scale by a whole number so the bitmap font stays crisp, and centre the
+	// board on a canvas of exactly the requested size.
+	bw, bh := board.Bounds().Dx(), board.Bounds().Dy()
+	scale := max(1, min(width/bw, height/bh))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.background), image.Point{}, draw.Src)
+	offset := image.Pt((width-bw*scale)/2, (height-bh*scale)/2)
+	target := image.Rectangle{Min: offset, Max: offset.Add(image.Pt(bw*scale, bh*scale))}
+	draw.NearestNeighbor.Scale(img, target, board, board.Bounds(), draw.Src, nil)
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"elevate2024/internal/api"
+	"elevate2024/internal/store"
+)
+
+//go:embed sdk
+var sdkFiles embed.FS
+
+// SDK_TYPES are the wire types described in the generated JavaScript client,
+// in the order they are declared there.
+var SDK_TYPES = []reflect.Type{
+	reflect.TypeFor[Score](),
+	reflect.TypeFor[Token](),
+	reflect.TypeFor[Challenge](),
+	reflect.TypeFor[Finish](),
+	reflect.TypeFor[api.Submission](),
+	reflect.TypeFor[api.Telemetry](),
+	reflect.TypeFor[api.Position](),
+	reflect.TypeFor[Submitted](),
+	reflect.TypeFor[Receipt](),
+	reflect.TypeFor[Dispute](),
+	reflect.TypeFor[BoardPatch](),
+	reflect.TypeFor[store.PatchOp](),
+	reflect.TypeFor[BoardDiff](),
+	reflect.TypeFor[api.RankedScore](),
+}
+
+// An sdkFile is a generated client file and the content type to serve it as.
+type sdkFile struct {
+	name        string
+	contentType string
+	// declare renders one of SDK_TYPES for the file.
+	declare func(t reflect.Type) string
+}
+
+var SDK_FILES = []sdkFile{
+	{"highscore.js", "text/javascript; charset=utf-8", jsDocTypedef},
+	{"highscore.d.ts", "application/typescript; charset=utf-8", tsInterface},
+}
+
+// generateSDK renders the client files from their templates and SDK_TYPES.
+func generateSDK() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, file := range SDK_FILES {
+		tmpl, err := template.ParseFS(sdkFiles, "sdk/"+file.name)
+		if err != nil {
+			return nil, err
+		}
+		var typedefs []string
+		for _, t := range SDK_TYPES {
+			typedefs = append(typedefs, file.declare(t))
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, struct{ Typedefs string }{strings.Join(typedefs, "\n")}); err != nil {
+			return nil, err
+		}
+		files[file.name] = buf.Bytes()
+	}
+	return files, nil
+}
+
+// sdkHandler serves the generated client files under /sdk/. They may be
+// imported from any origin.
+func (s *HighScoreServer) sdkHandler() http.HandlerFunc {
+	files, err := generateSDK()
+	if err != nil {
+		// The templates are embedded, so this is a bug caught by the tests.
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("file")
+		for _, file := range SDK_FILES {
+			if file.name != name {
+				continue
+			}
+			w.Header().Set("Content-Type", file.contentType)
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			if name == "highscore.js" {
+				w.Header().Set("X-TypeScript-Types", "highscore.d.ts")
+			}
+			http.ServeContent(w, r, name, s.started, bytes.NewReader(files[name]))
+			return
+		}
+		s.errorPage(w, r, http.StatusNotFound)
+	}
+}
+
+// sdkField is a struct field as it appears in JSON.
+type sdkField struct {
+	name     string
+	optional bool
+	t        reflect.Type
+}
+
+func sdkFields(t reflect.Type) []sdkField {
+	var fields []sdkField
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, options, _ := strings.Cut(tag, ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, sdkField{
+			name:     name,
+			optional: strings.Contains(options, "omitempty") || field.Type.Kind() == reflect.Pointer,
+			t:        field.Type,
+		})
+	}
+	return fields
+}
+
+// jsType names t in the type language JSDoc and TypeScript share.
+func jsType(t reflect.Type) string {
+	if t == reflect.TypeFor[time.Time]() {
+		return "string"
+	}
+	for _, sdkType := range SDK_TYPES {
+		if t == sdkType {
+			return t.Name()
+		}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Pointer:
+		return jsType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return jsType(t.Elem()) + "[]"
+	case reflect.Map:
+		return fmt.Sprintf("Record<string, %s>", jsType(t.Elem()))
+	}
+	return "any"
+}
+
+func jsDocTypedef(t reflect.Type) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "/**\n * @typedef {Object} %s\n", t.Name())
+	for _, field := range sdkFields(t) {
+		name := field.name
+		if field.optional {
+			name = "[" + name + "]"
+		}
+		fmt.Fprintf(&b, " * @property {%s} %s\n", jsType(field.t), name)
+	}
+	b.WriteString(" */\n")
+	return b.String()
+}
+
+func tsInterface(t reflect.Type) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+	for _, field := range sdkFields(t) {
+		optional := ""
+		if field.optional {
+			optional = "?"
+		}
+		fmt.Fprintf(&b, "  %s%s: %s;\n", field.name, optional, jsType(field.t))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,23 @@
+// Types for /sdk/highscore.js, generated from the same Go types.
+
+{{.Typedefs}}
+export function solveChallenge(challenge: Challenge): Promise<string>;
+
+export class StatusError extends Error {
+  constructor(status: number, message: string);
+  status: number;
+}
+
+export class HighScoreClient {
+  constructor(url?: string);
+  url: string;
+  device: string | null;
+  registerDevice(code: string, name: string): Promise<void>;
+  request(method: string, path: string, body?: any, idempotent?: boolean): Promise<any>;
+  getToken(difficulty?: string, mode?: "practice" | "competition"): Promise<Token>;
+  heartbeat(token: Token, playerName?: string): Promise<void>;
+  finishRun(token: Token): Promise<Finish>;
+  submitScore(submission: Submission | string): Promise<Submitted>;
+  disputeScore(receipt: Receipt, reason: string): Promise<Dispute>;
+  watchScores(onScores: (scores: Score[]) => void, query?: string): EventSource;
+}
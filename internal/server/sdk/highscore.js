@@ -0,0 +1,233 @@
+// Client for the high score server. The server generates this file from the
+// Go types it speaks, so it always matches the server it was loaded from.
+//
+//   import { HighScoreClient } from "https://scores.example/sdk/highscore.js";
+//   const scores = new HighScoreClient();
+//   const token = await scores.getToken();
+//   ...
+//   const finish = await scores.finishRun(token);
+//   await scores.submitScore({ player_name: "AAA", elapsed, remaining_health, token, finish });
+//
+// Only /events is open to other origins; pages elsewhere can follow the board
+// but must be served alongside the server to submit scores.
+
+{{.Typedefs}}
+
+// Statuses worth retrying unchanged: the server was busy, or a proxy couldn't
+// reach it.
+const TEMPORARY = [429, 502, 503, 504];
+const RETRIES = 4;
+const BACKOFF_MS = 250;
+const MAX_BACKOFF_MS = 10000;
+
+/** An answer from the server that wasn't a success. */
+export class StatusError extends Error {
+  /**
+   * @param {number} status
+   * @param {string} message
+   */
+  constructor(status, message) {
+    super(`server answered ${status}: ${message}`);
+    this.status = status;
+  }
+}
+
+const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms));
+
+// Where a registered booth kiosk keeps its device credential.
+const DEVICE_KEY = "highscore-device";
+
+/**
+ * Finds a nonce such that the SHA-256 of the challenge followed by it starts
+ * with the challenge's difficulty in zero bits.
+ * @param {Challenge} challenge
+ * @returns {Promise<string>}
+ */
+export async function solveChallenge(challenge) {
+  const encoder = new TextEncoder();
+  for (let i = 0; ; i++) {
+    const nonce = i.toString(36);
+    const sum = new Uint8Array(
+      await crypto.subtle.digest("SHA-256", encoder.encode(challenge.challenge + nonce)),
+    );
+    let bits = 0;
+    for (const byte of sum) {
+      if (byte !== 0) {
+        bits += Math.clz32(byte) - 24;
+        break;
+      }
+      bits += 8;
+    }
+    if (bits >= challenge.difficulty) {
+      return nonce;
+    }
+  }
+}
+
+export class HighScoreClient {
+  /**
+   * @param {string} [url] the server's base URL; by default the server this
+   *   script came from
+   */
+  constructor(url = new URL("..", import.meta.url).href) {
+    this.url = url.replace(/\/?$/, "/");
+    /** @type {string | null} the device credential, on a registered kiosk */
+    this.device = globalThis.localStorage?.getItem(DEVICE_KEY) ?? null;
+  }
+
+  /**
+   * Registers this browser as a booth kiosk with a pairing code from the
+   * admin API, keeping the credential for every later request.
+   * @param {string} code
+   * @param {string} name
+   * @returns {Promise<void>}
+   */
+  async registerDevice(code, name) {
+    const { credential } = await this.request("POST", "devices/register", { code, name }, false);
+    globalThis.localStorage?.setItem(DEVICE_KEY, credential);
+    this.device = credential;
+  }
+
+  /**
+   * Sends a request, retrying temporary failures with backoff, and network
+   * errors too if idempotent.
+   * @param {string} method
+   * @param {string} path
+   * @param {any} [body] sent as JSON, or as is if already a string
+   * @param {boolean} [idempotent]
+   */
+  async request(method, path, body, idempotent = true) {
+    for (let attempt = 0; ; attempt++) {
+      let wait = Math.min(BACKOFF_MS * 2 ** attempt, MAX_BACKOFF_MS);
+      try {
+        const headers = body === undefined ? {} : { "Content-Type": "application/json" };
+        if (this.device) {
+          headers["X-Device-Credential"] = this.device;
+        }
+        const res = await fetch(this.url + path, {
+          method,
+          headers,
+          body: body === undefined || typeof body === "string" ? body : JSON.stringify(body),
+        });
+        if (res.ok) {
+          return res.status === 204 ? undefined : await res.json();
+        }
+        const error = new StatusError(res.status, (await res.text()).trim());
+        if (!TEMPORARY.includes(res.status) || attempt >= RETRIES) {
+          throw error;
+        }
+        const retryAfter = Number(res.headers.get("Retry-After"));
+        if (retryAfter > 0) {
+          wait = Math.min(retryAfter * 1000, MAX_BACKOFF_MS);
+        }
+      } catch (error) {
+        if (error instanceof StatusError || !idempotent || attempt >= RETRIES) {
+          throw error;
+        }
+      }
+      await sleep(wait);
+    }
+  }
+
+  /**
+   * Starts a run, on difficulty and in mode ("practice" or "competition")
+   * if given, which the token then pins down. Practice runs only count on
+   * the practice board, which is cleared every hour.
+   * If the server asks for a proof of work first, it is done here.
+   * @param {string} [difficulty]
+   * @param {string} [mode]
+   * @returns {Promise<Token>}
+   */
+  async getToken(difficulty, mode) {
+    const query = new URLSearchParams();
+    if (difficulty) {
+      query.set("difficulty", difficulty);
+    }
+    if (mode) {
+      query.set("mode", mode);
+    }
+    try {
+      return await this.request("GET", "start?" + query);
+    } catch (error) {
+      if (!(error instanceof StatusError) || error.status !== 428) {
+        throw error;
+      }
+    }
+    const challenge = await this.request("GET", "start/challenge");
+    query.set("challenge", challenge.challenge);
+    query.set("nonce", await solveChallenge(challenge));
+    return this.request("GET", "start?" + query);
+  }
+
+  /**
+   * Tells the server a run is still going, for the "now playing" display.
+   * @param {Token} token
+   * @param {string} [playerName]
+   * @returns {Promise<void>}
+   */
+  async heartbeat(token, playerName) {
+    await this.request("POST", "heartbeat", { token, player_name: playerName }, false);
+  }
+
+  /**
+   * Has the server note the moment a run ends, so the board times it.
+   * @param {Token} token
+   * @returns {Promise<Finish>}
+   */
+  finishRun(token) {
+    return this.request("POST", "finish", { token });
+  }
+
+  /**
+   * Records a run. It is only retried when the server turned it away unread,
+   * as the server can't tell a retry from a second run.
+   * @param {Submission|string} submission or an already encoded body, e.g.
+   *   one sealed to the server's payload key
+   * @returns {Promise<Submitted>}
+   */
+  submitScore(submission) {
+    return this.request("POST", "record", submission, false);
+  }
+
+  /**
+   * Contests what was done to a recorded run, e.g. its deletion, for the
+   * admins to review. Follow it at disputes/{id}.
+   * @param {Receipt} receipt from submitScore
+   * @param {string} reason
+   * @returns {Promise<Dispute>}
+   */
+  disputeScore(receipt, reason) {
+    return this.request("POST", "disputes", { receipt, reason }, false);
+  }
+
+  /**
+   * Follows the board, calling onScores with all of it whenever it changes.
+   * Close the returned EventSource to stop.
+   * @param {(scores: Score[]) => void} onScores
+   * @param {string} [query] a filter such as "top=5" or "team=red"
+   * @returns {EventSource}
+   */
+  watchScores(onScores, query = "") {
+    const events = new EventSource(this.url + "events" + (query && "?" + query));
+    /** @type {Score[]} */
+    let scores = [];
+    events.addEventListener("scores", (event) => {
+      scores = JSON.parse(event.data);
+      onScores(scores);
+    });
+    events.addEventListener("patch", (event) => {
+      /** @type {BoardPatch} */
+      const patch = JSON.parse(event.data);
+      scores = scores.slice();
+      for (const op of patch.ops) {
+        if (op.op === "remove") {
+          scores.splice(op.rank - 1, 1);
+        } else if (op.op === "insert") {
+          scores.splice(op.rank - 1, 0, op.score);
+        }
+      }
+      onScores(scores);
+    });
+    return events;
+  }
+}
@@ -0,0 +1,634 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"elevate2024/internal/api"
+	"elevate2024/internal/broadcast"
+	"elevate2024/internal/i18n"
+	"elevate2024/internal/store"
+	"elevate2024/internal/token"
+)
+
+const THRESHOLD = 5
+
+// MAX_HEALTH is the boss's starting health in the game, and MAX_ELAPSED
+// longer than anyone plays a single run.
+const (
+	MAX_HEALTH  = 1000
+	MAX_ELAPSED = time.Hour
+)
+
+// DEFAULT_BOARD_SIZE is how many scores the board keeps unless WithBoardSize
+// says otherwise.
+const DEFAULT_BOARD_SIZE = 20
+
+// Handlers deal in scores and tokens everywhere, so they keep short names.
+type (
+	Score      = store.Score
+	Token      = token.Token
+	Finish     = token.Finish
+	Receipt    = token.Receipt
+	Challenge  = token.Challenge
+	Submitted  = api.Submitted
+	BoardPatch = api.BoardPatch
+	BoardDiff  = api.BoardDiff
+)
+
+type HighScoreServer struct {
+	board             *store.Board
+	boardSize         int
+	encoded           atomic.Pointer[encodedBoard]
+	scoreboards       scoreboardCache
+	tokens            *token.Minter
+	work              int
+	challenges        spentChallenges
+	federation        *federation
+	federationEvery   time.Duration
+	mirror            *mirror
+	cluster           *cluster // replicating the board, if any
+	routeLimits       []*routeLimit
+	features          []string
+	now               func() time.Time
+	validators        []Validator
+	mutex             boardMutex
+	storeTimeout      time.Duration
+	maxHealth         int
+	maxElapsed        time.Duration
+	finishMode        string
+	difficulties      map[string]float64
+	i18n              *i18n.Catalog
+	csp               string
+	adminPasswordHash []byte
+	adminNetworks     []netip.Prefix
+	eventName         string
+	publicURL         string
+	basePath          string
+	kiosk             KioskConfig
+	goals             []Goal
+	theme             themeState
+	sponsors          sponsorRotation
+	celebrations      celebrations
+	started           time.Time
+	suggestNames      bool
+
+	feed    []feedEntry
+	feedSeq int
+	results map[string]Result
+
+	tail        broadcast.Hub[SubmissionEvent]
+	submissions *submitQueue
+	totp        totpAuth
+	stations    stationKeys
+	devices     deviceRegistry
+	audit       auditLog
+	disputes    disputeQueue
+	ladder      ladder
+	bracket     bracketState
+
+	retention retentionPolicy
+	ips       ipMasker
+	uniques   uniquePlayers
+	sinks     []EventSink
+	// connections to configured brokers, kept open from Start
+	brokers   []func()
+	live      broadcast.Hub[BoardEvent]
+	reactions reactionTally
+	runs      runTracker
+	sessions  runSessions
+	heatmap   heatmap
+
+	experiments experiments
+	mailer      *smtpMailer
+
+	qualifying *qualifyingRule
+	history    submissionHistory
+	timeseries submissionSeries
+	duplicates recentScores
+	reports    reportArchive
+	prizes     prizeState
+	names      nameOwners
+	reserved   reservedNames
+	practice   practiceBoard
+	draws      drawState
+	quarantine quarantine
+	honeypot   honeypot
+	payload    *payloadKeys
+}
+
+// topScores returns the best n scores. The in-memory board never makes
+// readers wait, so ctx only matters to stores that can.
+func (s *HighScoreServer) topScores(ctx context.Context, n int) ([]Score, error) {
+	return s.board.Top(n), nil
+}
+
+// An encodedBoard is the top of the board encoded as JSON, along with the
+// board version it was encoded from.
+type encodedBoard struct {
+	version uint64
+	data    []byte
+}
+
+// boardSnapshot returns the top of the board as /events and MQTT send it. It
+// is encoded once per board version, however many clients ask for it.
+func (s *HighScoreServer) boardSnapshot() ([]byte, error) {
+	scores, version := s.board.View()
+	if cached := s.encoded.Load(); cached != nil && cached.version == version {
+		return cached.data, nil
+	}
+	data, err := json.Marshal(scores[:min(s.boardSize, len(scores))])
+	if err != nil {
+		return nil, err
+	}
+	s.encoded.Store(&encodedBoard{version: version, data: data})
+	return data, nil
+}
+
+// checkToken verifies that a token was minted by this server.
+func (s *HighScoreServer) checkToken(token Token) error {
+	return s.tokens.Check(token, s.now())
+}
+
+// validateScore checks a submitted score and its start token, returning the
+// reason for rejecting it if anything is wrong.
+func (s *HighScoreServer) validateScore(newScore Score) error {
+	if err := s.checkToken(newScore.Token); err != nil {
+		return err
+	}
+	if newScore.Token.Work < s.work {
+		return i18n.Errorf("run started without proof of work")
+	}
+
+	if err := s.checkBounds(newScore); err != nil {
+		return err
+	}
+
+	if len(newScore.PlayerName) < 1 || len(newScore.PlayerName) > 3 {
+		return i18n.Errorf("player name must be 1-3 characters")
+	}
+
+	if err := checkTeam(newScore.Team); err != nil {
+		return err
+	}
+
+	if err := s.checkDifficulty(newScore); err != nil {
+		return err
+	}
+	if newScore.Token.Difficulty != "" && newScore.Difficulty != newScore.Token.Difficulty {
+		return i18n.Errorf("difficulty %q doesn't match the %q the run was started on", newScore.Difficulty, newScore.Token.Difficulty)
+	}
+
+	wallClockElapsed := float64(s.now().UnixMilli()-newScore.Token.StartMs) / 1000
+	// We must have minted the token at least newScore.Elapsed ago
+	if wallClockElapsed < newScore.Elapsed {
+		log.Printf("Received odd elapsed time: %v (token says %v)\n", newScore.Elapsed, wallClockElapsed)
+		return i18n.Errorf("elapsed time %v exceeds token age %v", newScore.Elapsed, wallClockElapsed)
+	}
+	// Also, if newScore.Elapsed is much less than wall-clock, it's possible they
+	// were sitting on the page before submit for a long time.
+	// TODO: compare the elapsed time against the best possible time to reject oddness
+
+	for _, validate := range s.validators {
+		if err := validate(newScore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBounds rejects scores outside what the game can produce, so garbage
+// can't top the board or break sorting and display.
+func (s *HighScoreServer) checkBounds(score Score) error {
+	if score.RemainingHealth < 0 {
+		return i18n.Errorf("negative remaining health")
+	}
+	if s.maxHealth > 0 && score.RemainingHealth > s.maxHealth {
+		return i18n.Errorf("remaining health %v exceeds the maximum of %v", score.RemainingHealth, s.maxHealth)
+	}
+	if score.Elapsed < 0 || math.IsNaN(score.Elapsed) || math.IsInf(score.Elapsed, 0) {
+		return i18n.Errorf("invalid elapsed time %v", score.Elapsed)
+	}
+	if s.maxElapsed > 0 && score.Elapsed > s.maxElapsed.Seconds() {
+		return i18n.Errorf("elapsed time %v exceeds the maximum of %v", score.Elapsed, s.maxElapsed.Seconds())
+	}
+	return nil
+}
+
+// addScore reads a submission and hands it to the submission queue, so a
+// burst of them is worked through at a bounded rate rather than all at once.
+func (s *HighScoreServer) addScore(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.allowUntrusted(r) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "too many submissions", http.StatusTooManyRequests)
+		return
+	}
+	if err := s.submissions.do(func() { s.processScore(w, r, body) }); err != nil {
+		writeBusy(w)
+	}
+}
+
+func (s *HighScoreServer) processScore(w http.ResponseWriter, r *http.Request, body []byte) {
+	// Nobody is waiting for the answer if the client gave up while queued.
+	if r.Context().Err() != nil {
+		return
+	}
+
+	sub, status, err := s.checkSubmission(r, body)
+	if err != nil {
+		s.publishSubmission(s.submissionEvent(r, sub.Score, err))
+		if status == http.StatusForbidden {
+			w.WriteHeader(status)
+		} else {
+			s.httpError(w, r, err, status)
+		}
+		return
+	}
+	newScore, claimed, email := sub.Score, sub.Claimed, sub.Email
+
+	ip := s.clientIP(r)
+	if marker := honeypotMarker(sub.Body); marker != "" {
+		s.flagClient(ip, marker)
+	}
+	if s.honeypot.isFlagged(ip) {
+		s.runs.finish(newScore.Token)
+		newScore.Token = Token{}
+		newScore, rank, err := s.shadowScore(r.Context(), newScore)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		event := s.submissionEvent(r, newScore, nil)
+		event.Rank = rank
+		event.Flags = []string{"honeypot"}
+		s.publishSubmission(event)
+
+		s.writeSubmitted(w, newScore, rank, "", "")
+		return
+	}
+
+	if newScore.Token.Mode == MODE_PRACTICE {
+		s.runs.finish(newScore.Token)
+		s.practiceScore(w, newScore)
+		return
+	}
+
+	device, _ := s.device(r)
+	suspicion := s.assess(submissionCheck{Score: newScore, Token: newScore.Token, IP: ip, Fingerprint: s.fingerprint(r), Claimed: claimed, Device: device})
+	s.duplicates.add(s.now(), newScore, ip)
+	replay := s.runs.checkpointsOf(newScore.Token)
+	s.runs.finish(newScore.Token)
+
+	// Zero out the token to save space
+	newScore.Token = Token{}
+	newScore.Reserved = s.reserved.has(newScore.PlayerName)
+
+	if s.quarantine.holds(suspicion) {
+		id, err := s.quarantineScore(r.Context(), newScore, suspicion, ip, email)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		event := s.submissionEvent(r, newScore, nil)
+		event.Flags = suspicion.names()
+		event.Suspicion = suspicion.Score
+		event.Quarantined = id
+		s.publishSubmission(event)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(Submitted{Status: "pending_review"})
+		return
+	}
+
+	if newScore.Reserved && s.reserved.hide {
+		newScore, rank, err := s.shadowScore(r.Context(), newScore)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		event := s.submissionEvent(r, newScore, nil)
+		event.Rank = rank
+		event.Flags = append(suspicion.names(), "reserved")
+		s.publishSubmission(event)
+
+		s.writeSubmitted(w, newScore, rank, "", "")
+		return
+	}
+
+	// Checked before the score is on the board, so it only ever finds
+	// someone else's.
+	warning, suggestion := s.nameWarning(s.language(w, r), newScore.PlayerName, s.fingerprint(r))
+	newScore, rank, err := s.insertScore(r.Context(), newScore)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.setResultEmail(newScore.ID, email)
+	s.setResultReplay(newScore.ID, replay)
+	s.heatmap.add(sub.Telemetry)
+	s.celebrations.accepted(newScore, rank)
+	s.uniques.add(newScore.PlayerName, s.fingerprint(r))
+
+	event := s.submissionEvent(r, newScore, nil)
+	event.Rank = rank
+	event.Flags = suspicion.names()
+	event.Suspicion = suspicion.Score
+	s.publishSubmission(event)
+
+	s.writeSubmitted(w, newScore, rank, warning, suggestion)
+}
+
+// A submission is a score that passed validation, along with what else the
+// client sent with it.
+type submission struct {
+	Score Score
+	// the elapsed time the client claimed, before the server timed the run
+	Claimed float64
+	Email   string
+	// gameplay data for the heatmap, if the client sent any
+	Telemetry *api.Telemetry
+	// the decrypted body
+	Body []byte
+}
+
+// checkSubmission runs a submission body through everything short of the
+// anti-cheat assessment: decryption, the station signature, run timing,
+// validateScore and the email address. It has no side effects, so dry runs
+// share it. On failure it returns the status to answer with and as much of
+// the score as was read.
+func (s *HighScoreServer) checkSubmission(r *http.Request, body []byte) (submission, int, error) {
+	if s.prizes.locked() {
+		return submission{}, http.StatusConflict, errEventClosed
+	}
+
+	// Station signatures cover the body as sent, encrypted or not.
+	signed := body
+	body, err := s.payload.open(body)
+	if err != nil {
+		return submission{}, http.StatusBadRequest, err
+	}
+
+	sub := submission{Body: body}
+	if err := json.Unmarshal(body, &sub.Score); err != nil {
+		return sub, http.StatusBadRequest, err
+	}
+	sub.Score.Submitted = s.submittedAt()
+
+	// The station is only ever taken from a verified signature.
+	sub.Score.Station, err = s.stations.verify(r, signed)
+	if err != nil {
+		return sub, http.StatusForbidden, err
+	}
+
+	// The run is timed by the server where the client let it, and what the
+	// client claimed is kept for the cross-check.
+	sub.Claimed = sub.Score.Elapsed
+	if err := s.timeRun(&sub.Score, body); err != nil {
+		return sub, http.StatusBadRequest, err
+	}
+
+	if err := s.validateScore(sub.Score); err != nil {
+		return sub, http.StatusBadRequest, err
+	}
+	// Only the signed bucket counts, not one the client added to the score.
+	sub.Score.Bucket = sub.Score.Token.Bucket
+
+	sub.Email, err = s.parseEmail(body)
+	if err != nil {
+		return sub, http.StatusBadRequest, err
+	}
+	sub.Telemetry, err = parseTelemetry(body)
+	if err != nil {
+		return sub, http.StatusBadRequest, err
+	}
+	return sub, 0, nil
+}
+
+// submittedAt is the time to stamp on a score accepted now. It is kept to
+// the millisecond, as JavaScript dates are, so scores compare the same on
+// either side.
+func (s *HighScoreServer) submittedAt() time.Time {
+	return s.now().UTC().Truncate(time.Millisecond)
+}
+
+// writeSubmitted tells the client where its accepted score landed, with a
+// receipt to prove it, and any warning about the name it was played under.
+func (s *HighScoreServer) writeSubmitted(w http.ResponseWriter, score Score, rank int, warning, suggestion string) {
+	receipt := s.tokens.Receipt(score.ID, rank, score.Submitted)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Submitted{
+		Status:     "accepted",
+		ID:         score.ID,
+		Rank:       rank,
+		URL:        s.basePath + "/s/" + score.ID,
+		Receipt:    &receipt,
+		Warning:    warning,
+		Suggestion: suggestion,
+	})
+}
+
+// insertScore assigns an accepted score its ID and adds it to the board,
+// returning the stored score and its rank at the time of insertion.
+func (s *HighScoreServer) insertScore(ctx context.Context, score Score) (Score, int, error) {
+	id, err := newScoreID()
+	if err != nil {
+		return score, 0, err
+	}
+	score.ID = id
+	if score.Submitted.IsZero() {
+		score.Submitted = s.submittedAt()
+	}
+	s.federation.add(score)
+	return s.placeScore(ctx, score)
+}
+
+// placeScore adds a score that already has its ID to the board, returning it
+// as stored and its rank at the time of insertion.
+func (s *HighScoreServer) placeScore(ctx context.Context, score Score) (Score, int, error) {
+	s.normalize(&score)
+	result, err := s.apply(ctx, boardCommand{Op: COMMAND_INSERT, Score: &score, Time: s.now()})
+	if err != nil {
+		return score, 0, err
+	}
+	return result.Score, result.Rank, nil
+}
+
+func (s *HighScoreServer) getToken(w http.ResponseWriter, r *http.Request) {
+	token, err := s.mintToken(r)
+	if err != nil {
+		s.httpError(w, r, err, startStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(honeypotToken{Token: token})
+}
+
+// mintToken starts a run for the client making r: on the difficulty and in
+// the mode it asks for with ?difficulty= and ?mode=, if any, and in its
+// experiment bucket. If proof of work is on, the client must have solved a
+// challenge first.
+func (s *HighScoreServer) mintToken(r *http.Request) (Token, error) {
+	difficulty := r.URL.Query().Get("difficulty")
+	if err := s.checkDifficulty(Score{Difficulty: difficulty}); err != nil {
+		return Token{}, err
+	}
+	mode := r.URL.Query().Get("mode")
+	if err := checkMode(mode); err != nil {
+		return Token{}, err
+	}
+	work, err := s.checkProofOfWork(r)
+	if err != nil {
+		return Token{}, err
+	}
+	return s.tokens.Mint(s.now(), token.Run{
+		Difficulty:  difficulty,
+		Fingerprint: s.fingerprint(r),
+		Bucket:      s.experiments.bucket(s.clientIP(r)),
+		Work:        work,
+		Mode:        mode,
+	}), nil
+}
+
+// fingerprint identifies a client by its address and browser, to tell
+// whether a run is submitted by the client that started it.
+func (s *HighScoreServer) fingerprint(r *http.Request) string {
+	sum := sha256.Sum256([]byte(s.clientIP(r) + "\x00" + r.UserAgent()))
+	return base64.RawURLEncoding.EncodeToString(sum[:9])
+}
+
+func (s *HighScoreServer) resetScore(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if s.isAdmin(r) {
+		if !s.requireTOTP(w, r) {
+			return
+		}
+
+		result, err := s.apply(r.Context(), boardCommand{Op: COMMAND_RESET, Time: s.now()})
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		log.Println("Cleared scores")
+		s.audit.record(s.clientIP(r), "reset", "", result.Cleared, nil)
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+}
+
+// getScores serves the board for clients that poll instead of streaming. The
+// board version doubles as an ETag, so polling an unchanged board costs a
+// 304 and no body.
+func (s *HighScoreServer) getScores(w http.ResponseWriter, r *http.Request) {
+	filter, err := s.parseBoardFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scores, version := s.board.View()
+
+	// The board starts over on restart, so the ETag names the run too.
+	etag := fmt.Sprintf(`"%d-%d"`, s.started.Unix(), version)
+	w.Header().Set("X-Board-Version", strconv.FormatUint(version, 10))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filter.apply(scores))
+}
+
+// etagMatches reports whether an If-None-Match header lists etag.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *HighScoreServer) stream(w http.ResponseWriter, r *http.Request) {
+	filter, err := srv.parseBoardFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Unfiltered clients share one encoding of the board.
+	snapshot := srv.boardSnapshot
+	if filter != (boardFilter{top: srv.boardSize}) {
+		snapshot = func() ([]byte, error) {
+			scores, err := srv.topScores(r.Context(), srv.boardSize)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(filter.apply(scores))
+		}
+	}
+
+	// Subscribed before the initial events are gathered, so nothing that
+	// happens in between is missed. They are handed to Serve rather than
+	// put on the channel, which nothing reads until Serve starts.
+	events := srv.live.Subscribe()
+	defer srv.live.Unsubscribe(events)
+	var initial []BoardEvent
+	if srv.mirror != nil {
+		initial = srv.mirror.current()
+	} else {
+		counts := srv.reactions.current()
+		playing := srv.runs.current()
+		now := srv.now()
+		initial = append(initial,
+			BoardEvent{Type: EVENT_REACTIONS, Time: now, Reactions: &counts},
+			BoardEvent{Type: EVENT_NOW_PLAYING, Time: now, NowPlaying: &playing})
+		if countdown := srv.countdown(); countdown != nil {
+			initial = append(initial, BoardEvent{Type: EVENT_COUNTDOWN, Time: now, Countdown: countdown})
+		}
+		if len(srv.goals) > 0 {
+			srv.mutex.Lock()
+			progress := srv.goalProgress(srv.participation())
+			srv.mutex.Unlock()
+			initial = append(initial, BoardEvent{Type: EVENT_GOALS, Time: now, Goals: progress})
+		}
+	}
+	broadcast.Serve(w, r, broadcast.Stream[BoardEvent]{
+		Name:      EVENT_SCORES,
+		Snapshot:  snapshot,
+		Patch:     boardPatch,
+		PatchName: EVENT_PATCH,
+		Initial:   initial,
+		Events:    events,
+	})
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Open Graph images are rendered at 240x126 and scaled up to the 1200x630
+// size recommended by most social networks.
+const (
+	ogImageWidth  = 240
+	ogImageHeight = 126
+	ogImageScale  = 5
+)
+
+// A Result is an accepted submission as it stood when it was recorded. Results
+// outlive the board itself so that share links keep working after a score
+// drops out of the top entries.
+type Result struct {
+	Score     Score
+	Rank      int
+	Submitted time.Time
+	Deleted   time.Time // zero unless an admin has deleted the score
+	Email     string    // optional, for mailing the final placement
+	Mailed    time.Time // zero until the final placement has been mailed
+	Shadow    bool      // from a flagged client or hidden staff; never shown on the board
+	// the checkpoints the run reported while it was played, kept as
+	// evidence for disputes
+	Replay []checkpoint
+}
+
+func newScoreID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *HighScoreServer) lookupResult(ctx context.Context, id string) (Result, bool, error) {
+	if err := s.lockBoard(ctx, "lookup"); err != nil {
+		return Result{}, false, err
+	}
+	defer s.mutex.Unlock()
+
+	result, ok := s.results[id]
+	if !result.Deleted.IsZero() {
+		return Result{}, false, nil
+	}
+	return result, ok, nil
+}
+
+// baseURL returns the absolute URL of the server's root as seen by the
+// client, under the base path if there is one.
+func (s *HighScoreServer) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s/", scheme, r.Host, s.basePath)
+}
+
+var sharePage = template.Must(template.New("share").Parse(`<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <title>{{.Title}}</title>
+    <meta property="og:type" content="website" />
+    <meta property="og:title" content="{{.Title}}" />
+    <meta property="og:description" content="{{.Description}}" />
+    <meta property="og:url" content="{{.URL}}" />
+    <meta property="og:image" content="{{.Image}}" />
+    <meta property="og:image:width" content="1200" />
+    <meta property="og:image:height" content="630" />
+    <meta name="twitter:card" content="summary_large_image" />
+    <style>
+      body {
+        margin: 0;
+        padding: 24px;
+        background: #000;
+        color: #fff;
+        font-family: monospace;
+        text-align: center;
+      }
+      img {
+        max-width: 100%;
+      }
+      a {
+        color: #7fff7f;
+      }
+    </style>
+  </head>
+  <body>
+    <img src="{{.Image}}" alt="{{.Description}}" />
+    <p>{{.Description}}</p>
+    <p><a href="{{.Home}}">Think you can do better? Play now!</a></p>
+  </body>
+</html>
+`))
+
+func (s *HighScoreServer) shareScore(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	result, ok, err := s.lookupResult(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		s.errorPage(w, r, http.StatusNotFound)
+		return
+	}
+
+	base := s.baseURL(r)
+	data := struct {
+		Title, Description, URL, Image, Home string
+	}{
+		Title: fmt.Sprintf("%s placed #%d", result.Score.PlayerName, result.Rank),
+		Description: fmt.Sprintf("%s placed #%d with %d health remaining in %.2fs on %s.",
+			result.Score.PlayerName, result.Rank, result.Score.RemainingHealth,
+			result.Score.Elapsed, result.Submitted.Format("January 2, 2006")),
+		URL:   base + "s/" + id,
+		Image: base + "s/" + id + "/og.png",
+		Home:  base,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := sharePage.Execute(w, data); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *HighScoreServer) shareImage(w http.ResponseWriter, r *http.Request) {
+	result, ok, err := s.lookupResult(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	theme := scoreboardThemes["dark"]
+	small := image.NewRGBA(image.Rect(0, 0, ogImageWidth, ogImageHeight))
+	draw.Draw(small, small.Bounds(), image.NewUniform(theme.background), image.Point{}, draw.Src)
+
+	d := &font.Drawer{Dst: small, Face: basicfont.Face7x13}
+	// centres s horizontally with its baseline at y
+	text := func(c color.Color, y int, s string) {
+		d.Src = image.NewUniform(c)
+		d.Dot = fixed.P((ogImageWidth-d.MeasureString(s).Round())/2, y)
+		d.DrawString(s)
+	}
+	text(theme.accent, 24, "HIGH SCORE")
+	text(theme.foreground, 52, result.Score.PlayerName)
+	text(theme.accent, 72, "#"+strconv.Itoa(result.Rank))
+	text(theme.foreground, 92, fmt.Sprintf("%d health  %.2fs", result.Score.RemainingHealth, result.Score.Elapsed))
+	text(theme.muted, 114, result.Submitted.Format("Jan 2, 2006"))
+
+	img := image.NewRGBA(image.Rect(0, 0, ogImageWidth*ogImageScale, ogImageHeight*ogImageScale))
+	draw.NearestNeighbor.Scale(img, img.Bounds(), small, small.Bounds(), draw.Src, nil)
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if err := png.Encode(w, img); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MIN_SPONSOR_EVERY is the shortest period a sponsor slide may repeat with,
+// so the big screen still mostly shows the board.
+const MIN_SPONSOR_EVERY = time.Minute
+
+// A Sponsor is a promo message shown on the big screen for Duration every
+// Every, interleaved with the board.
+type Sponsor struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	// the name of a theme logo to show with the message, if any
+	Logo     string        `json:"logo,omitempty"`
+	Every    time.Duration `json:"-"`
+	Duration time.Duration `json:"-"`
+
+	next time.Time
+}
+
+// MarshalJSON writes the durations as seconds.
+func (s Sponsor) MarshalJSON() ([]byte, error) {
+	type fields Sponsor
+	return json.Marshal(struct {
+		fields
+		Every    float64   `json:"every_seconds"`
+		Duration float64   `json:"duration_seconds"`
+		Next     time.Time `json:"next"`
+	}{fields(s), s.Every.Seconds(), s.Duration.Seconds(), s.next})
+}
+
+// A SponsorSlide is what a "sponsor" event tells displays to show, and for
+// how long.
+type SponsorSlide struct {
+	ID      string  `json:"id"`
+	Message string  `json:"message"`
+	Logo    string  `json:"logo,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// sponsorRotation schedules the sponsors. One slide is shown at a time; a
+// sponsor that comes due during another's slide waits for it to end.
+type sponsorRotation struct {
+	mutex    sync.Mutex
+	sponsors []*Sponsor
+	seq      int
+	// when the slide showing now ends
+	showing time.Time
+}
+
+// due returns the slide to show at now, if any, and schedules its sponsor's
+// next one.
+func (r *sponsorRotation) due(now time.Time) (*Sponsor, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if now.Before(r.showing) {
+		return nil, false
+	}
+	var next *Sponsor
+	for _, sponsor := range r.sponsors {
+		if !now.Before(sponsor.next) && (next == nil || sponsor.next.Before(next.next)) {
+			next = sponsor
+		}
+	}
+	if next == nil {
+		return nil, false
+	}
+	next.next = now.Add(next.Every)
+	r.showing = now.Add(next.Duration)
+	return next, true
+}
+
+// broadcastSponsors sends a "sponsor" event to /events subscribers whenever a
+// sponsor's slide comes due.
+func (s *HighScoreServer) broadcastSponsors(interval time.Duration) {
+	for range time.Tick(interval) {
+		sponsor, ok := s.sponsors.due(time.Now())
+		if !ok {
+			continue
+		}
+		slide := SponsorSlide{ID: sponsor.ID, Message: sponsor.Message, Seconds: sponsor.Duration.Seconds()}
+		if sponsor.Logo != "" {
+			slide.Logo = s.theme.current(s.basePath).Logos[sponsor.Logo]
+		}
+		s.live.Publish(BoardEvent{Type: EVENT_SPONSOR, Time: s.now(), Sponsor: &slide})
+	}
+}
+
+func (s *HighScoreServer) listSponsors(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.sponsors.mutex.Lock()
+	sponsors := []Sponsor{}
+	for _, sponsor := range s.sponsors.sponsors {
+		sponsors = append(sponsors, *sponsor)
+	}
+	s.sponsors.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(sponsors)
+}
+
+// addSponsor schedules a sponsor slide from
+// {"message": ..., "logo": ..., "every": "10m", "duration": "30s"}. Its
+// first showing is as soon as the screen is free.
+func (s *HighScoreServer) addSponsor(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Message  string `json:"message"`
+		Logo     string `json:"logo"`
+		Every    string `json:"every"`
+		Duration string `json:"duration"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sponsor := &Sponsor{Message: strings.TrimSpace(body.Message), Logo: body.Logo}
+	if sponsor.Message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if sponsor.Every, err = time.ParseDuration(body.Every); err != nil || sponsor.Every < MIN_SPONSOR_EVERY {
+		http.Error(w, "every must be a duration of at least "+MIN_SPONSOR_EVERY.String(), http.StatusBadRequest)
+		return
+	}
+	if sponsor.Duration, err = time.ParseDuration(body.Duration); err != nil || sponsor.Duration <= 0 || sponsor.Duration >= sponsor.Every {
+		http.Error(w, "duration must be a positive duration shorter than every", http.StatusBadRequest)
+		return
+	}
+
+	s.sponsors.mutex.Lock()
+	s.sponsors.seq++
+	sponsor.ID = strconv.Itoa(s.sponsors.seq)
+	sponsor.next = time.Now()
+	s.sponsors.sponsors = append(s.sponsors.sponsors, sponsor)
+	added := *sponsor
+	s.sponsors.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "sponsor-add", "", nil, added)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(added)
+}
+
+func (s *HighScoreServer) deleteSponsor(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	id := r.PathValue("id")
+	s.sponsors.mutex.Lock()
+	i := slices.IndexFunc(s.sponsors.sponsors, func(sponsor *Sponsor) bool { return sponsor.ID == id })
+	var removed Sponsor
+	if i >= 0 {
+		removed = *s.sponsors.sponsors[i]
+		s.sponsors.sponsors = slices.Delete(s.sponsors.sponsors, i, i+1)
+	}
+	s.sponsors.mutex.Unlock()
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "sponsor-delete", "", removed, nil)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"elevate2024/internal/assets"
+)
+
+// API_PREFIXES are paths that never fall back to the frontend, so a mistyped
+// API call gets a 404 rather than the game.
+var API_PREFIXES = []string{"admin/", "api/"}
+
+// frontend serves the static files, and index.html for any other page a
+// browser navigates to, so client-side routes such as /board/daily can be
+// linked to directly. Anything else that doesn't exist gets the 404 page.
+// Files precompressed at build time are served compressed to clients that
+// accept it.
+func (s *HighScoreServer) frontend(static fs.FS) http.Handler {
+	files := http.FileServer(http.FS(static))
+	manifest, err := assets.Load(static)
+	if err != nil {
+		log.Printf("Serving the frontend uncompressed: %v\n", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" || name == "index.html" {
+			s.serveIndex(w, r, static, manifest)
+			return
+		}
+		if _, err := fs.Stat(static, name); err == nil {
+			if !manifest.Serve(w, r, static, name) {
+				files.ServeHTTP(w, r)
+			}
+			return
+		}
+		if wantsHTML(r) && path.Ext(name) == "" && !hasAPIPrefix(name+"/") {
+			s.serveIndex(w, r, static, manifest)
+			return
+		}
+		s.errorPage(w, r, http.StatusNotFound)
+	})
+}
+
+func hasAPIPrefix(name string) bool {
+	for _, prefix := range API_PREFIXES {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsHTML reports whether r is a browser loading a page, as opposed to a
+// script or API client.
+func wantsHTML(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!doctype html>
+<html lang="{{.Language}}">
+  <head>
+    <meta charset="UTF-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <title>{{.Status}} {{.Title}}</title>
+    <style>
+      body {
+        margin: 0;
+        padding: 24px;
+        background: #000;
+        color: #fff;
+        font-family: monospace;
+        text-align: center;
+      }
+      h1 {
+        font-size: 96px;
+        margin: 48px 0 0;
+        color: #7fff7f;
+      }
+      a {
+        color: #7fff7f;
+      }
+    </style>
+  </head>
+  <body>
+    <h1>{{.Status}}</h1>
+    <p>{{.Title}}</p>
+    <p><a href="{{.Root}}">{{.Home}}</a></p>
+  </body>
+</html>
+`))
+
+// errorPage answers with a page in the frontend's style for browsers, and
+// the plain status text for everyone else.
+func (s *HighScoreServer) errorPage(w http.ResponseWriter, r *http.Request, code int) {
+	if !wantsHTML(r) {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	lang := s.language(w, r)
+	strs := s.i18n.Strings(lang)
+	title := http.StatusText(code)
+	if code == http.StatusNotFound {
+		title = strs["error.not_found"]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := errorPageTemplate.Execute(w, struct {
+		Language, Title, Home, Root string
+		Status                      int
+	}{lang, title, strs["error.home"], s.basePath + "/", code}); err != nil {
+		log.Println(err)
+	}
+}
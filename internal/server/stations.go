@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Trusted native game builds identify themselves with these headers, signing
+// the raw /record body with their station's pre-shared key.
+const STATION_HEADER = "X-Station-ID"
+const SIGNATURE_HEADER = "X-Signature"
+
+// stationKeys holds the pre-shared keys for kiosk stations.
+type stationKeys struct {
+	mutex   sync.Mutex
+	keys    map[string][]byte
+	revoked map[string]bool
+}
+
+// loadKeyFile reads a file of "name key" lines, such as station or board
+// keys; what names is what they name, for errors. Blank lines and lines
+// starting with # are ignored, and a name listed more than once gets its last
+// key.
+func loadKeyFile(path string, what string) (map[string][]byte, error) {
+	lists, err := loadKeyLists(path, what)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string][]byte{}
+	for name, list := range lists {
+		keys[name] = list[len(list)-1]
+	}
+	return keys, nil
+}
+
+// loadKeyLists reads a key file like loadKeyFile, keeping every key listed
+// for a name, in the order they appear.
+func loadKeyLists(path string, what string) (map[string][][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := map[string][][]byte{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"%s key\"", path, line, what)
+		}
+		keys[fields[0]] = append(keys[fields[0]], []byte(fields[1]))
+	}
+	return keys, scanner.Err()
+}
+
+// verify checks the station signature on a submission body. It returns the
+// station ID, or "" for an unsigned submission from an ordinary browser.
+func (k *stationKeys) verify(r *http.Request, body []byte) (string, error) {
+	station := r.Header.Get(STATION_HEADER)
+	if station == "" {
+		return "", nil
+	}
+
+	k.mutex.Lock()
+	key, ok := k.keys[station]
+	revoked := k.revoked[station]
+	k.mutex.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("unknown station %q", station)
+	}
+	if revoked {
+		return "", fmt.Errorf("station %q has been revoked", station)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(SIGNATURE_HEADER))
+	if err != nil {
+		return "", fmt.Errorf("malformed station signature: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", errors.New("invalid station signature")
+	}
+	return station, nil
+}
+
+type StationStatus struct {
+	ID      string `json:"id"`
+	Revoked bool   `json:"revoked"`
+}
+
+func (s *HighScoreServer) listStations(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.stations.mutex.Lock()
+	stations := []StationStatus{}
+	for id := range s.stations.keys {
+		stations = append(stations, StationStatus{ID: id, Revoked: s.stations.revoked[id]})
+	}
+	s.stations.mutex.Unlock()
+	slices.SortFunc(stations, func(a, b StationStatus) int { return strings.Compare(a.ID, b.ID) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stations)
+}
+
+func (s *HighScoreServer) setStationRevoked(revoked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		id := r.PathValue("id")
+		s.stations.mutex.Lock()
+		defer s.stations.mutex.Unlock()
+
+		if _, ok := s.stations.keys[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if s.stations.revoked == nil {
+			s.stations.revoked = map[string]bool{}
+		}
+		s.stations.revoked[id] = revoked
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+
+	"elevate2024/internal/api"
+	"elevate2024/internal/i18n"
+)
+
+// The heatmap divides the play area into HEATMAP_COLUMNS by HEATMAP_ROWS
+// cells, roughly the shape of the screen.
+const (
+	HEATMAP_COLUMNS = 32
+	HEATMAP_ROWS    = 18
+)
+
+// parseTelemetry reads a submission's optional telemetry.
+func parseTelemetry(body []byte) (*api.Telemetry, error) {
+	var submission struct {
+		Telemetry *api.Telemetry `json:"telemetry"`
+	}
+	if err := json.Unmarshal(body, &submission); err != nil {
+		return nil, err
+	}
+	telemetry := submission.Telemetry
+	if telemetry == nil {
+		return nil, nil
+	}
+	if len(telemetry.Deaths) > api.MAX_DEATHS {
+		return nil, i18n.Errorf("telemetry may report at most %v deaths", api.MAX_DEATHS)
+	}
+	for _, death := range telemetry.Deaths {
+		if !(death.X >= 0 && death.X <= 1 && death.Y >= 0 && death.Y <= 1) {
+			return nil, i18n.Errorf("death position %v,%v is outside the play area", death.X, death.Y)
+		}
+	}
+	if telemetry.Level < 0 {
+		return nil, i18n.Errorf("negative level")
+	}
+	return telemetry, nil
+}
+
+// heatmap aggregates the telemetry of accepted runs. Only the totals are
+// kept, so it stays the same size however many runs report, and it lives
+// apart from the board: a /reset doesn't clear it.
+type heatmap struct {
+	mutex  sync.Mutex
+	runs   int
+	cells  [HEATMAP_ROWS][HEATMAP_COLUMNS]int
+	levels map[int]int
+}
+
+func (h *heatmap) add(telemetry *api.Telemetry) {
+	if telemetry == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.runs++
+	for _, death := range telemetry.Deaths {
+		row := min(int(death.Y*HEATMAP_ROWS), HEATMAP_ROWS-1)
+		column := min(int(death.X*HEATMAP_COLUMNS), HEATMAP_COLUMNS-1)
+		h.cells[row][column]++
+	}
+	if telemetry.Level > 0 {
+		if h.levels == nil {
+			h.levels = map[int]int{}
+		}
+		h.levels[telemetry.Level]++
+	}
+}
+
+// Heatmap is the telemetry of every run that sent some. Cells[row][column]
+// counts the deaths in that cell, the top row first; Max is the largest
+// count, to scale colours by.
+type Heatmap struct {
+	Runs    int          `json:"runs"`
+	Columns int          `json:"columns"`
+	Rows    int          `json:"rows"`
+	Cells   [][]int      `json:"cells"`
+	Max     int          `json:"max"`
+	Levels  []LevelCount `json:"levels"`
+}
+
+// A LevelCount is how many runs got as far as a level and no further.
+type LevelCount struct {
+	Level int `json:"level"`
+	Runs  int `json:"runs"`
+}
+
+func (h *heatmap) snapshot() Heatmap {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	snapshot := Heatmap{
+		Runs:    h.runs,
+		Columns: HEATMAP_COLUMNS,
+		Rows:    HEATMAP_ROWS,
+		Cells:   make([][]int, HEATMAP_ROWS),
+		Levels:  []LevelCount{},
+	}
+	for i := range h.cells {
+		snapshot.Cells[i] = slices.Clone(h.cells[i][:])
+		snapshot.Max = max(snapshot.Max, slices.Max(snapshot.Cells[i]))
+	}
+	for level, runs := range h.levels {
+		snapshot.Levels = append(snapshot.Levels, LevelCount{level, runs})
+	}
+	slices.SortFunc(snapshot.Levels, func(a, b LevelCount) int { return a.Level - b.Level })
+	return snapshot
+}
+
+func (s *HighScoreServer) getHeatmap(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(s.heatmap.snapshot())
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MAX_LOGO_SIZE is the largest logo the admin API takes, and MAX_LOGOS how
+// many it keeps.
+const (
+	MAX_LOGO_SIZE = 1 << 20
+	MAX_LOGOS     = 16
+)
+
+// LOGO_TYPES are the image types logos may be. SVG is left out, as it can
+// carry scripts.
+var LOGO_TYPES = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+var (
+	themeKey   = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+	themeColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-z]{3,20}|(rgb|hsl)a?\([0-9., %/deg]+\))$`)
+	themeFont  = regexp.MustCompile(`^[A-Za-z0-9 ,'-]{1,100}$`)
+)
+
+// A Theme is a sponsor's branding for the frontend, set per event through the
+// admin API rather than baked into the embedded assets. Pages apply colors
+// and fonts as the CSS custom properties --theme-NAME and --font-NAME.
+type Theme struct {
+	// CSS colors by name, e.g. "background", "text" and "accent"
+	Colors map[string]string `json:"colors"`
+	// CSS font families by role, e.g. "body" and "heading"
+	Fonts map[string]string `json:"fonts"`
+	// where to fetch each uploaded logo, by name; set by the server
+	Logos map[string]string `json:"logos"`
+}
+
+// check rejects colors and fonts that aren't plainly one CSS value, so a
+// theme can't inject styles of its own.
+func (t Theme) check() error {
+	if len(t.Colors) > 32 || len(t.Fonts) > 8 {
+		return fmt.Errorf("too many colors or fonts")
+	}
+	for name, color := range t.Colors {
+		if !themeKey.MatchString(name) || !themeColor.MatchString(color) {
+			return fmt.Errorf("invalid color %s: %q", name, color)
+		}
+	}
+	for role, font := range t.Fonts {
+		if !themeKey.MatchString(role) || !themeFont.MatchString(font) {
+			return fmt.Errorf("invalid font %s: %q", role, font)
+		}
+	}
+	return nil
+}
+
+// A logo is an uploaded image and its content type.
+type logo struct {
+	contentType string
+	data        []byte
+}
+
+// themeState is the current theme and its logos.
+type themeState struct {
+	mutex sync.Mutex
+	theme Theme
+	logos map[string]logo
+	// bumped on every logo change, so URLs change with the image
+	version int
+}
+
+// current returns the theme with its logo URLs.
+func (t *themeState) current(base string) Theme {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	theme := Theme{Colors: maps.Clone(t.theme.Colors), Fonts: maps.Clone(t.theme.Fonts), Logos: map[string]string{}}
+	if theme.Colors == nil {
+		theme.Colors = map[string]string{}
+	}
+	if theme.Fonts == nil {
+		theme.Fonts = map[string]string{}
+	}
+	for name := range t.logos {
+		theme.Logos[name] = fmt.Sprintf("%s/theme/logos/%s?v=%d", base, name, t.version)
+	}
+	return theme
+}
+
+// A FrontendConfig is the answer to GET /config.json: what the frontend needs
+// to know about this event.
+type FrontendConfig struct {
+	Event     string `json:"event"`
+	PublicURL string `json:"public_url"`
+	Theme     Theme  `json:"theme"`
+}
+
+// frontendConfig serves GET /config.json.
+func (s *HighScoreServer) frontendConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(FrontendConfig{
+		Event:     s.eventName,
+		PublicURL: s.publicURL,
+		Theme:     s.theme.current(s.basePath),
+	})
+}
+
+// getLogo serves GET /theme/logos/{name}.
+func (s *HighScoreServer) getLogo(w http.ResponseWriter, r *http.Request) {
+	s.theme.mutex.Lock()
+	logo, ok := s.theme.logos[r.PathValue("name")]
+	s.theme.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", logo.contentType)
+	// The URL changes with the logo.
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(logo.data)
+}
+
+// setTheme replaces the theme's colors and fonts with PUT /admin/theme.
+// Logos are managed on their own.
+func (s *HighScoreServer) setTheme(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var theme Theme
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&theme); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := theme.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.theme.mutex.Lock()
+	before := s.theme.theme
+	s.theme.theme = Theme{Colors: theme.Colors, Fonts: theme.Fonts}
+	s.theme.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "theme", "", before, theme)
+	w.WriteHeader(http.StatusOK)
+}
+
+// putLogo uploads a logo with PUT /admin/theme/logos/{name}, the image as the
+// body.
+func (s *HighScoreServer) putLogo(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	name := r.PathValue("name")
+	if !themeKey.MatchString(name) {
+		http.Error(w, "invalid logo name", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_LOGO_SIZE))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(LOGO_TYPES, contentType) {
+		http.Error(w, fmt.Sprintf("logo must be one of %s, not %s", strings.Join(LOGO_TYPES, ", "), contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	s.theme.mutex.Lock()
+	if _, ok := s.theme.logos[name]; !ok && len(s.theme.logos) >= MAX_LOGOS {
+		s.theme.mutex.Unlock()
+		http.Error(w, "too many logos", http.StatusConflict)
+		return
+	}
+	if s.theme.logos == nil {
+		s.theme.logos = map[string]logo{}
+	}
+	s.theme.logos[name] = logo{contentType: contentType, data: data}
+	s.theme.version++
+	s.theme.mutex.Unlock()
+
+	s.audit.record(s.clientIP(r), "theme-logo", "", nil, name)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteLogo removes a logo with DELETE /admin/theme/logos/{name}.
+func (s *HighScoreServer) deleteLogo(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	name := r.PathValue("name")
+	s.theme.mutex.Lock()
+	_, ok := s.theme.logos[name]
+	delete(s.theme.logos, name)
+	s.theme.version++
+	s.theme.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.audit.record(s.clientIP(r), "theme-logo-delete", "", name, nil)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// STORE_TIMEOUT is how long a score operation waits for the board before
+// giving up, unless Config.StoreTimeout says otherwise.
+const STORE_TIMEOUT = 2 * time.Second
+
+// A boardMutex guards the board like a sync.Mutex, except that score
+// operations can stop waiting for it when their context ends. The zero value
+// is unlocked.
+type boardMutex struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func (m *boardMutex) init() {
+	m.once.Do(func() { m.ch = make(chan struct{}, 1) })
+}
+
+func (m *boardMutex) Lock() {
+	m.init()
+	m.ch <- struct{}{}
+}
+
+func (m *boardMutex) Unlock() {
+	<-m.ch
+}
+
+// LockContext locks m, or returns ctx.Err() if ctx ends first.
+func (m *boardMutex) LockContext(ctx context.Context) error {
+	m.init()
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A StoreError reports that a score operation couldn't reach the board in
+// time, so the request can be retried rather than failed outright.
+type StoreError struct {
+	Op  string
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("%s: store unavailable: %v", e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// lockBoard takes s.mutex for the score operation op, waiting no longer than
+// ctx allows or the store timeout, whichever is sooner.
+func (s *HighScoreServer) lockBoard(ctx context.Context, op string) error {
+	if s.storeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.storeTimeout)
+		defer cancel()
+	}
+	if err := s.mutex.LockContext(ctx); err != nil {
+		return &StoreError{Op: op, Err: err}
+	}
+	return nil
+}
+
+// writeStoreError answers a request whose score operation failed: 503 with a
+// JSON body if the store was unavailable, 500 for anything else.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var storeErr *StoreError
+	if !errors.As(err, &storeErr) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Warn("Store unavailable", "err", err)
+
+	reason := "cancelled"
+	if errors.Is(storeErr.Err, context.DeadlineExceeded) {
+		reason = "timeout"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(struct {
+		Error  string `json:"error"`
+		Op     string `json:"op"`
+		Reason string `json:"reason"`
+	}{"store_unavailable", storeErr.Op, reason})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"elevate2024/internal/store"
+)
+
+// TIMESERIES_RESOLUTION is the finest bucket the timeseries keeps; coarser
+// ones must be a multiple of it. TIMESERIES_POINTS is the most buckets one
+// request may ask for.
+const (
+	TIMESERIES_RESOLUTION = time.Minute
+	TIMESERIES_POINTS     = 10000
+)
+
+// TIMESERIES_COLUMNS are the CSV columns of /admin/export?format=timeseries.
+var TIMESERIES_COLUMNS = []string{"start", "submissions", "best_player", "best_normalized", "leader_player", "leader_normalized"}
+
+// A TimeseriesPoint is a bucket of the day's activity.
+type TimeseriesPoint struct {
+	Start       time.Time `json:"start"`
+	Submissions int       `json:"submissions"`
+	// the best score submitted during the bucket, and the best submitted by
+	// its end
+	Best   *Score `json:"best,omitempty"`
+	Leader *Score `json:"leader,omitempty"`
+}
+
+// A Timeseries is the answer to GET /stats/timeseries.
+type Timeseries struct {
+	BucketSeconds float64           `json:"bucket_seconds"`
+	Points        []TimeseriesPoint `json:"points"`
+}
+
+// submissionSeries counts accepted scores and the best of them per
+// TIMESERIES_RESOLUTION, by when they were submitted. It follows
+// submissions rather than the board, so it is kept through resets, and
+// scores deleted later still count where they were submitted.
+type submissionSeries struct {
+	mutex   sync.Mutex
+	buckets map[int64]*TimeseriesPoint
+}
+
+func (t *submissionSeries) add(score Score) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	start := score.Submitted.Truncate(TIMESERIES_RESOLUTION)
+	if t.buckets == nil {
+		t.buckets = map[int64]*TimeseriesPoint{}
+	}
+	b, ok := t.buckets[start.Unix()]
+	if !ok {
+		b = &TimeseriesPoint{Start: start.UTC()}
+		t.buckets[start.Unix()] = b
+	}
+	b.Submissions++
+	if b.Best == nil || store.Cmp(score, *b.Best) < 0 {
+		best := score
+		b.Best = &best
+	}
+}
+
+// series adds the buckets up into ones of the given size, from the first
+// submission to the last, with empty buckets in between so the series can
+// be charted as it is.
+func (t *submissionSeries) series(bucket time.Duration) (Timeseries, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	series := Timeseries{BucketSeconds: bucket.Seconds(), Points: []TimeseriesPoint{}}
+	if len(t.buckets) == 0 {
+		return series, nil
+	}
+	var first, last int64
+	for start := range t.buckets {
+		if first == 0 || start < first {
+			first = start
+		}
+		last = max(last, start)
+	}
+	from := time.Unix(first, 0).Truncate(bucket)
+	count := int(time.Unix(last, 0).Sub(from)/bucket) + 1
+	if count > TIMESERIES_POINTS {
+		return series, fmt.Errorf("%v buckets of %v is too many; the most is %v", count, bucket, TIMESERIES_POINTS)
+	}
+
+	var leader *Score
+	for i := 0; i < count; i++ {
+		point := TimeseriesPoint{Start: from.Add(time.Duration(i) * bucket).UTC()}
+		for at := point.Start; at.Before(point.Start.Add(bucket)); at = at.Add(TIMESERIES_RESOLUTION) {
+			b, ok := t.buckets[at.Unix()]
+			if !ok {
+				continue
+			}
+			point.Submissions += b.Submissions
+			if point.Best == nil || store.Cmp(*b.Best, *point.Best) < 0 {
+				point.Best = b.Best
+			}
+		}
+		if point.Best != nil && (leader == nil || store.Cmp(*point.Best, *leader) < 0) {
+			leader = point.Best
+		}
+		point.Leader = leader
+		series.Points = append(series.Points, point)
+	}
+	return series, nil
+}
+
+// parseBucket reads the bucket size from ?bucket=, an hour by default.
+func parseBucket(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("bucket")
+	if v == "" {
+		return time.Hour, nil
+	}
+	bucket, err := time.ParseDuration(v)
+	if err != nil || bucket <= 0 || bucket%TIMESERIES_RESOLUTION != 0 {
+		return 0, fmt.Errorf("invalid bucket %q: must be a multiple of %v", v, TIMESERIES_RESOLUTION)
+	}
+	return bucket, nil
+}
+
+// getTimeseries serves GET /stats/timeseries?bucket=5m: submissions and the
+// best scores over time, for charting the day's activity.
+func (s *HighScoreServer) getTimeseries(w http.ResponseWriter, r *http.Request) {
+	bucket, err := parseBucket(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := s.timeseries.series(bucket)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(series)
+}
+
+// exportTimeseries writes the timeseries as CSV, for /admin/export.
+func (s *HighScoreServer) exportTimeseries(w http.ResponseWriter, r *http.Request) {
+	bucket, err := parseBucket(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := s.timeseries.series(bucket)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="timeseries.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(TIMESERIES_COLUMNS)
+	scoreColumns := func(score *Score) []string {
+		if score == nil {
+			return []string{"", ""}
+		}
+		return []string{score.PlayerName, strconv.FormatFloat(score.Normalized, 'f', -1, 64)}
+	}
+	for _, point := range series.Points {
+		row := []string{point.Start.Format(time.RFC3339), strconv.Itoa(point.Submissions)}
+		row = append(row, scoreColumns(point.Best)...)
+		row = append(row, scoreColumns(point.Leader)...)
+		out.Write(row)
+	}
+	out.Flush()
+}
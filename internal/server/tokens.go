@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"elevate2024/internal/token"
+)
+
+// TOKEN_SIGNING are the accepted -token-signing settings: tokens signed with
+// a shared secret, or with Ed25519 so others can verify them with the public
+// key alone.
+var TOKEN_SIGNING = []string{"hmac", "ed25519"}
+
+// MIN_TOKEN_KEY is the shortest key -token-keys may give a board.
+const MIN_TOKEN_KEY = 16
+
+// newMinter sets up the start tokens for config's board, as of the server
+// starting at started. key, from WithKey, is used unless -token-keys gives
+// one; with neither, tokens are only good until the server restarts. An
+// Ed25519 key is derived from the key, so either kind of signing can be set
+// up from the same file.
+//
+// To rotate a board's key, add a line with the new one after the old and
+// restart: the last key listed signs new tokens, and earlier ones are kept
+// for a token's lifetime, so runs already underway can still be submitted.
+func newMinter(config Config, key []byte, started time.Time) (*token.Minter, error) {
+	if !slices.Contains(TOKEN_SIGNING, config.TokenSigning) {
+		return nil, fmt.Errorf("unknown token signing %q (supported: %s)", config.TokenSigning, strings.Join(TOKEN_SIGNING, ", "))
+	}
+	var previous [][]byte
+	if config.TokenKeys != "" {
+		keys, err := loadKeyLists(config.TokenKeys, "board")
+		if err != nil {
+			return nil, err
+		}
+		listed := keys[config.Board]
+		for _, key := range listed {
+			if len(key) < MIN_TOKEN_KEY {
+				return nil, fmt.Errorf("%s: keys for board %q must be at least %d characters", config.TokenKeys, config.Board, MIN_TOKEN_KEY)
+			}
+		}
+		if len(listed) == 0 {
+			return nil, fmt.Errorf("%s: no key of at least %d characters for board %q", config.TokenKeys, MIN_TOKEN_KEY, config.Board)
+		}
+		key, previous = listed[len(listed)-1], listed[:len(listed)-1]
+	}
+	if key == nil {
+		key = make([]byte, 16)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	minter := func(key []byte) *token.Minter {
+		if config.TokenSigning == "ed25519" {
+			seed := sha256.Sum256(key)
+			return token.NewEd25519Minter(ed25519.NewKeyFromSeed(seed[:]), config.Board)
+		}
+		return token.NewBoardMinter(key, config.Board)
+	}
+	tokens := minter(key)
+	// A token has to outlast the longest run allowed, and then some for the
+	// player to enter their name.
+	tokens.TTL = max(token.DEFAULT_TTL, config.MaxElapsed+time.Hour)
+	for _, key := range previous {
+		tokens.Retain(minter(key), started.Add(tokens.TTL))
+	}
+	return tokens, nil
+}
+
+// KEYS_MAX_AGE is how long verifiers may cache the published keys. A rotated
+// key is picked up within this long of the server restarting with it.
+const KEYS_MAX_AGE = 5 * time.Minute
+
+// A VerificationKey is a published token key, in JWK form (RFC 8037) with
+// what a verifier needs to handle rotation. The current key verifies tokens
+// minted since NotBefore, each of which expires within TokenTTL of its start.
+// A key it replaced is still published until NotAfter, when the last token it
+// signed has expired, so a verifier need keep it no longer than that.
+type VerificationKey struct {
+	KeyID     string     `json:"kid"`
+	KeyType   string     `json:"kty"`
+	Curve     string     `json:"crv"`
+	Algorithm string     `json:"alg"`
+	Use       string     `json:"use"`
+	X         string     `json:"x"`
+	Board     string     `json:"board,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// TokenTTL is how long tokens stay valid, in seconds.
+	TokenTTL int64 `json:"token_ttl"`
+}
+
+// verificationKey publishes an Ed25519 public key for s's board.
+func (s *HighScoreServer) verificationKey(id string, public ed25519.PublicKey) VerificationKey {
+	return VerificationKey{
+		KeyID:     id,
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		Algorithm: "EdDSA",
+		Use:       "sig",
+		X:         base64.RawURLEncoding.EncodeToString(public),
+		Board:     s.tokens.Board(),
+		TokenTTL:  int64(s.tokens.TTL.Seconds()),
+	}
+}
+
+// tokenKeys serves GET /.well-known/highscore-keys.json, the keys that
+// verify start tokens, for verifying runs without asking the server: the
+// current key, and those it replaced while their tokens may still be live.
+// Tokens name their key in their kid. Tokens signed with a shared secret
+// can't be verified by anyone else, so then there is nothing to publish.
+func (s *HighScoreServer) tokenKeys(w http.ResponseWriter, r *http.Request) {
+	public := s.tokens.PublicKey()
+	if public == nil {
+		http.Error(w, "tokens aren't signed with a public key", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(KEYS_MAX_AGE.Seconds())))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	current := s.verificationKey(s.tokens.KeyID(), public)
+	started := s.started.UTC()
+	current.NotBefore = &started
+	keys := []VerificationKey{current}
+	now := s.now()
+	for _, retired := range s.tokens.Retired() {
+		if retired.PublicKey() == nil || !now.Before(retired.NotAfter) {
+			continue
+		}
+		key := s.verificationKey(retired.ID(), retired.PublicKey())
+		notAfter := retired.NotAfter.UTC()
+		key.NotAfter = &notAfter
+		keys = append(keys, key)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Keys []VerificationKey `json:"keys"`
+	}{keys})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TOTP_STEP = 30 * time.Second
+const TOTP_DIGITS = 6
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the RFC 6238 code for the given time step counter.
+func totpCode(secret []byte, counter uint64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(b)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%1_000_000)
+}
+
+// totpAuth holds the admin's second factor. It is disabled until a secret is
+// either supplied on the command line or enrolled and confirmed over the API.
+type totpAuth struct {
+	mutex   sync.Mutex
+	secret  []byte
+	pending []byte
+	// last accepted counter, so a code can't be replayed within its window
+	lastCounter uint64
+}
+
+func parseTOTPSecret(s string) ([]byte, error) {
+	secret, err := totpEncoding.DecodeString(strings.ToUpper(strings.ReplaceAll(s, " ", "")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return secret, nil
+}
+
+func (t *totpAuth) enabled() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.secret != nil
+}
+
+// match checks code against secret as of now, allowing one step of clock
+// drift either way, and returns the matching counter.
+func (t *totpAuth) match(secret []byte, code string, now time.Time) (uint64, bool) {
+	step := uint64(now.Unix() / int64(TOTP_STEP/time.Second))
+	for _, counter := range []uint64{step - 1, step, step + 1} {
+		if hmac.Equal([]byte(totpCode(secret, counter)), []byte(code)) {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// verify reports whether code is valid for the enrolled secret as of now. It
+// always succeeds when no second factor is enrolled.
+func (t *totpAuth) verify(code string, now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.secret == nil {
+		return true
+	}
+	counter, ok := t.match(t.secret, code, now)
+	if !ok || counter <= t.lastCounter {
+		return false
+	}
+	t.lastCounter = counter
+	return true
+}
+
+// requireTOTP checks the totp form value for destructive admin actions,
+// writing an error response if it is missing or wrong.
+func (s *HighScoreServer) requireTOTP(w http.ResponseWriter, r *http.Request) bool {
+	if s.totp.verify(r.FormValue("totp"), s.now()) {
+		return true
+	}
+	http.Error(w, "a current TOTP code is required", http.StatusForbidden)
+	return false
+}
+
+// totpEnroll starts enrollment by generating a new secret. It only takes
+// effect once confirmed with a code from the authenticator app, so a typo
+// during setup can't lock the admin out.
+func (s *HighScoreServer) totpEnroll(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// Replacing an enrolled secret needs the current one.
+	if s.totp.enabled() && !s.requireTOTP(w, r) {
+		return
+	}
+
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.totp.mutex.Lock()
+	s.totp.pending = secret
+	s.totp.mutex.Unlock()
+
+	encoded := totpEncoding.EncodeToString(secret)
+	label := url.PathEscape(s.eventName + ":admin")
+	uri := fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s", label, encoded, url.QueryEscape(s.eventName))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: encoded,
+		URI:    uri,
+	})
+}
+
+func (s *HighScoreServer) totpConfirm(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.totp.mutex.Lock()
+	defer s.totp.mutex.Unlock()
+
+	if s.totp.pending == nil {
+		http.Error(w, "no TOTP enrollment in progress", http.StatusConflict)
+		return
+	}
+	counter, ok := s.totp.match(s.totp.pending, r.FormValue("totp"), s.now())
+	if !ok {
+		http.Error(w, "incorrect TOTP code", http.StatusForbidden)
+		return
+	}
+	s.totp.secret = s.totp.pending
+	s.totp.pending = nil
+	s.totp.lastCounter = counter
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *HighScoreServer) totpDisable(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !s.requireTOTP(w, r) {
+		return
+	}
+
+	s.totp.mutex.Lock()
+	s.totp.secret = nil
+	s.totp.mutex.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"sync"
+
+	"elevate2024/internal/hll"
+)
+
+// uniquePlayers estimates how many different people have had a run accepted,
+// telling players apart by their initials and client fingerprint. Only a
+// salted HyperLogLog sketch is kept, so the count can be reported without
+// storing anything about the players.
+type uniquePlayers struct {
+	mutex  sync.Mutex
+	sketch hll.Sketch
+}
+
+func (u *uniquePlayers) add(name string, fingerprint string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.sketch.Add(name, fingerprint)
+}
+
+func (u *uniquePlayers) estimate() uint64 {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.sketch.Estimate()
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// The build's version, commit and time, for builds that set them with
+//
+//	go build -ldflags "-X elevate2024/internal/server.BUILD_VERSION=v1.2.0 ..."
+//
+// Whatever is left empty comes from the version control information the Go
+// toolchain embeds.
+var (
+	BUILD_VERSION string
+	BUILD_COMMIT  string
+	BUILD_TIME    string
+)
+
+// A VersionInfo says which build a server is running and with what turned on,
+// so staff can tell deployed instances apart.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	// whether the build had uncommitted changes
+	Modified  bool      `json:"modified,omitempty"`
+	GoVersion string    `json:"go_version"`
+	Started   time.Time `json:"started"`
+	// the optional features this instance was started with
+	Features []string `json:"features"`
+}
+
+// Build returns what is known about the running build.
+func Build() VersionInfo {
+	info := VersionInfo{GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Version = build.Main.Version
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = setting.Value
+			case "vcs.time":
+				info.BuildTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	info.Version = cmp.Or(BUILD_VERSION, info.Version, "(devel)")
+	info.Commit = cmp.Or(BUILD_COMMIT, info.Commit)
+	info.BuildTime = cmp.Or(BUILD_TIME, info.BuildTime)
+	return info
+}
+
+// features names the optional features config turns on, with the setting
+// where there's a choice of several.
+func features(config Config) []string {
+	features := []string{}
+	on := func(enabled bool, name string) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	on(config.Board != "", "board="+config.Board)
+	on(config.TokenKeys != "", "token-keys")
+	features = append(features, "token-signing="+config.TokenSigning, "finish-mode="+config.FinishMode)
+	on(config.PayloadMode != "off", "payload="+config.PayloadMode)
+	on(config.ProofOfWork > 0, "proof-of-work="+strconv.Itoa(config.ProofOfWork))
+	on(config.StationKeys != "", "station-keys")
+	on(config.UntrustedSubmitLimit > 0, "untrusted-submit-limit")
+	on(config.TOTPSecret != "", "totp")
+	on(config.AdminAllowCIDR != "", "admin-allow-cidr")
+	on(config.QualifyTop > 0, "qualifying")
+	on(len(config.Experiments) > 0, "experiments")
+	on(len(config.Goals) > 0, "goals")
+	on(len(config.Prizes) > 0, "prizes")
+	on(config.SuggestNames, "suggest-names")
+	on(len(config.ReservedNames) > 0 || config.HideReserved, "reserved-names")
+	on(config.NATSURL != "", "nats")
+	on(config.MQTTURL != "", "mqtt")
+	on(config.KafkaBrokers != "", "kafka")
+	on(len(config.Notify) > 0, "notify")
+	on(config.ReportAt != "", "daily-report")
+	on(config.SMTPURL != "", "smtp")
+	on(config.FederationKeys != "", "federation")
+	on(config.FederationPrimary != "", "federation-satellite")
+	on(config.MirrorOf != "", "mirror")
+	on(config.RaftID != "", "raft")
+	on(len(config.RouteLimits) > 0, "route-limits")
+	on(config.BasePath != "", "base-path")
+	return features
+}
+
+// version serves GET /version.
+func (s *HighScoreServer) version(w http.ResponseWriter, r *http.Request) {
+	info := Build()
+	info.Started = s.started
+	info.Features = s.features
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"elevate2024/internal/broadcast"
+	"elevate2024/internal/i18n"
+)
+
+// Native clients on /ws send a checkpoint every WS_CHECKPOINT_INTERVAL while
+// a run is in progress. A run that goes CHECKPOINT_GAP without one looks
+// paused or replayed.
+const (
+	WS_CHECKPOINT_INTERVAL = HEARTBEAT_INTERVAL
+	CHECKPOINT_GAP         = 3 * WS_CHECKPOINT_INTERVAL
+	// WS_IDLE_TIMEOUT closes sessions that have gone quiet.
+	WS_IDLE_TIMEOUT = 5 * time.Minute
+)
+
+// A wsMessage is one JSON message on /ws, in either direction.
+//
+// The client sends:
+//
+//	start       begin a run; answered with a token
+//	checkpoint  player_name (optional), elapsed and boss_health partway through
+//	finish      the run is over; answered with the signed finish
+//	submit      score: the body /record takes, with token and finish filled
+//	            in by the server if left out; answered with a result
+//
+// The server sends token, finish and result messages, and error for
+// messages it can't act on. A result carries the status and body /record
+// would have answered with.
+type wsMessage struct {
+	Type string `json:"type"`
+
+	PlayerName string          `json:"player_name,omitempty"`
+	Elapsed    float64         `json:"elapsed,omitempty"`
+	BossHealth *int            `json:"boss_health,omitempty"`
+	Score      json.RawMessage `json:"score,omitempty"`
+
+	Token  *Token          `json:"token,omitempty"`
+	Finish *Finish         `json:"finish,omitempty"`
+	Status int             `json:"status,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// wsSession is one connection's run in progress.
+type wsSession struct {
+	token  *Token
+	finish *Finish
+}
+
+// websocket serves /ws, where native game clients play a whole run over one
+// connection: fetching the token, sending checkpoints, and submitting. The
+// checkpoints feed the "checkpoints" suspicion signal.
+func (s *HighScoreServer) websocket() http.Handler {
+	return websocket.Server{
+		Handshake: checkWebSocketOrigin,
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			s.serveWebSocket(conn)
+		},
+	}
+}
+
+// checkWebSocketOrigin lets native clients, which send no Origin, connect,
+// but only pages from this server: a browser can't POST to /record from
+// another origin either.
+func checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return fmt.Errorf("origin %q not allowed", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+func (s *HighScoreServer) serveWebSocket(conn *websocket.Conn) {
+	r := conn.Request()
+	var session wsSession
+	for {
+		conn.SetReadDeadline(time.Now().Add(WS_IDLE_TIMEOUT))
+		var message wsMessage
+		if err := websocket.JSON.Receive(conn, &message); err != nil {
+			return
+		}
+		reply, err := s.handleWebSocket(r, &session, message)
+		if err != nil {
+			lang := s.i18n.Match(r.Header.Get("Accept-Language"))
+			reply = wsMessage{Type: "error", Error: s.i18n.Message(lang, err)}
+		}
+		if reply.Type == "" {
+			continue
+		}
+		// The connection outlives the server's write timeout, so each reply
+		// gets its own.
+		conn.SetWriteDeadline(time.Now().Add(broadcast.WRITE_TIMEOUT))
+		if err := websocket.JSON.Send(conn, reply); err != nil {
+			log.Printf("WebSocket: %v\n", err)
+			return
+		}
+	}
+}
+
+func (s *HighScoreServer) handleWebSocket(r *http.Request, session *wsSession, message wsMessage) (wsMessage, error) {
+	switch message.Type {
+	case "start":
+		token, err := s.mintToken(r)
+		if err != nil {
+			return wsMessage{}, err
+		}
+		*session = wsSession{token: &token}
+		return wsMessage{Type: "token", Token: &token}, nil
+
+	case "checkpoint":
+		if session.token == nil || session.finish != nil {
+			return wsMessage{}, i18n.Errorf("no run in progress")
+		}
+		if message.BossHealth == nil {
+			return wsMessage{}, i18n.Errorf("missing boss_health")
+		}
+		if len(message.PlayerName) > 3 {
+			return wsMessage{}, i18n.Errorf("player name must be 1-3 characters")
+		}
+		s.runs.checkpoint(*session.token, message.PlayerName, checkpoint{
+			received:   s.now(),
+			elapsed:    message.Elapsed,
+			bossHealth: *message.BossHealth,
+		})
+		return wsMessage{}, nil
+
+	case "finish":
+		if session.token == nil {
+			return wsMessage{}, i18n.Errorf("no run in progress")
+		}
+		finish := s.endWebSocketRun(session)
+		return wsMessage{Type: "finish", Finish: &finish}, nil
+
+	case "submit":
+		if session.token == nil {
+			return wsMessage{}, i18n.Errorf("no run in progress")
+		}
+		body, err := s.webSocketSubmission(session, message.Score)
+		if err != nil {
+			return wsMessage{}, err
+		}
+		var w bufferedResponse
+		if err := s.submissions.do(func() { s.processScore(&w, r, body) }); err != nil {
+			writeBusy(&w)
+		}
+		*session = wsSession{}
+		return wsMessage{Type: "result", Status: w.status, Result: w.json()}, nil
+	}
+	return wsMessage{}, i18n.Errorf("unknown message type %q", message.Type)
+}
+
+// endWebSocketRun ends the session's run, if it hasn't been already.
+func (s *HighScoreServer) endWebSocketRun(session *wsSession) Finish {
+	if session.finish == nil {
+		token := *session.token
+		finish := s.runs.end(token, func() Finish {
+			return s.tokens.Finish(token, s.now())
+		})
+		session.finish = &finish
+	}
+	return *session.finish
+}
+
+// webSocketSubmission fills the session's token and finish into a /record
+// body that doesn't have them. Encrypted bodies are passed on untouched, so
+// they must carry their own.
+func (s *HighScoreServer) webSocketSubmission(session *wsSession, score json.RawMessage) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(score, &fields); err != nil {
+		return nil, err
+	}
+	if _, sealed := fields["box"]; sealed {
+		return score, nil
+	}
+	if _, ok := fields["token"]; !ok {
+		fields["token"], _ = json.Marshal(session.token)
+	}
+	if _, ok := fields["finish"]; !ok {
+		fields["finish"], _ = json.Marshal(s.endWebSocketRun(session))
+	}
+	return json.Marshal(fields)
+}
+
+// bufferedResponse holds an answer to pass on over a WebSocket instead of
+// writing it to a connection.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	if b.header == nil {
+		b.header = http.Header{}
+	}
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// json is the body as JSON, quoting it if it is plain text such as an error.
+func (b *bufferedResponse) json() json.RawMessage {
+	body := bytes.TrimSpace(b.body.Bytes())
+	if json.Valid(body) {
+		return body
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// writeTo passes the held answer on to w.
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+	}
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"slices"
+)
+
+// MAX_PATCH_OPS is the most ops a patch may hold before a full snapshot is
+// cheaper to send and apply.
+const MAX_PATCH_OPS = 4
+
+// A PatchOp changes one row of a client's copy of the board. Ranks are
+// 1-based and refer to the board as it is when the op is applied: "remove"
+// drops the score at rank, "insert" puts score at rank, shifting the rest
+// down.
+type PatchOp struct {
+	Op    string `json:"op"`
+	Rank  int    `json:"rank"`
+	Score *Score `json:"score,omitempty"`
+}
+
+// Diff returns the ops that turn old into new: removals from the bottom up,
+// then insertions from the top down. It gives up if that takes more than
+// MAX_PATCH_OPS or doesn't reproduce new, e.g. after an edit reorders rows.
+func Diff(old []Score, new []Score) ([]PatchOp, bool) {
+	inNew := map[Score]bool{}
+	for _, score := range new {
+		inNew[score] = true
+	}
+	inOld := map[Score]bool{}
+	for _, score := range old {
+		inOld[score] = true
+	}
+
+	ops := []PatchOp{}
+	board := slices.Clone(old)
+	for i := len(old) - 1; i >= 0; i-- {
+		if !inNew[old[i]] {
+			ops = append(ops, PatchOp{Op: "remove", Rank: i + 1})
+			board = slices.Delete(board, i, i+1)
+		}
+	}
+	for i, score := range new {
+		if !inOld[score] {
+			if i > len(board) {
+				return nil, false
+			}
+			ops = append(ops, PatchOp{Op: "insert", Rank: i + 1, Score: &score})
+			board = slices.Insert(board, i, score)
+		}
+	}
+	if len(ops) > MAX_PATCH_OPS || !slices.Equal(board, new) {
+		return nil, false
+	}
+	return ops, true
+}
+
+// Apply applies ops to a client's copy of the board, returning the new board.
+// It reports false if an op doesn't fit the board, which means the copy is out
+// of step with the server and needs a full snapshot.
+func Apply(board []Score, ops []PatchOp) ([]Score, bool) {
+	board = slices.Clone(board)
+	for _, op := range ops {
+		switch {
+		case op.Op == "remove" && op.Rank >= 1 && op.Rank <= len(board):
+			board = slices.Delete(board, op.Rank-1, op.Rank)
+		case op.Op == "insert" && op.Score != nil && op.Rank >= 1 && op.Rank <= len(board)+1:
+			board = slices.Insert(board, op.Rank-1, *op.Score)
+		default:
+			return nil, false
+		}
+	}
+	return board, true
+}
@@ -0,0 +1,202 @@
+// Package store holds the leaderboard: the scores on it, how they rank, and a
+// version that changes whenever the board does.
+package store
+
+import (
+	"cmp"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"elevate2024/internal/token"
+)
+
+type Score struct {
+	ID              string      `json:"id"`
+	PlayerName      string      `json:"player_name"`
+	Elapsed         float64     `json:"elapsed"`
+	RemainingHealth int         `json:"remaining_health"`
+	Team            string      `json:"team,omitempty"`
+	Token           token.Token `json:"token"`
+	Station         string      `json:"station,omitempty"`
+	// the venue a federated score was played at, if not this server's
+	Venue    string `json:"venue,omitempty"`
+	Imported bool   `json:"imported,omitempty"`
+	// played under a name reserved for staff, so it can't win a prize
+	Reserved bool `json:"reserved,omitempty"`
+	// the mode the run was played on, and its damage to the boss scaled by
+	// that mode's multiplier so runs on every mode rank together
+	Difficulty string  `json:"difficulty,omitempty"`
+	Normalized float64 `json:"normalized"`
+	// the experiment variants the run was played on, from its token
+	Bucket string `json:"bucket,omitempty"`
+	// when the server accepted the score, never taken from the client
+	Submitted time.Time `json:"submitted"`
+}
+
+// Cmp orders scores best first: the higher normalized score, then less health
+// left on the boss, then the longer run, then whoever got there first.
+func Cmp(a Score, b Score) int {
+	return cmp.Or(
+		-cmp.Compare(a.Normalized, b.Normalized),
+		cmp.Compare(a.RemainingHealth, b.RemainingHealth),
+		-cmp.Compare(a.Elapsed, b.Elapsed),
+		a.Submitted.Compare(b.Submitted),
+	)
+}
+
+// A Board is the set of scores competing for the top spots, kept best first.
+//
+// Reads never block: they see an immutable snapshot, which every write
+// replaces whole. Writes must be serialized by the caller; the server makes
+// them under its board lock, since they go together with other bookkeeping.
+type Board struct {
+	// Limit, if positive, is how many scores the board keeps. Writes drop
+	// anything ranked below it.
+	Limit int
+
+	current atomic.Pointer[snapshot]
+}
+
+type snapshot struct {
+	scores  []Score // sorted, and never modified once published
+	version uint64
+	// the snapshots before this one, oldest first and at most HISTORY of
+	// them; their own history is dropped so it doesn't chain back forever
+	history []snapshot
+}
+
+// HISTORY is how many earlier versions of the board At can return.
+const HISTORY = 64
+
+var empty = &snapshot{scores: []Score{}}
+
+func (b *Board) load() *snapshot {
+	if current := b.current.Load(); current != nil {
+		return current
+	}
+	return empty
+}
+
+// publish replaces the snapshot with scores, which must be sorted and not
+// shared with any earlier snapshot.
+func (b *Board) publish(scores []Score) {
+	if b.Limit > 0 && len(scores) > b.Limit {
+		scores = scores[:b.Limit]
+	}
+	old := b.load()
+	history := old.history[max(0, len(old.history)-HISTORY+1):]
+	history = append(slices.Clip(history), snapshot{scores: old.scores, version: old.version})
+	b.current.Store(&snapshot{scores: slices.Clip(scores), version: old.version + 1, history: history})
+}
+
+// Scores returns the scores on the board in rank order. The caller must not
+// modify them.
+func (b *Board) Scores() []Score {
+	return b.load().scores
+}
+
+// Version increases every time the board changes.
+func (b *Board) Version() uint64 {
+	return b.load().version
+}
+
+// View returns the scores on the board and the version they belong to.
+func (b *Board) View() ([]Score, uint64) {
+	current := b.load()
+	return current.scores, current.version
+}
+
+// At returns the scores on the board as of version, if it is the current
+// version or one of the HISTORY before it. The caller must not modify them.
+func (b *Board) At(version uint64) ([]Score, bool) {
+	current := b.load()
+	if version == current.version {
+		return current.scores, true
+	}
+	for _, past := range current.history {
+		if past.version == version {
+			return past.scores, true
+		}
+	}
+	return nil, false
+}
+
+// Top returns the best n scores. The caller must not modify them.
+func (b *Board) Top(n int) []Score {
+	scores := b.load().scores
+	return scores[:min(n, len(scores))]
+}
+
+// insertionIndex is where score goes among sorted scores: after everything
+// that ranks ahead of or level with it.
+func insertionIndex(scores []Score, score Score) int {
+	i, _ := slices.BinarySearchFunc(scores, score, func(other Score, score Score) int {
+		if Cmp(other, score) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	return i
+}
+
+// Rank returns the rank score would have if it were added to the board.
+func (b *Board) Rank(score Score) int {
+	return insertionIndex(b.load().scores, score) + 1
+}
+
+// Leader returns the best score on the board.
+func (b *Board) Leader() (Score, bool) {
+	scores := b.load().scores
+	if len(scores) == 0 {
+		return Score{}, false
+	}
+	return scores[0], true
+}
+
+// Add puts a score on the board.
+func (b *Board) Add(score Score) {
+	scores := b.load().scores
+	b.publish(slices.Insert(slices.Clone(scores), insertionIndex(scores, score), score))
+}
+
+// Remove takes every matching score off the board, returning how many there
+// were.
+func (b *Board) Remove(match func(Score) bool) int {
+	scores := b.load().scores
+	kept := slices.DeleteFunc(slices.Clone(scores), match)
+	removed := len(scores) - len(kept)
+	if removed > 0 {
+		b.publish(kept)
+	}
+	return removed
+}
+
+// Update applies fn to the score with the given ID, reporting whether it is on
+// the board.
+func (b *Board) Update(id string, fn func(*Score)) bool {
+	scores := b.load().scores
+	i := slices.IndexFunc(scores, func(score Score) bool { return score.ID == id })
+	if i < 0 {
+		return false
+	}
+	updated := slices.Clone(scores)
+	fn(&updated[i])
+	slices.SortStableFunc(updated, Cmp)
+	b.publish(updated)
+	return true
+}
+
+// Replace swaps the whole board for scores, in one version.
+func (b *Board) Replace(scores []Score) {
+	scores = slices.Clone(scores)
+	slices.SortStableFunc(scores, Cmp)
+	b.publish(scores)
+}
+
+// Reset clears the board, returning the scores that were on it.
+func (b *Board) Reset() []Score {
+	old := b.load().scores
+	b.publish([]Score{})
+	return old
+}
@@ -0,0 +1,396 @@
+package store
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func score(id string, health int, elapsed float64) Score {
+	return Score{ID: id, PlayerName: id, RemainingHealth: health, Elapsed: elapsed}
+}
+
+func submittedAt(s Score, unix int64) Score {
+	s.Submitted = time.Unix(unix, 0)
+	return s
+}
+
+func normalized(s Score, n float64) Score {
+	s.Normalized = n
+	return s
+}
+
+func ids(scores []Score) []string {
+	var out []string
+	for _, s := range scores {
+		out = append(out, s.ID)
+	}
+	return out
+}
+
+func TestCmp(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Score
+		want int
+	}{
+		{"higher normalized wins", normalized(score("a", 20, 30), 1470), normalized(score("b", 10, 60), 990), -1},
+		{"less health wins", score("a", 10, 60), score("b", 20, 30), -1},
+		{"longer run breaks ties", score("a", 10, 60), score("b", 10, 30), -1},
+		{"earlier submission breaks remaining ties", submittedAt(score("a", 10, 30), 1), submittedAt(score("b", 10, 30), 2), -1},
+		{"equal", score("a", 10, 30), score("b", 10, 30), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Cmp(tt.a, tt.b); got != tt.want {
+				t.Errorf("Cmp = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopSorts(t *testing.T) {
+	var b Board
+	if top := b.Top(3); top == nil || len(top) != 0 {
+		t.Errorf("empty Top = %#v, want an empty slice", top)
+	}
+	for _, s := range []Score{score("c", 30, 1), score("a", 10, 1), score("d", 40, 1), score("b", 20, 1)} {
+		b.Add(s)
+	}
+	if got, want := ids(b.Top(3)), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("Top(3) = %v, want %v", got, want)
+	}
+	if got := len(b.Scores()); got != 4 {
+		t.Errorf("after Top(3) the board holds %v scores, want 4", got)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	b := Board{Limit: 2}
+	for _, s := range []Score{score("c", 30, 1), score("a", 10, 1), score("b", 20, 1)} {
+		b.Add(s)
+	}
+	if got, want := ids(b.Scores()), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("board of 2 = %v, want %v", got, want)
+	}
+}
+
+func TestUpdateResorts(t *testing.T) {
+	var b Board
+	b.Add(score("a", 10, 1))
+	b.Add(score("b", 20, 1))
+	b.Update("b", func(s *Score) { s.RemainingHealth = 0 })
+	if got, want := ids(b.Scores()), []string{"b", "a"}; !slices.Equal(got, want) {
+		t.Errorf("after update = %v, want %v", got, want)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	b := Board{Limit: 2}
+	b.Add(score("x", 0, 1))
+	version := b.Version()
+	b.Replace([]Score{score("c", 30, 1), score("a", 10, 1), score("b", 20, 1)})
+	if got, want := ids(b.Scores()), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("replaced board = %v, want %v", got, want)
+	}
+	if b.Version() != version+1 {
+		t.Errorf("version = %v, want %v", b.Version(), version+1)
+	}
+}
+
+func TestRankAndLeader(t *testing.T) {
+	var b Board
+	if _, ok := b.Leader(); ok {
+		t.Error("empty board has a leader")
+	}
+	b.Add(score("a", 10, 1))
+	b.Add(score("b", 30, 1))
+
+	if leader, _ := b.Leader(); leader.ID != "a" {
+		t.Errorf("Leader = %v, want a", leader.ID)
+	}
+	tests := []struct {
+		score Score
+		want  int
+	}{
+		{score("x", 5, 1), 1},
+		{score("x", 10, 1), 2}, // ties rank behind existing scores
+		{score("x", 20, 1), 2},
+		{score("x", 50, 1), 3},
+	}
+	for _, tt := range tests {
+		if got := b.Rank(tt.score); got != tt.want {
+			t.Errorf("Rank(%v) = %v, want %v", tt.score.RemainingHealth, got, tt.want)
+		}
+	}
+}
+
+func TestWritesLeaveEarlierScoresAlone(t *testing.T) {
+	var b Board
+	b.Add(score("a", 10, 1))
+	b.Add(score("b", 20, 1))
+	before := b.Scores()
+	b.Add(score("c", 5, 1))
+	b.Update("a", func(s *Score) { s.PlayerName = "AAA" })
+	if got := ids(before); !slices.Equal(got, []string{"a", "b"}) || before[0].PlayerName != "a" {
+		t.Errorf("earlier Scores changed to %v", before)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	var b Board
+	steps := []struct {
+		name    string
+		change  func()
+		changed bool
+	}{
+		{"add", func() { b.Add(score("a", 10, 1)) }, true},
+		{"update", func() { b.Update("a", func(s *Score) { s.PlayerName = "AAA" }) }, true},
+		{"update missing", func() { b.Update("zz", func(s *Score) {}) }, false},
+		{"remove missing", func() { b.Remove(func(s Score) bool { return s.ID == "zz" }) }, false},
+		{"top", func() { b.Top(10) }, false},
+		{"remove", func() { b.Remove(func(s Score) bool { return s.ID == "a" }) }, true},
+		{"reset", func() { b.Reset() }, true},
+	}
+	for _, step := range steps {
+		before := b.Version()
+		step.change()
+		if changed := b.Version() != before; changed != step.changed {
+			t.Errorf("%v: version changed = %v, want %v", step.name, changed, step.changed)
+		}
+	}
+}
+
+func TestAt(t *testing.T) {
+	var b Board
+	b.Add(score("a", 10, 1))
+	first := b.Version()
+	for i := range HISTORY {
+		b.Add(score(strconv.Itoa(i), 20, 1))
+	}
+
+	if scores, ok := b.At(b.Version()); !ok || len(scores) != HISTORY+1 {
+		t.Errorf("At(current) = %v, %v", ids(scores), ok)
+	}
+	if scores, ok := b.At(first); !ok || !slices.Equal(ids(scores), []string{"a"}) {
+		t.Errorf("At(%v) = %v, %v, want [a]", first, ids(scores), ok)
+	}
+	if _, ok := b.At(first - 1); ok {
+		t.Errorf("At(%v) found a version older than HISTORY", first-1)
+	}
+	if _, ok := b.At(b.Version() + 1); ok {
+		t.Error("At found a version from the future")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b, c, d := score("a", 10, 1), score("b", 20, 1), score("c", 30, 1), score("d", 40, 1)
+	edited := b
+	edited.PlayerName = "BBB"
+
+	tests := []struct {
+		name     string
+		old, new []Score
+		ok       bool
+	}{
+		{"insert at top", []Score{b, c}, []Score{a, b, c}, true},
+		{"insert pushing one off", []Score{a, c, d}, []Score{a, b, c}, true},
+		{"removal", []Score{a, b, c}, []Score{a, c}, true},
+		{"edit in place", []Score{a, b, c}, []Score{a, edited, c}, true},
+		{"unchanged", []Score{a, b}, []Score{a, b}, true},
+		{"reorder", []Score{a, b, c}, []Score{b, a, c}, false},
+		{"too many ops", []Score{a, b, c}, []Score{d, edited}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, ok := Diff(tt.old, tt.new)
+			if ok != tt.ok {
+				t.Fatalf("Diff ok = %v, want %v (ops %v)", ok, tt.ok, ops)
+			}
+			if !ok {
+				return
+			}
+			// Applying the ops as a client would must give the new board.
+			board, ok := Apply(tt.old, ops)
+			if !ok || !slices.Equal(board, tt.new) {
+				t.Errorf("applying %v gave %v, want %v", ops, ids(board), ids(tt.new))
+			}
+		})
+	}
+}
+
+func TestApplyOutOfStep(t *testing.T) {
+	a := score("a", 10, 1)
+	for _, ops := range [][]PatchOp{
+		{{Op: "remove", Rank: 2}},
+		{{Op: "insert", Rank: 3, Score: &a}},
+		{{Op: "insert", Rank: 1}},
+		{{Op: "replace", Rank: 1, Score: &a}},
+	} {
+		if board, ok := Apply([]Score{a}, ops); ok {
+			t.Errorf("Apply(%v) = %v, want out of step", ops, ids(board))
+		}
+	}
+}
+
+// lockedBoard is how the board used to be read: sorted and truncated under
+// the same mutex writers take.
+type lockedBoard struct {
+	mutex  sync.Mutex
+	scores []Score
+}
+
+func (b *lockedBoard) Add(score Score) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.scores = append(b.scores, score)
+}
+
+func (b *lockedBoard) Top(n int) []Score {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	slices.SortStableFunc(b.scores, Cmp)
+	b.scores = b.scores[:min(n, len(b.scores))]
+	return slices.Clip(b.scores)
+}
+
+// benchmarkReads reads the top 20 from 1000 goroutines, as a tick of 1000 SSE
+// clients would, while another goroutine keeps adding scores.
+func benchmarkReads(b *testing.B, add func(Score), top func(int) []Score) {
+	for i := range 20 {
+		add(score(strconv.Itoa(i), i, 1))
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				add(score(strconv.Itoa(i), i%40, 1))
+			}
+		}
+	}()
+
+	b.SetParallelism(max(1, 1000/runtime.GOMAXPROCS(0)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if len(top(20)) == 0 {
+				b.Error("empty board")
+			}
+		}
+	})
+}
+
+func BenchmarkReads(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) {
+		var board lockedBoard
+		benchmarkReads(b, board.Add, board.Top)
+	})
+	b.Run("snapshot", func(b *testing.B) {
+		board := Board{Limit: 20}
+		var mutex sync.Mutex // writers are still serialized
+		add := func(score Score) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			board.Add(score)
+		}
+		benchmarkReads(b, add, board.Top)
+	})
+}
+
+// BenchmarkAdd inserts into boards already full of scores, each insert
+// sorting the new score in and truncating the board to its limit.
+func BenchmarkAdd(b *testing.B) {
+	for _, size := range []int{20, 100, 1000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			board := Board{Limit: size}
+			for i := range size {
+				board.Add(score(strconv.Itoa(i), i, 1))
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				board.Add(score("x", i%(2*size), 1))
+			}
+		})
+	}
+}
+
+// BenchmarkSortTruncate measures the sort and truncate the board used to do on
+// every read after an insert, for comparison with BenchmarkAdd.
+func BenchmarkSortTruncate(b *testing.B) {
+	for _, size := range []int{20, 100, 1000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			scores := make([]Score, size+1)
+			for i := range scores {
+				scores[i] = score(strconv.Itoa(i), (i*7)%size, 1)
+			}
+			var board lockedBoard
+			b.ResetTimer()
+			for range b.N {
+				board.scores = append(board.scores[:0], scores...)
+				board.Top(size)
+			}
+		})
+	}
+}
+
+// TestMatchesSortOnRead checks the board against what it replaced: every
+// score appended, then stably sorted by Cmp and truncated to the limit.
+func TestMatchesSortOnRead(t *testing.T) {
+	const limit = 10
+	rng := rand.New(rand.NewPCG(1, 2))
+	var model []Score
+	b := Board{Limit: limit}
+	settle := func() {
+		slices.SortStableFunc(model, Cmp)
+		model = model[:min(limit, len(model))]
+	}
+
+	for i := range 2000 {
+		// Few distinct values, so ties are common.
+		s := score(strconv.Itoa(i), rng.IntN(5), float64(rng.IntN(3)))
+
+		ahead := 0
+		for _, other := range model {
+			if Cmp(other, s) <= 0 {
+				ahead++
+			}
+		}
+		if got, want := b.Rank(s), ahead+1; got != want {
+			t.Fatalf("step %v: Rank = %v, want %v", i, got, want)
+		}
+
+		switch op := rng.IntN(10); {
+		case op < 6:
+			b.Add(s)
+			model = append(model, s)
+		case op < 8 && len(model) > 0:
+			health := model[rng.IntN(len(model))].RemainingHealth
+			match := func(s Score) bool { return s.RemainingHealth == health }
+			if got, want := b.Remove(match), len(model)-len(slices.DeleteFunc(slices.Clone(model), match)); got != want {
+				t.Fatalf("step %v: Remove = %v, want %v", i, got, want)
+			}
+			model = slices.DeleteFunc(model, match)
+		case len(model) > 0:
+			id := model[rng.IntN(len(model))].ID
+			health := rng.IntN(5)
+			b.Update(id, func(s *Score) { s.RemainingHealth = health })
+			model[slices.IndexFunc(model, func(s Score) bool { return s.ID == id })].RemainingHealth = health
+		}
+		settle()
+
+		if got := b.Scores(); !slices.Equal(got, model) {
+			t.Fatalf("step %v: board = %v, want %v", i, ids(got), ids(model))
+		}
+		if leader, ok := b.Leader(); ok != (len(model) > 0) || ok && leader != model[0] {
+			t.Fatalf("step %v: Leader = %v, %v", i, leader.ID, ok)
+		}
+	}
+}
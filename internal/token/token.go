@@ -0,0 +1,443 @@
+// Package token mints and checks the start tokens the game fetches when a run
+// begins and sends back with its score. A token is a compact signed set of
+// claims (see package claims): when the run started, a nonce, and what else
+// the server pinned down when it began, such as the board and difficulty. A
+// Finish, minted against a token when the run ends, closes it off, so the two
+// together give the run's length on the server's clock, and a Receipt records
+// where the server placed the score it was submitted with. A server can also
+// make clients solve a Challenge before it mints them a token, and issues
+// registered kiosks a device credential to identify themselves with.
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"elevate2024/internal/claims"
+)
+
+// A Token is a start token as the game receives it. Its fields are there for
+// clients to read; only Signed is trusted, and Check rejects a token whose
+// fields don't match it.
+type Token struct {
+	Start     int64  `json:"start"`
+	StartMs   int64  `json:"start_ms"`
+	Nonce     string `json:"nonce"`
+	ExpiresMs int64  `json:"expires_ms"`
+	// Board names the board the token was minted for, when the server hosts
+	// a named one.
+	Board string `json:"board,omitempty"`
+	// Difficulty is the mode the run was started on, if the client said.
+	Difficulty string `json:"difficulty,omitempty"`
+	// Fingerprint identifies the client that started the run.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Bucket names the experiment variants the run was assigned, if any.
+	Bucket string `json:"bucket,omitempty"`
+	// Work is the difficulty of the proof of work the client did for the
+	// token, if it was asked for one.
+	Work int `json:"work,omitempty"`
+	// Mode is "practice" for a run that only counts on the practice board,
+	// and "competition" or empty for one that counts for real.
+	Mode string `json:"mode,omitempty"`
+	// KeyID names the key that signed the token, if it is published.
+	KeyID string `json:"kid,omitempty"`
+	// Signed is the token in its compact signed form.
+	Signed string `json:"signed"`
+}
+
+func (t Token) claims() claims.Claims {
+	return claims.Claims{
+		StartMs:     t.StartMs,
+		Nonce:       t.Nonce,
+		ExpiresMs:   t.ExpiresMs,
+		Board:       t.Board,
+		Difficulty:  t.Difficulty,
+		Fingerprint: t.Fingerprint,
+		Bucket:      t.Bucket,
+		Work:        t.Work,
+		Mode:        t.Mode,
+		KeyID:       t.KeyID,
+	}
+}
+
+func fromClaims(c claims.Claims, signed string) Token {
+	return Token{
+		Start:       time.UnixMilli(c.StartMs).Unix(),
+		StartMs:     c.StartMs,
+		Nonce:       c.Nonce,
+		ExpiresMs:   c.ExpiresMs,
+		Board:       c.Board,
+		Difficulty:  c.Difficulty,
+		Fingerprint: c.Fingerprint,
+		Bucket:      c.Bucket,
+		Work:        c.Work,
+		Mode:        c.Mode,
+		KeyID:       c.KeyID,
+		Signed:      signed,
+	}
+}
+
+// A Finish records when the run started by a token ended.
+type Finish struct {
+	StartMs int64  `json:"start_ms"`
+	EndMs   int64  `json:"end_ms"`
+	Hmac    string `json:"hmac"`
+}
+
+// Elapsed is how long the run lasted between its token and its finish.
+func (f Finish) Elapsed() time.Duration {
+	return time.Duration(f.EndMs-f.StartMs) * time.Millisecond
+}
+
+// TAG_FINISH starts what a finish's signature covers, so it can't be taken
+// for anything else signed with the same secret.
+const TAG_FINISH = 'f'
+
+// A Receipt is the server's signed acknowledgement of an accepted score: its
+// ID, the rank it took and when. Players keep it to prove what the server
+// said should the score later be moved or removed.
+type Receipt struct {
+	ID          string `json:"id"`
+	Rank        int    `json:"rank"`
+	SubmittedMs int64  `json:"submitted_ms"`
+	Signature   string `json:"signature"`
+}
+
+// TAG_RECEIPT starts what a receipt's signature covers.
+const TAG_RECEIPT = 'r'
+
+// A Challenge is a proof of work for a client to do before it is given a
+// token: finding a nonce such that the SHA-256 of Challenge followed by the
+// nonce starts with Difficulty zero bits. It costs a player's phone a moment
+// once per run, but a bot minting tokens by the thousand far more.
+type Challenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+	ExpiresMs  int64  `json:"expires_ms"`
+}
+
+// TAG_CHALLENGE starts what a challenge's signature covers.
+const TAG_CHALLENGE = 'w'
+
+// CHALLENGE_TTL is how long a client has to solve a challenge.
+const CHALLENGE_TTL = 5 * time.Minute
+
+// TAG_DEVICE starts what a device credential's signature covers.
+const TAG_DEVICE = 'd'
+
+// DEFAULT_TTL is how long a token stays valid unless the Minter says
+// otherwise: well past the longest run anyone plays.
+const DEFAULT_TTL = 2 * time.Hour
+
+// A Run is what a token is minted for, besides when it starts.
+type Run struct {
+	Difficulty  string
+	Fingerprint string
+	Bucket      string
+	// the difficulty of the proof of work done for the token, if any
+	Work int
+	// practice or competition, as the client chose
+	Mode string
+}
+
+// A Minter signs tokens with its key. It is safe for concurrent use, though
+// TTL must be set, and retired keys retained, before it is.
+type Minter struct {
+	key *claims.Key
+	// keys m no longer signs with but still accepts tokens from
+	retired []RetiredKey
+	// secret signs finishes, which only this server ever checks
+	secret []byte
+	board  string
+	minted atomic.Uint64
+	// TTL is how long tokens stay valid; 0 means DEFAULT_TTL.
+	TTL time.Duration
+}
+
+// A RetiredKey is a key a Minter was rotated away from. Tokens it signed are
+// still accepted until NotAfter, by when they have all expired.
+type RetiredKey struct {
+	key      *claims.Key
+	NotAfter time.Time
+}
+
+// ID returns the ID of the retired key, or "" for a shared secret.
+func (r RetiredKey) ID() string {
+	return r.key.ID()
+}
+
+// PublicKey returns the retired key's public key, or nil for a shared secret.
+func (r RetiredKey) PublicKey() ed25519.PublicKey {
+	return r.key.PublicKey()
+}
+
+// NewMinter returns a Minter with a fresh random key, so tokens from earlier
+// runs of the server are rejected.
+func NewMinter() (*Minter, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return NewMinterWithKey(key), nil
+}
+
+// NewMinterWithKey returns a Minter that signs with key, so tokens stay valid
+// for as long as the key does.
+func NewMinterWithKey(key []byte) *Minter {
+	return NewBoardMinter(key, "")
+}
+
+// NewBoardMinter returns a Minter for one of several boards, each with its own
+// key. Its tokens name the board, and it rejects tokens naming another, so
+// the boards can't take each other's runs even by accident.
+func NewBoardMinter(key []byte, board string) *Minter {
+	return &Minter{key: claims.HMACKey(key), secret: key, board: board}
+}
+
+// NewEd25519Minter returns a Minter whose tokens anyone with its public key
+// can verify, but only it can mint.
+func NewEd25519Minter(private ed25519.PrivateKey, board string) *Minter {
+	secret := sha256.Sum256(append(private.Seed(), "finish"...))
+	return &Minter{key: claims.Ed25519Key(private), secret: secret[:], board: board}
+}
+
+// PublicKey returns the key that verifies m's tokens, or nil if they are
+// signed with a shared secret.
+func (m *Minter) PublicKey() ed25519.PublicKey {
+	return m.key.PublicKey()
+}
+
+// KeyID returns the ID of the key that verifies m's tokens, or "" if they are
+// signed with a shared secret.
+func (m *Minter) KeyID() string {
+	return m.key.ID()
+}
+
+// Retain makes m accept tokens old signed until notAfter, so runs started
+// before the key was rotated can still be submitted.
+func (m *Minter) Retain(old *Minter, notAfter time.Time) {
+	m.retired = append(m.retired, RetiredKey{key: old.key, NotAfter: notAfter})
+}
+
+// Retired returns the keys m still accepts tokens from besides its own.
+func (m *Minter) Retired() []RetiredKey {
+	return m.retired
+}
+
+// Board is the board m mints tokens for.
+func (m *Minter) Board() string {
+	return m.board
+}
+
+func (m *Minter) mac(tag byte, text string, values ...int64) []byte {
+	b := []byte{tag}
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint64(b, uint64(v))
+	}
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(append(b, text...))
+	return mac.Sum(nil)
+}
+
+// nonce tells apart tokens minted in the same millisecond. Without the
+// secret it can't be predicted.
+func (m *Minter) nonce(startMs int64) string {
+	return base64.RawURLEncoding.EncodeToString(m.mac('n', "", startMs, int64(m.minted.Add(1)))[:9])
+}
+
+// Mint returns a token for a run starting at now.
+func (m *Minter) Mint(now time.Time, run Run) Token {
+	ttl := m.TTL
+	if ttl == 0 {
+		ttl = DEFAULT_TTL
+	}
+	c := claims.Claims{
+		StartMs:     now.UnixMilli(),
+		ExpiresMs:   now.Add(ttl).UnixMilli(),
+		Board:       m.board,
+		Difficulty:  run.Difficulty,
+		Fingerprint: run.Fingerprint,
+		Bucket:      run.Bucket,
+		Work:        run.Work,
+		Mode:        run.Mode,
+		KeyID:       m.key.ID(),
+	}
+	c.Nonce = m.nonce(c.StartMs)
+	return fromClaims(c, m.key.Sign(c))
+}
+
+// verify checks a token's signature with m's key or, failing that, any key it
+// retains as of now.
+func (m *Minter) verify(signed string, now time.Time) (claims.Claims, error) {
+	wrongKey := func(err error) bool {
+		return errors.Is(err, claims.ErrSignature) || errors.Is(err, claims.ErrVersion)
+	}
+	c, err := m.key.Verify(signed, now)
+	if !wrongKey(err) {
+		return c, err
+	}
+	for _, retired := range m.retired {
+		if !now.Before(retired.NotAfter) {
+			continue
+		}
+		if c, err := retired.key.Verify(signed, now); !wrongKey(err) {
+			return c, err
+		}
+	}
+	return c, err
+}
+
+// Check verifies that a token was minted by m, or by a key it retains, for
+// its board and hasn't expired by now.
+func (m *Minter) Check(token Token, now time.Time) error {
+	c, err := m.verify(token.Signed, now)
+	if err != nil {
+		return fmt.Errorf("token: %w", err)
+	}
+	if c.Board != m.board {
+		return fmt.Errorf("token: minted for board %q, not %q", c.Board, m.board)
+	}
+	if token != fromClaims(c, token.Signed) {
+		return errors.New("token: fields don't match the signed token")
+	}
+	return nil
+}
+
+// Finish returns a finish for the run started by token, ending at now. The
+// caller checks the token first.
+func (m *Minter) Finish(token Token, now time.Time) Finish {
+	end := max(now.UnixMilli(), token.StartMs)
+	return Finish{
+		StartMs: token.StartMs,
+		EndMs:   end,
+		Hmac:    base64.StdEncoding.EncodeToString(m.mac(TAG_FINISH, token.Nonce, token.StartMs, end)),
+	}
+}
+
+// CheckFinish verifies that finish was minted by m for the run started by
+// token.
+func (m *Minter) CheckFinish(finish Finish, token Token) error {
+	if finish.StartMs != token.StartMs {
+		return errors.New("finish: belongs to another run")
+	}
+	signature, err := base64.StdEncoding.DecodeString(finish.Hmac)
+	if err != nil {
+		return fmt.Errorf("finish: malformed signature: %w", err)
+	}
+	if !hmac.Equal(signature, m.mac(TAG_FINISH, token.Nonce, finish.StartMs, finish.EndMs)) {
+		return errors.New("finish: invalid signature")
+	}
+	return nil
+}
+
+// Receipt returns a receipt for the score with the given ID, placed at rank
+// when it was submitted at submitted.
+func (m *Minter) Receipt(id string, rank int, submitted time.Time) Receipt {
+	ms := submitted.UnixMilli()
+	return Receipt{
+		ID:          id,
+		Rank:        rank,
+		SubmittedMs: ms,
+		Signature:   base64.RawURLEncoding.EncodeToString(m.mac(TAG_RECEIPT, id, int64(rank), ms)),
+	}
+}
+
+// CheckReceipt verifies that receipt was issued by m.
+func (m *Minter) CheckReceipt(receipt Receipt) error {
+	signature, err := base64.RawURLEncoding.DecodeString(receipt.Signature)
+	if err != nil {
+		return fmt.Errorf("receipt: malformed signature: %w", err)
+	}
+	if !hmac.Equal(signature, m.mac(TAG_RECEIPT, receipt.ID, int64(receipt.Rank), receipt.SubmittedMs)) {
+		return errors.New("receipt: invalid signature")
+	}
+	return nil
+}
+
+// Challenge returns a new challenge of the given difficulty for the client
+// with fingerprint, which only that client can redeem. The challenge is
+// signed rather than stored: "EXPIRES.DIFFICULTY.NONCE.SIGNATURE".
+func (m *Minter) Challenge(now time.Time, difficulty int, fingerprint string) Challenge {
+	expires := now.Add(CHALLENGE_TTL).UnixMilli()
+	nonce := m.nonce(now.UnixMilli())
+	signature := m.mac(TAG_CHALLENGE, nonce+"\x00"+fingerprint, expires, int64(difficulty))
+	return Challenge{
+		Challenge:  fmt.Sprintf("%d.%d.%s.%s", expires, difficulty, nonce, base64.RawURLEncoding.EncodeToString(signature[:12])),
+		Difficulty: difficulty,
+		ExpiresMs:  expires,
+	}
+}
+
+// CheckWork verifies that nonce solves a challenge m issued to the client
+// with fingerprint, and that it hasn't expired by now. It returns the
+// challenge, for the caller to see that it was hard enough and to refuse it
+// a second time.
+func (m *Minter) CheckWork(challenge string, nonce string, fingerprint string, now time.Time) (Challenge, error) {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return Challenge{}, errors.New("challenge: malformed")
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Challenge{}, errors.New("challenge: malformed expiry")
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Challenge{}, errors.New("challenge: malformed difficulty")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !hmac.Equal(signature, m.mac(TAG_CHALLENGE, parts[2]+"\x00"+fingerprint, expires, int64(difficulty))[:12]) {
+		return Challenge{}, errors.New("challenge: invalid signature")
+	}
+	if now.UnixMilli() > expires {
+		return Challenge{}, errors.New("challenge: expired")
+	}
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	if zeroBits(sum[:]) < difficulty {
+		return Challenge{}, errors.New("challenge: nonce doesn't solve it")
+	}
+	return Challenge{Challenge: challenge, Difficulty: difficulty, ExpiresMs: expires}, nil
+}
+
+// zeroBits counts the zero bits b starts with.
+func zeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Device returns a credential for the registered device with the given ID,
+// as "ID.SIGNATURE". It doesn't expire; the server keeps a list of revoked
+// devices instead.
+func (m *Minter) Device(id string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(m.mac(TAG_DEVICE, id))
+}
+
+// CheckDevice verifies that m issued credential, returning its device's ID.
+func (m *Minter) CheckDevice(credential string) (string, error) {
+	id, encoded, ok := strings.Cut(credential, ".")
+	if !ok {
+		return "", errors.New("device: malformed credential")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal(signature, m.mac(TAG_DEVICE, id)) {
+		return "", errors.New("device: invalid credential")
+	}
+	return id, nil
+}
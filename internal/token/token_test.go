@@ -0,0 +1,360 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMintedTokensCheck(t *testing.T) {
+	m, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.UnixMilli(1700000000123)
+	token := m.Mint(now, Run{})
+	if token.Start != now.Unix() || token.StartMs != now.UnixMilli() {
+		t.Errorf("Start, StartMs = %v, %v, want %v, %v", token.Start, token.StartMs, now.Unix(), now.UnixMilli())
+	}
+	if token.ExpiresMs != now.Add(DEFAULT_TTL).UnixMilli() || token.Nonce == "" {
+		t.Errorf("ExpiresMs, Nonce = %v, %q", token.ExpiresMs, token.Nonce)
+	}
+	if err := m.Check(token, now); err != nil {
+		t.Errorf("Check(minted token) = %v", err)
+	}
+	run := Run{Difficulty: "hard", Fingerprint: "fp", Bucket: "speed=fast", Mode: "practice"}
+	if err := m.Check(m.Mint(now, run), now); err != nil {
+		t.Errorf("Check(minted token with claims) = %v", err)
+	}
+	if other := m.Mint(now, Run{}); other.Nonce == token.Nonce {
+		t.Error("two tokens minted at once have the same nonce")
+	}
+}
+
+func TestCheckRejects(t *testing.T) {
+	m, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	token := m.Mint(now, Run{Bucket: "speed=fast"})
+	edited := func(edit func(*Token)) Token {
+		t := token
+		edit(&t)
+		return t
+	}
+
+	tests := []struct {
+		name  string
+		token Token
+	}{
+		{"moved start", edited(func(t *Token) { t.Start -= 60 })},
+		{"moved start_ms", edited(func(t *Token) { t.StartMs -= 60000 })},
+		{"later expiry", edited(func(t *Token) { t.ExpiresMs += 60000 })},
+		{"changed bucket", edited(func(t *Token) { t.Bucket = "speed=slow" })},
+		{"dropped bucket", edited(func(t *Token) { t.Bucket = "" })},
+		{"added difficulty", edited(func(t *Token) { t.Difficulty = "easy" })},
+		{"malformed signature", edited(func(t *Token) { t.Signed = "not a token" })},
+		{"empty signature", edited(func(t *Token) { t.Signed = "" })},
+		{"legacy token", Token{Start: token.Start, StartMs: token.StartMs}},
+		{"other key", other.Mint(now, Run{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Check(tt.token, now); err == nil {
+				t.Error("Check succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	m := NewMinterWithKey([]byte("key"))
+	m.TTL = time.Minute
+	now := time.Unix(1700000000, 0)
+	token := m.Mint(now, Run{})
+	if err := m.Check(token, now.Add(59*time.Second)); err != nil {
+		t.Errorf("Check before expiry = %v", err)
+	}
+	if err := m.Check(token, now.Add(time.Minute)); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Check after expiry = %v, want expired", err)
+	}
+}
+
+func TestEd25519Minter(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewEd25519Minter(private, "demo")
+	now := time.Unix(1700000000, 0)
+	token := m.Mint(now, Run{})
+	if !strings.HasPrefix(token.Signed, "e1.") || !m.PublicKey().Equal(private.Public()) {
+		t.Errorf("token %q, public key %x", token.Signed, m.PublicKey())
+	}
+	if err := m.Check(token, now); err != nil {
+		t.Errorf("Check(minted token) = %v", err)
+	}
+	finish := m.Finish(token, now.Add(time.Second))
+	if err := m.CheckFinish(finish, token); err != nil {
+		t.Errorf("CheckFinish(minted finish) = %v", err)
+	}
+
+	// Anyone can verify with the public key, so make sure it can't sign.
+	forged := NewBoardMinter(m.PublicKey(), "demo").Mint(now, Run{})
+	if err := m.Check(forged, now); err == nil {
+		t.Error("accepted an HMAC token keyed with the public key")
+	}
+	if NewMinterWithKey([]byte("key")).PublicKey() != nil {
+		t.Error("an HMAC minter has a public key")
+	}
+}
+
+func TestFinish(t *testing.T) {
+	m, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.UnixMilli(1700000000123)
+	token := m.Mint(start, Run{})
+	finish := m.Finish(token, start.Add(42310*time.Millisecond))
+	if err := m.CheckFinish(finish, token); err != nil {
+		t.Fatalf("CheckFinish(minted finish) = %v", err)
+	}
+	if got, want := finish.Elapsed(), 42310*time.Millisecond; got != want {
+		t.Errorf("Elapsed() = %v, want %v", got, want)
+	}
+
+	// A clock that steps backwards doesn't make for a negative run.
+	if got := m.Finish(token, start.Add(-time.Second)).Elapsed(); got != 0 {
+		t.Errorf("Elapsed() before the start = %v, want 0", got)
+	}
+
+	other := m.Mint(start.Add(time.Second), Run{})
+	// Minted in the same millisecond, so only the nonce tells them apart.
+	twin := m.Mint(start, Run{})
+	tests := []struct {
+		name   string
+		finish Finish
+		token  Token
+	}{
+		{"moved end", Finish{StartMs: finish.StartMs, EndMs: finish.EndMs + 10000, Hmac: finish.Hmac}, token},
+		{"other run", finish, other},
+		{"same start", finish, twin},
+		{"empty signature", Finish{StartMs: finish.StartMs, EndMs: finish.EndMs}, token},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.CheckFinish(tt.finish, tt.token); err == nil {
+				t.Error("CheckFinish succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	m, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt := m.Receipt("abc", 3, time.UnixMilli(1700000000123))
+	if err := m.CheckReceipt(receipt); err != nil {
+		t.Fatalf("CheckReceipt(issued receipt) = %v", err)
+	}
+
+	other, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	promoted := receipt
+	promoted.Rank = 1
+	renamed := receipt
+	renamed.ID = "abd"
+	tests := []struct {
+		name    string
+		minter  *Minter
+		receipt Receipt
+	}{
+		{"better rank", m, promoted},
+		{"other score", m, renamed},
+		{"other server", other, receipt},
+		{"empty signature", m, Receipt{ID: receipt.ID, Rank: receipt.Rank, SubmittedMs: receipt.SubmittedMs}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.minter.CheckReceipt(tt.receipt); err == nil {
+				t.Error("CheckReceipt succeeded, want an error")
+			}
+		})
+	}
+}
+
+func solves(challenge Challenge, nonce string) bool {
+	sum := sha256.Sum256([]byte(challenge.Challenge + nonce))
+	return zeroBits(sum[:]) >= challenge.Difficulty
+}
+
+// solve finds a nonce for challenge by brute force, as a client would.
+func solve(challenge Challenge) string {
+	for i := 0; ; i++ {
+		if nonce := strconv.Itoa(i); solves(challenge, nonce) {
+			return nonce
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	m, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.UnixMilli(1700000000123)
+	challenge := m.Challenge(now, 12, "phone")
+	nonce := solve(challenge)
+	got, err := m.CheckWork(challenge.Challenge, nonce, "phone", now)
+	if err != nil {
+		t.Fatalf("CheckWork(solved) = %v", err)
+	}
+	if got != challenge {
+		t.Errorf("CheckWork = %+v, want %+v", got, challenge)
+	}
+
+	wrong := "x"
+	for solves(challenge, wrong) {
+		wrong += "x"
+	}
+	easier := strings.Replace(challenge.Challenge, ".12.", ".1.", 1)
+	tests := []struct {
+		name        string
+		challenge   string
+		nonce       string
+		fingerprint string
+		now         time.Time
+	}{
+		{"unsolved", challenge.Challenge, wrong, "phone", now},
+		{"other client", challenge.Challenge, nonce, "bot", now},
+		{"expired", challenge.Challenge, nonce, "phone", now.Add(CHALLENGE_TTL + time.Second)},
+		{"lowered difficulty", easier, solve(Challenge{Challenge: easier, Difficulty: 1}), "phone", now},
+		{"malformed", "nope", nonce, "phone", now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.CheckWork(tt.challenge, tt.nonce, tt.fingerprint, tt.now); err == nil {
+				t.Error("CheckWork succeeded")
+			}
+		})
+	}
+}
+
+func TestDevice(t *testing.T) {
+	m, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := m.Device("booth-1")
+	if id, err := m.CheckDevice(credential); err != nil || id != "booth-1" {
+		t.Fatalf("CheckDevice(issued) = %q, %v", id, err)
+	}
+	other, err := NewMinter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signature, _ := strings.Cut(credential, ".")
+	for name, check := range map[string]func() error{
+		"other server": func() error { _, err := other.CheckDevice(credential); return err },
+		"other device": func() error { _, err := m.CheckDevice("booth-2." + signature); return err },
+		"no signature": func() error { _, err := m.CheckDevice("booth-1"); return err },
+	} {
+		if check() == nil {
+			t.Errorf("%v: CheckDevice succeeded", name)
+		}
+	}
+}
+
+func TestBoardMinter(t *testing.T) {
+	demo := NewBoardMinter([]byte("demo key"), "demo")
+	competition := NewBoardMinter([]byte("competition key"), "competition")
+	now := time.Unix(1700000000, 0)
+
+	token := demo.Mint(now, Run{Bucket: "speed=fast"})
+	if token.Board != "demo" {
+		t.Errorf("Board = %q, want demo", token.Board)
+	}
+	if err := demo.Check(token, now); err != nil {
+		t.Errorf("Check(own token) = %v", err)
+	}
+	if err := competition.Check(token, now); err == nil {
+		t.Error("competition board accepted a demo token")
+	}
+	// A leaked demo key only mints tokens the demo board's key signed.
+	forged := NewBoardMinter([]byte("demo key"), "competition").Mint(now, Run{})
+	if err := competition.Check(forged, now); err == nil {
+		t.Error("competition board accepted a token signed with the demo key")
+	}
+	relabeled := token
+	relabeled.Board = ""
+	if err := NewMinterWithKey([]byte("demo key")).Check(relabeled, now); err == nil {
+		t.Error("unnamed board accepted a demo token with its board removed")
+	}
+}
+
+func TestRetain(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	old := NewBoardMinter([]byte("old key"), "demo")
+	started := old.Mint(now, Run{})
+
+	rotated := NewBoardMinter([]byte("new key"), "demo")
+	if err := rotated.Check(started, now); err == nil {
+		t.Fatal("accepted a token signed with a key it doesn't retain")
+	}
+	rotated.Retain(old, now.Add(time.Hour))
+	if err := rotated.Check(started, now.Add(time.Minute)); err != nil {
+		t.Errorf("Check(token from the retired key) = %v", err)
+	}
+	if err := rotated.Check(rotated.Mint(now, Run{}), now); err != nil {
+		t.Errorf("Check(own token) = %v", err)
+	}
+	if err := rotated.Check(started, now.Add(time.Hour)); err == nil {
+		t.Error("accepted a token from a retired key past its not-after")
+	}
+	if err := rotated.Check(NewBoardMinter([]byte("other key"), "demo").Mint(now, Run{}), now); err == nil {
+		t.Error("accepted a token signed with an unknown key")
+	}
+	if retired := rotated.Retired(); len(retired) != 1 || !retired[0].NotAfter.Equal(now.Add(time.Hour)) {
+		t.Errorf("Retired() = %+v, want the old key until an hour from now", retired)
+	}
+}
+
+func BenchmarkMint(b *testing.B) {
+	m, err := NewMinter()
+	if err != nil {
+		b.Fatal(err)
+	}
+	now := time.Now()
+	for range b.N {
+		m.Mint(now, Run{})
+	}
+}
+
+func BenchmarkCheck(b *testing.B) {
+	m, err := NewMinter()
+	if err != nil {
+		b.Fatal(err)
+	}
+	now := time.Now()
+	token := m.Mint(now, Run{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := m.Check(token, now); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
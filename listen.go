@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lanURL guesses the URL other devices on the network can reach us at. Dialing
+// UDP sends no packets but makes the kernel pick the outbound interface.
+func lanURL(scheme string, addr *net.TCPAddr) string {
+	host := addr.IP.String()
+	if addr.IP.IsUnspecified() {
+		host = "localhost"
+		if conn, err := net.Dial("udp", "203.0.113.1:9"); err == nil {
+			host = conn.LocalAddr().(*net.UDPAddr).IP.String()
+			conn.Close()
+		}
+	}
+	return fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host, strconv.Itoa(addr.Port)))
+}
+
+// A listenSpec is one -host: an address to listen on, and the routes served
+// there if not all of them, so e.g. a tailscale address can be kept to admin
+// routes while kiosks on the venue LAN get the rest.
+type listenSpec struct {
+	host   string
+	routes []string
+}
+
+// parseListenSpec reads a -host: "HOST" or "HOST=PATH,...". A PATH ending in
+// / covers everything under it, as with http.ServeMux.
+func parseListenSpec(spec string) (listenSpec, error) {
+	host, routes, restricted := strings.Cut(spec, "=")
+	l := listenSpec{host: host}
+	if !restricted {
+		return l, nil
+	}
+	for _, route := range strings.Split(routes, ",") {
+		if !strings.HasPrefix(route, "/") {
+			return listenSpec{}, fmt.Errorf("-host %q: route %q must start with /", spec, route)
+		}
+		l.routes = append(l.routes, route)
+	}
+	return l, nil
+}
+
+// restrict serves only l's routes from next, and 404 for the rest.
+func (l listenSpec) restrict(next http.Handler) http.Handler {
+	if l.routes == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range l.routes {
+			if r.URL.Path == route || strings.HasSuffix(route, "/") && strings.HasPrefix(r.URL.Path, route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// httpLimits bound how long a client may take over each part of a request,
+// so one that trickles out its headers or body can't hold a connection
+// forever. Streams lift the read and write timeouts for themselves, bounding
+// each write instead.
+type httpLimits struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+}
+
+func (l httpLimits) server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: l.readHeaderTimeout,
+		ReadTimeout:       l.readTimeout,
+		WriteTimeout:      l.writeTimeout,
+		IdleTimeout:       l.idleTimeout,
+		MaxHeaderBytes:    l.maxHeaderBytes,
+	}
+}
+
+// listenAdmin opens the separate admin listener. With a certificate it serves
+// TLS, and with a client CA bundle it additionally refuses any connection that
+// doesn't present a certificate signed by one of those CAs.
+func listenAdmin(host string, certFile string, keyFile string, clientCAFile string) (net.Listener, error) {
+	if certFile == "" && keyFile == "" && clientCAFile == "" {
+		l, err := net.Listen("tcp", host)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Serving admin routes on http://%v/\n", l.Addr())
+		return l, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-admin-tls-cert and -admin-tls-key must be given together")
+	}
+
+	config, err := loadTLSConfig(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	l, err := tls.Listen("tcp", host, config)
+	if err != nil {
+		return nil, err
+	}
+	if config.ClientCAs != nil {
+		log.Printf("Serving admin routes on https://%v/ (client certificate required)\n", l.Addr())
+	} else {
+		log.Printf("Serving admin routes on https://%v/\n", l.Addr())
+	}
+	return l, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"elevate2024/internal/server"
+)
+
+// A loadTest plays a crowd against a running server: players start runs,
+// send heartbeats while they play, finish and submit a score at the end, then
+// check the board, while spectators keep /events open.
+type loadTest struct {
+	URL       string
+	Players   int
+	Watchers  int
+	Duration  time.Duration
+	RunLength time.Duration
+}
+
+// LOADTEST_ENDPOINTS are the endpoints a load test reports on, in order.
+var LOADTEST_ENDPOINTS = []string{"/start", "/heartbeat", "/finish", "/record", "/scores", "/events"}
+
+// endpointStats collects the outcome of every request to one endpoint.
+type endpointStats struct {
+	mutex     sync.Mutex
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+func (e *endpointStats) record(start time.Time, status int, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err != nil {
+		e.errors++
+		return
+	}
+	if e.statuses == nil {
+		e.statuses = map[int]int{}
+	}
+	e.statuses[status]++
+	e.latencies = append(e.latencies, time.Since(start))
+}
+
+func (e *endpointStats) count() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.latencies) + e.errors
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(len(sorted)-1, int(float64(len(sorted))*p))]
+}
+
+type loadTestRun struct {
+	loadTest
+	client    *http.Client
+	endpoints map[string]*endpointStats
+	events    atomic.Int64
+	watching  atomic.Int64
+}
+
+func (l *loadTestRun) do(endpoint string, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := l.client.Do(req)
+	if err != nil {
+		l.endpoints[endpoint].record(start, 0, err)
+		return nil, err
+	}
+	l.endpoints[endpoint].record(start, resp.StatusCode, nil)
+	return resp, nil
+}
+
+func (l *loadTestRun) post(ctx context.Context, endpoint string, body any) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", l.URL+endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return l.do(endpoint, req)
+}
+
+// sleep waits for d, reporting false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// play runs one player's games back to back until ctx ends.
+func (l *loadTestRun) play(ctx context.Context) {
+	for ctx.Err() == nil {
+		req, _ := http.NewRequestWithContext(ctx, "GET", l.URL+"/start", nil)
+		resp, err := l.do("/start", req)
+		if err != nil {
+			sleep(ctx, time.Second)
+			continue
+		}
+		var token server.Token
+		err = json.NewDecoder(resp.Body).Decode(&token)
+		resp.Body.Close()
+		if err != nil {
+			sleep(ctx, time.Second)
+			continue
+		}
+
+		// Runs vary in length around RunLength, heartbeating as the game does.
+		started := time.Now()
+		length := time.Duration(float64(l.RunLength) * (0.5 + rand.Float64()))
+		for time.Since(started) < length {
+			if !sleep(ctx, min(server.HEARTBEAT_INTERVAL, length-time.Since(started))) {
+				return
+			}
+			if resp, err := l.post(ctx, "/heartbeat", map[string]any{"token": token}); err == nil {
+				resp.Body.Close()
+			}
+		}
+
+		var finish *server.Finish
+		if resp, err := l.post(ctx, "/finish", map[string]any{"token": token}); err == nil {
+			if resp.StatusCode == http.StatusCreated {
+				json.NewDecoder(resp.Body).Decode(&finish)
+			}
+			resp.Body.Close()
+		}
+
+		name := string([]byte{byte('A' + rand.IntN(26)), byte('A' + rand.IntN(26)), byte('A' + rand.IntN(26))})
+		resp, err = l.post(ctx, "/record", map[string]any{
+			"player_name":      name,
+			"elapsed":          time.Since(started).Truncate(time.Second).Seconds(),
+			"remaining_health": rand.IntN(100),
+			"token":            token,
+			"finish":           finish,
+		})
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		// Players look for themselves on the board afterwards.
+		req, _ = http.NewRequestWithContext(ctx, "GET", l.URL+"/scores", nil)
+		if resp, err := l.do("/scores", req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+}
+
+// watch keeps an /events stream open until ctx ends, reconnecting if it
+// drops.
+func (l *loadTestRun) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		req, _ := http.NewRequestWithContext(ctx, "GET", l.URL+"/events", nil)
+		resp, err := l.do("/events", req)
+		if err != nil {
+			sleep(ctx, time.Second)
+			continue
+		}
+		l.watching.Add(1)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "data:") {
+				l.events.Add(1)
+			}
+		}
+		l.watching.Add(-1)
+		resp.Body.Close()
+	}
+}
+
+// runLoadTest plays the load test and prints a report of what came back.
+func runLoadTest(test loadTest) error {
+	test.URL = strings.TrimSuffix(test.URL, "/")
+	l := &loadTestRun{
+		loadTest: test,
+		client: &http.Client{Transport: &http.Transport{
+			MaxIdleConnsPerHost: test.Players + test.Watchers,
+		}},
+		endpoints: map[string]*endpointStats{},
+	}
+	for _, endpoint := range LOADTEST_ENDPOINTS {
+		l.endpoints[endpoint] = &endpointStats{}
+	}
+
+	// Fail early rather than report a wall of errors.
+	resp, err := l.client.Get(test.URL + "/scores")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v/scores: %v", test.URL, resp.Status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), test.Duration)
+	defer cancel()
+
+	fmt.Printf("Load testing %v with %v players and %v watchers for %v\n", test.URL, test.Players, test.Watchers, test.Duration)
+	var wg sync.WaitGroup
+	for range test.Watchers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.watch(ctx)
+		}()
+	}
+	for range test.Players {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Stagger the players so their runs don't all end together.
+			if sleep(ctx, rand.N(test.RunLength)) {
+				l.play(ctx)
+			}
+		}()
+	}
+
+	progress := time.NewTicker(5 * time.Second)
+	defer progress.Stop()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-progress.C:
+			fmt.Printf("%v watching, %v events, %v submissions\n", l.watching.Load(), l.events.Load(), l.endpoints["/record"].count())
+		case <-done:
+			running = false
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "endpoint\trequests\terrors\tstatuses\tp50\tp95\tp99\tmax")
+	for _, endpoint := range LOADTEST_ENDPOINTS {
+		e := l.endpoints[endpoint]
+		slices.Sort(e.latencies)
+		var statuses []string
+		for status, n := range e.statuses {
+			statuses = append(statuses, fmt.Sprintf("%d×%d", status, n))
+		}
+		slices.Sort(statuses)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", endpoint, len(e.latencies)+e.errors, e.errors, strings.Join(statuses, " "),
+			percentile(e.latencies, 0.5).Round(time.Microsecond), percentile(e.latencies, 0.95).Round(time.Microsecond),
+			percentile(e.latencies, 0.99).Round(time.Microsecond), percentile(e.latencies, 1).Round(time.Microsecond))
+	}
+	w.Flush()
+	fmt.Printf("%v SSE events received\n", l.events.Load())
+	return nil
+}
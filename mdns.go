@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const MDNS_SERVICE = "_highscore._tcp.local."
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// An mdnsResponder answers multicast DNS queries for a single DNS-SD service
+// instance, so displays on the LAN can find the server without knowing its
+// address or port ahead of time.
+type mdnsResponder struct {
+	conn     *net.UDPConn
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	ips      []net.IP
+}
+
+// advertiseMDNS starts answering queries for _highscore._tcp on the given port.
+func advertiseMDNS(port int) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	hostname = strings.Split(hostname, ".")[0]
+
+	var ips []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			ips = append(ips, ipnet.IP.To4())
+		}
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no IPv4 addresses to advertise")
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+
+	m := &mdnsResponder{
+		conn:     conn,
+		service:  dnsmessage.MustNewName(MDNS_SERVICE),
+		instance: dnsmessage.MustNewName("highscore-" + hostname + "." + MDNS_SERVICE),
+		host:     dnsmessage.MustNewName(hostname + ".local."),
+		port:     uint16(port),
+		ips:      ips,
+	}
+	go m.serve()
+	return nil
+}
+
+func (m *mdnsResponder) records() []dnsmessage.Resource {
+	// Records are announced with a 2 minute TTL; the recommended 75 minutes is
+	// too long for a server whose port changes on every restart.
+	hdr := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	rs := []dnsmessage.Resource{
+		{Header: hdr(dnsmessage.MustNewName("_services._dns-sd._udp.local."), dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: m.service}},
+		{Header: hdr(m.service, dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: m.instance}},
+		{Header: hdr(m.instance, dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Target: m.host, Port: m.port}},
+		{Header: hdr(m.instance, dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"path=/"}}},
+	}
+	for _, ip := range m.ips {
+		var a [4]byte
+		copy(a[:], ip)
+		rs = append(rs, dnsmessage.Resource{Header: hdr(m.host, dnsmessage.TypeA), Body: &dnsmessage.AResource{A: a}})
+	}
+	return rs
+}
+
+// answer returns the records matching the given questions.
+func (m *mdnsResponder) answer(questions []dnsmessage.Question) []dnsmessage.Resource {
+	all := m.records()
+	var answers []dnsmessage.Resource
+	for _, q := range questions {
+		for _, rr := range all {
+			if strings.EqualFold(q.Name.String(), rr.Header.Name.String()) &&
+				(q.Type == rr.Header.Type || q.Type == dnsmessage.TypeALL) {
+				answers = append(answers, rr)
+			}
+		}
+	}
+	return answers
+}
+
+// additionals returns the records a browser will need next, so that a single
+// PTR query is enough to resolve the instance to an address and port.
+func (m *mdnsResponder) additionals(answers []dnsmessage.Resource) []dnsmessage.Resource {
+	var extra []dnsmessage.Resource
+	for _, rr := range m.records() {
+		if rr.Header.Type == dnsmessage.TypePTR || slices.ContainsFunc(answers, func(a dnsmessage.Resource) bool {
+			return a.Header.Type == rr.Header.Type && a.Header.Name == rr.Header.Name
+		}) {
+			continue
+		}
+		extra = append(extra, rr)
+	}
+	return extra
+}
+
+func (m *mdnsResponder) pack(msg dnsmessage.Message) []byte {
+	msg.Header.Response = true
+	msg.Header.Authoritative = true
+	b, err := msg.Pack()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return b
+}
+
+func (m *mdnsResponder) serve() {
+	// Announce ourselves a couple of times so browsers that are already
+	// listening pick up the new port straight away.
+	for i := 0; i < 2; i++ {
+		announcement := dnsmessage.Message{Answers: m.records()}
+		if _, err := m.conn.WriteToUDP(m.pack(announcement), mdnsGroup); err != nil {
+			log.Println(err)
+		}
+		time.Sleep(time.Second)
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || msg.Header.Response {
+			continue
+		}
+		answers := m.answer(msg.Questions)
+		if len(answers) == 0 {
+			continue
+		}
+
+		// Queries from a port other than 5353 are one-shot legacy queries
+		// and are answered directly, echoing the query ID and questions.
+		resp := dnsmessage.Message{Answers: answers, Additionals: m.additionals(answers)}
+		dest := mdnsGroup
+		if from.Port != mdnsGroup.Port {
+			dest = from
+			resp.Header.ID = msg.Header.ID
+			resp.Questions = msg.Questions
+		}
+		if _, err := m.conn.WriteToUDP(m.pack(resp), dest); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// requestedPort returns the port in a -host value, or 0 if it asks for any.
+func requestedPort(host string) int {
+	_, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"elevate2024/client"
+	"elevate2024/internal/server"
+)
+
+// Sizes of the monitor's sections.
+const (
+	MONITOR_TOP     = 20
+	MONITOR_REASONS = 8
+	MONITOR_RECENT  = 8
+)
+
+// A monitor follows a server from the terminal, for staff who only have SSH:
+// the top of the board from /events, and submissions and load from
+// /admin/events.
+type monitor struct {
+	mutex    sync.Mutex
+	server   string
+	board    []server.Score
+	status   server.ServerStatus
+	attempts []monitorAttempt
+	reasons  map[string]int
+	recent   []server.SubmissionEvent
+	// why the admin stream is down, if it is
+	err error
+}
+
+type monitorAttempt struct {
+	time     time.Time
+	accepted bool
+}
+
+func runMonitor(flags *adminFlags, args []string) error {
+	public := flags.String("public", "", "URL of the server's public routes, if not the same as -server")
+	refresh := flags.Duration("refresh", time.Second, "how often to redraw")
+	a, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	if *public == "" {
+		*public = a.url
+	}
+	// Streams stay open, so they can't have the admin client's timeout.
+	streaming := *a.client
+	streaming.Timeout = 0
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	m := &monitor{server: a.url, reasons: map[string]int{}}
+	boards, err := (&client.Client{URL: *public, HTTP: &streaming}).StreamScores(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for board := range boards {
+			m.mutex.Lock()
+			m.board = board
+			m.mutex.Unlock()
+		}
+	}()
+	go m.follow(ctx, a, &streaming)
+
+	// Draw on the terminal's alternate screen, so the shell is left as it
+	// was on exit.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+	ticker := time.NewTicker(*refresh)
+	defer ticker.Stop()
+	for {
+		m.draw(os.Stdout)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// follow reads /admin/events until ctx is done, reconnecting whenever the
+// stream drops.
+func (m *monitor) follow(ctx context.Context, a *adminClient, httpClient *http.Client) {
+	for ctx.Err() == nil {
+		err := m.read(ctx, a, httpClient)
+		m.mutex.Lock()
+		m.err = err
+		m.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (m *monitor) read(ctx context.Context, a *adminClient, httpClient *http.Client) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.url+"/admin/events", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("admin", a.password)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/admin/events: %v", resp.Status)
+	}
+
+	m.mutex.Lock()
+	m.err = nil
+	m.mutex.Unlock()
+	err = client.ReadEvents(resp.Body, func(event string, data []byte) error {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		switch event {
+		case "status":
+			return json.Unmarshal(data, &m.status)
+		case "message":
+			var submission server.SubmissionEvent
+			if err := json.Unmarshal(data, &submission); err != nil {
+				return err
+			}
+			m.record(submission)
+		}
+		return nil
+	})
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// numbers are blanked out of rejection reasons, so that e.g. every "elapsed
+// time exceeds token age" is counted together.
+var numbers = regexp.MustCompile(`[0-9][0-9.]*`)
+
+func (m *monitor) record(event server.SubmissionEvent) {
+	now := time.Now()
+	m.attempts = slices.DeleteFunc(m.attempts, func(a monitorAttempt) bool { return now.Sub(a.time) > 10*time.Minute })
+	m.attempts = append(m.attempts, monitorAttempt{now, event.Accepted})
+	switch {
+	case !event.Accepted:
+		m.reasons[numbers.ReplaceAllString(event.Reason, "#")]++
+	case event.Quarantined != "":
+		m.reasons["held for review"]++
+	}
+	m.recent = append(m.recent, event)
+	if len(m.recent) > MONITOR_RECENT {
+		m.recent = m.recent[len(m.recent)-MONITOR_RECENT:]
+	}
+}
+
+// rate counts accepted and rejected attempts within window.
+func (m *monitor) rate(window time.Duration) (int, int) {
+	accepted, rejected := 0, 0
+	for _, a := range m.attempts {
+		if time.Since(a.time) > window {
+			continue
+		}
+		if a.accepted {
+			accepted++
+		} else {
+			rejected++
+		}
+	}
+	return accepted, rejected
+}
+
+func (m *monitor) draw(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "Monitoring %v at %v (Ctrl-C to quit)\n", m.server, time.Now().Format(time.TimeOnly))
+	if m.err != nil {
+		fmt.Fprintf(&b, "\x1b[31mAdmin stream down, retrying: %v\x1b[0m\n", m.err)
+	}
+	queue := m.status.Queue
+	fmt.Fprintf(&b, "Watching %d   Playing %d   Queue %d/%d (max %d, turned away %d)\n",
+		m.status.Watchers, m.status.Playing, queue.Depth, queue.Capacity, queue.MaxDepth, queue.Rejected)
+	accepted, rejected := m.rate(time.Minute)
+	accepted10, rejected10 := m.rate(10 * time.Minute)
+	fmt.Fprintf(&b, "Submissions   last minute: %d accepted, %d rejected   last 10 minutes: %d accepted, %d rejected\n\n",
+		accepted, rejected, accepted10, rejected10)
+
+	table := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "RANK\tNAME\tHEALTH\tTIME\tDIFFICULTY\tSCORE")
+	for i, score := range m.board[:min(MONITOR_TOP, len(m.board))] {
+		fmt.Fprintf(table, "%d\t%s\t%d\t%.2fs\t%s\t%g\n", i+1, score.PlayerName, score.RemainingHealth, score.Elapsed, score.Difficulty, score.Normalized)
+	}
+	table.Flush()
+
+	b.WriteString("\nREJECTIONS\n")
+	reasons := make([]string, 0, len(m.reasons))
+	for reason := range m.reasons {
+		reasons = append(reasons, reason)
+	}
+	slices.SortFunc(reasons, func(a, b string) int { return m.reasons[b] - m.reasons[a] })
+	for _, reason := range reasons[:min(MONITOR_REASONS, len(reasons))] {
+		fmt.Fprintf(&b, "%6d  %s\n", m.reasons[reason], reason)
+	}
+
+	b.WriteString("\nRECENT\n")
+	for i := len(m.recent) - 1; i >= 0; i-- {
+		event := m.recent[i]
+		outcome := fmt.Sprintf("\x1b[32maccepted\x1b[0m #%d", event.Rank)
+		switch {
+		case !event.Accepted:
+			outcome = "\x1b[31mrejected\x1b[0m: " + event.Reason
+		case event.Quarantined != "":
+			outcome = "\x1b[33mheld for review\x1b[0m"
+		}
+		fmt.Fprintf(&b, "%s  %-15s  %-3s %4d  %s\n", event.Time.Local().Format(time.TimeOnly), event.IP, event.Score.PlayerName, event.Score.RemainingHealth, outcome)
+	}
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const INSECURE_PASSWORD = "changeme"
+
+// loadAdminPassword picks the admin password from, in order of preference,
+// the -pw flag, the -pw-file file and the ADMIN_PASSWORD environment
+// variable. It returns the password and a description of where it came from.
+func loadAdminPassword(pw string, file string, insecure bool) (string, string, error) {
+	source := "-pw"
+	switch {
+	case pw != "":
+	case file != "":
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		pw = strings.TrimRight(string(b), "\r\n")
+		source = file
+	case os.Getenv("ADMIN_PASSWORD") != "":
+		pw = os.Getenv("ADMIN_PASSWORD")
+		source = "$ADMIN_PASSWORD"
+	default:
+		pw = INSECURE_PASSWORD
+		source = "the insecure default"
+	}
+
+	if pw == "" {
+		return "", "", fmt.Errorf("admin password from %s is empty", source)
+	}
+	if pw == INSECURE_PASSWORD && !insecure {
+		return "", "", errors.New("refusing to start with the default admin password; set -pw, -pw-file or ADMIN_PASSWORD, or pass -insecure")
+	}
+	return pw, source, nil
+}